@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/scottcagno/angular-refresher/pkg/web/jwt"
+)
+
+func runDecode(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := newFlagSet("decode", "[token]")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	tokenString, err := readToken(fs.Args(), stdin)
+	if err != nil {
+		return err
+	}
+	if strings.Count(tokenString, ".") == 4 {
+		return jwt.NewValidationError("token is encrypted and can not be decoded", jwt.ValidationErrorMalformed)
+	}
+	// An unknown signing method does not prevent the token from being decoded
+	token, _, err := jwt.NewParser(jwt.WithJSONNumber()).ParseUnverified(tokenString, jwt.MapClaims{})
+	var ve *jwt.ValidationError
+	if err != nil && (!errors.As(err, &ve) || ve.Errors&jwt.ValidationErrorMalformed != 0) {
+		return err
+	}
+	return printToken(stdout, token)
+}
+
+// printToken writes the header and claims of the token as indented JSON.
+func printToken(w io.Writer, token *jwt.Token) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(
+		struct {
+			Header map[string]any `json:"header"`
+			Claims jwt.Claims     `json:"claims"`
+		}{token.Header, token.Claims},
+	)
+}
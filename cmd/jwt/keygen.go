@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/scottcagno/angular-refresher/pkg/web/jwt"
+)
+
+func runKeygen(args []string, _ io.Reader, stdout io.Writer) error {
+	fs := newFlagSet("keygen", "")
+	typ := fs.String("type", "rsa", "key type: rsa, ec or ed25519")
+	bits := fs.Int("bits", 2048, "size of an RSA key in bits")
+	curve := fs.String("curve", "P-256", "curve of an EC key: P-256, P-384 or P-521")
+	out := fs.String("out", "", "write the private key to this file instead of the standard output")
+	pub := fs.String("pub", "", "write the public key to this file")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	var privPEM, pubPEM string
+	var writePriv, writePub func(file string) error
+	var public crypto.PublicKey
+	var alg string
+
+	switch strings.ToLower(*typ) {
+	case "rsa":
+		if *bits < 2048 {
+			return fmt.Errorf("RSA keys must be at least 2048 bits, got %d", *bits)
+		}
+		key, pk := jwt.GenerateRSAKeyPair(*bits)
+		privPEM, pubPEM = jwt.RSAPrivateKeyToString(key), jwt.RSAPublicKeyToString(pk)
+		writePriv = func(file string) error { return jwt.WriteRSAPrivateKeyAsPEM(key, file) }
+		writePub = func(file string) error { return jwt.WriteRSAPublicKeyAsPEM(pk, file) }
+		public, alg = pk, jwt.SigningMethodRS256.Alg()
+	case "ec", "ecdsa":
+		var c elliptic.Curve
+		switch strings.ToUpper(*curve) {
+		case "P-256", "P256":
+			c, alg = elliptic.P256(), jwt.SigningMethodES256.Alg()
+		case "P-384", "P384":
+			c, alg = elliptic.P384(), jwt.SigningMethodES384.Alg()
+		case "P-521", "P521":
+			c, alg = elliptic.P521(), jwt.SigningMethodES512.Alg()
+		default:
+			return fmt.Errorf("unsupported curve %q", *curve)
+		}
+		key, pk := jwt.GenerateECDSAKeyPair(c)
+		privPEM, pubPEM = jwt.ECDSAPrivateKeyToString(key), jwt.ECDSAPublicKeyToString(pk)
+		writePriv = func(file string) error { return jwt.WriteECDSAPrivateKeyAsPEM(key, file) }
+		writePub = func(file string) error { return jwt.WriteECDSAPublicKeyAsPEM(pk, file) }
+		public = pk
+	case "ed25519", "eddsa":
+		key, pk := jwt.GenerateEd25519KeyPair()
+		privPEM, pubPEM = jwt.Ed25519PrivateKeyToString(key), jwt.Ed25519PublicKeyToString(pk)
+		writePriv = func(file string) error { return jwt.WriteEd25519PrivateKeyAsPEM(key, file) }
+		writePub = func(file string) error { return jwt.WriteEd25519PublicKeyAsPEM(pk, file) }
+		public, alg = pk, jwt.SigningMethodEdDSA.Alg()
+	default:
+		return fmt.Errorf("unsupported key type %q", *typ)
+	}
+
+	if *out == "" {
+		fmt.Fprint(stdout, privPEM)
+		if *pub == "" {
+			fmt.Fprint(stdout, pubPEM)
+		}
+	} else {
+		err = writePriv(*out)
+		if err != nil {
+			return err
+		}
+	}
+	if *pub != "" {
+		err = writePub(*pub)
+		if err != nil {
+			return err
+		}
+	}
+	if *out != "" {
+		// Report the kid the key is published with in a JSON Web Key Set
+		jwk, err := jwt.NewJWK("", alg, public)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "alg: %s\nkid: %s\n", alg, jwk.Kid)
+	}
+	return nil
+}
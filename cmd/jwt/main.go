@@ -1,33 +1,182 @@
-package jwt
+// Command jwt generates signing keys, and mints, inspects and verifies JSON
+// Web Tokens using the pkg/web/jwt package.
+//
+// Usage:
+//
+//	jwt keygen -type rsa|ec|ed25519 [-bits 2048] [-curve P-256] [-out private.pem] [-pub public.pem]
+//	jwt sign   -alg RS256 (-key private.pem | -secret s) [-sub admin] [-claim role=ROLE_ADMIN] [-claims claims.json] [token options]
+//	jwt decode [token]
+//	jwt verify (-key public.pem | -secret s | -jwks jwks.json) [-iss issuer] [-aud audience] [token]
+//
+// When the token argument is omitted, or is "-", the token is read from the
+// standard input. The verify command exits with a non-zero status describing
+// why a token is not valid; see the exit* constants.
+package main
 
 import (
-	"encoding/json"
-	"log"
-	"net/http"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/scottcagno/angular-refresher/pkg/web/jwt"
+)
+
+// Exit codes. A token failing validation for several reasons exits with the
+// first matching code, in the order below.
+const (
+	exitOK           = 0
+	exitError        = 1 // any other error, such as a missing key file
+	exitUsage        = 2 // invalid command or flags
+	exitMalformed    = 3 // ValidationErrorMalformed
+	exitUnverifiable = 4 // ValidationErrorUnverifiable
+	exitSignature    = 5 // ValidationErrorSignatureInvalid
+	exitRevoked      = 6 // ValidationErrorRevoked
+	exitExpired      = 7 // ValidationErrorExpired
+	exitNotValidYet  = 8 // ValidationErrorNotValidYet and ValidationErrorIssuedAt
+	exitClaims       = 9 // ValidationErrorIssuer, Audience, Id and ClaimsInvalid
 )
 
+type command struct {
+	name  string
+	usage string
+	run   func(args []string, stdin io.Reader, stdout io.Writer) error
+}
+
+var commands = []command{
+	{"keygen", "generate a key pair and write it as PEM", runKeygen},
+	{"sign", "create and sign a token", runSign},
+	{"decode", "print the header and claims of a token without verifying it", runDecode},
+	{"verify", "verify the signature and claims of a token", runVerify},
+}
+
+// errUsage is returned by a command when it is invoked incorrectly. The flag
+// set has already reported the problem.
+var errUsage = errors.New("usage")
+
 func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
 
-	http.HandleFunc("/home", handleHome)
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+		usage(stderr)
+		if len(args) == 0 {
+			return exitUsage
+		}
+		return exitOK
+	}
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+		err := cmd.run(args[1:], stdin, stdout)
+		if err == nil {
+			return exitOK
+		}
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		if err != errUsage {
+			fmt.Fprintf(stderr, "jwt %s: %v\n", cmd.name, err)
+		}
+		return exitCode(err)
+	}
+	fmt.Fprintf(stderr, "jwt: unknown command %q\n", args[0])
+	usage(stderr)
+	return exitUsage
+}
 
-	log.Panic(http.ListenAndServe(":9090", nil))
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: jwt <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "jwt <command> -h" for the flags of a command.`)
 }
 
-type Message struct {
-	Status string `json:"status"`
-	Info   string `json:"info"`
+// exitCode maps an error onto the exit status of the process, using the flags
+// of a *jwt.ValidationError.
+func exitCode(err error) int {
+	if err == errUsage {
+		return exitUsage
+	}
+	var ve *jwt.ValidationError
+	if !errors.As(err, &ve) {
+		return exitError
+	}
+	switch {
+	case ve.Errors&jwt.ValidationErrorMalformed != 0:
+		return exitMalformed
+	case ve.Errors&jwt.ValidationErrorUnverifiable != 0:
+		return exitUnverifiable
+	case ve.Errors&jwt.ValidationErrorSignatureInvalid != 0:
+		return exitSignature
+	case ve.Errors&jwt.ValidationErrorRevoked != 0:
+		return exitRevoked
+	case ve.Errors&jwt.ValidationErrorExpired != 0:
+		return exitExpired
+	case ve.Errors&(jwt.ValidationErrorNotValidYet|jwt.ValidationErrorIssuedAt) != 0:
+		return exitNotValidYet
+	case ve.Errors != 0:
+		return exitClaims
+	}
+	return exitError
 }
 
-func handleHome(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	var message Message
-	err := json.NewDecoder(r.Body).Decode(&message)
-	if err != nil {
-		return
+// newFlagSet returns a flag set for the command, which reports errors
+// instead of exiting.
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet("jwt "+name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: jwt %s [flags] %s\n\nFlags:\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses the arguments, mapping flag errors onto errUsage.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err == nil || err == flag.ErrHelp {
+		return err
+	}
+	return errUsage
+}
+
+// readToken returns the token passed as the only argument, or read from the
+// standard input when there is no argument or the argument is "-".
+func readToken(args []string, stdin io.Reader) (string, error) {
+	if len(args) > 1 {
+		return "", fmt.Errorf("expected a single token, got %d arguments", len(args))
+	}
+	if len(args) == 1 && args[0] != "-" {
+		return strings.TrimSpace(args[0]), nil
 	}
-	err = json.NewEncoder(w).Encode(message)
+	b, err := io.ReadAll(io.LimitReader(stdin, 1<<20))
 	if err != nil {
-		return
+		return "", err
 	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", errors.New("no token provided")
+	}
+	return token, nil
+}
+
+// listFlag is a flag that can be repeated, collecting every value.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
 
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
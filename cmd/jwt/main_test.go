@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runCommand(t *testing.T, stdin string, args ...string) (string, int) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return strings.TrimSpace(stdout.String()), code
+}
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	priv := filepath.Join(dir, "private.pem")
+	pub := filepath.Join(dir, "public.pem")
+	if _, code := runCommand(t, "", "keygen", "-type", "ed25519", "-out", priv, "-pub", pub); code != exitOK {
+		t.Fatalf("keygen exited with %d", code)
+	}
+	info, err := os.Stat(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("Private key file mode %v, expecting %v", mode, os.FileMode(0600))
+	}
+	token, code := runCommand(t, "", "sign", "-alg", "EdDSA", "-key", priv, "-sub", "admin", "-aud", "api")
+	if code != exitOK {
+		t.Fatalf("sign exited with %d", code)
+	}
+	expired, _ := runCommand(t, "", "sign", "-alg", "EdDSA", "-key", priv, "-exp", "1ns", "-iat=false")
+
+	tests := []struct {
+		name  string
+		stdin string
+		args  []string
+		code  int
+	}{
+		{"decode", token, []string{"decode"}, exitOK},
+		{"decode malformed", "", []string{"decode", "abc"}, exitMalformed},
+		{"verify", "", []string{"verify", "-key", pub, "-aud", "api", token}, exitOK},
+		{"verify stdin", token, []string{"verify", "-key", pub, "-"}, exitOK},
+		{"verify audience", "", []string{"verify", "-key", pub, "-aud", "web", token}, exitClaims},
+		{"verify expired", "", []string{"verify", "-key", pub, expired}, exitExpired},
+		{"verify signature", "", []string{"verify", "-secret", "secret", "-alg", "EdDSA", token}, exitSignature},
+		{"verify method", "", []string{"verify", "-secret", "secret", token}, exitSignature},
+		{"verify missing key", "", []string{"verify", "-key", filepath.Join(dir, "missing.pem"), token}, exitError},
+		{"verify without key", "", []string{"verify", token}, exitUsage},
+		{"unknown command", "", []string{"mint"}, exitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if _, code := runCommand(t, tt.stdin, tt.args...); code != tt.code {
+					t.Errorf("[%v] Exit code %d, expecting %d", tt.name, code, tt.code)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/scottcagno/angular-refresher/pkg/web/jwt"
+)
+
+func runSign(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := newFlagSet("sign", "")
+	alg := fs.String("alg", "RS256", "signing method")
+	keyFile := fs.String("key", "", "PEM encoded private key file")
+	secret := fs.String("secret", "", "HMAC secret, for the HS signing methods")
+	kid := fs.String("kid", "", "key id placed in the header (default is the thumbprint of the key)")
+	claimsFile := fs.String("claims", "", `JSON file holding the claims, or "-" for the standard input`)
+	var claimFlags, audFlags listFlag
+	fs.Var(&claimFlags, "claim", "claim as name=value, where value is JSON or a string (repeatable)")
+	iss := fs.String("iss", "", "issuer (iss)")
+	sub := fs.String("sub", "", "subject (sub)")
+	fs.Var(&audFlags, "aud", "audience (aud, repeatable)")
+	jti := fs.String("jti", "", "token id (jti)")
+	exp := fs.Duration("exp", 15*time.Minute, "lifetime of the token (exp); zero omits the claim")
+	iat := fs.Bool("iat", true, "set the issued at (iat) and not before (nbf) claims")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 || (*keyFile == "") == (*secret == "") {
+		fmt.Fprintln(fs.Output(), "exactly one of -key and -secret is required")
+		fs.Usage()
+		return errUsage
+	}
+
+	method := jwt.GetSigningMethod(*alg)
+	if method == nil {
+		return fmt.Errorf("signing method (alg) %q is unavailable", *alg)
+	}
+
+	// Collect the claims; flags override the claims file
+	claims := make(jwt.MapClaims)
+	if *claimsFile != "" {
+		claims, err = readClaims(*claimsFile, stdin)
+		if err != nil {
+			return err
+		}
+	}
+	for _, c := range claimFlags {
+		name, value, found := strings.Cut(c, "=")
+		if !found || name == "" {
+			return fmt.Errorf("invalid claim %q, expected name=value", c)
+		}
+		claims[name] = claimValue(value)
+	}
+	now := time.Now()
+	setString(claims, "iss", *iss)
+	setString(claims, "sub", *sub)
+	setString(claims, "jti", *jti)
+	switch len(audFlags) {
+	case 0:
+	case 1:
+		claims["aud"] = audFlags[0]
+	default:
+		claims["aud"] = []string(audFlags)
+	}
+	if *iat {
+		claims["iat"] = jwt.NewNumericDate(now)
+		claims["nbf"] = jwt.NewNumericDate(now)
+	}
+	if *exp > 0 {
+		claims["exp"] = jwt.NewNumericDate(now.Add(*exp))
+	}
+
+	token := jwt.NewTokenWithClaims(method, claims)
+	var key any
+	if *secret != "" {
+		key = []byte(*secret)
+		if *kid != "" {
+			token.Header["kid"] = *kid
+		}
+	} else {
+		sk, err := jwt.KeyFiles(*keyFile, "")(method)
+		if err != nil {
+			return err
+		}
+		key = sk.Private
+		token.Header["kid"] = *kid
+		if *kid == "" {
+			jwk, err := jwt.NewJWK("", method.Alg(), sk.Public)
+			if err != nil {
+				return err
+			}
+			token.Header["kid"] = jwk.Kid
+		}
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, signed)
+	return nil
+}
+
+// readClaims reads a JSON object of claims from the file, or from stdin when
+// the file is "-". Numbers are kept as json.Number, so they are not rounded.
+func readClaims(file string, stdin io.Reader) (jwt.MapClaims, error) {
+	var b []byte
+	var err error
+	if file == "-" {
+		b, err = io.ReadAll(io.LimitReader(stdin, 1<<20))
+	} else {
+		b, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	claims := make(jwt.MapClaims)
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	err = dec.Decode(&claims)
+	if err != nil {
+		return nil, fmt.Errorf("reading claims: %w", err)
+	}
+	return claims, nil
+}
+
+// claimValue returns the value as JSON when it is valid JSON, and as a string
+// otherwise, so that -claim admin=true yields a boolean and -claim
+// role=ROLE_ADMIN yields a string.
+func claimValue(value string) any {
+	var v any
+	dec := json.NewDecoder(strings.NewReader(value))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return value
+	}
+	return v
+}
+
+func setString(claims jwt.MapClaims, name, value string) {
+	if value != "" {
+		claims[name] = value
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/scottcagno/angular-refresher/pkg/web/jwt"
+)
+
+func runVerify(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := newFlagSet("verify", "[token]")
+	keyFile := fs.String("key", "", "PEM encoded public key or certificate file")
+	secret := fs.String("secret", "", "HMAC secret, for the HS signing methods")
+	jwksFile := fs.String("jwks", "", "JSON Web Key Set file; the key is selected using the kid")
+	algs := fs.String("alg", "", "comma separated signing methods to accept (default depends on the key)")
+	iss := fs.String("iss", "", "required issuer (iss)")
+	aud := fs.String("aud", "", "required audience (aud)")
+	leeway := fs.Duration("leeway", 0, "allowed clock skew")
+	maxAge := fs.Duration("max-age", 0, "reject tokens issued longer ago than this")
+	var required listFlag
+	fs.Var(&required, "require", "claim that must be present (repeatable)")
+	revocations := fs.String("revocations", "", "revocation store file, as written by a FileRevocationStore")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	sources := 0
+	for _, s := range []string{*keyFile, *secret, *jwksFile} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		fmt.Fprintln(fs.Output(), "exactly one of -key, -secret and -jwks is required")
+		fs.Usage()
+		return errUsage
+	}
+	tokenString, err := readToken(fs.Args(), stdin)
+	if err != nil {
+		return err
+	}
+
+	// Select the key, along with the signing methods it can be used with
+	var keyFn jwt.KeyFunc
+	var methods []string
+	switch {
+	case *secret != "":
+		keyFn = func(*jwt.Token) (any, error) { return []byte(*secret), nil }
+		methods = []string{"HS256", "HS384", "HS512"}
+	case *keyFile != "":
+		key, err := readPublicKey(*keyFile)
+		if err != nil {
+			return err
+		}
+		methods = keyMethods(key)
+		if methods == nil {
+			return fmt.Errorf("unsupported public key type %T", key)
+		}
+		keyFn = func(*jwt.Token) (any, error) { return key, nil }
+	default:
+		ks, err := jwt.LoadJWKS(*jwksFile)
+		if err != nil {
+			return err
+		}
+		keyFn = ks.KeyFunc()
+		methods = ks.Algorithms()
+	}
+	if *algs != "" {
+		methods = strings.Split(*algs, ",")
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithJSONNumber(),
+		jwt.WithLeeway(*leeway),
+		jwt.WithMaxAge(*maxAge),
+		jwt.WithRequiredClaims(required...),
+	}
+	if *iss != "" {
+		options = append(options, jwt.WithIssuer(*iss))
+	}
+	if *aud != "" {
+		options = append(options, jwt.WithAudience(*aud))
+	}
+	if *revocations != "" {
+		if _, err = os.Stat(*revocations); err != nil {
+			return err
+		}
+		rs, err := jwt.NewFileRevocationStore(*revocations)
+		if err != nil {
+			return err
+		}
+		options = append(options, jwt.WithRevocations(rs))
+	}
+	token, err := jwt.NewParser(options...).Parse(tokenString, keyFn)
+	if err != nil {
+		return err
+	}
+	return printToken(stdout, token)
+}
+
+// readPublicKey reads a PEM encoded PKIX or PKCS1 public key, or the public
+// key of a certificate.
+func readPublicKey(file string) (crypto.PublicKey, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing keys")
+	}
+	if strings.Contains(block.Type, "PRIVATE") {
+		return nil, fmt.Errorf("%s holds a private key, expected a public key", file)
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s does not hold a public key or certificate", file)
+	}
+	return cert.PublicKey, nil
+}
+
+// keyMethods returns the signing methods the public key can be used with.
+func keyMethods(key crypto.PublicKey) []string {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+	case *ecdsa.PublicKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return []string{"ES256"}
+		case 384:
+			return []string{"ES384"}
+		case 521:
+			return []string{"ES512"}
+		}
+	case ed25519.PublicKey:
+		return []string{"EdDSA"}
+	}
+	return nil
+}
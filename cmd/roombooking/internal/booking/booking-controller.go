@@ -95,10 +95,32 @@ func (c *Controller) Del(w http.ResponseWriter, r *http.Request) {
 		api.WriteJSON(w, http.StatusExpectationFailed, err)
 		return
 	}
-	err = c.Repository.Delete(id)
+	err = api.RepositoryWithContext(c.Repository, r.Context()).Delete(id)
 	if err != nil {
 		api.WriteJSON(w, http.StatusExpectationFailed, err)
 		return
 	}
 	api.WriteJSON(w, http.StatusOK, nil)
 }
+
+// CanCancel is an api.PolicyFunc that only allows a booking to be cancelled
+// by the user it belongs to, or by an admin.
+func (c *Controller) CanCancel(p *api.Principal, r *http.Request) bool {
+	if p.HasRole("ROLE_ADMIN") {
+		return true
+	}
+	param, found := api.GetParam(r, "id")
+	if !found {
+		return false
+	}
+	id, err := strconv.Atoi(param)
+	if err != nil {
+		return false
+	}
+	booking, err := c.Repository.FindOne(func(b *Booking) bool { return b.ID == id })
+	if err != nil {
+		// Let the handler report the missing booking
+		return true
+	}
+	return booking.User.Name == p.Subject
+}
@@ -64,7 +64,7 @@ func (c *Controller) Add(w http.ResponseWriter, r *http.Request) {
 		newRoom.ID = c.nextID
 		c.nextID++
 	}
-	err = c.Insert(newRoom.ID, &newRoom)
+	err = api.RepositoryWithContext(c.Repository, r.Context()).Insert(newRoom.ID, &newRoom)
 	if err != nil {
 		api.WriteJSON(w, http.StatusExpectationFailed, err)
 		return
@@ -91,7 +91,7 @@ func (c *Controller) Set(w http.ResponseWriter, r *http.Request) {
 		api.WriteJSON(w, http.StatusExpectationFailed, err)
 		return
 	}
-	err = c.Update(rid, &updatedRoom)
+	err = api.RepositoryWithContext(c.Repository, r.Context()).Update(rid, &updatedRoom)
 	if err != nil {
 		api.WriteJSON(w, http.StatusExpectationFailed, err)
 		return
@@ -112,7 +112,7 @@ func (c *Controller) Del(w http.ResponseWriter, r *http.Request) {
 		api.WriteJSON(w, http.StatusExpectationFailed, err)
 		return
 	}
-	err = c.Delete(rid)
+	err = api.RepositoryWithContext(c.Repository, r.Context()).Delete(rid)
 	if err != nil {
 		api.WriteJSON(w, http.StatusExpectationFailed, err)
 		return
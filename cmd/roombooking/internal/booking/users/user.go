@@ -1,7 +1,11 @@
 package users
 
 import (
+	"encoding/json"
+	"errors"
+
 	"github.com/scottcagno/angular-refresher/pkg/web/api"
+	"github.com/scottcagno/angular-refresher/pkg/web/password"
 )
 
 type User struct {
@@ -42,6 +46,37 @@ type mappingFunc = func(meth, path string, params ...string) int
 // 	return nil
 // }
 
-func (u *User) SetPassword(password string) {
-	u.Password = password
+// ErrNotHashed is returned by SetPasswordHash when the value is not a PHC
+// encoded hash.
+var ErrNotHashed = errors.New("users: password is not a PHC encoded hash")
+
+// SetPassword hashes and sets the password of the user. The password is
+// always hashed, also when it looks like a hash, so clients can not store a
+// hash of their choosing.
+func (u *User) SetPassword(pass string) error {
+	hash, err := password.Hash(pass)
+	if err != nil {
+		return err
+	}
+	u.Password = hash
+	return nil
+}
+
+// SetPasswordHash sets the password hash of the user, when migrating users
+// whose passwords were hashed elsewhere. It must never be given a value read
+// from a request.
+func (u *User) SetPasswordHash(hash string) error {
+	if !password.IsHashed(hash) {
+		return ErrNotHashed
+	}
+	u.Password = hash
+	return nil
+}
+
+// MarshalJSON omits the password hash, so it is never written to a client.
+// A password may still be provided when a user is added or updated.
+func (u User) MarshalJSON() ([]byte, error) {
+	type user User
+	u.Password = ""
+	return json.Marshal(user(u))
 }
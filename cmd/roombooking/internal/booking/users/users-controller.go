@@ -1,14 +1,11 @@
 package users
 
 import (
-	"errors"
-	"log"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/scottcagno/angular-refresher/pkg/web/api"
-	"github.com/scottcagno/angular-refresher/pkg/web/jwt"
 )
 
 type Controller struct {
@@ -64,11 +61,20 @@ func (c *Controller) Add(w http.ResponseWriter, r *http.Request) {
 		api.WriteJSON(w, http.StatusExpectationFailed, err)
 		return
 	}
+	if newUser.Password == "" {
+		api.WriteJSON(w, http.StatusBadRequest, api.M{"err": "password required"})
+		return
+	}
 	if newUser.ID == 0 {
 		newUser.ID = c.nextID
 		c.nextID++
 	}
-	err = c.Insert(newUser.ID, &newUser)
+	err = newUser.SetPassword(newUser.Password)
+	if err != nil {
+		api.WriteJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+	err = api.RepositoryWithContext(c.Repository, r.Context()).Insert(newUser.ID, &newUser)
 	if err != nil {
 		api.WriteJSON(w, http.StatusExpectationFailed, err)
 		return
@@ -95,7 +101,23 @@ func (c *Controller) Set(w http.ResponseWriter, r *http.Request) {
 		api.WriteJSON(w, http.StatusExpectationFailed, err)
 		return
 	}
-	err = c.Update(uid, &updateUser)
+	if updateUser.Password == "" {
+		// the password is never sent to clients, so an update without one
+		// keeps the stored hash
+		existing, err := c.FindOne(func(u *User) bool { return u.ID == uid })
+		if err != nil {
+			api.WriteJSON(w, http.StatusExpectationFailed, err)
+			return
+		}
+		updateUser.Password = existing.Password
+	} else {
+		err = updateUser.SetPassword(updateUser.Password)
+		if err != nil {
+			api.WriteJSON(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	err = api.RepositoryWithContext(c.Repository, r.Context()).Update(uid, &updateUser)
 	if err != nil {
 		api.WriteJSON(w, http.StatusExpectationFailed, err)
 		return
@@ -116,7 +138,7 @@ func (c *Controller) Del(w http.ResponseWriter, r *http.Request) {
 		api.WriteJSON(w, http.StatusExpectationFailed, err)
 		return
 	}
-	err = c.Delete(uid)
+	err = api.RepositoryWithContext(c.Repository, r.Context()).Delete(uid)
 	if err != nil {
 		api.WriteJSON(w, http.StatusExpectationFailed, err)
 		return
@@ -145,8 +167,12 @@ func (c *Controller) Custom() http.HandlerFunc {
 				api.WriteJSON(w, http.StatusExpectationFailed, err)
 				return
 			}
-			user[0].Password = "reset"
-			err = c.Update(user[0].ID, user[0])
+			err = user[0].SetPassword("reset")
+			if err != nil {
+				api.WriteJSON(w, http.StatusInternalServerError, err)
+				return
+			}
+			err = api.RepositoryWithContext(c.Repository, r.Context()).Update(user[0].ID, user[0])
 			if err != nil {
 				api.WriteJSON(w, http.StatusExpectationFailed, err)
 				return
@@ -154,30 +180,18 @@ func (c *Controller) Custom() http.HandlerFunc {
 			api.WriteJSON(w, http.StatusOK, user)
 			return
 		case strings.HasSuffix(r.URL.Path, "users/getRole"):
-			// Check for a cookie containing our JWT string
-			cook, err := r.Cookie("token")
-			if err != nil && errors.Is(err, http.ErrNoCookie) {
-				api.WriteJSON(w, http.StatusNotFound, map[string]any{"err": "no cookie was found"})
-				return
-			}
-			// Attempt to validate the token string we found in the cookie
-			tok, err := c.Auth.Service.ValidateTokenString(cook.Value)
-			log.Println(err, tok)
-			if err != nil {
-				if _, is := err.(*jwt.ValidationError); is {
-					api.WriteJSON(w, http.StatusUnauthorized, err)
-					return
-				}
-				api.WriteJSON(w, http.StatusNotFound, map[string]any{"err": "validation failed"})
+			// The principal is placed into the request context by the auth
+			// service once the request has been authenticated
+			principal, found := api.RequestPrincipal(r)
+			if !found {
+				api.WriteJSON(w, http.StatusUnauthorized, map[string]any{"err": "not authenticated"})
 				return
 			}
-			// Check for the role in the claims payload
-			payload, ok := tok.Claims.(jwt.MapClaims)
-			if !ok {
-				api.WriteJSON(w, http.StatusNotFound, map[string]any{"err": "could not extract claims"})
-				return
+			var role string
+			if len(principal.Roles) > 0 {
+				role = principal.Roles[0]
 			}
-			api.WriteJSON(w, http.StatusOK, map[string]any{"role": payload["role"]})
+			api.WriteJSON(w, http.StatusOK, map[string]any{"role": role, "roles": principal.Roles})
 			return
 		case strings.HasSuffix(r.URL.Path, "users/list"):
 			// Get all the users from the users store
@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/scottcagno/angular-refresher/cmd/roombooking/internal/booking"
@@ -12,6 +13,7 @@ import (
 	"github.com/scottcagno/angular-refresher/pkg/web"
 	"github.com/scottcagno/angular-refresher/pkg/web/api"
 	"github.com/scottcagno/angular-refresher/pkg/web/api/middleware"
+	"github.com/scottcagno/angular-refresher/pkg/web/jwt"
 )
 
 func main() {
@@ -30,16 +32,51 @@ func main() {
 		},
 	}
 
-	// initialize jwtService
-	jwtService := api.NewJWTAuthService(
-		"cmd/roombooking/private_key.pem",
-		"cmd/roombooking/public_key.pem",
-		inMemoryDefaultUsers...,
-	)
+	// initialize jwtService; the signing key is read from the JWT_PRIVATE_KEY
+	// environment variable when it is set, and from the key files otherwise
+	keys := jwt.KeyFilesOrGenerate("cmd/roombooking/private_key.pem", "cmd/roombooking/public_key.pem")
+	if _, found := os.LookupEnv("JWT_PRIVATE_KEY"); found {
+		keys = jwt.KeyEnv("JWT_PRIVATE_KEY", "")
+	}
+	// the in memory users are only added when there is no identity provider
+	if os.Getenv("OIDC_ISSUER") != "" {
+		inMemoryDefaultUsers = nil
+	}
+	jwtService, err := api.NewJWTAuthServiceWithConfig(&jwt.JWTServiceConfig{Keys: keys}, inMemoryDefaultUsers...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// users may enroll a second factor at /api/auth/mfa/enroll
+	jwtService.MFA = api.NewMFAStore("Room Booking")
 
 	// initialize global data service (contains ref to all repositories)
 	ds := services.NewDataService()
 
+	// record who changed which room, user or booking in a hash chained
+	// audit log, which admins query at /api/audit
+	auditPath := os.Getenv("AUDIT_LOG")
+	if auditPath == "" {
+		auditPath = "cmd/roombooking/audit.log"
+	}
+	auditLog, err := api.OpenAuditLog(auditPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ds.RoomRepo.Repository = api.NewAuditedRepository(
+		"rooms", ds.RoomRepo.Repository, auditLog, func(r *rooms.Room) int { return r.ID },
+	)
+	auditedUsers := api.NewAuditedRepository(
+		"users", ds.UserRepo.Repository, auditLog, func(u *users.User) int { return u.ID },
+	)
+	auditedUsers.Redact = []string{"password"}
+	ds.UserRepo.Repository = auditedUsers
+	// bookings hold their user, so its password is redacted there as well
+	auditedBookings := api.NewAuditedRepository(
+		"bookings", ds.BookingRepo.Repository, auditLog, func(b *booking.Booking) int { return b.ID },
+	)
+	auditedBookings.Redact = []string{"password"}
+	ds.BookingRepo.Repository = auditedBookings
+
 	// initialize rooms controller (and inject the data service into it)
 	roomCont := &rooms.Controller{RoomRepository: ds.RoomRepo}
 
@@ -49,27 +86,67 @@ func main() {
 	// initialize booking controller
 	bookingCont := &booking.Controller{BookingRepository: ds.BookingRepo}
 
-	// initialize our cors handler
-	// cors := middleware.CORSHandler(
-	// 	&middleware.CORSConfig{
-	// 		AllowOrigins:     "http://localhost:4200/api/**",
-	// 		AllowMethods:     "GET,POST,PUT,DELETE",
-	// 		AllowHeaders:     "",
-	// 		AllowCredentials: false,
-	// 		ExposeHeaders:    "",
-	// 		MaxAge:           int(time.Duration(12 * time.Hour).Seconds()),
-	// 	},
-	// )
-
 	apiConf := &api.APIConfig{
 		CORS: &middleware.CORSConfig{
-			AllowOrigins:     "http://localhost:4200",
-			AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
-			AllowHeaders:     "Content-Type",
+			AllowOrigins:     []string{"http://localhost:4200"},
+			AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
+			AllowHeaders:     []string{"Authorization", "Content-Type", "X-XSRF-TOKEN"},
 			AllowCredentials: true,
-			ExposeHeaders:    "",
 			MaxAge:           int(time.Duration(12 * time.Hour).Seconds()),
 		},
+		// the token cookie makes the api CSRF-able; the angular client
+		// sends the XSRF-TOKEN cookie back in the X-XSRF-TOKEN header
+		CSRF: &middleware.CSRFConfig{
+			TrustedOrigins: []string{"http://localhost:4200"},
+			// browsers post the csp reports without the token
+			ExemptPaths: []string{"/api/csp-reports"},
+		},
+		// the api only serves json, so its responses load nothing at all
+		Headers: &middleware.SecureHeadersConfig{
+			CSP: middleware.NewCSP().
+				Set(middleware.DefaultSrc, middleware.SourceNone).
+				Set(middleware.FrameAncestors, middleware.SourceNone).
+				ReportURI("/api/csp-reports"),
+		},
+		Audit: &api.AuditConfig{Log: auditLog},
+	}
+
+	// sessions are shared between instances when a redis server is
+	// configured. Staff log in with the company identity provider when it
+	// is configured; the provider redirects back from another site, so the
+	// session cookie must be SameSite=Lax
+	issuer := os.Getenv("OIDC_ISSUER")
+	sessionConf := &web.SessionManagerConfig{}
+	if addr := os.Getenv("SESSION_REDIS_ADDR"); addr != "" {
+		sessionConf.Backend = web.NewRedisBackend(
+			web.RedisConfig{Addr: addr, Password: os.Getenv("SESSION_REDIS_PASSWORD")},
+		)
+	}
+	if issuer != "" {
+		sessionConf.SameSite = http.SameSiteLaxMode
+	}
+	sessions := web.NewSessionManager(sessionConf)
+	apiConf.Sessions = sessions
+
+	var oidcClient *api.OIDCClient
+	if issuer != "" {
+		oidcClient, err = api.NewOIDCClient(
+			&api.OIDCConfig{
+				Issuer:       issuer,
+				ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+				ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+				RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+				RoleMapping: []api.OIDCRoleMapping{
+					{Claim: "roombooking-admins", Role: "ROLE_ADMIN"},
+					{Claim: "staff", Role: "ROLE_USER"},
+				},
+				Sessions: sessions,
+				Tokens:   jwtService.Service,
+			},
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	// initialize new rest api server
@@ -78,14 +155,76 @@ func main() {
 	// initialize auth service
 	authService := api.MakeAuthService(jwtService)
 
+	// initialize api key auth service; build bots and integrations use
+	// keys, while signed in users fall back to their token
+	apiKeyService := api.NewAPIKeyAuthService(nil, jwtService)
+	apiKeyAuth := api.MakeAuthService(apiKeyService)
+	apiKeyAuth.KeysPath = "/"
+	apiKeyAuth.RevokeKeyPath = "/revoke"
+
+	// initialize the oauth server; users signed in to the app approve the
+	// authorization requests of the mobile app
+	oauthServer, err := api.NewOAuthServer(
+		&api.OAuthServerConfig{
+			Service:       jwtService.Service,
+			Users:         jwtService.Users,
+			Authenticator: jwtService,
+			MFA:           jwtService.MFA,
+		},
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = oauthServer.Clients.Register(
+		api.OAuthClient{
+			ID:           "roombooking-mobile",
+			Name:         "Room Booking Mobile",
+			Public:       true,
+			RedirectURIs: []string{"roombooking://oauth/callback"},
+			GrantTypes:   []string{api.GrantAuthorizationCode, api.GrantRefreshToken},
+			Scopes:       []string{"rooms:read", "bookings"},
+		},
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// register controllers with api
 	restAPI.RegisterAuthService("/api/auth", authService)
+	restAPI.RegisterAuthService("/api/keys", apiKeyAuth)
+	// the resources accept both keys and tokens
+	restAPI.SetAuthService(apiKeyAuth)
+	restAPI.RegisterJWKS(jwtService.Service.KeySet())
+	restAPI.RegisterCSPReports("/api/csp-reports", middleware.NewCSPReportCollector(log.Default(), 100))
+	restAPI.RegisterOAuthServer("/oauth", oauthServer)
+	restAPI.RegisterAudit("audit", auditLog)
+	if oidcClient != nil {
+		restAPI.RegisterOIDC("/auth/oidc", oidcClient)
+	}
 	restAPI.Register("rooms", roomCont, false)
-	restAPI.Register("users", userCont, true)
-	restAPI.Register("bookings", bookingCont, false)
-	restAPI.RegisterCustom("users/resetPassword", userCont, true)
-	restAPI.RegisterCustom("users/getRole", userCont, false)
-	restAPI.RegisterCustom("users/list", userCont, false)
+	restAPI.RegisterProtected(
+		"users", userCont, api.AccessControl{
+			http.MethodGet: {Roles: []string{"ROLE_USER"}},
+			api.AnyMethod:  {Roles: []string{"ROLE_ADMIN"}},
+		},
+	)
+	restAPI.RegisterProtected(
+		"bookings", bookingCont, api.AccessControl{
+			http.MethodDelete: {Roles: []string{"ROLE_USER"}, Scopes: []string{"bookings"}, Policy: bookingCont.CanCancel},
+			api.AnyMethod:     {Roles: []string{"ROLE_USER"}, Scopes: []string{"bookings"}},
+		},
+	)
+	restAPI.RegisterCustomProtected(
+		"users/resetPassword", userCont, api.AccessControl{
+			api.AnyMethod: {Roles: []string{"ROLE_ADMIN"}},
+		},
+	)
+	restAPI.RegisterCustomProtected("users/getRole", userCont, api.AccessControl{})
+	restAPI.RegisterCustomProtected(
+		"users/list", userCont, api.AccessControl{
+			api.AnyMethod: {Roles: []string{"ROLE_ADMIN"}},
+		},
+	)
 
 	// certFile := "cmd/roombooking/cert/CA/CA.pem"
 	// keyFile := "cmd/roombooking/cert/CA/CA.key"
@@ -5,5 +5,6 @@ go 1.19
 require (
 	"github.com/cagnosolutions/webapp" v0.0.0-20220207201229-e66a1512f56c
 	"github.com/golang-jwt/jwt/v4" v4.4.3
+	"golang.org/x/crypto" v0.17.0
 )
 
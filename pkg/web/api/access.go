@@ -0,0 +1,215 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrForbidden is returned by the Authorizer when an authenticated principal
+// does not meet the requirements of an AccessRule.
+var ErrForbidden = errors.New("principal is not allowed to access this resource")
+
+// AnyMethod is the AccessControl key used for every HTTP method that does not
+// have a rule of its own.
+const AnyMethod = "*"
+
+// PolicyFunc is a hook used to make fine-grained authorization decisions that
+// can not be expressed using roles or permissions alone, such as checking that
+// a user only cancels their own bookings. It is called after the role and
+// permission requirements of an AccessRule have been met.
+type PolicyFunc func(p *Principal, r *http.Request) bool
+
+// AccessRule describes what a principal needs in order to be granted access.
+// A rule with no roles, permissions or policy only requires that the request
+// is authenticated.
+type AccessRule struct {
+	// Roles holds the roles that are allowed access. The principal must have
+	// at least one of them, either directly or through the role hierarchy.
+	Roles []string
+
+	// Permissions holds the permissions that are required. The principal
+	// must have every one of them.
+	Permissions []string
+
+	// Scopes holds the OAuth scopes that grant access to a token issued to
+	// an OAuth client, which is recognized by its "client_id" claim. Such a
+	// token must have been granted at least one of them, on top of meeting
+	// the other requirements of the rule; a rule without scopes refuses it.
+	Scopes []string
+
+	// Policy is an optional hook that is called last and must return true
+	// for access to be granted.
+	Policy PolicyFunc
+}
+
+// AccessControl maps HTTP methods to the rule guarding them. Methods without
+// an entry fall back to the AnyMethod entry, and are allowed for any
+// authenticated principal when there is none.
+type AccessControl map[string]AccessRule
+
+// rule returns the AccessRule that should be applied to the provided method.
+func (ac AccessControl) rule(method string) AccessRule {
+	if rule, ok := ac[method]; ok {
+		return rule
+	}
+	return ac[AnyMethod]
+}
+
+// RoleHierarchy maps a role onto the roles it implies. For example, mapping
+// "ROLE_ADMIN" onto "ROLE_USER" means that an admin may access everything a
+// user may access. Implied roles are resolved transitively.
+type RoleHierarchy map[string][]string
+
+// Expand returns the provided roles along with every role they imply.
+func (h RoleHierarchy) Expand(roles []string) []string {
+	seen := make(map[string]bool, len(roles))
+	var expanded []string
+	var walk func(role string)
+	walk = func(role string) {
+		if seen[role] {
+			return
+		}
+		seen[role] = true
+		expanded = append(expanded, role)
+		for _, implied := range h[role] {
+			walk(implied)
+		}
+	}
+	for _, role := range roles {
+		walk(role)
+	}
+	return expanded
+}
+
+// DefaultRoleHierarchy is the role hierarchy used when none is configured.
+var DefaultRoleHierarchy = RoleHierarchy{
+	"ROLE_ADMIN": {"ROLE_USER"},
+}
+
+// Authorizer decides whether an authenticated principal satisfies an AccessRule.
+type Authorizer struct {
+	// Hierarchy is used to resolve implied roles.
+	Hierarchy RoleHierarchy
+
+	// Permissions maps a role onto the permissions it grants. Principals
+	// also keep any permissions granted to them directly.
+	Permissions map[string][]string
+}
+
+// NewAuthorizer returns an *Authorizer using the provided role hierarchy and
+// role permissions. A nil hierarchy will use the DefaultRoleHierarchy.
+func NewAuthorizer(hierarchy RoleHierarchy, permissions map[string][]string) *Authorizer {
+	if hierarchy == nil {
+		hierarchy = DefaultRoleHierarchy
+	}
+	return &Authorizer{
+		Hierarchy:   hierarchy,
+		Permissions: permissions,
+	}
+}
+
+// HasRole reports whether the principal has the provided role, either directly
+// or through the role hierarchy.
+func (a *Authorizer) HasRole(p *Principal, role string) bool {
+	if p == nil {
+		return false
+	}
+	for _, r := range a.Hierarchy.Expand(p.Roles) {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether the principal has the provided permission,
+// either directly or through one of its (expanded) roles.
+func (a *Authorizer) HasPermission(p *Principal, permission string) bool {
+	if p == nil {
+		return false
+	}
+	for _, perm := range p.Permissions {
+		if perm == permission {
+			return true
+		}
+	}
+	for _, role := range a.Hierarchy.Expand(p.Roles) {
+		for _, perm := range a.Permissions[role] {
+			if perm == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authorize checks the principal against the provided rule. It returns
+// ErrNoCredentials if there is no principal, and ErrForbidden if the principal
+// does not satisfy the rule.
+func (a *Authorizer) Authorize(p *Principal, r *http.Request, rule AccessRule) error {
+	if p == nil {
+		return ErrNoCredentials
+	}
+	if len(rule.Roles) > 0 {
+		var hasRole bool
+		for _, role := range rule.Roles {
+			if a.HasRole(p, role) {
+				hasRole = true
+				break
+			}
+		}
+		if !hasRole {
+			return ErrForbidden
+		}
+	}
+	for _, perm := range rule.Permissions {
+		if !a.HasPermission(p, perm) {
+			return ErrForbidden
+		}
+	}
+	if _, ok := p.Claims["client_id"]; ok && !hasScope(p, rule.Scopes) {
+		return ErrForbidden
+	}
+	if rule.Policy != nil && !rule.Policy(p, r) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// hasScope reports whether the token of the principal was granted one of the
+// scopes.
+func hasScope(p *Principal, scopes []string) bool {
+	granted, _ := p.Claims["scope"].(string)
+	for _, g := range strings.Fields(granted) {
+		for _, scope := range scopes {
+			if g == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Protect wraps next so that it is only called when the principal in the
+// request context satisfies the AccessRule registered for the request method.
+// Requests without a principal are answered with a 401 Unauthorized, and
+// requests whose principal does not satisfy the rule with a 403 Forbidden.
+// Protect expects to run after an authentication middleware such as
+// AuthService.Secure or RequireJWT.
+func (a *Authorizer) Protect(ac AccessControl, next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		p, _ := RequestPrincipal(r)
+		err := a.Authorize(p, r, ac.rule(r.Method))
+		if err != nil {
+			if errors.Is(err, ErrNoCredentials) {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
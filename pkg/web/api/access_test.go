@@ -0,0 +1,110 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/scottcagno/angular-refresher/pkg/web/jwt"
+)
+
+func TestRoleHierarchyExpand(t *testing.T) {
+	h := RoleHierarchy{
+		"ROLE_ADMIN":   {"ROLE_MANAGER"},
+		"ROLE_MANAGER": {"ROLE_USER"},
+		"ROLE_USER":    {"ROLE_ADMIN"}, // cycles must not loop forever
+	}
+	got := h.Expand([]string{"ROLE_ADMIN"})
+	if len(got) != 3 {
+		t.Fatalf("got %v, want three roles", got)
+	}
+}
+
+func TestAuthorizerProtect(t *testing.T) {
+	authz := NewAuthorizer(nil, map[string][]string{"ROLE_USER": {"bookings:read"}})
+	ownerOnly := func(p *Principal, r *http.Request) bool {
+		return r.URL.Query().Get("owner") == p.Subject
+	}
+	ac := AccessControl{
+		http.MethodGet:    {Roles: []string{"ROLE_USER"}, Permissions: []string{"bookings:read"}},
+		http.MethodDelete: {Roles: []string{"ROLE_USER"}, Policy: ownerOnly},
+		AnyMethod:         {Roles: []string{"ROLE_ADMIN"}},
+	}
+	h := authz.Protect(ac, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	admin := &Principal{Subject: "admin", Roles: []string{"ROLE_ADMIN"}}
+	user := &Principal{Subject: "user", Roles: []string{"ROLE_USER"}}
+
+	tests := []struct {
+		name      string
+		method    string
+		target    string
+		principal *Principal
+		code      int
+	}{
+		{"anonymous", http.MethodGet, "/bookings", nil, http.StatusUnauthorized},
+		{"user get", http.MethodGet, "/bookings", user, http.StatusOK},
+		{"admin get through hierarchy", http.MethodGet, "/bookings", admin, http.StatusOK},
+		{"user post", http.MethodPost, "/bookings", user, http.StatusForbidden},
+		{"admin post", http.MethodPost, "/bookings", admin, http.StatusOK},
+		{"user cancels own", http.MethodDelete, "/bookings?owner=user", user, http.StatusOK},
+		{"user cancels other", http.MethodDelete, "/bookings?owner=jane", user, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				r := httptest.NewRequest(tt.method, tt.target, nil)
+				if tt.principal != nil {
+					r = r.WithContext(NewPrincipalContext(r.Context(), tt.principal))
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				if w.Code != tt.code {
+					t.Fatalf("got status %d, want %d", w.Code, tt.code)
+				}
+			},
+		)
+	}
+}
+
+func TestAuthorizerScopes(t *testing.T) {
+	authz := NewAuthorizer(nil, nil)
+	ac := AccessControl{
+		http.MethodGet: {Roles: []string{"ROLE_USER"}},
+		AnyMethod:      {Roles: []string{"ROLE_USER"}, Scopes: []string{"bookings"}},
+	}
+	h := authz.Protect(ac, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name   string
+		method string
+		claims jwt.MapClaims
+		code   int
+	}{
+		{"user token", http.MethodDelete, jwt.MapClaims{"sub": "user", "role": "ROLE_USER"}, http.StatusOK},
+		{"granted scope", http.MethodDelete, withRole(oauthClaims("mobile", "rooms:read bookings")), http.StatusOK},
+		{"other scope", http.MethodDelete, withRole(oauthClaims("mobile", "rooms:read")), http.StatusForbidden},
+		{"no scope", http.MethodDelete, withRole(oauthClaims("mobile", "")), http.StatusForbidden},
+		{"rule without scopes", http.MethodGet, withRole(oauthClaims("mobile", "bookings")), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				r := httptest.NewRequest(tt.method, "/bookings", nil)
+				r = r.WithContext(NewPrincipalContext(r.Context(), PrincipalFromClaims(tt.claims)))
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				if w.Code != tt.code {
+					t.Fatalf("[%v] Status %d, expecting %d", tt.name, w.Code, tt.code)
+				}
+			},
+		)
+	}
+}
+
+// withRole adds the subject and role of a user to the claims.
+func withRole(claims jwt.MapClaims) jwt.MapClaims {
+	claims["sub"] = "user"
+	claims["role"] = "ROLE_USER"
+	return claims
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -9,60 +10,89 @@ import (
 
 	"github.com/scottcagno/angular-refresher/pkg/web"
 	"github.com/scottcagno/angular-refresher/pkg/web/api/middleware"
+	"github.com/scottcagno/angular-refresher/pkg/web/jwt"
 )
 
 type M = map[string]any
 
 type APIConfig struct {
-	CORS   *middleware.CORSConfig
-	Muxer  *http.ServeMux
-	Logger *log.Logger
+	CORS       *middleware.CORSConfig
+	Muxer      *http.ServeMux
+	Logger     *log.Logger
+	Authorizer *Authorizer
+	Sessions   web.SessionProvider
+	CSRF       *middleware.CSRFConfig
+	Headers    *middleware.SecureHeadersConfig
+	Audit      *AuditConfig
 	//Auth   *jwt.JWTService
 }
 
 var defaultAPIConfig = &APIConfig{
-	CORS:   middleware.DefaultCORSConfig,
-	Muxer:  http.NewServeMux(),
-	Logger: log.New(os.Stderr, "[DEFAULT] ", log.LstdFlags),
+	CORS:       middleware.DefaultCORSConfig,
+	Logger:     log.New(os.Stderr, "[DEFAULT] ", log.LstdFlags),
+	Authorizer: NewAuthorizer(nil, nil),
 	//Auth:   nil,
 }
 
-func checkConf(c *APIConfig) *APIConfig {
-	if c == nil {
-		c = defaultAPIConfig
+// checkConf returns a copy of the config with any default values that need
+// to be set.
+func checkConf(conf *APIConfig) *APIConfig {
+	if conf == nil {
+		conf = defaultAPIConfig
 	}
+	c := *conf
 	if c.Muxer == nil {
 		c.Muxer = http.NewServeMux()
 	}
 	if c.Logger == nil {
 		c.Logger = log.New(os.Stderr, "[DEFAULT] ", log.LstdFlags)
 	}
+	if c.Authorizer == nil {
+		c.Authorizer = NewAuthorizer(nil, nil)
+	}
 	// if c.Auth == nil {
 	// 	c.Auth = jwt.NewJWTService()
 	// }
-	return c
+	return &c
 }
 
 type API struct {
 	base        string
 	conf        *APIConfig
-	cors        http.Handler
+	cors        middleware.Middleware
 	logger      *log.Logger
 	mux         *http.ServeMux
-	sessions    *web.SessionStore
+	sessions    middleware.Middleware
+	csrf        middleware.Middleware
+	headers     middleware.Middleware
+	audit       middleware.Middleware
+	chain       http.Handler
+	closer      io.Closer // the session manager created by NewAPI
 	handlers    []handler
 	authService *AuthService
+	authorizer  *Authorizer
 }
 
+// NewAPI returns a new *API serving the resources under base. A nil config
+// will use the default values. When no SessionProvider is configured, the
+// API creates a session manager of its own, which Close stops.
 func NewAPI(base string, conf *APIConfig) *API {
+	var sessions *web.SessionManager
+	if conf == nil || conf.Sessions == nil {
+		sessions = web.NewSessionManager(nil)
+	}
 	conf = checkConf(conf)
+	if sessions != nil {
+		conf.Sessions = sessions
+	}
 	api := new(API)
 	api.base = base
 	api.conf = conf
 	if conf.CORS != nil {
-		api.cors = middleware.CORSHandler(conf.CORS)
+		api.cors = middleware.CORS(conf.CORS)
 	}
 	api.logger = conf.Logger
+	api.authorizer = conf.Authorizer
 	api.mux = conf.Muxer
 	api.mux.Handle("/", http.RedirectHandler(api.base, http.StatusSeeOther))
 	api.mux.Handle(filepath.ToSlash(filepath.Join(api.base, "stats")), api.StatsHandler())
@@ -71,17 +101,59 @@ func NewAPI(base string, conf *APIConfig) *API {
 	// if conf.Auth != nil {
 	// 	api.mux.Handle(filepath.ToSlash(filepath.Join(api.base, "validate")), api.AuthHandler())
 	// }
-	// api.sessions = web.NewSessionStore(&web.SessionStoreConfig{
-	// 	SessionID: "go_sess_id",
-	// 	Domain:    "localhost",
-	// 	Timeout:   time.Duration(30) * time.Minute,
-	// })
-	api.sessions = web.NewSessionStore(nil)
+	api.sessions = web.SessionMiddleware(conf.Sessions)
+	if conf.CSRF != nil {
+		api.csrf = middleware.CSRF(conf.CSRF)
+	}
+	if conf.Headers != nil {
+		api.headers = middleware.SecureHeaders(conf.Headers)
+	}
+	if conf.Audit != nil {
+		api.audit = Audit(conf.Audit)
+	}
+	if sessions != nil {
+		api.closer = sessions
+	}
+	api.chain = api.buildChain()
 	api.handlers = make([]handler, 0)
 	// api.logger.Println(api.conf.Auth.Keys())
 	return api
 }
 
+// buildChain chains the middleware of the api in front of its muxer.
+func (api *API) buildChain() http.Handler {
+	var h http.Handler = api.mux
+	// protect the resource handler from forged requests, if configured
+	if api.csrf != nil {
+		h = api.csrf(h)
+	}
+	// call the resource handler, with the session of the request
+	h = api.sessions(h)
+	// record the requests changing data in the audit log, if configured
+	if api.audit != nil {
+		h = api.audit(h)
+	}
+	// apply cors handler if we have one; it answers preflight requests on
+	// its own, so they never reach the resource handler
+	if api.cors != nil {
+		h = api.cors(h)
+	}
+	// set the security headers of every response, if configured
+	if api.headers != nil {
+		h = api.headers(h)
+	}
+	return h
+}
+
+// Close stops the session manager the API created, when no SessionProvider
+// was configured. A configured SessionProvider is left to its owner.
+func (api *API) Close() error {
+	if api.closer == nil {
+		return nil
+	}
+	return api.closer.Close()
+}
+
 // func _NewAPI(base string, cors http.Handler, logger *log.Logger, mux *http.ServeMux) *API {
 // 	if logger == nil {
 // 		logger = log.New(os.Stderr, "[DEFAULT] ", log.LstdFlags)
@@ -127,7 +199,7 @@ func (api *API) StatsHandler() http.Handler {
 // 			return
 // 		}
 // 		// generate JWT token
-// 		token := api.conf.Auth.GenerateSignedToken(user.Username, user.Password, user.Role)
+// 		token := api.conf.Auth.GenerateSignedToken(user.Username, user.Role)
 // 		WriteJSON(w, http.StatusOK, map[string]string{"results": token})
 // 		return
 // 	}
@@ -228,13 +300,25 @@ func (api *API) Register(name string, re Resource, secure bool) {
 	api.handlers = append(api.handlers, *h)
 	var hand http.Handler
 	if secure {
-		hand = api.authService.Secure(middleware.WithLogging(api.logger, h))
+		hand = api.secure(middleware.WithLogging(api.logger, h))
 	} else {
 		hand = middleware.WithLogging(api.logger, h)
 	}
 	api.mux.Handle(h.path, hand)
 }
 
+// SetAuthService sets the AuthService authenticating the requests to every
+// secure and protected resource. It must be called before registering them.
+// An AuthService registered using RegisterAuthService only serves its own
+// endpoints, so the resources never change their authentication depending
+// on the order in which the services were registered.
+func (api *API) SetAuthService(as *AuthService) {
+	api.authService = as
+}
+
+// RegisterAuthService registers the endpoints of the AuthService under base,
+// for example "/api/auth/register". Use SetAuthService to authenticate the
+// resources using it.
 func (api *API) RegisterAuthService(base string, as *AuthService) {
 	h1 := &customHandler{
 		path: filepath.ToSlash(filepath.Join(base, as.RegisterPath)),
@@ -252,7 +336,131 @@ func (api *API) RegisterAuthService(base string, as *AuthService) {
 	api.logger.Printf("::Register a token at %q\n", h1.path)
 	api.logger.Printf("::Validate a token at %q\n", h2.path)
 
-	api.authService = as
+	if rf, ok := as.Authenticator.(Refresher); ok {
+		h3 := &customHandler{
+			path: filepath.ToSlash(filepath.Join(base, as.RefreshPath)),
+			fn:   rf.Refresh,
+		}
+		h4 := &customHandler{
+			path: filepath.ToSlash(filepath.Join(base, as.LogoutPath)),
+			fn:   rf.Logout,
+		}
+		api.mux.Handle(h3.path, middleware.WithLogging(api.logger, h3))
+		api.mux.Handle(h4.path, middleware.WithLogging(api.logger, h4))
+		api.logger.Printf("::Refresh a token at %q\n", h3.path)
+		api.logger.Printf("::Logout at %q\n", h4.path)
+	}
+
+	if sf, ok := as.Authenticator.(SecondFactor); ok {
+		h5 := &customHandler{
+			path: filepath.ToSlash(filepath.Join(base, as.MFAPath)),
+			fn:   sf.VerifyMFA,
+		}
+		h6 := &customHandler{
+			path: filepath.ToSlash(filepath.Join(base, as.MFAEnrollPath)),
+			fn:   sf.EnrollMFA,
+		}
+		h7 := &customHandler{
+			path: filepath.ToSlash(filepath.Join(base, as.MFAConfirmPath)),
+			fn:   sf.ConfirmMFA,
+		}
+		// the enrollment endpoints authenticate the requests themselves,
+		// so users that must enroll can use their enrollment token
+		api.mux.Handle(h5.path, middleware.WithLogging(api.logger, h5))
+		api.mux.Handle(h6.path, middleware.WithLogging(api.logger, h6))
+		api.mux.Handle(h7.path, middleware.WithLogging(api.logger, h7))
+		api.logger.Printf("::Verify a second factor at %q\n", h5.path)
+		api.logger.Printf("::Enroll a second factor at %q\n", h6.path)
+	}
+
+	if km, ok := as.Authenticator.(KeyManager); ok {
+		h8 := &customHandler{
+			path: filepath.ToSlash(filepath.Join(base, as.KeysPath)),
+			fn:   km.ListKeys,
+		}
+		h9 := &customHandler{
+			path: filepath.ToSlash(filepath.Join(base, as.RevokeKeyPath)),
+			fn:   km.RevokeKey,
+		}
+		api.mux.Handle(h8.path, middleware.WithLogging(api.logger, as.Secure(h8)))
+		api.mux.Handle(h9.path, middleware.WithLogging(api.logger, as.Secure(h9)))
+		api.logger.Printf("::List keys at %q\n", h8.path)
+		api.logger.Printf("::Revoke a key at %q\n", h9.path)
+	}
+}
+
+// RegisterJWKS publishes the public keys of the key set as a JSON Web Key Set
+// at "/.well-known/jwks.json", so that other services can verify the tokens
+// issued by this one.
+func (api *API) RegisterJWKS(ks *jwt.KeySet) {
+	path := "/.well-known/jwks.json"
+	api.mux.Handle(path, middleware.WithLogging(api.logger, ks))
+	api.logger.Printf("::Publishing key set at %q\n", path)
+}
+
+// RegisterCSPReports registers the collector of the Content Security Policy
+// violation reports at path, which the report-uri or report-to directive of
+// the policy must point to.
+func (api *API) RegisterCSPReports(path string, c *middleware.CSPReportCollector) {
+	api.mux.Handle(path, middleware.WithLogging(api.logger, c))
+	api.logger.Printf("::Collecting CSP reports at %q\n", path)
+}
+
+// RegisterAudit registers the query endpoint of the audit log under name,
+// which only admins are allowed to use. See AuditLog.ServeHTTP for the
+// queries it answers.
+func (api *API) RegisterAudit(name string, l *AuditLog) {
+	h := &customHandler{
+		path:   filepath.ToSlash(filepath.Join(api.base, name)),
+		fn:     l.ServeHTTP,
+		secure: true,
+	}
+	api.mux.Handle(
+		h.path, api.protect(
+			AccessControl{
+				AnyMethod: {Roles: []string{"ROLE_ADMIN"}},
+			}, h,
+		),
+	)
+	api.logger.Printf("::Querying the audit log at %q\n", h.path)
+}
+
+// RegisterOAuthServer registers the token, authorization, introspection and
+// revocation endpoints of the OAuth 2.0 authorization server under base, for
+// example "/oauth/token".
+func (api *API) RegisterOAuthServer(base string, srv *OAuthServer) {
+	endpoints := []*customHandler{
+		{path: filepath.ToSlash(filepath.Join(base, srv.TokenPath)), fn: srv.Token},
+		{path: filepath.ToSlash(filepath.Join(base, srv.AuthorizePath)), fn: srv.Authorize},
+		{path: filepath.ToSlash(filepath.Join(base, srv.IntrospectPath)), fn: srv.Introspect},
+		{path: filepath.ToSlash(filepath.Join(base, srv.RevokePath)), fn: srv.Revoke},
+	}
+	for _, h := range endpoints {
+		api.mux.Handle(h.path, middleware.WithLogging(api.logger, h))
+	}
+	api.logger.Printf("::Registered OAuth 2.0 authorization server...\n")
+	api.logger.Printf("::Token endpoint at %q\n", endpoints[0].path)
+	api.logger.Printf("::Authorization endpoint at %q\n", endpoints[1].path)
+	api.logger.Printf("::Introspection endpoint at %q\n", endpoints[2].path)
+	api.logger.Printf("::Revocation endpoint at %q\n", endpoints[3].path)
+}
+
+// RegisterOIDC registers the login, callback and logout endpoints of the
+// OpenID Connect client under base. The callback endpoint must match the
+// RedirectURL the client was configured with.
+func (api *API) RegisterOIDC(base string, c *OIDCClient) {
+	endpoints := []*customHandler{
+		{path: filepath.ToSlash(filepath.Join(base, c.LoginPath)), fn: c.Login},
+		{path: filepath.ToSlash(filepath.Join(base, c.CallbackPath)), fn: c.Callback},
+		{path: filepath.ToSlash(filepath.Join(base, c.LogoutPath)), fn: c.Logout},
+	}
+	for _, h := range endpoints {
+		api.mux.Handle(h.path, middleware.WithLogging(api.logger, h))
+	}
+	api.logger.Printf("::Registered OpenID Connect login with %q...\n", c.Provider.Issuer)
+	api.logger.Printf("::Login at %q\n", endpoints[0].path)
+	api.logger.Printf("::Callback at %q\n", endpoints[1].path)
+	api.logger.Printf("::Logout at %q\n", endpoints[2].path)
 }
 
 func (api *API) RegisterCustom(name string, re CustomResource, secure bool) {
@@ -262,13 +470,60 @@ func (api *API) RegisterCustom(name string, re CustomResource, secure bool) {
 	}
 	var hand http.Handler
 	if secure {
-		hand = api.authService.Secure(middleware.WithLogging(api.logger, h))
+		hand = api.secure(middleware.WithLogging(api.logger, h))
 	} else {
 		hand = middleware.WithLogging(api.logger, h)
 	}
 	api.mux.Handle(h.path, hand)
 }
 
+// RegisterProtected registers a resource that requires an authenticated
+// principal satisfying the AccessRule registered for each HTTP method, for
+// example:
+//
+//	api.RegisterProtected("users", users, api.AccessControl{
+//		http.MethodGet:    {Roles: []string{"ROLE_USER"}},
+//		http.MethodDelete: {Roles: []string{"ROLE_ADMIN"}},
+//	})
+//
+// Unauthenticated requests receive a 401 and requests that are authenticated,
+// but not authorized, receive a 403.
+func (api *API) RegisterProtected(name string, re Resource, ac AccessControl) {
+	h := &handler{
+		name:   name,
+		path:   filepath.ToSlash(filepath.Join(api.base, name)),
+		reso:   re,
+		secure: true,
+	}
+	api.handlers = append(api.handlers, *h)
+	api.mux.Handle(h.path, api.protect(ac, h))
+}
+
+// RegisterCustomProtected is the CustomResource counterpart of RegisterProtected.
+func (api *API) RegisterCustomProtected(name string, re CustomResource, ac AccessControl) {
+	h := &customHandler{
+		path:   filepath.ToSlash(filepath.Join(api.base, name)),
+		fn:     re.Custom(),
+		secure: true,
+	}
+	api.mux.Handle(h.path, api.protect(ac, h))
+}
+
+// protect chains the logging, authentication and authorization handlers
+// in front of the provided handler.
+func (api *API) protect(ac AccessControl, h http.Handler) http.Handler {
+	return middleware.WithLogging(api.logger, api.secure(api.authorizer.Protect(ac, h)))
+}
+
+// secure authenticates the requests using the AuthService set by
+// SetAuthService.
+func (api *API) secure(h http.Handler) http.Handler {
+	if api.authService == nil {
+		panic("api: SetAuthService must be called before registering a secure or protected resource")
+	}
+	return api.authService.Secure(h)
+}
+
 // func (api *API) _RegisterSecure(name string, re SecureResource) {
 // 	h := &customHandler{
 // 		path: filepath.ToSlash(filepath.Join(api.base, name)),
@@ -278,19 +533,8 @@ func (api *API) RegisterCustom(name string, re CustomResource, secure bool) {
 // }
 
 func (api *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// apply cors handler if we have one
-	if api.cors != nil {
-		api.cors.ServeHTTP(w, r)
-	}
-	// lookup resource handler
-	rh, pat := api.mux.Handler(r)
-	// do something with the pattern if we need to
-	_ = pat
-	// if strings.HasSuffix(pat, "/validate") {
-	// 	rh.ServeHTTP(w, r)
-	// }
-	// call the resource handler
-	rh.ServeHTTP(w, r)
+	// the middleware chain is built once, by NewAPI
+	api.chain.ServeHTTP(w, r)
 }
 
 // func (api *API) HandleRequestMapping(mapping RequestMapping) {
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/scottcagno/angular-refresher/pkg/web/api/middleware"
+)
+
+type countingResource struct {
+	calls int
+}
+
+func (cr *countingResource) Custom() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cr.calls++
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func TestAPIPreflight(t *testing.T) {
+	a := NewAPI(
+		"/api/", &APIConfig{
+			CORS:   &middleware.CORSConfig{AllowOrigins: []string{"http://localhost:4200"}},
+			Muxer:  http.NewServeMux(),
+			Logger: log.New(io.Discard, "", 0),
+		},
+	)
+	res := &countingResource{}
+	a.RegisterCustom("bookings", res, false)
+
+	tests := []struct {
+		name   string
+		method string
+		origin string
+		code   int
+		calls  int
+	}{
+		{"preflight", http.MethodOptions, "http://localhost:4200", http.StatusNoContent, 0},
+		{"refused preflight", http.MethodOptions, "http://attacker.example", http.StatusForbidden, 0},
+		{"actual request", http.MethodGet, "http://localhost:4200", http.StatusOK, 1},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				res.calls = 0
+				r := httptest.NewRequest(tt.method, "/api/bookings", nil)
+				r.Header.Set("Origin", tt.origin)
+				if tt.method == http.MethodOptions {
+					r.Header.Set("Access-Control-Request-Method", http.MethodDelete)
+				}
+				w := httptest.NewRecorder()
+				a.ServeHTTP(w, r)
+				if w.Code != tt.code || res.calls != tt.calls {
+					t.Errorf("[%v] Status %d, %d calls, expecting %d, %d calls", tt.name, w.Code, res.calls, tt.code, tt.calls)
+				}
+			},
+		)
+	}
+}
+
+func TestAPIAuthService(t *testing.T) {
+	js := &JWTAuthService{Service: newTestJWTService(t), Users: NewUserStore()}
+	pair, err := js.Service.IssueTokenPair("user", "ROLE_USER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := MakeAuthService(NewAPIKeyAuthService(nil, nil))
+	keys.KeysPath, keys.RevokeKeyPath = "/", "/revoke"
+
+	for _, order := range []string{"tokens first", "keys first"} {
+		t.Run(
+			order, func(t *testing.T) {
+				a := NewAPI("/api/", &APIConfig{Muxer: http.NewServeMux(), Logger: log.New(io.Discard, "", 0)})
+				tokens := MakeAuthService(js)
+				defer func() {
+					if recover() == nil {
+						t.Errorf("[%v] Registered a protected resource without an AuthService", order)
+					}
+				}()
+				if order == "tokens first" {
+					a.RegisterAuthService("/api/auth", tokens)
+					a.RegisterAuthService("/api/keys", keys)
+				} else {
+					a.RegisterAuthService("/api/keys", keys)
+					a.RegisterAuthService("/api/auth", tokens)
+				}
+				// registering auth services does not change how the
+				// resources authenticate
+				a.SetAuthService(tokens)
+				a.RegisterCustomProtected("bookings", &countingResource{}, AccessControl{})
+				r := httptest.NewRequest(http.MethodGet, "/api/bookings", nil)
+				r.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+				w := httptest.NewRecorder()
+				a.ServeHTTP(w, r)
+				if w.Code != http.StatusOK {
+					t.Errorf("[%v] Status %d, expecting %d", order, w.Code, http.StatusOK)
+				}
+
+				a.SetAuthService(nil)
+				a.RegisterCustomProtected("rooms", &countingResource{}, AccessControl{})
+			},
+		)
+	}
+}
+
+func TestNewAPIDefaultConfig(t *testing.T) {
+	a := NewAPI("/a/", nil)
+	b := NewAPI("/b/", nil)
+	if defaultAPIConfig.Sessions != nil || defaultAPIConfig.Muxer != nil {
+		t.Errorf("NewAPI modified the default config")
+	}
+	if a.mux == b.mux {
+		t.Errorf("NewAPI shared a muxer between two APIs")
+	}
+	if a.conf.Sessions == b.conf.Sessions {
+		t.Errorf("NewAPI shared a session manager between two APIs")
+	}
+	for _, api := range []*API{a, b} {
+		if err := api.Close(); err != nil {
+			t.Errorf("[%v] Close, got %v", api.base, err)
+		}
+	}
+}
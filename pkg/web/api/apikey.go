@@ -0,0 +1,547 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scottcagno/angular-refresher/pkg/web/api/middleware"
+	"github.com/scottcagno/angular-refresher/pkg/web/jwt"
+)
+
+const (
+	apiKeysEndpoint      = "/keys"
+	apiKeyRevokeEndpoint = "/keys/revoke"
+
+	// apiKeyScheme is the Authorization scheme of API keys, as in
+	// "Authorization: ApiKey key_3f9a1c2b7d4e.<secret>".
+	apiKeyScheme = "ApiKey"
+)
+
+var (
+	ErrInvalidAPIKey = errors.New("apikey: invalid key")
+	ErrAPIKeyExpired = errors.New("apikey: key has expired")
+	ErrAPIKeyAddress = errors.New("apikey: key is not allowed from this address")
+	ErrAPIKeyScope   = errors.New("apikey: key is not allowed to access this resource")
+)
+
+// KeyManager is implemented by an Authenticator that manages long-lived keys
+// on behalf of its users. If the Authenticator registered with the API
+// implements it, the endpoints to list and revoke keys are registered along
+// with the register and validate endpoints.
+type KeyManager interface {
+	// ListKeys lists the keys of the authenticated user.
+	ListKeys(w http.ResponseWriter, r *http.Request)
+
+	// RevokeKey revokes one of the keys of the authenticated user.
+	RevokeKey(w http.ResponseWriter, r *http.Request)
+}
+
+// APIKey is a long-lived credential of a machine client, such as a build bot.
+// A key is presented as "<id>.<secret>"; the id is public and shown in
+// listings, while the secret is only returned once, when the key is created.
+type APIKey struct {
+	// ID is the public prefix of the key.
+	ID string `json:"id"`
+
+	// Name describes what the key is used for.
+	Name string `json:"name,omitempty"`
+
+	// Owner is the user that created the key, and the subject of the
+	// requests made with it.
+	Owner string `json:"owner"`
+
+	// Roles holds the roles granted to requests made with the key.
+	Roles []string `json:"roles"`
+
+	// Resources holds the URL path prefixes, such as "/api/bookings", the
+	// key may be used for. A key without resources may be used for all of
+	// them.
+	Resources []string `json:"resources,omitempty"`
+
+	// AllowedIPs holds the addresses and CIDR ranges, such as "10.0.0.0/8",
+	// the key may be used from. A key without addresses may be used from
+	// anywhere.
+	AllowedIPs []string `json:"allowed_ips,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+
+	// ExpiresAt is the time the key expires. A key without it never
+	// expires.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// LastUsedAt and LastUsedIP record the last request made with the key.
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+
+	// secretHash is the SHA-256 digest of the secret.
+	secretHash string
+	networks   []*net.IPNet
+}
+
+// Expired reports whether the key has expired at time t.
+func (k *APIKey) Expired(t time.Time) bool {
+	return k.ExpiresAt != nil && !t.Before(*k.ExpiresAt)
+}
+
+// AllowsIP reports whether the key may be used from ip.
+func (k *APIKey) AllowsIP(ip net.IP) bool {
+	if len(k.networks) == 0 {
+		return true
+	}
+	for _, n := range k.networks {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsPath reports whether the key may be used for the URL path.
+func (k *APIKey) AllowsPath(path string) bool {
+	if len(k.Resources) == 0 {
+		return true
+	}
+	for _, prefix := range k.Resources {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNetworks parses a list of addresses and CIDR ranges.
+func parseNetworks(list []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("apikey: invalid address %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("apikey: invalid address range %q", s)
+		}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+// APIKeyStore holds the API keys. Only a digest of every secret is stored.
+// Because the secrets are generated by the store, and are long and random, a
+// fast digest is sufficient.
+type APIKeyStore struct {
+	// Prefix is prepended to the id of every new key, so that keys are
+	// easy to recognize, for example by secret scanners.
+	Prefix string
+
+	mu    sync.Mutex // guards updates of the last use against revocation
+	store *MemoryStore[string, APIKey]
+	now   func() time.Time
+}
+
+func NewAPIKeyStore(prefix string) *APIKeyStore {
+	return &APIKeyStore{
+		Prefix: prefix,
+		store:  NewMemoryStore[string, APIKey](),
+		now:    time.Now,
+	}
+}
+
+// Create adds a new key to the store using the name, owner, roles, resources,
+// allowed addresses and expiry of the provided key. It returns the new key,
+// along with the full "<id>.<secret>" to hand to the client, which can not
+// be recovered afterwards.
+func (ks *APIKeyStore) Create(key APIKey) (*APIKey, string, error) {
+	if key.Owner == "" {
+		return nil, "", errors.New("apikey: owner is required")
+	}
+	networks, err := parseNetworks(key.AllowedIPs)
+	if err != nil {
+		return nil, "", err
+	}
+	id := make([]byte, 6)
+	secret := make([]byte, 32)
+	if _, err = rand.Read(id); err != nil {
+		return nil, "", err
+	}
+	if _, err = rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	key.ID = hex.EncodeToString(id)
+	if ks.Prefix != "" {
+		key.ID = ks.Prefix + "_" + key.ID
+	}
+	token := key.ID + "." + base64.RawURLEncoding.EncodeToString(secret)
+	key.secretHash = clientSecretHash(token)
+	key.networks = networks
+	key.CreatedAt = ks.now()
+	key.LastUsedAt, key.LastUsedIP = nil, ""
+	if err = ks.store.Add(key.ID, key); err != nil {
+		return nil, "", err
+	}
+	return &key, token, nil
+}
+
+// GetKey returns the key with the provided id.
+func (ks *APIKeyStore) GetKey(id string) (*APIKey, error) {
+	key, err := ks.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// ListKeys returns the keys of the owner, or every key when owner is empty,
+// ordered by creation time.
+func (ks *APIKeyStore) ListKeys(owner string) []APIKey {
+	keys := make([]APIKey, 0)
+	ks.store.Range(
+		func(_ string, key APIKey) bool {
+			if owner == "" || key.Owner == owner {
+				keys = append(keys, key)
+			}
+			return true
+		},
+	)
+	sort.Slice(
+		keys, func(i, j int) bool {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		},
+	)
+	return keys
+}
+
+// Revoke removes the key from the store.
+func (ks *APIKeyStore) Revoke(id string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	_, err := ks.store.Del(id)
+	return err
+}
+
+// Verify looks up the key of the "<id>.<secret>" token, and checks the secret
+// using a constant time comparison and the expiry of the key.
+func (ks *APIKeyStore) Verify(token string) (*APIKey, error) {
+	id, _, _ := strings.Cut(token, ".")
+	key, err := ks.store.Get(id)
+	if err != nil {
+		// Compare anyway, so the time taken does not reveal whether
+		// the key exists
+		subtle.ConstantTimeCompare([]byte(clientSecretHash(token)), []byte(clientSecretHash("")))
+		return nil, ErrInvalidAPIKey
+	}
+	if subtle.ConstantTimeCompare([]byte(clientSecretHash(token)), []byte(key.secretHash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	if key.Expired(ks.now()) {
+		return nil, ErrAPIKeyExpired
+	}
+	return &key, nil
+}
+
+// touch records a use of the key. A key revoked in the meantime is not
+// restored.
+func (ks *APIKeyStore) touch(id string, ip net.IP) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	key, err := ks.store.Get(id)
+	if err != nil {
+		return
+	}
+	now := ks.now()
+	key.LastUsedAt = &now
+	if ip != nil {
+		key.LastUsedIP = ip.String()
+	}
+	ks.store.Set(id, key)
+}
+
+// APIKeyConfig configures how API keys are located and reported on.
+type APIKeyConfig struct {
+	// Header is a header that is accepted to carry the key, in addition to
+	// "Authorization: ApiKey <key>".
+	//
+	// Optional. Default value "X-API-Key"
+	Header string
+
+	// Prefix is prepended to the id of every new key.
+	//
+	// Optional. Default value "key"
+	Prefix string
+
+	// Realm is the protection space reported in the WWW-Authenticate
+	// challenge.
+	//
+	// Optional. Default value "restricted"
+	Realm string
+}
+
+var defaultAPIKeyConfig = &APIKeyConfig{
+	Header: "X-API-Key",
+	Prefix: "key",
+	Realm:  "restricted",
+}
+
+func checkAPIKeyConfig(conf *APIKeyConfig) *APIKeyConfig {
+	if conf == nil {
+		return defaultAPIKeyConfig
+	}
+	c := *conf
+	if c.Header == "" {
+		c.Header = defaultAPIKeyConfig.Header
+	}
+	if c.Prefix == "" {
+		c.Prefix = defaultAPIKeyConfig.Prefix
+	}
+	if c.Realm == "" {
+		c.Realm = defaultAPIKeyConfig.Realm
+	}
+	return &c
+}
+
+// APIKeyAuthService authenticates machine clients using API keys. Keys are
+// created by the register endpoint, which requires the caller to be
+// authenticated, and every key is owned by the user that created it.
+//
+// The client address checked against the allowed addresses of a key is the
+// address of the connection. When the API runs behind a proxy, the proxy
+// must set the request RemoteAddr.
+type APIKeyAuthService struct {
+	Keys   *APIKeyStore
+	Config *APIKeyConfig
+
+	// Fallback authenticates the requests that do not carry an API key,
+	// so that the same resources can be used by people signing in with
+	// another Authenticator. Only Authenticate and Challenge are
+	// delegated to it.
+	Fallback Authenticator
+
+	// Authorizer resolves the roles of the caller when a key is created;
+	// a key can only be granted roles the caller has.
+	//
+	// Optional. Default value uses the DefaultRoleHierarchy
+	Authorizer *Authorizer
+}
+
+func NewAPIKeyAuthService(conf *APIKeyConfig, fallback Authenticator) *APIKeyAuthService {
+	conf = checkAPIKeyConfig(conf)
+	return &APIKeyAuthService{
+		Keys:       NewAPIKeyStore(conf.Prefix),
+		Config:     conf,
+		Fallback:   fallback,
+		Authorizer: NewAuthorizer(nil, nil),
+	}
+}
+
+// requestAPIKey returns the API key carried by the request, if any.
+func (ks *APIKeyAuthService) requestAPIKey(r *http.Request) (string, bool) {
+	scheme, key, found := strings.Cut(r.Header.Get(middleware.HeaderAuthorization), " ")
+	if found && strings.EqualFold(scheme, apiKeyScheme) && strings.TrimSpace(key) != "" {
+		return strings.TrimSpace(key), true
+	}
+	if key = r.Header.Get(checkAPIKeyConfig(ks.Config).Header); key != "" {
+		return key, true
+	}
+	return "", false
+}
+
+// Authenticate implements the Authenticator interface. The principal of a
+// request made with an API key has the owner of the key as its subject, and
+// the id of the key in the "key_id" claim.
+func (ks *APIKeyAuthService) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := ks.requestAPIKey(r)
+	if !ok {
+		if ks.Fallback != nil {
+			return ks.Fallback.Authenticate(r)
+		}
+		return nil, ErrNoCredentials
+	}
+	key, err := ks.Keys.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	ip := remoteIP(r)
+	if !key.AllowsIP(ip) {
+		return nil, ErrAPIKeyAddress
+	}
+	if !key.AllowsPath(r.URL.Path) {
+		return nil, ErrAPIKeyScope
+	}
+	ks.Keys.touch(key.ID, ip)
+	return &Principal{
+		Subject: key.Owner,
+		Roles:   append([]string(nil), key.Roles...),
+		Claims:  jwt.MapClaims{"sub": key.Owner, "key_id": key.ID},
+	}, nil
+}
+
+// Challenge implements the Authenticator interface. A key that may not be
+// used from the client address, or for the resource, is answered with a 403
+// Forbidden.
+func (ks *APIKeyAuthService) Challenge(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrAPIKeyAddress), errors.Is(err, ErrAPIKeyScope):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	case ks.Fallback != nil && !errors.Is(err, ErrInvalidAPIKey) && !errors.Is(err, ErrAPIKeyExpired):
+		ks.Fallback.Challenge(w, r, err)
+		return
+	}
+	challenge := fmt.Sprintf("%s realm=%q", apiKeyScheme, checkAPIKeyConfig(ks.Config).Realm)
+	if err != nil && !errors.Is(err, ErrNoCredentials) {
+		challenge += fmt.Sprintf(", error=\"invalid_key\", error_description=%q", err.Error())
+	}
+	w.Header().Set(middleware.HeaderWWWAuthenticate, challenge)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// Register creates a new key for the authenticated caller. It accepts the
+// "name", "expires_in" (in seconds), and any number of "role", "resource"
+// and "allowed_ip" form values. A key without roles is granted the roles of
+// the caller. The response holds the key, which is only shown once.
+//
+// Keys can not be used to create other keys.
+func (ks *APIKeyAuthService) Register(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	principal, err := ks.Authenticate(r)
+	if err != nil {
+		ks.Challenge(w, r, err)
+		return
+	}
+	if _, ok := principal.Claims["key_id"]; ok {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if err = r.ParseForm(); err != nil {
+		WriteJSON(w, http.StatusBadRequest, M{"err": err.Error()})
+		return
+	}
+	key := APIKey{
+		Name:       r.PostForm.Get("name"),
+		Owner:      principal.Subject,
+		Roles:      r.PostForm["role"],
+		Resources:  r.PostForm["resource"],
+		AllowedIPs: r.PostForm["allowed_ip"],
+	}
+	if len(key.Roles) == 0 {
+		key.Roles = principal.Roles
+	}
+	authorizer := ks.Authorizer
+	if authorizer == nil {
+		authorizer = NewAuthorizer(nil, nil)
+	}
+	for _, role := range key.Roles {
+		if !authorizer.HasRole(principal, role) {
+			WriteJSON(w, http.StatusForbidden, M{"err": fmt.Sprintf("role %q can not be granted", role)})
+			return
+		}
+	}
+	if s := r.PostForm.Get("expires_in"); s != "" {
+		seconds, err := strconv.Atoi(s)
+		if err != nil || seconds <= 0 {
+			WriteJSON(w, http.StatusBadRequest, M{"err": "invalid expires_in"})
+			return
+		}
+		expires := ks.Keys.now().Add(time.Duration(seconds) * time.Second)
+		key.ExpiresAt = &expires
+	}
+	created, token, err := ks.Keys.Create(key)
+	if err != nil {
+		WriteJSON(w, http.StatusBadRequest, M{"err": err.Error()})
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	WriteJSON(w, http.StatusCreated, M{"key": token, "details": created})
+}
+
+// Validate reports whether the request carries a valid key. It responds with a
+// 200 OK if it does, and with a challenge otherwise.
+func (ks *APIKeyAuthService) Validate(w http.ResponseWriter, r *http.Request) {
+	_, err := ks.Authenticate(r)
+	if err != nil {
+		ks.Challenge(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// ListKeys implements the KeyManager interface. Admins are shown every key,
+// everyone else only their own keys. It expects to run behind
+// AuthService.Secure.
+func (ks *APIKeyAuthService) ListKeys(w http.ResponseWriter, r *http.Request) {
+	principal, ok := RequestPrincipal(r)
+	if !ok {
+		ks.Challenge(w, r, ErrNoCredentials)
+		return
+	}
+	owner := principal.Subject
+	if ks.isAdmin(principal) {
+		owner = ""
+	}
+	WriteJSON(w, http.StatusOK, M{"keys": ks.Keys.ListKeys(owner)})
+}
+
+// RevokeKey implements the KeyManager interface. It revokes the key with the
+// id in the "id" form value. Admins may revoke any key, everyone else only
+// their own keys. It expects to run behind AuthService.Secure.
+func (ks *APIKeyAuthService) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", http.MethodPost+", "+http.MethodDelete)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	principal, ok := RequestPrincipal(r)
+	if !ok {
+		ks.Challenge(w, r, ErrNoCredentials)
+		return
+	}
+	id := r.FormValue("id")
+	key, err := ks.Keys.GetKey(id)
+	if err != nil || (key.Owner != principal.Subject && !ks.isAdmin(principal)) {
+		WriteJSON(w, http.StatusNotFound, M{"err": "key not found"})
+		return
+	}
+	_ = ks.Keys.Revoke(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ks *APIKeyAuthService) isAdmin(p *Principal) bool {
+	authorizer := ks.Authorizer
+	if authorizer == nil {
+		authorizer = NewAuthorizer(nil, nil)
+	}
+	return authorizer.HasRole(p, "ROLE_ADMIN")
+}
+
+// remoteIP returns the address of the client connection.
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
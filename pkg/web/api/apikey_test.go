@@ -0,0 +1,164 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAPIKeys(t *testing.T) (*APIKeyAuthService, *JWTAuthService) {
+	js := &JWTAuthService{Service: newTestJWTService(t), Users: NewUserStore()}
+	return NewAPIKeyAuthService(nil, js), js
+}
+
+// createKey creates a key using the register endpoint, signed in as the user.
+func createKey(t *testing.T, ks *APIKeyAuthService, js *JWTAuthService, role string, form url.Values) (*httptest.ResponseRecorder, string) {
+	access := js.Service.GenerateSignedToken("jane", role)
+	w := postForm(http.HandlerFunc(ks.Register), form, access)
+	if w.Code != http.StatusCreated {
+		return w, ""
+	}
+	return w, decodeBody(t, w)["key"].(string)
+}
+
+func keyRequest(path, header, key, remote string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.RemoteAddr = remote
+	if header == "" {
+		r.Header.Set("Authorization", "ApiKey "+key)
+	} else {
+		r.Header.Set(header, key)
+	}
+	return r
+}
+
+func TestAPIKeyAuthenticate(t *testing.T) {
+	ks, js := newTestAPIKeys(t)
+	now := time.Unix(1700000000, 0)
+	ks.Keys.now = func() time.Time { return now }
+
+	_, key := createKey(
+		t, ks, js, "ROLE_USER", url.Values{
+			"name":       {"build bot"},
+			"resource":   {"/api/bookings"},
+			"allowed_ip": {"10.0.0.0/8", "192.0.2.7"},
+			"expires_in": {"3600"},
+		},
+	)
+	if !strings.HasPrefix(key, "key_") {
+		t.Fatalf("Unexpected key %q", key)
+	}
+
+	tests := []struct {
+		name    string
+		r       *http.Request
+		elapsed time.Duration
+		err     error
+	}{
+		{"authorization header", keyRequest("/api/bookings", "", key, "10.1.2.3:4000"), 0, nil},
+		{"custom header", keyRequest("/api/bookings/3", "X-API-Key", key, "192.0.2.7:4000"), 0, nil},
+		{"wrong secret", keyRequest("/api/bookings", "", key+"x", "10.1.2.3:4000"), 0, ErrInvalidAPIKey},
+		{"unknown key", keyRequest("/api/bookings", "", "key_000000000000.secret", "10.1.2.3:4000"), 0, ErrInvalidAPIKey},
+		{"other resource", keyRequest("/api/bookingsx", "", key, "10.1.2.3:4000"), 0, ErrAPIKeyScope},
+		{"other address", keyRequest("/api/bookings", "", key, "192.0.2.8:4000"), 0, ErrAPIKeyAddress},
+		{"expired", keyRequest("/api/bookings", "", key, "10.1.2.3:4000"), time.Hour, ErrAPIKeyExpired},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				ks.Keys.now = func() time.Time { return now.Add(tt.elapsed) }
+				principal, err := ks.Authenticate(tt.r)
+				if err != tt.err {
+					t.Fatalf("[%v] Error %v, expecting %v", tt.name, err, tt.err)
+				}
+				if err == nil && (principal.Subject != "jane" || !principal.HasRole("ROLE_USER")) {
+					t.Errorf("[%v] Unexpected principal %+v", tt.name, principal)
+				}
+			},
+		)
+	}
+
+	keys := ks.Keys.ListKeys("jane")
+	if len(keys) != 1 || keys[0].LastUsedIP != "192.0.2.7" || keys[0].LastUsedAt == nil {
+		t.Errorf("Last use was not recorded: %+v", keys)
+	}
+}
+
+func TestAPIKeyChallenge(t *testing.T) {
+	ks, js := newTestAPIKeys(t)
+	protected := MakeAuthService(ks).Secure(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name      string
+		r         *http.Request
+		code      int
+		challenge string
+	}{
+		{"no credentials", httptest.NewRequest(http.MethodGet, "/api/bookings", nil), http.StatusUnauthorized, "Bearer"},
+		{"invalid key", keyRequest("/api/bookings", "", "key_1.x", "10.1.2.3:4000"), http.StatusUnauthorized, "ApiKey"},
+		{"fallback", httptest.NewRequest(http.MethodGet, "/api/bookings", nil), http.StatusOK, ""},
+	}
+	tests[2].r.Header.Set("Authorization", "Bearer "+js.Service.GenerateSignedToken("jane", "ROLE_USER"))
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				protected.ServeHTTP(w, tt.r)
+				if w.Code != tt.code || !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), tt.challenge) {
+					t.Errorf("[%v] Status %d, challenge %q", tt.name, w.Code, w.Header().Get("WWW-Authenticate"))
+				}
+			},
+		)
+	}
+}
+
+func TestAPIKeyManagement(t *testing.T) {
+	ks, js := newTestAPIKeys(t)
+
+	if w, _ := createKey(t, ks, js, "ROLE_USER", url.Values{"role": {"ROLE_ADMIN"}}); w.Code != http.StatusForbidden {
+		t.Fatalf("Granted a role the user does not have: status %d", w.Code)
+	}
+	if w, _ := createKey(t, ks, js, "ROLE_USER", url.Values{"allowed_ip": {"not an address"}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("Invalid address: status %d, expecting %d", w.Code, http.StatusBadRequest)
+	}
+	_, key := createKey(t, ks, js, "ROLE_USER", nil)
+	_, other := createKey(t, ks, js, "ROLE_USER", nil)
+
+	// Keys can not be used to create other keys
+	r := keyRequest("/api/keys/register", "", key, "10.1.2.3:4000")
+	r.Method = http.MethodPost
+	w := httptest.NewRecorder()
+	ks.Register(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Created a key using a key: status %d", w.Code)
+	}
+
+	as := MakeAuthService(ks)
+	list := as.Secure(http.HandlerFunc(ks.ListKeys))
+	revoke := as.Secure(http.HandlerFunc(ks.RevokeKey))
+	admin := js.Service.GenerateSignedToken("admin", "ROLE_ADMIN")
+	bob := js.Service.GenerateSignedToken("bob", "ROLE_USER")
+
+	if w = postForm(list, nil, bob); len(decodeBody(t, w)["keys"].([]any)) != 0 {
+		t.Fatalf("Listed the keys of another user: %s", w.Body)
+	}
+	if w = postForm(list, nil, admin); len(decodeBody(t, w)["keys"].([]any)) != 2 {
+		t.Fatalf("Admin listing: %s", w.Body)
+	}
+	id, _, _ := strings.Cut(key, ".")
+	if w = postForm(revoke, url.Values{"id": {id}}, bob); w.Code != http.StatusNotFound {
+		t.Fatalf("Revoked the key of another user: status %d", w.Code)
+	}
+	if w = postForm(revoke, url.Values{"id": {id}}, admin); w.Code != http.StatusNoContent {
+		t.Fatalf("Revoke: status %d", w.Code)
+	}
+	if _, err := ks.Authenticate(keyRequest("/api/rooms", "", key, "10.1.2.3:4000")); err != ErrInvalidAPIKey {
+		t.Fatalf("Revoked key: error %v, expecting %v", err, ErrInvalidAPIKey)
+	}
+	if _, err := ks.Authenticate(keyRequest("/api/rooms", "", other, "10.1.2.3:4000")); err != nil {
+		t.Fatalf("Other key: %v", err)
+	}
+}
@@ -0,0 +1,659 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scottcagno/angular-refresher/pkg/web/api/middleware"
+)
+
+// ErrAuditTampered is returned when the audit log does not verify, because
+// an entry was changed, removed or inserted after it was written.
+var ErrAuditTampered = errors.New("audit: log has been tampered with")
+
+// ErrAuditBroken is returned by Append once a failed write could not be
+// removed from the log; appending more entries would break the chain.
+var ErrAuditBroken = errors.New("audit: log is broken by a failed write")
+
+// The actions of the entries recorded by an AuditedRepository. The entries
+// recorded by the Audit middleware use the method of the request instead.
+const (
+	AuditInsert = "insert"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// AuditChange is the change of a single field of an entity. Before is absent
+// for the fields of an inserted entity, and After for those of a deleted one.
+type AuditChange struct {
+	Field  string          `json:"field,omitempty"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// AuditEntry is a single record of the audit log. Every entry holds the hash
+// of the entry before it, so no entry can be changed without breaking the
+// chain.
+type AuditEntry struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal,omitempty"`
+	Roles     []string  `json:"roles,omitempty"`
+
+	// Username is the username presented by a request that did not
+	// authenticate, such as a failed login. It has not been verified.
+	Username string `json:"username,omitempty"`
+
+	Action    string        `json:"action"`
+	Resource  string        `json:"resource"`
+	Key       string        `json:"key,omitempty"`
+	Status    int           `json:"status,omitempty"`
+	Changes   []AuditChange `json:"changes,omitempty"`
+	ClientIP  string        `json:"client_ip,omitempty"`
+	RequestID string        `json:"request_id,omitempty"`
+
+	Prev string `json:"prev"`
+	Hash string `json:"hash"`
+}
+
+// digest returns the hash of the entry, covering every field but the hash
+// itself.
+func (e *AuditEntry) digest() string {
+	c := *e
+	c.Hash = ""
+	b, err := json.Marshal(&c)
+	if err != nil {
+		panic(err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditLog is an append-only file of audit entries, one JSON object per
+// line, chained together by their hashes. The chain shows entries that were
+// changed, or removed from the middle of the file, but not those removed
+// from its end; keep the Head somewhere else to detect those as well.
+type AuditLog struct {
+	path string
+	now  func() time.Time
+
+	mu     sync.Mutex
+	file   auditFile
+	seq    uint64
+	last   string
+	broken bool
+}
+
+// auditFile is the file of an audit log.
+type auditFile interface {
+	io.WriteCloser
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// OpenAuditLog opens the audit log at path, which is created when it does
+// not exist yet. New entries are appended after the existing ones.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	l := &AuditLog{path: path, now: time.Now}
+	fd, err := os.Open(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		err = readAuditEntries(
+			fd, func(e *AuditEntry) error {
+				l.seq, l.last = e.Seq, e.Hash
+				return nil
+			},
+		)
+		fd.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	l.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Append numbers the entry, chains it to the last one and writes it to the
+// log. The entry is synced to the disk before Append returns. When the write
+// fails, the log is truncated to the entries before it; when it can not be,
+// every following Append returns ErrAuditBroken.
+func (l *AuditLog) Append(e *AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return os.ErrClosed
+	}
+	if l.broken {
+		return ErrAuditBroken
+	}
+	if e.Time.IsZero() {
+		e.Time = l.now()
+	}
+	e.Time = e.Time.UTC()
+	e.Seq = l.seq + 1
+	e.Prev = l.last
+	e.Hash = e.digest()
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	info, err := l.file.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err = l.file.Write(append(b, '\n')); err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		// remove what was written of the entry, so the next one is
+		// chained to the last entry of the file
+		if terr := l.file.Truncate(info.Size()); terr != nil {
+			l.broken = true
+			log.Printf("audit: %s: %v", l.path, terr)
+		}
+		return err
+	}
+	l.seq, l.last = e.Seq, e.Hash
+	return nil
+}
+
+// Head returns the number and hash of the last entry.
+func (l *AuditLog) Head() (uint64, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq, l.last
+}
+
+// Verify reads the log, and returns the number of entries that verified. It
+// returns ErrAuditTampered when the chain is broken.
+func (l *AuditLog) Verify() (int, error) {
+	fd, err := os.Open(l.path)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+	return VerifyAuditLog(fd)
+}
+
+// Close closes the log; entries can no longer be appended.
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// VerifyAuditLog reads the entries of an audit log from r, and returns the
+// number of entries that verified. It returns ErrAuditTampered when an entry
+// does not hash to its recorded hash, or is not chained to the one before.
+func VerifyAuditLog(r io.Reader) (int, error) {
+	var n int
+	var prev string
+	err := readAuditEntries(
+		r, func(e *AuditEntry) error {
+			if e.Seq != uint64(n)+1 || e.Prev != prev || e.Hash != e.digest() {
+				return fmt.Errorf("%w: entry %d", ErrAuditTampered, n+1)
+			}
+			n++
+			prev = e.Hash
+			return nil
+		},
+	)
+	return n, err
+}
+
+// readAuditEntries calls fn with every entry read from r.
+func readAuditEntries(r io.Reader, fn func(e *AuditEntry) error) error {
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		b, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(b)) > 0 {
+			var e AuditEntry
+			if jsonErr := json.Unmarshal(b, &e); jsonErr != nil {
+				return fmt.Errorf("%w: line %d: %v", ErrAuditTampered, line, jsonErr)
+			}
+			if fnErr := fn(&e); fnErr != nil {
+				return fnErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// AuditQuery selects entries of the audit log. Empty fields match any entry.
+type AuditQuery struct {
+	Principal string
+	Action    string
+	Resource  string
+	Key       string
+	RequestID string
+	Since     time.Time
+	Until     time.Time
+
+	// Limit is the maximum number of entries returned; the most recent
+	// ones are kept. Zero means no limit.
+	Limit int
+}
+
+func (q *AuditQuery) matches(e *AuditEntry) bool {
+	return (q.Principal == "" || q.Principal == e.Principal || q.Principal == e.Username) &&
+		(q.Action == "" || q.Action == e.Action) &&
+		(q.Resource == "" || q.Resource == e.Resource) &&
+		(q.Key == "" || q.Key == e.Key) &&
+		(q.RequestID == "" || q.RequestID == e.RequestID) &&
+		(q.Since.IsZero() || !e.Time.Before(q.Since)) &&
+		(q.Until.IsZero() || e.Time.Before(q.Until))
+}
+
+// Query returns the entries matching the query, oldest first.
+func (l *AuditLog) Query(q AuditQuery) ([]AuditEntry, error) {
+	fd, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	entries := make([]AuditEntry, 0)
+	err = readAuditEntries(
+		fd, func(e *AuditEntry) error {
+			if !q.matches(e) {
+				return nil
+			}
+			if q.Limit > 0 && len(entries) == q.Limit {
+				entries = append(entries[:0], entries[1:]...)
+			}
+			entries = append(entries, *e)
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+const (
+	defaultAuditQueryLimit = 100
+	maxAuditQueryLimit     = 1000
+)
+
+// ServeHTTP answers the queries of the audit log, taking the AuditQuery
+// fields from the "principal", "action", "resource", "key", "request_id",
+// "since", "until" (RFC 3339) and "limit" query parameters. A "verify"
+// parameter verifies the log instead. The log must only be served to
+// admins; see API.RegisterAudit.
+func (l *AuditLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	params := r.URL.Query()
+	if params.Has("verify") {
+		n, err := l.Verify()
+		if err != nil {
+			WriteJSON(w, http.StatusOK, M{"verified": n, "valid": false, "err": err.Error()})
+			return
+		}
+		WriteJSON(w, http.StatusOK, M{"verified": n, "valid": true})
+		return
+	}
+	q := AuditQuery{
+		Principal: params.Get("principal"),
+		Action:    params.Get("action"),
+		Resource:  params.Get("resource"),
+		Key:       params.Get("key"),
+		RequestID: params.Get("request_id"),
+		Limit:     defaultAuditQueryLimit,
+	}
+	var err error
+	for name, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if v := params.Get(name); v != "" {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				WriteJSON(w, http.StatusBadRequest, M{"err": name + " must be an RFC 3339 time"})
+				return
+			}
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		q.Limit, err = strconv.Atoi(v)
+		if err != nil || q.Limit < 1 || q.Limit > maxAuditQueryLimit {
+			WriteJSON(w, http.StatusBadRequest, M{"err": "limit must be between 1 and " + strconv.Itoa(maxAuditQueryLimit)})
+			return
+		}
+	}
+	entries, err := l.Query(q)
+	if err != nil {
+		WriteJSON(w, http.StatusInternalServerError, M{"err": err.Error()})
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	WriteJSON(w, http.StatusOK, M{"entries": entries})
+}
+
+// auditDiff returns the changes between the JSON encodings of an entity. The
+// fields of objects are compared one by one, and the values of the redacted
+// fields are left out, at any depth.
+func auditDiff(before, after json.RawMessage, redact []string) []AuditChange {
+	if isJSONNull(before) && isJSONNull(after) {
+		return nil
+	}
+	var b, a map[string]json.RawMessage
+	if (!isJSONNull(before) && json.Unmarshal(before, &b) != nil) ||
+		(!isJSONNull(after) && json.Unmarshal(after, &a) != nil) {
+		// not objects, so the values are compared as a whole
+		if bytes.Equal(before, after) {
+			return nil
+		}
+		return []AuditChange{{Before: nullToNil(before), After: nullToNil(after)}}
+	}
+	fields := make([]string, 0, len(b)+len(a))
+	for field := range b {
+		fields = append(fields, field)
+	}
+	for field := range a {
+		if _, found := b[field]; !found {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	var changes []AuditChange
+	for _, field := range fields {
+		bv, av := nullToNil(b[field]), nullToNil(a[field])
+		if bytes.Equal(bv, av) {
+			continue
+		}
+		if isRedacted(field, redact) {
+			bv, av = redacted(bv), redacted(av)
+		} else {
+			bv, av = redactJSON(bv, redact), redactJSON(av, redact)
+		}
+		changes = append(changes, AuditChange{Field: field, Before: bv, After: av})
+	}
+	return changes
+}
+
+var redactedValue = json.RawMessage(`"[redacted]"`)
+
+func isRedacted(field string, redact []string) bool {
+	for _, r := range redact {
+		if strings.EqualFold(r, field) {
+			return true
+		}
+	}
+	return false
+}
+
+func redacted(v json.RawMessage) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	return redactedValue
+}
+
+// redactJSON redacts the fields of the objects nested in the value.
+func redactJSON(v json.RawMessage, redact []string) json.RawMessage {
+	if v == nil || len(redact) == 0 || !bytes.ContainsAny(v, "{") {
+		return v
+	}
+	d := json.NewDecoder(bytes.NewReader(v))
+	d.UseNumber()
+	var value any
+	if err := d.Decode(&value); err != nil {
+		return v
+	}
+	b, err := json.Marshal(redactValue(value, redact))
+	if err != nil {
+		return v
+	}
+	return b
+}
+
+func redactValue(value any, redact []string) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for field, fv := range v {
+			if isRedacted(field, redact) {
+				v[field] = redactedValue
+			} else {
+				v[field] = redactValue(fv, redact)
+			}
+		}
+	case []any:
+		for i := range v {
+			v[i] = redactValue(v[i], redact)
+		}
+	}
+	return value
+}
+
+func isJSONNull(v json.RawMessage) bool {
+	return len(v) == 0 || string(v) == "null"
+}
+
+func nullToNil(v json.RawMessage) json.RawMessage {
+	if isJSONNull(v) {
+		return nil
+	}
+	return v
+}
+
+// auditScope holds what is known about the request an entry is recorded
+// for. The Audit middleware places it in the request context, and it learns
+// about the principal once the request has been authenticated.
+type auditScope struct {
+	requestID string
+	clientIP  string
+
+	mu        sync.Mutex
+	principal *Principal
+}
+
+// auditScopeKey is the context key used to store the *auditScope.
+type auditScopeKey struct{}
+
+func auditScopeFrom(ctx context.Context) (*auditScope, bool) {
+	s, ok := ctx.Value(auditScopeKey{}).(*auditScope)
+	return s, ok
+}
+
+func (s *auditScope) setPrincipal(p *Principal) {
+	s.mu.Lock()
+	s.principal = p
+	s.mu.Unlock()
+}
+
+// fill copies the request details into the entry.
+func (s *auditScope) fill(e *AuditEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.principal != nil {
+		e.Principal = s.principal.Subject
+		e.Roles = append([]string(nil), s.principal.Roles...)
+	}
+	e.ClientIP = s.clientIP
+	e.RequestID = s.requestID
+}
+
+// AuditConfig configures the Audit middleware.
+type AuditConfig struct {
+	// Log receives the entries.
+	//
+	// Required.
+	Log *AuditLog
+
+	// TrustForwardedFor takes the client address from the last address of
+	// the X-Forwarded-For header, which must then be set by a proxy in
+	// front of the api.
+	//
+	// Optional. Default value false
+	TrustForwardedFor bool
+
+	// Logger reports the entries that could not be written.
+	//
+	// Optional. Default value log.Default()
+	Logger *log.Logger
+}
+
+func checkAuditConfig(conf *AuditConfig) *AuditConfig {
+	if conf == nil || conf.Log == nil {
+		panic("audit: a log is required")
+	}
+	c := *conf
+	if c.Logger == nil {
+		c.Logger = log.Default()
+	}
+	return &c
+}
+
+// Audit returns a middleware recording the requests that may change data, and
+// those that fail authentication or authorization, in the audit log. It gives
+// every request an id, taken from the X-Request-ID header when the client
+// sent a valid one, which is returned in the same header and recorded along
+// with the changes made by any AuditedRepository used with the request
+// context.
+func Audit(conf *AuditConfig) middleware.Middleware {
+	c := checkAuditConfig(conf)
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			scope := &auditScope{
+				requestID: requestID(r),
+				clientIP:  clientIP(r, c.TrustForwardedFor),
+			}
+			w.Header().Set(middleware.HeaderXRequestID, scope.requestID)
+			aw := &auditWriter{ResponseWriter: w}
+			next.ServeHTTP(aw, r.WithContext(context.WithValue(r.Context(), auditScopeKey{}, scope)))
+			if aw.status == 0 {
+				aw.status = http.StatusOK
+			}
+			if isSafeMethod(r.Method) && aw.status != http.StatusUnauthorized && aw.status != http.StatusForbidden {
+				return
+			}
+			e := &AuditEntry{
+				Action:   r.Method,
+				Resource: r.URL.Path,
+				Key:      r.URL.Query().Get("id"),
+				Status:   aw.status,
+			}
+			scope.fill(e)
+			if e.Principal == "" {
+				e.Username, _, _ = r.BasicAuth()
+			}
+			if err := c.Log.Append(e); err != nil {
+				c.Logger.Printf("audit: %s %s (request %s) was not recorded: %v\n", r.Method, r.URL.Path, scope.requestID, err)
+			}
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// requestID returns the id sent by the client when it is a short token, and
+// a new random id otherwise.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(middleware.HeaderXRequestID); id != "" && len(id) <= 64 {
+		valid := true
+		for _, c := range id {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			return id
+		}
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// clientIP returns the address of the client, which is taken from the
+// X-Forwarded-For header when it is trusted.
+func clientIP(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		if xff := r.Header.Get(middleware.HeaderXForwardedFor); xff != "" {
+			addrs := strings.Split(xff, ",")
+			if ip := net.ParseIP(strings.TrimSpace(addrs[len(addrs)-1])); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+	if ip := remoteIP(r); ip != nil {
+		return ip.String()
+	}
+	return r.RemoteAddr
+}
+
+// auditWriter records the status code of the response.
+type auditWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *auditWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *auditWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+func (w *auditWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// ContextRepository is a Repository that can act on behalf of the caller of
+// a request, such as the AuditedRepository.
+type ContextRepository[T any, K comparable] interface {
+	Repository[T, K]
+
+	// WithContext returns the repository acting on behalf of the caller
+	// of the request ctx belongs to.
+	WithContext(ctx context.Context) Repository[T, K]
+}
+
+// RepositoryWithContext returns the repository acting on behalf of the caller
+// of the request ctx belongs to, when the repository is a ContextRepository,
+// and the repository itself otherwise. Handlers use it to make their changes,
+// for example:
+//
+//	err := api.RepositoryWithContext(c.Repository, r.Context()).Update(id, room)
+func RepositoryWithContext[T any, K comparable](repo Repository[T, K], ctx context.Context) Repository[T, K] {
+	if cr, ok := repo.(ContextRepository[T, K]); ok {
+		return cr.WithContext(ctx)
+	}
+	return repo
+}
+
+// AuditedRepository records the changes made to a Repository in an AuditLog,
+// along with the fields that changed. Changes made using WithContext are
+// recorded along with the principal, client address and id of the request,
+// others as changes made by the system itself.
+//
+// The entities are compared using their JSON encoding. The repository keeps
+// the encoding of every entity, so the changes of entities that were
+// modified in place before being updated are still recorded.
+type AuditedRepository[T any, K comparable] struct {
+	Repository[T, K]
+
+	// Redact holds the names of the JSON fields, such as passwords, whose
+	// values are left out of the entries, also when they are found in the
+	// nested objects. Their changes are still recorded.
+	Redact []string
+
+	name  string
+	log   *AuditLog
+	keyOf func(T) K
+
+	mu        sync.Mutex
+	snapshots map[K]json.RawMessage
+}
+
+// NewAuditedRepository returns the repo recording its changes in the log,
+// using name as the resource of the entries. The keyOf function returns the
+// key an entity is stored under.
+func NewAuditedRepository[T any, K comparable](name string, repo Repository[T, K], log *AuditLog, keyOf func(T) K) *AuditedRepository[T, K] {
+	ar := &AuditedRepository[T, K]{
+		Repository: repo,
+		name:       name,
+		log:        log,
+		keyOf:      keyOf,
+		snapshots:  make(map[K]json.RawMessage),
+	}
+	// an empty repository reports an error, which is fine here
+	all, _ := repo.Find(func(t T) bool { return true })
+	for _, t := range all {
+		ar.snapshots[keyOf(t)] = auditSnapshot(t)
+	}
+	return ar
+}
+
+// WithContext implements the ContextRepository interface.
+func (ar *AuditedRepository[T, K]) WithContext(ctx context.Context) Repository[T, K] {
+	return &auditedContextRepository[T, K]{AuditedRepository: ar, ctx: ctx}
+}
+
+// Exec implements the Repository interface, recording the changes made to
+// the entities for which exec reports true.
+func (ar *AuditedRepository[T, K]) Exec(query QueryFunc[T], exec QueryFunc[T]) (int, error) {
+	return ar.exec(context.Background(), query, exec)
+}
+
+// Insert implements the Repository interface.
+func (ar *AuditedRepository[T, K]) Insert(newK K, newT T) error {
+	return ar.insert(context.Background(), newK, newT)
+}
+
+// Update implements the Repository interface.
+func (ar *AuditedRepository[T, K]) Update(oldK K, newT T) error {
+	return ar.update(context.Background(), oldK, newT)
+}
+
+// Delete implements the Repository interface.
+func (ar *AuditedRepository[T, K]) Delete(oldK K) error {
+	return ar.delete(context.Background(), oldK)
+}
+
+func (ar *AuditedRepository[T, K]) exec(ctx context.Context, query QueryFunc[T], exec QueryFunc[T]) (int, error) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	var changed []T
+	n, err := ar.Repository.Exec(
+		query, func(t T) bool {
+			if exec(t) {
+				changed = append(changed, t)
+				return true
+			}
+			return false
+		},
+	)
+	for _, t := range changed {
+		k := ar.keyOf(t)
+		before, after := ar.snapshots[k], auditSnapshot(t)
+		ar.snapshots[k] = after
+		if auditErr := ar.record(ctx, AuditUpdate, k, before, after); auditErr != nil && err == nil {
+			err = auditErr
+		}
+	}
+	return n, err
+}
+
+func (ar *AuditedRepository[T, K]) insert(ctx context.Context, newK K, newT T) error {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if err := ar.Repository.Insert(newK, newT); err != nil {
+		return err
+	}
+	after := auditSnapshot(newT)
+	ar.snapshots[newK] = after
+	return ar.record(ctx, AuditInsert, newK, nil, after)
+}
+
+func (ar *AuditedRepository[T, K]) update(ctx context.Context, oldK K, newT T) error {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if err := ar.Repository.Update(oldK, newT); err != nil {
+		return err
+	}
+	before, after := ar.snapshots[oldK], auditSnapshot(newT)
+	ar.snapshots[oldK] = after
+	return ar.record(ctx, AuditUpdate, oldK, before, after)
+}
+
+func (ar *AuditedRepository[T, K]) delete(ctx context.Context, oldK K) error {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if err := ar.Repository.Delete(oldK); err != nil {
+		return err
+	}
+	before := ar.snapshots[oldK]
+	delete(ar.snapshots, oldK)
+	return ar.record(ctx, AuditDelete, oldK, before, nil)
+}
+
+// record appends the entry of a change. The change has been made already,
+// so a failure to record it is returned to the caller, rather than hidden.
+func (ar *AuditedRepository[T, K]) record(ctx context.Context, action string, k K, before, after json.RawMessage) error {
+	e := &AuditEntry{
+		Action:   action,
+		Resource: ar.name,
+		Key:      fmt.Sprint(k),
+		Changes:  auditDiff(before, after, ar.Redact),
+	}
+	if scope, ok := auditScopeFrom(ctx); ok {
+		scope.fill(e)
+	}
+	if err := ar.log.Append(e); err != nil {
+		return fmt.Errorf("audit: %s %s %v was not recorded: %w", action, ar.name, k, err)
+	}
+	return nil
+}
+
+func auditSnapshot(t any) json.RawMessage {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// auditedContextRepository is an AuditedRepository acting on behalf of the
+// caller of a request.
+type auditedContextRepository[T any, K comparable] struct {
+	*AuditedRepository[T, K]
+	ctx context.Context
+}
+
+func (r *auditedContextRepository[T, K]) Exec(query QueryFunc[T], exec QueryFunc[T]) (int, error) {
+	return r.exec(r.ctx, query, exec)
+}
+
+func (r *auditedContextRepository[T, K]) Insert(newK K, newT T) error {
+	return r.insert(r.ctx, newK, newT)
+}
+
+func (r *auditedContextRepository[T, K]) Update(oldK K, newT T) error {
+	return r.update(r.ctx, oldK, newT)
+}
+
+func (r *auditedContextRepository[T, K]) Delete(oldK K) error {
+	return r.delete(r.ctx, oldK)
+}
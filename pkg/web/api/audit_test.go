@@ -0,0 +1,307 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type auditedRoom struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Password string `json:"password,omitempty"`
+}
+
+// roomResource renames the room with the id of the request, using the
+// repository on behalf of the caller.
+type roomResource struct {
+	repo Repository[*auditedRoom, int]
+}
+
+func (rr *roomResource) Custom() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+		err := RepositoryWithContext(rr.repo, r.Context()).Update(id, &auditedRoom{ID: id, Name: r.URL.Query().Get("name")})
+		if err != nil {
+			WriteJSON(w, http.StatusNotFound, M{"err": err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func openTestAuditLog(t *testing.T) *AuditLog {
+	l, err := OpenAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range []string{AuditInsert, AuditUpdate, AuditDelete} {
+		if err = l.Append(&AuditEntry{Principal: "admin", Action: action, Resource: "rooms", Key: "1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	// reopening continues the chain
+	l, err = OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err = l.Append(&AuditEntry{Principal: "user", Action: http.MethodPost, Resource: "/api/auth/register"}); err != nil {
+		t.Fatal(err)
+	}
+	if seq, _ := l.Head(); seq != 4 {
+		t.Fatalf("Head %d, expecting 4", seq)
+	}
+	if n, err := l.Verify(); n != 4 || err != nil {
+		t.Fatalf("Verified %d entries (%v), expecting 4", n, err)
+	}
+	entries, err := l.Query(AuditQuery{Principal: "admin", Limit: 2})
+	if err != nil || len(entries) != 2 || entries[0].Action != AuditUpdate || entries[1].Action != AuditDelete {
+		t.Fatalf("Unexpected entries %+v (%v)", entries, err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	tests := []struct {
+		name   string
+		log    []byte
+		verify int
+	}{
+		{
+			"changed", bytes.Replace(data, []byte(`"principal":"user"`), []byte(`"principal":"root"`), 1), 3,
+		},
+		{
+			"removed", bytes.Join([][]byte{lines[0], lines[2], lines[3]}, nil), 1,
+		},
+		{
+			"reordered", bytes.Join([][]byte{lines[1], lines[0], lines[2], lines[3]}, nil), 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				n, err := VerifyAuditLog(bytes.NewReader(tt.log))
+				if n != tt.verify || !errors.Is(err, ErrAuditTampered) {
+					t.Errorf("[%v] Verified %d entries (%v), expecting %d and %v", tt.name, n, err, tt.verify, ErrAuditTampered)
+				}
+			},
+		)
+	}
+}
+
+// failingFile writes half of every write to the file, and fails
+type failingFile struct {
+	*os.File
+	truncate error
+}
+
+func (f *failingFile) Write(b []byte) (int, error) {
+	n, _ := f.File.Write(b[:len(b)/2])
+	return n, io.ErrShortWrite
+}
+
+func (f *failingFile) Truncate(size int64) error {
+	if f.truncate != nil {
+		return f.truncate
+	}
+	return f.File.Truncate(size)
+}
+
+func TestAuditLogFailedWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		truncate error
+		want     error
+	}{
+		{"truncated", nil, nil},
+		{"broken", os.ErrPermission, ErrAuditBroken},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				path := filepath.Join(t.TempDir(), "audit.log")
+				l, err := OpenAuditLog(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer l.Close()
+				if err = l.Append(&AuditEntry{Action: AuditInsert, Resource: "rooms"}); err != nil {
+					t.Fatal(err)
+				}
+				file := l.file
+				l.file = &failingFile{File: file.(*os.File), truncate: tt.truncate}
+				if err = l.Append(&AuditEntry{Action: AuditUpdate, Resource: "rooms"}); err != io.ErrShortWrite {
+					t.Fatalf("[%v] Append error %v, expecting %v", tt.name, err, io.ErrShortWrite)
+				}
+				l.file = file
+				if err = l.Append(&AuditEntry{Action: AuditDelete, Resource: "rooms"}); err != tt.want {
+					t.Fatalf("[%v] Append error %v, expecting %v", tt.name, err, tt.want)
+				}
+				if tt.want != nil {
+					return
+				}
+				if n, err := l.Verify(); n != 2 || err != nil {
+					t.Errorf("[%v] Verified %d entries (%v), expecting 2", tt.name, n, err)
+				}
+			},
+		)
+	}
+}
+
+func TestAuditDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		before  string
+		after   string
+		changes string
+	}{
+		{
+			"insert", ``, `{"id":1,"name":"Blue","password":"x"}`,
+			`[{"field":"id","after":1},{"field":"name","after":"Blue"},{"field":"password","after":"[redacted]"}]`,
+		},
+		{
+			"update", `{"id":1,"name":"Blue","password":"x"}`, `{"id":1,"name":"Red","password":"y"}`,
+			`[{"field":"name","before":"Blue","after":"Red"},{"field":"password","before":"[redacted]","after":"[redacted]"}]`,
+		},
+		{
+			"nested", `{"id":1,"user":{"name":"user","password":"x"}}`, `{"id":1,"user":{"name":"admin","password":"y"}}`,
+			`[{"field":"user","before":{"name":"user","password":"[redacted]"},"after":{"name":"admin","password":"[redacted]"}}]`,
+		},
+		{"unchanged", `{"id":1}`, `{"id":1}`, `null`},
+		{"delete", `{"id":1}`, `null`, `[{"field":"id","before":1}]`},
+		{"not objects", `3`, `4`, `[{"before":3,"after":4}]`},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				changes := auditDiff(json.RawMessage(tt.before), json.RawMessage(tt.after), []string{"Password"})
+				got, _ := json.Marshal(changes)
+				if string(got) != tt.changes {
+					t.Errorf("[%v] Changes %s, expecting %s", tt.name, got, tt.changes)
+				}
+			},
+		)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	l := openTestAuditLog(t)
+	repo := NewMemoryRepository[*auditedRoom, int]()
+	room := &auditedRoom{ID: 1, Name: "Blue"}
+	if err := repo.Insert(room.ID, room); err != nil {
+		t.Fatal(err)
+	}
+	rooms := NewAuditedRepository[*auditedRoom, int]("rooms", repo, l, func(r *auditedRoom) int { return r.ID })
+
+	js := &JWTAuthService{Service: newTestJWTService(t), Users: NewUserStore()}
+	a := NewAPI(
+		"/api/", &APIConfig{
+			Muxer:  http.NewServeMux(),
+			Logger: log.New(io.Discard, "", 0),
+			Audit:  &AuditConfig{Log: l, TrustForwardedFor: true},
+		},
+	)
+	as := MakeAuthService(js)
+	a.RegisterAuthService("/api/auth", as)
+	a.SetAuthService(as)
+	a.RegisterCustomProtected("rooms", &roomResource{repo: rooms}, AccessControl{})
+	a.RegisterAudit("audit", l)
+
+	serve := func(method, target, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		r.RemoteAddr = "10.0.0.2:4711"
+		r.Header.Set("X-Forwarded-For", "203.0.113.9, 192.0.2.7")
+		r.Header.Set("X-Request-ID", "req-"+strconv.Itoa(len(target)))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, r)
+		return w
+	}
+
+	user := js.Service.GenerateSignedToken("user", "ROLE_USER")
+	if w := serve(http.MethodPut, "/api/rooms?id=1&name=Red", user); w.Code != http.StatusNoContent {
+		t.Fatalf("Status %d, expecting %d", w.Code, http.StatusNoContent)
+	}
+	if w := serve(http.MethodDelete, "/api/rooms?id=1", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Status %d, expecting %d", w.Code, http.StatusUnauthorized)
+	}
+	// reads are not recorded
+	serve(http.MethodGet, "/api/auth/validate", user)
+	// system changes have no principal
+	if err := rooms.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := l.Query(AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AuditEntry{
+		{Seq: 1, Principal: "user", Action: AuditUpdate, Resource: "rooms", Key: "1", ClientIP: "192.0.2.7", RequestID: "req-24"},
+		{Seq: 2, Principal: "user", Action: http.MethodPut, Resource: "/api/rooms", Key: "1", Status: http.StatusNoContent, ClientIP: "192.0.2.7", RequestID: "req-24"},
+		{Seq: 3, Action: http.MethodDelete, Resource: "/api/rooms", Key: "1", Status: http.StatusUnauthorized, ClientIP: "192.0.2.7", RequestID: "req-15"},
+		{Seq: 4, Action: AuditDelete, Resource: "rooms", Key: "1"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("Recorded %d entries, expecting %d: %+v", len(entries), len(want), entries)
+	}
+	for i, e := range entries {
+		w := want[i]
+		if e.Seq != w.Seq || e.Principal != w.Principal || e.Action != w.Action || e.Resource != w.Resource ||
+			e.Key != w.Key || e.Status != w.Status || e.ClientIP != w.ClientIP || e.RequestID != w.RequestID {
+			t.Errorf("Entry %d is %+v, expecting %+v", i+1, e, w)
+		}
+	}
+	if c := entries[0].Changes; len(c) != 1 || c[0].Field != "name" || string(c[0].Before) != `"Blue"` || string(c[0].After) != `"Red"` {
+		t.Errorf("Unexpected changes %+v", c)
+	}
+	if c := entries[3].Changes; len(c) != 2 || string(c[1].Before) != `"Red"` {
+		t.Errorf("Unexpected changes %+v", c)
+	}
+
+	// only admins query the log
+	if w := serve(http.MethodGet, "/api/audit", user); w.Code != http.StatusForbidden {
+		t.Fatalf("Status %d for a user, expecting %d", w.Code, http.StatusForbidden)
+	}
+	admin := js.Service.GenerateSignedToken("admin", "ROLE_ADMIN")
+	w := serve(http.MethodGet, "/api/audit?resource=rooms&action=update", admin)
+	var res struct {
+		Entries []AuditEntry `json:"entries"`
+	}
+	if err = json.NewDecoder(w.Body).Decode(&res); err != nil || w.Code != http.StatusOK || len(res.Entries) != 1 {
+		t.Fatalf("Status %d, %d entries (%v), expecting %d and 1 entry", w.Code, len(res.Entries), err, http.StatusOK)
+	}
+	w = serve(http.MethodGet, "/api/audit?verify", admin)
+	if body := w.Body.String(); !strings.Contains(body, `"valid":true`) {
+		t.Errorf("Verify returned %s", body)
+	}
+	if w = serve(http.MethodGet, "/api/audit?since=yesterday", admin); w.Code != http.StatusBadRequest {
+		t.Errorf("Status %d, expecting %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -4,6 +4,13 @@ import (
 	"net/http"
 )
 
+// Secure passes the Basic authentication credentials of the request to the
+// CheckAuth method of the resource.
+//
+// Deprecated: the resource has to check the credentials itself, which is
+// easily done in a way that leaks them through timing. Use the
+// middleware.BasicAuth or middleware.DigestAuth middleware, which can check
+// the credentials against a UserStore.
 func (api *API) Secure(re SecureResource) http.HandlerFunc {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
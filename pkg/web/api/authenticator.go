@@ -2,174 +2,430 @@ package api
 
 import (
 	"errors"
-	"log"
+	"fmt"
 	"net/http"
-	"time"
+	"strings"
 
 	"github.com/scottcagno/angular-refresher/pkg/web"
+	"github.com/scottcagno/angular-refresher/pkg/web/api/middleware"
 	"github.com/scottcagno/angular-refresher/pkg/web/jwt"
 )
 
 const (
 	registerEndpoint = "/register"
 	validateEndpoint = "/validate"
+	refreshEndpoint  = "/refresh"
+	logoutEndpoint   = "/logout"
+
+	refreshCookieName = "refresh_token"
 )
 
 type Authenticator interface {
 	Register(w http.ResponseWriter, r *http.Request)
 	Validate(w http.ResponseWriter, r *http.Request)
+
+	// Authenticate inspects the request and returns the authenticated
+	// principal. It should return ErrNoCredentials when the request
+	// carries no credentials at all, and any other error when the
+	// credentials that were provided could not be verified.
+	Authenticate(r *http.Request) (*Principal, error)
+
+	// Challenge writes the response that is sent to the client when
+	// Authenticate fails with the provided error.
+	Challenge(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// Refresher is implemented by an Authenticator that issues short-lived
+// credentials which can be renewed. If the Authenticator registered with
+// the API implements it, the refresh and logout endpoints are registered
+// along with the register and validate endpoints.
+type Refresher interface {
+	// Refresh exchanges a refresh token for a new set of credentials.
+	Refresh(w http.ResponseWriter, r *http.Request)
+
+	// Logout revokes the credentials held by the client.
+	Logout(w http.ResponseWriter, r *http.Request)
 }
 
 type AuthService struct {
-	RegisterPath string
-	ValidatePath string
+	RegisterPath   string
+	ValidatePath   string
+	RefreshPath    string
+	LogoutPath     string
+	MFAPath        string
+	MFAEnrollPath  string
+	MFAConfirmPath string
+	KeysPath       string
+	RevokeKeyPath  string
 	Authenticator
 }
 
 func MakeAuthService(authenticator Authenticator) *AuthService {
 	return &AuthService{
-		RegisterPath:  registerEndpoint,
-		ValidatePath:  validateEndpoint,
-		Authenticator: authenticator,
+		RegisterPath:   registerEndpoint,
+		ValidatePath:   validateEndpoint,
+		RefreshPath:    refreshEndpoint,
+		LogoutPath:     logoutEndpoint,
+		MFAPath:        mfaEndpoint,
+		MFAEnrollPath:  mfaEnrollEndpoint,
+		MFAConfirmPath: mfaConfirmEndpoint,
+		KeysPath:       apiKeysEndpoint,
+		RevokeKeyPath:  apiKeyRevokeEndpoint,
+		Authenticator:  authenticator,
 	}
 }
 
+// Secure wraps next so that it is only called once the request has been
+// successfully authenticated. The authenticated *Principal is placed into the
+// request context, and any request that fails authentication is answered by
+// the Authenticator's Challenge and never reaches next.
 func (s *AuthService) Secure(next http.Handler) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
-			s.Authenticator.Validate(w, r)
-			next.ServeHTTP(w, r)
+			principal, err := s.Authenticator.Authenticate(r)
+			if err != nil {
+				s.Authenticator.Challenge(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(NewPrincipalContext(r.Context(), principal)))
 		},
 	)
 }
 
+// ErrNoCredentials is returned by an Authenticator when the request does
+// not carry any credentials at all.
+var ErrNoCredentials = errors.New("no credentials present in request")
+
+// JWTAuthConfig configures how the JWT authentication middleware locates
+// and reports on tokens.
+type JWTAuthConfig struct {
+	// Extractors are tried in order until one of them yields a token.
+	//
+	// Optional. Default value is the Authorization bearer header followed
+	// by the "token" cookie.
+	Extractors []jwt.TokenExtractor
+
+	// Realm is the protection space reported in the WWW-Authenticate
+	// challenge.
+	//
+	// Optional. Default value "restricted"
+	Realm string
+}
+
+var defaultJWTAuthConfig = &JWTAuthConfig{
+	Extractors: []jwt.TokenExtractor{
+		jwt.HeaderExtractor(),
+		jwt.CookieExtractor("token"),
+	},
+	Realm: "restricted",
+}
+
+func checkJWTAuthConfig(conf *JWTAuthConfig) *JWTAuthConfig {
+	if conf == nil {
+		return defaultJWTAuthConfig
+	}
+	c := *conf
+	if len(c.Extractors) == 0 {
+		c.Extractors = defaultJWTAuthConfig.Extractors
+	}
+	if c.Realm == "" {
+		c.Realm = defaultJWTAuthConfig.Realm
+	}
+	return &c
+}
+
+// authenticateJWT extracts a token from the request using the configured
+// extractors, validates it using the provided service and returns the
+// principal described by the token claims.
+func authenticateJWT(service *jwt.JWTService, conf *JWTAuthConfig, r *http.Request) (*Principal, error) {
+	tokenString, err := jwt.ExtractToken(r, conf.Extractors...)
+	if err != nil {
+		if errors.Is(err, jwt.ErrNoTokenInRequest) {
+			return nil, ErrNoCredentials
+		}
+		return nil, err
+	}
+	token, err := service.ValidateTokenString(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return PrincipalFromClaims(claims), nil
+}
+
+// bearerChallenge writes a 401 response along with a WWW-Authenticate header
+// using the "Bearer" scheme as described in RFC 6750. If err is anything other
+// than ErrNoCredentials, the challenge reports the token as invalid.
+func bearerChallenge(w http.ResponseWriter, realm string, err error) {
+	challenge := fmt.Sprintf("Bearer realm=%q", realm)
+	if err != nil && !errors.Is(err, ErrNoCredentials) {
+		challenge += fmt.Sprintf(
+			", error=\"invalid_token\", error_description=%q", strings.ReplaceAll(err.Error(), `"`, `'`),
+		)
+	}
+	w.Header().Set(middleware.HeaderWWWAuthenticate, challenge)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// RequireJWT returns a middleware that only calls the next handler if the
+// request carries a token that can be validated by the provided service.
+// Tokens are located using the extractors in the provided config (in order),
+// and the resulting *Principal is placed in the request context. Requests
+// failing authentication are answered with a 401 and a Bearer challenge. A
+// nil config will use the default values.
+func RequireJWT(service *jwt.JWTService, conf *JWTAuthConfig) middleware.Middleware {
+	conf = checkJWTAuthConfig(conf)
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			principal, err := authenticateJWT(service, conf, r)
+			if err != nil {
+				bearerChallenge(w, conf.Realm, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(NewPrincipalContext(r.Context(), principal)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
 type JWTAuthService struct {
 	Service *jwt.JWTService
 	Users   *UserStore
+	Config  *JWTAuthConfig
+
+	// MFA holds the second factor of the users. When set, users enrolled
+	// in it must complete the login using VerifyMFA.
+	MFA *MFAStore
 }
 
+// NewJWTAuthService returns a JWTAuthService signing tokens using the RSA key
+// pair found in the provided files, which are generated when they do not
+// exist yet. It panics if the key pair can not be loaded.
 func NewJWTAuthService(privateKeyFile, publicKeyFile string, defaultUsers ...*web.SystemUser) *JWTAuthService {
+	jwtAuthService, err := NewJWTAuthServiceWithConfig(
+		&jwt.JWTServiceConfig{
+			Keys: jwt.KeyFilesOrGenerate(privateKeyFile, publicKeyFile),
+		}, defaultUsers...,
+	)
+	if err != nil {
+		panic(err)
+	}
+	return jwtAuthService
+}
+
+// NewJWTAuthServiceWithConfig returns a JWTAuthService using a new JWTService
+// configured using the provided config.
+func NewJWTAuthServiceWithConfig(conf *jwt.JWTServiceConfig, defaultUsers ...*web.SystemUser) (*JWTAuthService, error) {
+	service, err := jwt.NewJWTServiceWithConfig(conf)
+	if err != nil {
+		return nil, err
+	}
 	jwtAuthService := &JWTAuthService{
-		Service: jwt.NewJWTService(privateKeyFile, publicKeyFile),
+		Service: service,
 		Users:   NewUserStore(),
+		Config:  checkJWTAuthConfig(nil),
 	}
 	if defaultUsers != nil {
 		for _, user := range defaultUsers {
 			jwtAuthService.Users.AddUser(user.Username, user.Password, user.Role)
 		}
 	}
-	return jwtAuthService
+	return jwtAuthService, nil
 }
 
 func (js *JWTAuthService) Register(w http.ResponseWriter, r *http.Request) {
 	// Check for HTTP basic authentication
 	username, password, hasBasicAuth := r.BasicAuth()
-	log.Println(username, password, hasBasicAuth)
 	if !hasBasicAuth {
 		w.Header().Set("WWW-Authenticate", `Basic realm="restricted", charset="UTF-8"`)
 		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
-	var user *web.SystemUser
-	// Received valid HTTP basic authentication token; check store
-	user = js.Users.GetUser(username)
-	if user == nil {
-		// User was not found, we must fill out a new one based on the provided
-		// basic authentication we received.
-		user.Username = username
-		user.Password = password
-		user.Role = "ROLE_USER"
-	}
-	// Found valid user in store, generate and sign new token
-	tokenString := js.Service.GenerateSignedToken(user.Username, user.Password, user.Role)
-	// Create a new cookie, and add the JWT token string to the cookie
-	chocoChip := &http.Cookie{
-		Name:       "token",
-		Value:      tokenString,
-		Path:       "/",
-		Domain:     "",
-		Expires:    time.Time{},
-		RawExpires: "",
-		MaxAge:     js.Service.ExpireTime().Second(),
-		Secure:     true, // set true, when in production
-		HttpOnly:   true,
-		SameSite:   0,
-		Raw:        "",
-		Unparsed:   nil,
-	}
-	// Add the cookie to the request
-	http.SetCookie(w, chocoChip)
+	// Received HTTP basic authentication credentials; verify them against
+	// the hashed passwords in the store
+	user, ok := js.Users.Authenticate(username, password)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="restricted", charset="UTF-8"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	// The user has a second factor, so instead of the token pair, issue a
+	// short-lived token that is only accepted by VerifyMFA
+	if js.MFA != nil && js.MFA.Enrolled(user.Username) {
+		pending, err := js.Service.IssuePurposeToken(user.Username, user.Role, mfaPurpose, mfaPendingTTL)
+		if err != nil {
+			WriteJSON(w, http.StatusInternalServerError, M{"err": err.Error()})
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		WriteJSON(
+			w, http.StatusOK, M{
+				"mfa_required": true,
+				"mfa_token":    pending,
+				"expires_in":   int(mfaPendingTTL.Seconds()),
+			},
+		)
+		return
+	}
+	// The user must use a second factor, but has not enrolled in one yet,
+	// so only issue a token that can be used to enroll
+	if js.MFA != nil && js.MFA.Required(user.Role) {
+		enrollToken, err := js.Service.IssuePurposeToken(user.Username, user.Role, mfaEnrollPurpose, mfaEnrollTTL)
+		if err != nil {
+			WriteJSON(w, http.StatusInternalServerError, M{"err": err.Error()})
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		WriteJSON(
+			w, http.StatusOK, M{
+				"mfa_enrollment_required": true,
+				"enroll_token":            enrollToken,
+				"expires_in":              int(mfaEnrollTTL.Seconds()),
+			},
+		)
+		return
+	}
+	// Found valid user in store, issue a new access and refresh token
+	pair, err := js.Service.IssueTokenPair(user.Username, user.Role)
+	if err != nil {
+		WriteJSON(w, http.StatusInternalServerError, M{"err": err.Error()})
+		return
+	}
+	js.writeTokenPair(w, pair)
 }
 
-func (js *JWTAuthService) Validate(w http.ResponseWriter, r *http.Request) {
-	var err error
-	// Check for a cookie containing our JWT string
-	chocoChip, err := r.Cookie("token")
-	if err != nil && errors.Is(err, http.ErrNoCookie) {
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+// Refresh exchanges the refresh token, found in the "refresh_token" cookie or
+// form value, for a new token pair. A refresh token can only be used once;
+// presenting a used token again revokes every token descending from the same
+// login. Refresh tokens issued to an OAuth client can only be used at the
+// token endpoint of the OAuth server.
+func (js *JWTAuthService) Refresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 		return
 	}
-	tokenString := chocoChip.Value
+	refreshToken, found := requestRefreshToken(r)
+	if !found {
+		WriteJSON(w, http.StatusUnauthorized, M{"err": "refresh token required"})
+		return
+	}
+	pair, err := js.Service.RefreshWith(
+		refreshToken, func(rt *jwt.RefreshToken) error {
+			if _, ok := rt.Claims["client_id"]; ok {
+				return jwt.ErrRefreshTokenInvalid
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		clearTokenCookies(w)
+		WriteJSON(w, http.StatusUnauthorized, M{"err": err.Error()})
+		return
+	}
+	js.writeTokenPair(w, pair)
+}
+
+// Logout revokes the refresh token held by the client, along with every
+// other token descending from the same login, revokes the access token that
+// was presented and clears the token cookies.
+func (js *JWTAuthService) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if refreshToken, found := requestRefreshToken(r); found {
+		// An unknown token has nothing left to revoke, so the error
+		// can be ignored
+		_ = js.Service.RevokeRefreshToken(refreshToken)
+	}
+	if tokenString, err := jwt.ExtractToken(r, checkJWTAuthConfig(js.Config).Extractors...); err == nil {
+		// Revoke the access token as well, so it can not be used for
+		// the remainder of its lifetime
+		_ = js.Service.RevokeToken(tokenString)
+	}
+	clearTokenCookies(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeTokenPair sets the token cookies and writes the pair as the response.
+func (js *JWTAuthService) writeTokenPair(w http.ResponseWriter, pair *jwt.TokenPair) {
+	setTokenCookies(w, js.Service, pair)
+	WriteJSON(w, http.StatusOK, pair)
+}
+
+// setTokenCookies sets the "token" and "refresh_token" cookies holding the pair.
+func setTokenCookies(w http.ResponseWriter, service *jwt.JWTService, pair *jwt.TokenPair) {
+	http.SetCookie(
+		w, &http.Cookie{
+			Name:     "token",
+			Value:    pair.AccessToken,
+			Path:     "/",
+			MaxAge:   int(service.AccessTTL().Seconds()),
+			Secure:   true, // set true, when in production
+			HttpOnly: true,
+		},
+	)
+	http.SetCookie(
+		w, &http.Cookie{
+			Name:     refreshCookieName,
+			Value:    pair.RefreshToken,
+			Path:     "/",
+			MaxAge:   int(service.RefreshTTL().Seconds()),
+			Secure:   true, // set true, when in production
+			HttpOnly: true,
+		},
+	)
+}
+
+// requestRefreshToken returns the refresh token from the "refresh_token"
+// cookie, or from the form value of the same name.
+func requestRefreshToken(r *http.Request) (string, bool) {
+	if c, err := r.Cookie(refreshCookieName); err == nil && c.Value != "" {
+		return c.Value, true
+	}
+	if v := r.PostFormValue(refreshCookieName); v != "" {
+		return v, true
+	}
+	return "", false
+}
 
-	// // Check the header for an Authorization Bearer
-	// bearer := r.Header.Get("Authorization")
-	// if bearer == "" || !strings.HasPrefix(bearer, "Bearer") {
-	// 	// We did not find an Authorization Bearer <token>, so we return a 401 status
-	// 	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-	// 	return
-	// }
-	// tokenString := bearer
+// clearTokenCookies instructs the client to remove the token cookies.
+func clearTokenCookies(w http.ResponseWriter) {
+	for _, name := range []string{"token", refreshCookieName} {
+		http.SetCookie(w, &http.Cookie{Name: name, Value: "", Path: "/", MaxAge: -1, Secure: true, HttpOnly: true})
+	}
+}
 
-	// Attempt to validate the token string we found in the cookie
-	_, err = js.Service.ValidateTokenString(tokenString)
+// Validate reports whether the request carries a valid token. It responds
+// with a 200 OK if it does, and with a Bearer challenge otherwise.
+func (js *JWTAuthService) Validate(w http.ResponseWriter, r *http.Request) {
+	_, err := js.Authenticate(r)
 	if err != nil {
-		if _, is := err.(*jwt.ValidationError); is {
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-			return
-		}
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		js.Challenge(w, r, err)
 		return
 	}
 	// If we get here, then our token was valid, return 200 OK
 	w.WriteHeader(http.StatusOK)
-	return
 }
 
+// Authenticate implements the Authenticator interface.
+func (js *JWTAuthService) Authenticate(r *http.Request) (*Principal, error) {
+	return authenticateJWT(js.Service, checkJWTAuthConfig(js.Config), r)
+}
+
+// Challenge implements the Authenticator interface.
+func (js *JWTAuthService) Challenge(w http.ResponseWriter, r *http.Request, err error) {
+	bearerChallenge(w, checkJWTAuthConfig(js.Config).Realm, err)
+}
+
+// VerifyJWT wraps next so that it is only called when the request carries a
+// valid token, looked up first in the Authorization header and then in the
+// "token" cookie.
 func VerifyJWT(service *jwt.JWTService, next http.HandlerFunc) http.HandlerFunc {
-	const errCode = http.StatusUnauthorized
-	return func(w http.ResponseWriter, r *http.Request) {
-		//
-		// Check for a cookie containing a JWT token
-		//
-		cook, err := r.Cookie("token")
-		if err != nil && errors.Is(err, http.ErrNoCookie) {
-			// No cookie found, return status unauthorized
-			http.Error(w, http.StatusText(errCode), errCode)
-			return
-		}
-		tokenString := cook.Value
-		// //
-		// // Check the header for an Authorization Bearer
-		// //
-		// bearer := r.Header.Get("Authorization")
-		// if bearer == "" || !strings.HasPrefix(bearer, "Bearer") {
-		// 	// No Bearer token found, return status unauthorized
-		// 	http.Error(w, http.StatusText(errCode), errCode)
-		// 	return
-		// }
-		// tokenString := bearer
-
-		//
-		// Attempt to validate the token string
-		//
-		_, err = service.ValidateTokenString(tokenString)
-		if err != nil {
-			if _, is := err.(*jwt.ValidationError); is {
-				http.Error(w, http.StatusText(errCode), errCode)
-				return
-			}
-			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
-			return
-		}
-	}
+	return RequireJWT(service, nil)(next).ServeHTTP
 }
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/scottcagno/angular-refresher/pkg/web/jwt"
+)
+
+func newTestJWTService(t *testing.T) *jwt.JWTService {
+	service, err := jwt.NewJWTServiceWithConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+func TestRequireJWT(t *testing.T) {
+	service := newTestJWTService(t)
+	valid := service.GenerateSignedToken("admin", "ROLE_ADMIN")
+
+	var called bool
+	var principal *Principal
+	protected := RequireJWT(service, nil)(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				principal, _ = RequestPrincipal(r)
+			},
+		),
+	)
+
+	tests := []struct {
+		name      string
+		prepare   func(r *http.Request)
+		code      int
+		challenge string
+	}{
+		{"no token", func(r *http.Request) {}, http.StatusUnauthorized, `Bearer realm="restricted"`},
+		{
+			"malformed bearer",
+			func(r *http.Request) { r.Header.Set("Authorization", "Bearer not.a.token") },
+			http.StatusUnauthorized,
+			`error="invalid_token"`,
+		},
+		{
+			"tampered cookie",
+			func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "token", Value: valid + "x"}) },
+			http.StatusUnauthorized,
+			`error="invalid_token"`,
+		},
+		{
+			"valid bearer",
+			func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+valid) },
+			http.StatusOK,
+			"",
+		},
+		{
+			"valid cookie",
+			func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "token", Value: valid}) },
+			http.StatusOK,
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				called, principal = false, nil
+				r := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+				tt.prepare(r)
+				w := httptest.NewRecorder()
+				protected.ServeHTTP(w, r)
+				if w.Code != tt.code {
+					t.Fatalf("got status %d, want %d", w.Code, tt.code)
+				}
+				if tt.code != http.StatusOK {
+					if called {
+						t.Fatalf("protected handler was called for an unauthorized request")
+					}
+					if got := w.Header().Get("WWW-Authenticate"); !strings.Contains(got, tt.challenge) {
+						t.Fatalf("got challenge %q, want it to contain %q", got, tt.challenge)
+					}
+					return
+				}
+				if !called {
+					t.Fatalf("protected handler was not called")
+				}
+				if principal == nil || principal.Subject != "admin" || !principal.HasRole("ROLE_ADMIN") {
+					t.Fatalf("unexpected principal in context: %+v", principal)
+				}
+			},
+		)
+	}
+}
+
+func TestRequireJWTExtractorOrder(t *testing.T) {
+	service := newTestJWTService(t)
+	valid := service.GenerateSignedToken("admin", "ROLE_ADMIN")
+
+	// Only look in the cookie, so a valid bearer header must be ignored.
+	conf := &JWTAuthConfig{Extractors: []jwt.TokenExtractor{jwt.CookieExtractor("token")}}
+	protected := RequireJWT(service, conf)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	r.Header.Set("Authorization", "Bearer "+valid)
+	w := httptest.NewRecorder()
+	protected.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestAuthServiceSecure(t *testing.T) {
+	js := &JWTAuthService{Service: newTestJWTService(t), Users: NewUserStore()}
+	as := MakeAuthService(js)
+
+	var called bool
+	protected := as.Secure(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	w := httptest.NewRecorder()
+	protected.ServeHTTP(w, r)
+	if called || w.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthorized request reached the resource (status %d)", w.Code)
+	}
+}
+
+func TestJWTAuthServiceRefresh(t *testing.T) {
+	js := &JWTAuthService{Service: newTestJWTService(t), Users: NewUserStore()}
+	pair, err := js.Service.IssueTokenPair("admin", "ROLE_ADMIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(fn http.HandlerFunc, refresh string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/auth", nil)
+		r.AddCookie(&http.Cookie{Name: refreshCookieName, Value: refresh})
+		w := httptest.NewRecorder()
+		fn(w, r)
+		return w
+	}
+
+	w := post(js.Refresh, pair.RefreshToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh: got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var rotated string
+	for _, c := range w.Result().Cookies() {
+		if c.Name == refreshCookieName {
+			rotated = c.Value
+		}
+	}
+	if rotated == "" || rotated == pair.RefreshToken {
+		t.Fatalf("refresh token cookie was not rotated")
+	}
+	if w = post(js.Refresh, pair.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Fatalf("reused refresh token: got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	pair, err = js.Service.IssueTokenPair("admin", "ROLE_ADMIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w = post(js.Logout, pair.RefreshToken); w.Code != http.StatusNoContent {
+		t.Fatalf("logout: got status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w = post(js.Refresh, pair.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh after logout: got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// refresh tokens of OAuth clients are bound to the OAuth token endpoint
+	pair, err = js.Service.IssueTokenPairWithClaims("admin", "ROLE_ADMIN", oauthClaims("mobile", "bookings"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w = post(js.Refresh, pair.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Fatalf("oauth refresh token: got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if _, err = js.Service.Refresh(pair.RefreshToken); err != nil {
+		t.Fatalf("rejected oauth refresh token was used: %v", err)
+	}
+}
@@ -22,8 +22,8 @@ func NewMemoryStore[K comparable, V any]() *MemoryStore[K, V] {
 }
 
 func (ms *MemoryStore[K, V]) Add(k K, v V) error {
-	_, added := ms.store.LoadOrStore(k, v)
-	if !added {
+	_, loaded := ms.store.LoadOrStore(k, v)
+	if loaded {
 		return ErrExists
 	}
 	return nil
@@ -48,3 +48,12 @@ func (ms *MemoryStore[K, V]) Del(k K) (V, error) {
 	}
 	return prev.(V), nil
 }
+
+// Range calls fn for every key and value in the store, until fn returns false.
+func (ms *MemoryStore[K, V]) Range(fn func(k K, v V) bool) {
+	ms.store.Range(
+		func(k, v any) bool {
+			return fn(k.(K), v.(V))
+		},
+	)
+}
@@ -0,0 +1,397 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scottcagno/angular-refresher/pkg/web/jwt"
+	"github.com/scottcagno/angular-refresher/pkg/web/totp"
+)
+
+const (
+	mfaEndpoint        = "/mfa"
+	mfaEnrollEndpoint  = "/mfa/enroll"
+	mfaConfirmEndpoint = "/mfa/confirm"
+
+	// mfaPurpose is the purpose of the "mfa pending" token issued by the
+	// first step of a login, see jwt.JWTService.IssuePurposeToken.
+	mfaPurpose    = "mfa"
+	mfaPendingTTL = 5 * time.Minute
+
+	// mfaEnrollPurpose is the purpose of the token issued instead of the
+	// token pair to users that must enroll in a second factor first. It is
+	// only accepted by EnrollMFA and ConfirmMFA.
+	mfaEnrollPurpose = "mfa-enroll"
+	mfaEnrollTTL     = 15 * time.Minute
+)
+
+var (
+	ErrMFANotEnrolled = errors.New("mfa: user is not enrolled")
+	ErrMFAEnrolled    = errors.New("mfa: user is already enrolled")
+	ErrMFAInvalidCode = errors.New("mfa: invalid code")
+	ErrMFALocked      = errors.New("mfa: too many invalid codes, try again later")
+)
+
+// SecondFactor is implemented by an Authenticator that supports a second
+// login factor. If the Authenticator registered with the API implements it,
+// the endpoints to enroll in, and to verify, the second factor are registered
+// along with the register and validate endpoints.
+type SecondFactor interface {
+	// VerifyMFA completes a login that requires a second factor.
+	VerifyMFA(w http.ResponseWriter, r *http.Request)
+
+	// EnrollMFA starts the enrollment of the authenticated user. It
+	// authenticates the request itself, because users that must enroll
+	// before they are issued tokens only have a limited enrollment token.
+	EnrollMFA(w http.ResponseWriter, r *http.Request)
+
+	// ConfirmMFA completes the enrollment of the authenticated user, and
+	// authenticates the request the same way as EnrollMFA.
+	ConfirmMFA(w http.ResponseWriter, r *http.Request)
+}
+
+// mfaFactor is the TOTP second factor of a user. The recovery codes are
+// stored as SHA-256 digests; a used code is replaced by an empty string.
+type mfaFactor struct {
+	mu          sync.Mutex
+	secret      string
+	confirmed   bool
+	lastCounter int64
+	recovery    []string
+	failures    int
+	lockedUntil time.Time
+}
+
+// MFAStore holds the TOTP (RFC 6238) second factor of every enrolled user. A
+// code can only be used once, and after MaxFailures invalid codes in a row the
+// factor is locked for LockoutDuration.
+type MFAStore struct {
+	// Issuer is the name of the service shown by authenticator apps.
+	Issuer string
+
+	// Params are the parameters used for new enrollments.
+	Params totp.Params
+
+	// RecoveryCodes is the number of recovery codes issued on enrollment.
+	RecoveryCodes int
+
+	// RequiredRoles are the roles whose users must use a second factor.
+	// Until they enroll, their logins only yield a token to enroll with.
+	RequiredRoles []string
+
+	MaxFailures     int
+	LockoutDuration time.Duration
+
+	factors *MemoryStore[string, *mfaFactor]
+	now     func() time.Time
+}
+
+func NewMFAStore(issuer string) *MFAStore {
+	return &MFAStore{
+		Issuer:          issuer,
+		Params:          totp.DefaultParams,
+		RecoveryCodes:   10,
+		RequiredRoles:   []string{"ROLE_ADMIN"},
+		MaxFailures:     5,
+		LockoutDuration: 5 * time.Minute,
+		factors:         NewMemoryStore[string, *mfaFactor](),
+		now:             time.Now,
+	}
+}
+
+// Enroll generates a new secret for the user. The enrollment only takes
+// effect once it is confirmed with a valid code; until then it can be
+// restarted. A confirmed enrollment must be disabled before enrolling again.
+func (ms *MFAStore) Enroll(username string) (*totp.Key, error) {
+	if f, err := ms.factors.Get(username); err == nil {
+		f.mu.Lock()
+		confirmed := f.confirmed
+		f.mu.Unlock()
+		if confirmed {
+			return nil, ErrMFAEnrolled
+		}
+	}
+	key, err := totp.NewKey(ms.Issuer, username)
+	if err != nil {
+		return nil, err
+	}
+	key.Params = ms.Params
+	ms.factors.Set(username, &mfaFactor{secret: key.Secret})
+	return key, nil
+}
+
+// Confirm completes the enrollment of the user using a code from the
+// authenticator app, and returns the recovery codes. The recovery codes are
+// only stored hashed, so they can not be shown again.
+func (ms *MFAStore) Confirm(username, code string) ([]string, error) {
+	f, err := ms.factors.Get(username)
+	if err != nil {
+		return nil, ErrMFANotEnrolled
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.confirmed {
+		return nil, ErrMFAEnrolled
+	}
+	counter, ok := totp.Validate(code, f.secret, ms.now(), ms.Params)
+	if !ok {
+		return nil, ErrMFAInvalidCode
+	}
+	codes, err := totp.GenerateRecoveryCodes(ms.RecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+	f.recovery = make([]string, len(codes))
+	for i, c := range codes {
+		f.recovery[i] = recoveryCodeHash(c)
+	}
+	f.confirmed, f.lastCounter = true, counter
+	return codes, nil
+}
+
+// Enrolled reports whether the user has a confirmed second factor.
+func (ms *MFAStore) Enrolled(username string) bool {
+	f, err := ms.factors.Get(username)
+	if err != nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.confirmed
+}
+
+// Required reports whether users with the role must use a second factor.
+func (ms *MFAStore) Required(role string) bool {
+	for _, r := range ms.RequiredRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Requires reports whether a login by the user needs a second factor,
+// because the user is enrolled or has a role that requires one.
+func (ms *MFAStore) Requires(username, role string) bool {
+	return ms.Enrolled(username) || ms.Required(role)
+}
+
+// Verify checks a code from the authenticator app, or one of the recovery
+// codes of the user. A code is only accepted once: a TOTP code must belong to
+// a later time step than the last accepted code, and a recovery code is
+// removed when it is used.
+func (ms *MFAStore) Verify(username, code string) error {
+	f, err := ms.factors.Get(username)
+	if err != nil {
+		return ErrMFANotEnrolled
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.confirmed {
+		return ErrMFANotEnrolled
+	}
+	now := ms.now()
+	if now.Before(f.lockedUntil) {
+		return ErrMFALocked
+	}
+	code = strings.TrimSpace(code)
+	if counter, ok := totp.Validate(code, f.secret, now, ms.Params); ok && counter > f.lastCounter {
+		f.lastCounter, f.failures = counter, 0
+		return nil
+	}
+	if ms.useRecoveryCode(f, code) {
+		f.failures = 0
+		return nil
+	}
+	f.failures++
+	if f.failures >= ms.MaxFailures {
+		f.failures, f.lockedUntil = 0, now.Add(ms.LockoutDuration)
+	}
+	return ErrMFAInvalidCode
+}
+
+// useRecoveryCode removes the matching recovery code. Every code is compared,
+// so the time taken does not reveal which one matched.
+func (ms *MFAStore) useRecoveryCode(f *mfaFactor, code string) bool {
+	hash := recoveryCodeHash(totp.NormalizeRecoveryCode(code))
+	found := -1
+	for i, h := range f.recovery {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			found = i
+		}
+	}
+	if found < 0 {
+		return false
+	}
+	f.recovery[found] = ""
+	return true
+}
+
+// RecoveryCodesLeft returns the number of unused recovery codes of the user.
+func (ms *MFAStore) RecoveryCodesLeft(username string) int {
+	f, err := ms.factors.Get(username)
+	if err != nil {
+		return 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int
+	for _, h := range f.recovery {
+		if h != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Disable removes the second factor of the user.
+func (ms *MFAStore) Disable(username string) error {
+	_, err := ms.factors.Del(username)
+	if err != nil {
+		return ErrMFANotEnrolled
+	}
+	return nil
+}
+
+// recoveryCodeHash returns the digest a recovery code is stored as. Because
+// the codes are generated, and are long and random, a fast digest is
+// sufficient.
+func recoveryCodeHash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyMFA completes the second step of a login. It accepts the "mfa pending"
+// token returned by Register, in the "mfa_token" form value or the
+// Authorization bearer header, along with a code in the "code" form value,
+// and issues the token pair once the code is verified. The pending token can
+// only be used once.
+func (js *JWTAuthService) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	if js.MFA == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	pending := r.PostFormValue("mfa_token")
+	if pending == "" {
+		pending, _ = jwt.ExtractToken(r, jwt.HeaderExtractor())
+	}
+	token, err := js.Service.ValidatePurposeToken(pending, mfaPurpose)
+	if err != nil {
+		WriteJSON(w, http.StatusUnauthorized, M{"err": "invalid or expired mfa token"})
+		return
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	username, _ := claims["sub"].(string)
+	role, _ := claims["role"].(string)
+	err = js.MFA.Verify(username, r.PostFormValue("code"))
+	if err != nil {
+		WriteJSON(w, http.StatusUnauthorized, M{"err": err.Error()})
+		return
+	}
+	_ = js.Service.RevokeToken(pending)
+	pair, err := js.Service.IssueTokenPair(username, role)
+	if err != nil {
+		WriteJSON(w, http.StatusInternalServerError, M{"err": err.Error()})
+		return
+	}
+	js.writeTokenPair(w, pair)
+}
+
+// EnrollMFA starts the enrollment of the authenticated user, and responds with
+// the secret and the otpauth:// URI to add to an authenticator app. The user
+// is authenticated by an access token, or by the enrollment token Register
+// issues to users that must enroll first.
+func (js *JWTAuthService) EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	principal, ok := js.mfaPrincipal(w, r)
+	if !ok {
+		return
+	}
+	key, err := js.MFA.Enroll(principal.Subject)
+	if err == ErrMFAEnrolled {
+		WriteJSON(w, http.StatusConflict, M{"err": err.Error()})
+		return
+	}
+	if err != nil {
+		WriteJSON(w, http.StatusInternalServerError, M{"err": err.Error()})
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	WriteJSON(w, http.StatusOK, M{"secret": key.Secret, "uri": key.URI()})
+}
+
+// ConfirmMFA completes the enrollment of the authenticated user using the
+// code in the "code" form value, and responds with the recovery codes.
+func (js *JWTAuthService) ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	principal, ok := js.mfaPrincipal(w, r)
+	if !ok {
+		return
+	}
+	codes, err := js.MFA.Confirm(principal.Subject, r.PostFormValue("code"))
+	switch err {
+	case nil:
+		if enrollToken, found := js.enrollToken(r); found {
+			_ = js.Service.RevokeToken(enrollToken)
+		}
+	case ErrMFAEnrolled:
+		WriteJSON(w, http.StatusConflict, M{"err": err.Error()})
+		return
+	case ErrMFANotEnrolled, ErrMFAInvalidCode:
+		WriteJSON(w, http.StatusBadRequest, M{"err": err.Error()})
+		return
+	default:
+		WriteJSON(w, http.StatusInternalServerError, M{"err": err.Error()})
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	WriteJSON(w, http.StatusOK, M{"recovery_codes": codes})
+}
+
+// mfaPrincipal returns the principal of the enrollment endpoints, which is
+// either placed in the request context by AuthService.Secure, authenticated
+// by an access token, or by an enrollment token.
+func (js *JWTAuthService) mfaPrincipal(w http.ResponseWriter, r *http.Request) (*Principal, bool) {
+	if js.MFA == nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	if principal, ok := RequestPrincipal(r); ok {
+		return principal, true
+	}
+	if principal, err := js.Authenticate(r); err == nil {
+		return principal, true
+	}
+	if enrollToken, found := js.enrollToken(r); found {
+		token, err := js.Service.ValidatePurposeToken(enrollToken, mfaEnrollPurpose)
+		if err == nil {
+			return PrincipalFromClaims(token.Claims.(jwt.MapClaims)), true
+		}
+	}
+	js.Challenge(w, r, ErrNoCredentials)
+	return nil, false
+}
+
+// enrollToken returns the enrollment token in the "enroll_token" form value,
+// or the Authorization bearer header.
+func (js *JWTAuthService) enrollToken(r *http.Request) (string, bool) {
+	if t := r.PostFormValue("enroll_token"); t != "" {
+		return t, true
+	}
+	t, err := jwt.ExtractToken(r, jwt.HeaderExtractor())
+	return t, err == nil && t != ""
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scottcagno/angular-refresher/pkg/web/password"
+	"github.com/scottcagno/angular-refresher/pkg/web/totp"
+)
+
+func newTestMFA(t *testing.T) (*JWTAuthService, *AuthService, *time.Time) {
+	users := NewUserStoreWithHasher(password.NewHasher(password.Params{LogN: 4}))
+	users.AddUser("admin", "secret", "ROLE_ADMIN")
+	js := &JWTAuthService{Service: newTestJWTService(t), Users: users, MFA: NewMFAStore("Room Booking")}
+	now := time.Unix(1700000000, 0)
+	js.MFA.now = func() time.Time { return now }
+	return js, MakeAuthService(js), &now
+}
+
+func postForm(h http.Handler, form url.Values, bearer string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/auth/mfa", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if bearer != "" {
+		r.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder) M {
+	var m M
+	if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+		t.Fatalf("Invalid response body %q: %v", w.Body, err)
+	}
+	return m
+}
+
+// enroll enrolls the admin user and returns the secret and recovery codes.
+func enroll(t *testing.T, js *JWTAuthService, as *AuthService, now time.Time) (string, []string) {
+	pair, err := js.Service.IssueTokenPair("admin", "ROLE_ADMIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := postForm(as.Secure(http.HandlerFunc(js.EnrollMFA)), nil, pair.AccessToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Enroll: status %d: %s", w.Code, w.Body)
+	}
+	body := decodeBody(t, w)
+	secret := body["secret"].(string)
+	if uri := body["uri"].(string); !strings.HasPrefix(uri, "otpauth://totp/Room%20Booking:admin?") {
+		t.Errorf("Unexpected key URI %q", uri)
+	}
+	if js.MFA.Enrolled("admin") {
+		t.Fatalf("Enrollment took effect before it was confirmed")
+	}
+
+	confirm := as.Secure(http.HandlerFunc(js.ConfirmMFA))
+	if w = postForm(confirm, url.Values{"code": {"000000"}}, pair.AccessToken); w.Code != http.StatusBadRequest {
+		t.Fatalf("Confirm with a wrong code: status %d, expecting %d", w.Code, http.StatusBadRequest)
+	}
+	code, _ := totp.GenerateCode(secret, now, js.MFA.Params)
+	if w = postForm(confirm, url.Values{"code": {code}}, pair.AccessToken); w.Code != http.StatusOK {
+		t.Fatalf("Confirm: status %d: %s", w.Code, w.Body)
+	}
+	var codes []string
+	for _, c := range decodeBody(t, w)["recovery_codes"].([]any) {
+		codes = append(codes, c.(string))
+	}
+	if len(codes) != js.MFA.RecoveryCodes || !js.MFA.Enrolled("admin") {
+		t.Fatalf("Confirm returned %d recovery codes, enrolled %v", len(codes), js.MFA.Enrolled("admin"))
+	}
+	return secret, codes
+}
+
+// firstStep logs in with the password and returns the "mfa pending" token.
+func firstStep(t *testing.T, js *JWTAuthService) string {
+	r := httptest.NewRequest(http.MethodPost, "/api/auth/register", nil)
+	r.SetBasicAuth("admin", "secret")
+	w := httptest.NewRecorder()
+	js.Register(w, r)
+	body := decodeBody(t, w)
+	if w.Code != http.StatusOK || body["mfa_required"] != true || len(w.Result().Cookies()) != 0 {
+		t.Fatalf("Register: status %d, cookies %v: %s", w.Code, w.Result().Cookies(), w.Body)
+	}
+	return body["mfa_token"].(string)
+}
+
+func TestMFALogin(t *testing.T) {
+	js, as, now := newTestMFA(t)
+	secret, _ := enroll(t, js, as, *now)
+
+	pending := firstStep(t, js)
+
+	// The pending token is not an access token
+	var called bool
+	protected := RequireJWT(js.Service, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+	if w := postForm(protected, nil, pending); called || w.Code != http.StatusUnauthorized {
+		t.Fatalf("Pending token reached a protected resource (status %d)", w.Code)
+	}
+
+	// The code used to confirm the enrollment can not be used again
+	code, _ := totp.GenerateCode(secret, *now, js.MFA.Params)
+	w := postForm(http.HandlerFunc(js.VerifyMFA), url.Values{"mfa_token": {pending}, "code": {code}}, "")
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Replayed code: status %d, expecting %d", w.Code, http.StatusUnauthorized)
+	}
+
+	*now = now.Add(30 * time.Second)
+	code, _ = totp.GenerateCode(secret, *now, js.MFA.Params)
+	w = postForm(http.HandlerFunc(js.VerifyMFA), url.Values{"code": {code}}, pending)
+	if w.Code != http.StatusOK {
+		t.Fatalf("VerifyMFA: status %d: %s", w.Code, w.Body)
+	}
+	access := decodeBody(t, w)["access_token"].(string)
+	r := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	r.Header.Set("Authorization", "Bearer "+access)
+	if principal, err := js.Authenticate(r); err != nil || principal.Subject != "admin" || !principal.HasRole("ROLE_ADMIN") {
+		t.Fatalf("VerifyMFA issued an invalid access token: %+v, %v", principal, err)
+	}
+
+	// The pending token can only be used once
+	*now = now.Add(30 * time.Second)
+	code, _ = totp.GenerateCode(secret, *now, js.MFA.Params)
+	w = postForm(http.HandlerFunc(js.VerifyMFA), url.Values{"mfa_token": {pending}, "code": {code}}, "")
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Reused pending token: status %d, expecting %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestMFARecoveryCodes(t *testing.T) {
+	js, as, now := newTestMFA(t)
+	_, codes := enroll(t, js, as, *now)
+
+	verify := func(code string) int {
+		w := postForm(http.HandlerFunc(js.VerifyMFA), url.Values{"code": {code}}, firstStep(t, js))
+		return w.Code
+	}
+	// Recovery codes are accepted as entered by hand, but only once
+	if code := verify(strings.ToUpper(strings.Replace(codes[0], "-", " ", 1))); code != http.StatusOK {
+		t.Fatalf("Recovery code: status %d, expecting %d", code, http.StatusOK)
+	}
+	if code := verify(codes[0]); code != http.StatusUnauthorized {
+		t.Fatalf("Reused recovery code: status %d, expecting %d", code, http.StatusUnauthorized)
+	}
+	if n := js.MFA.RecoveryCodesLeft("admin"); n != len(codes)-1 {
+		t.Errorf("%d recovery codes left, expecting %d", n, len(codes)-1)
+	}
+
+	// After too many invalid codes, even a valid code is rejected for a while
+	for i := 1; i < js.MFA.MaxFailures; i++ {
+		verify("000000")
+	}
+	if err := js.MFA.Verify("admin", codes[1]); err != ErrMFALocked {
+		t.Fatalf("Verify when locked: error %v, expecting %v", err, ErrMFALocked)
+	}
+	*now = now.Add(js.MFA.LockoutDuration)
+	if err := js.MFA.Verify("admin", codes[1]); err != nil {
+		t.Fatalf("Verify after the lockout: %v", err)
+	}
+}
+
+func TestMFARequiredRoles(t *testing.T) {
+	js, _, now := newTestMFA(t)
+	js.Users.AddUser("user", "secret", "ROLE_USER")
+	register := func(username string) (*httptest.ResponseRecorder, M) {
+		r := httptest.NewRequest(http.MethodPost, "/api/auth/register", nil)
+		r.SetBasicAuth(username, "secret")
+		w := httptest.NewRecorder()
+		js.Register(w, r)
+		return w, decodeBody(t, w)
+	}
+
+	// Users without a required role still log in with the password alone
+	if w, body := register("user"); w.Code != http.StatusOK || body["access_token"] == nil {
+		t.Fatalf("Register user: status %d: %s", w.Code, w.Body)
+	}
+
+	// An admin that has not enrolled only gets a token to enroll with
+	w, body := register("admin")
+	if w.Code != http.StatusOK || body["mfa_enrollment_required"] != true || body["access_token"] != nil || len(w.Result().Cookies()) != 0 {
+		t.Fatalf("Register admin: status %d, cookies %v: %s", w.Code, w.Result().Cookies(), w.Body)
+	}
+	enrollToken := body["enroll_token"].(string)
+	var called bool
+	protected := MakeAuthService(js).Secure(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+	if w = postForm(protected, nil, enrollToken); called || w.Code != http.StatusUnauthorized {
+		t.Fatalf("Enrollment token reached a protected resource (status %d)", w.Code)
+	}
+	if w = postForm(http.HandlerFunc(js.VerifyMFA), url.Values{"code": {"000000"}}, enrollToken); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Enrollment token accepted by VerifyMFA (status %d)", w.Code)
+	}
+
+	// The enrollment token is accepted by the enrollment endpoints
+	w = postForm(http.HandlerFunc(js.EnrollMFA), nil, enrollToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Enroll: status %d: %s", w.Code, w.Body)
+	}
+	code, _ := totp.GenerateCode(decodeBody(t, w)["secret"].(string), *now, js.MFA.Params)
+	if w = postForm(http.HandlerFunc(js.ConfirmMFA), url.Values{"enroll_token": {enrollToken}, "code": {code}}, ""); w.Code != http.StatusOK {
+		t.Fatalf("Confirm: status %d: %s", w.Code, w.Body)
+	}
+	if w = postForm(http.HandlerFunc(js.EnrollMFA), nil, enrollToken); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Reused enrollment token: status %d, expecting %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// Once enrolled, the admin logs in using the second factor
+	firstStep(t, js)
+}
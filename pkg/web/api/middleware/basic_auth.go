@@ -1,87 +1,206 @@
 package middleware
 
-// type AuthToken = string
-// type AuthUser struct {
-// 	Username string `json:"username"`
-// 	Password string `json:"password"`
-// }
-//
-// type BasicAuthConfig struct {
-// 	LoginPath    string
-// 	LogoutPath   string
-// 	ValidatePath string
-// 	AuthTokens   []AuthToken
-// 	AuthUsers    []AuthUser
-// }
-//
-// var defaultBasicAuthConfig = &BasicAuthConfig{
-// 	LoginPath:    "/login",
-// 	LogoutPath:   "/logout",
-// 	ValidatePath: "/validate",
-// 	AuthTokens:   make([]AuthToken, 0),
-// 	AuthUsers: []AuthUser{
-// 		{
-// 			Username: "admin",
-// 			Password: "secret",
-// 		},
-// 	},
-// }
-//
-// type BasicAuthController struct {
-// 	conf    *BasicAuthConfig
-// 	protect string
-// }
-//
-// func NewBasicAuthController(c *BasicAuthConfig) *BasicAuthController {
-// 	if c == nil {
-// 		c = defaultBasicAuthConfig
-// 	}
-// 	return &BasicAuthController{
-// 		conf: c,
-// 	}
-// }
-//
-// func (bac *BasicAuthController) validate(r *http.Request) bool {
-// 	u, p, ok := r.BasicAuth()
-// 	return bac.hasAuthUser(u, p) && ok
-// }
-//
-// func (bac *BasicAuthController) hasAuthUser(username, password string) bool {
-// 	for _, au := range bac.conf.AuthUsers {
-// 		if username == au.Username && password == au.Password {
-// 			return true
-// 		}
-// 	}
-// 	return false
-// }
-//
-// func (bac *BasicAuthController) Secure(next http.Handler) http.Handler {
-// 	fn := func(w http.ResponseWriter, r *http.Request) {
-// 		switch r.URL.Path {
-// 		case bac.conf.LoginPath:
-// 			if bac.validate(r) {
-// 				next.ServeHTTP(w, r)
-// 				return
-// 			}
-// 			u, p := r.FormValue("username"), r.FormValue("password")
-// 			if bac.hasAuthUser(u, p) {
-// 				next.ServeHTTP(w, r)
-// 				return
-// 			}
-// 			http.Redirect(w, r, bac.conf.LoginPath, http.StatusOK)
-// 			return
-// 		case bac.conf.LogoutPath:
-// 			r.SetBasicAuth("", "")
-// 			http.Redirect(w, r, bac.conf.LoginPath, http.StatusOK)
-// 			return
-// 		case bac.conf.ValidatePath:
-// 			if bac.validate(r) {
-// 				next.ServeHTTP(w, r)
-// 				return
-// 			}
-// 		}
-// 		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-// 		return
-// 	}
-// 	return http.HandlerFunc(fn)
-// }
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/scottcagno/angular-refresher/pkg/web"
+)
+
+// CredentialProvider checks the passwords of users. It is implemented by
+// api.UserStore and HtpasswdFile.
+type CredentialProvider interface {
+	// Authenticate returns the user when the password is correct. It must
+	// take the same time whether the user exists or not, and compare the
+	// password in constant time.
+	Authenticate(username, password string) (*web.SystemUser, bool)
+}
+
+// authUserKey is the context key used to store the authenticated user.
+type authUserKey struct{}
+
+// AuthUser returns the user authenticated by the Basic or Digest middleware,
+// if there is one.
+func AuthUser(r *http.Request) (*web.SystemUser, bool) {
+	u, ok := r.Context().Value(authUserKey{}).(*web.SystemUser)
+	return u, ok && u != nil
+}
+
+func withAuthUser(r *http.Request, u *web.SystemUser) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), authUserKey{}, u))
+}
+
+// BasicAuthConfig configures the HTTP Basic authentication (RFC 7617) of the
+// handlers. Basic authentication sends the password with every request, so
+// it must only be used over TLS.
+type BasicAuthConfig struct {
+	// Realm names the protection space, shown to the user by browsers.
+	//
+	// Optional. Default value "restricted"
+	Realm string
+
+	// Provider checks the credentials.
+	//
+	// Required.
+	Provider CredentialProvider
+
+	// MaxFailures is the number of failed attempts after which a username
+	// is locked out. A negative value disables the lockout.
+	//
+	// Optional. Default value 5
+	MaxFailures int
+
+	// LockoutDuration is how long a username stays locked out, and how
+	// long failed attempts are remembered.
+	//
+	// Optional. Default value 15 minutes
+	LockoutDuration time.Duration
+}
+
+var defaultBasicAuthConfig = &BasicAuthConfig{
+	Realm:           "restricted",
+	MaxFailures:     5,
+	LockoutDuration: time.Duration(15) * time.Minute,
+}
+
+func checkBasicAuthConfig(conf *BasicAuthConfig) *BasicAuthConfig {
+	if conf == nil || conf.Provider == nil {
+		panic("basic auth: a credential provider is required")
+	}
+	c := *conf
+	if c.Realm == "" {
+		c.Realm = defaultBasicAuthConfig.Realm
+	}
+	if c.MaxFailures == 0 {
+		c.MaxFailures = defaultBasicAuthConfig.MaxFailures
+	}
+	if c.LockoutDuration == 0 {
+		c.LockoutDuration = defaultBasicAuthConfig.LockoutDuration
+	}
+	return &c
+}
+
+// BasicAuth returns a middleware only passing on the requests carrying the
+// credentials of a user, which the handlers find using AuthUser. Other
+// requests get a 401 challenge, or a 429 while their username is locked out.
+func BasicAuth(conf *BasicAuthConfig) Middleware {
+	c := checkBasicAuthConfig(conf)
+	challenge := `Basic realm=` + strconv.Quote(c.Realm) + `, charset="UTF-8"`
+	locks := newLockout(c.MaxFailures, c.LockoutDuration)
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			username, password, ok := r.BasicAuth()
+			if !ok {
+				w.Header().Set(HeaderWWWAuthenticate, challenge)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			if wait := locks.locked(username); wait > 0 {
+				tooManyAttempts(w, wait)
+				return
+			}
+			user, ok := c.Provider.Authenticate(username, password)
+			if !ok {
+				locks.fail(username)
+				w.Header().Set(HeaderWWWAuthenticate, challenge)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			locks.reset(username)
+			next.ServeHTTP(w, withAuthUser(r, user))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set(HeaderRetryAfter, strconv.Itoa(int(wait.Seconds())+1))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
+
+// maxTrackedUsers bounds the number of usernames the lockout remembers, so
+// attempts using random usernames can not exhaust the memory.
+const maxTrackedUsers = 10000
+
+type failures struct {
+	count int
+	first time.Time
+	until time.Time
+}
+
+// lockout locks a username out after max failed attempts within the
+// duration, for the duration.
+type lockout struct {
+	max      int
+	duration time.Duration
+	now      func() time.Time
+
+	mu    sync.Mutex
+	users map[string]*failures
+}
+
+func newLockout(max int, duration time.Duration) *lockout {
+	return &lockout{
+		max:      max,
+		duration: duration,
+		now:      time.Now,
+		users:    make(map[string]*failures),
+	}
+}
+
+// locked returns how long the username stays locked out.
+func (l *lockout) locked(username string) time.Duration {
+	if l.max < 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, found := l.users[username]
+	if !found {
+		return 0
+	}
+	return f.until.Sub(l.now())
+}
+
+// fail records a failed attempt.
+func (l *lockout) fail(username string) {
+	if l.max < 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	f, found := l.users[username]
+	if !found || now.Sub(f.first) > l.duration {
+		if !found && len(l.users) >= maxTrackedUsers {
+			l.prune(now)
+			if len(l.users) >= maxTrackedUsers {
+				return
+			}
+		}
+		f = &failures{first: now}
+		l.users[username] = f
+	}
+	if f.count++; f.count >= l.max {
+		f.until = now.Add(l.duration)
+	}
+}
+
+// reset forgets the failed attempts of the username.
+func (l *lockout) reset(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.users, username)
+}
+
+// prune forgets the usernames with no recent failures, nor lockout.
+func (l *lockout) prune(now time.Time) {
+	for username, f := range l.users {
+		if now.Sub(f.first) > l.duration && now.After(f.until) {
+			delete(l.users, username)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/scottcagno/angular-refresher/pkg/web/password"
+)
+
+// writeFile writes the lines to a new file, and returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadHtpasswd(t *testing.T) {
+	hash, err := password.Hash("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := LoadHtpasswd(
+		writeFile(
+			t, ".htpasswd",
+			"# users\n\nalice:"+hash+"\nbob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"+
+				// as written by htpasswd -B
+				"carol:$2y$05$doc0h1cRwsbPmQ9LDv9aKOldAta22JGJZZio0tJFIRQ686KlgYul2\n",
+		),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		ok       bool
+	}{
+		{"scrypt", "alice", "s3cret", true},
+		{"scrypt wrong password", "alice", "secret", false},
+		{"sha", "bob", "password", true},
+		{"sha wrong password", "bob", "Password", false},
+		{"bcrypt", "carol", "s3cret", true},
+		{"bcrypt wrong password", "carol", "secret", false},
+		{"unknown user", "dave", "s3cret", false},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				user, ok := f.Authenticate(tt.username, tt.password)
+				if ok != tt.ok {
+					t.Fatalf("[%v] Authenticated %v, expecting %v", tt.name, ok, tt.ok)
+				}
+				if ok && user.Username != tt.username {
+					t.Errorf("[%v] User %q, expecting %q", tt.name, user.Username, tt.username)
+				}
+			},
+		)
+	}
+
+	for _, content := range []string{
+		"alice\n", "alice:plain\n", ":" + hash + "\n",
+		// as written by htpasswd -m, which is not supported
+		"alice:$apr1$rooms$CcAm9iAvb2ScCw3hxBxDi0\n",
+		"alice:$x$y$z\n",
+		"alice:$2y$05$short\n",
+	} {
+		if _, err = LoadHtpasswd(writeFile(t, ".htpasswd", content)); err == nil {
+			t.Errorf("Loaded %q, expecting an error", content)
+		}
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	hash, err := password.Hash("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := LoadHtpasswd(writeFile(t, ".htpasswd", "alice:"+hash+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var username string
+	h := BasicAuth(&BasicAuthConfig{Realm: "rooms", Provider: f, MaxFailures: 2})(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				user, _ := AuthUser(r)
+				username = user.Username
+			},
+		),
+	)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		code     int
+	}{
+		{"no credentials", "", "", http.StatusUnauthorized},
+		{"valid", "alice", "s3cret", http.StatusOK},
+		{"wrong password", "alice", "nope", http.StatusUnauthorized},
+		{"valid resets failures", "alice", "s3cret", http.StatusOK},
+		{"first failure", "alice", "nope", http.StatusUnauthorized},
+		{"second failure", "alice", "nope", http.StatusUnauthorized},
+		{"locked out", "alice", "s3cret", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				username = ""
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				if tt.username != "" {
+					r.SetBasicAuth(tt.username, tt.password)
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				if w.Code != tt.code {
+					t.Fatalf("[%v] Status %d, expecting %d", tt.name, w.Code, tt.code)
+				}
+				switch tt.code {
+				case http.StatusOK:
+					if username != tt.username {
+						t.Errorf("[%v] User %q, expecting %q", tt.name, username, tt.username)
+					}
+				case http.StatusUnauthorized:
+					want := `Basic realm="rooms", charset="UTF-8"`
+					if got := w.Header().Get("WWW-Authenticate"); got != want {
+						t.Errorf("[%v] Challenge %q, expecting %q", tt.name, got, want)
+					}
+				case http.StatusTooManyRequests:
+					if wait, _ := strconv.Atoi(w.Header().Get("Retry-After")); wait <= 0 {
+						t.Errorf("[%v] Retry-After %q, expecting a positive delay", tt.name, w.Header().Get("Retry-After"))
+					}
+				}
+			},
+		)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := newLockout(3, time.Minute)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		l.fail("alice")
+	}
+	if wait := l.locked("alice"); wait > 0 {
+		t.Fatalf("Locked out after 2 failures")
+	}
+	// failures older than the duration are forgotten
+	now = now.Add(2 * time.Minute)
+	l.fail("alice")
+	if wait := l.locked("alice"); wait > 0 {
+		t.Fatalf("Locked out by expired failures")
+	}
+	l.fail("alice")
+	l.fail("alice")
+	if wait := l.locked("alice"); wait != time.Minute {
+		t.Fatalf("Locked out for %v, expecting %v", wait, time.Minute)
+	}
+	if wait := l.locked("bob"); wait > 0 {
+		t.Fatalf("Other users are locked out")
+	}
+	now = now.Add(time.Minute)
+	if wait := l.locked("alice"); wait > 0 {
+		t.Fatalf("Still locked out after the duration")
+	}
+
+	disabled := newLockout(-1, time.Minute)
+	for i := 0; i < 10; i++ {
+		disabled.fail("alice")
+	}
+	if wait := disabled.locked("alice"); wait > 0 {
+		t.Fatalf("Locked out with the lockout disabled")
+	}
+}
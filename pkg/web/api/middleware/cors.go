@@ -2,34 +2,54 @@ package middleware
 
 import (
 	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
 )
 
 type CORSConfig struct {
-	// AllowOrigin defines a list of origins that may access the resource.
+	// AllowOrigins defines a list of origins that may access the resource.
+	// An origin may hold "*" wildcards, as in "https://*.example.com", and
+	// "*" on its own allows any origin.
 	//
-	// Optional. Default value "*"
+	// Optional. Default value []string{"*"}
 	// Header definition: Access-Control-Allow-Origin: <origin> | *
-	AllowOrigins string
+	AllowOrigins []string
+
+	// AllowOriginPatterns defines a list of regular expressions, matching
+	// the whole origin, that may access the resource.
+	//
+	// Optional. Default value nil
+	AllowOriginPatterns []string
+
+	// AllowOriginFunc, when set, reports whether an origin that is not in
+	// the lists may access the resource.
+	//
+	// Optional. Default value nil
+	AllowOriginFunc func(origin string) bool
 
 	// AllowMethods defines a list methods allowed when accessing the resource.
 	// This is used in response to a preflight request.
 	//
-	// Optional. Default value "GET,POST,HEAD,PUT,DELETE,PATCH"
+	// Optional. Default value []string{"GET", "POST", "HEAD", "PUT", "DELETE", "PATCH"}
 	// Header definition: Access-Control-Allow-Methods: <method>[, <method>]*
-	AllowMethods string
+	AllowMethods []string
 
 	// AllowHeaders defines a list of request headers that can be used when
 	// making the actual request. This is in response to a preflight request.
+	// "*" allows any header.
 	//
-	// Optional. Default value "".
+	// Optional. Default value []string{"Accept", "Authorization", "Content-Type"}
 	// Header definition: Access-Control-Allow-Headers: <header-name>[, <header-name>]*
-	AllowHeaders string
+	AllowHeaders []string
 
 	// AllowCredentials indicates whether the response to the request can
 	// be exposed when the credentials flag is true. When used as part of
 	// a response to a preflight request, this indicates whether the actual
-	// request can be made using credentials.
+	// request can be made using credentials. The matched origin is always
+	// echoed in that case, since "*" can not be used with credentials.
+	// Credentials require the allowed origins to be listed explicitly, using
+	// AllowOrigins (without "*"), AllowOriginPatterns or AllowOriginFunc.
 	//
 	// Optional. Default value false.
 	// Header definition: Access-Control-Allow-Credentials: true|false
@@ -38,9 +58,9 @@ type CORSConfig struct {
 	// ExposeHeaders defines a whitelist headers that clients are allowed to
 	// access.
 	//
-	// Optional. Default value "".
+	// Optional. Default value nil.
 	// Header definition: Access-Control-Expose-Headers: <header-name>[, <header-name>]*
-	ExposeHeaders string
+	ExposeHeaders []string
 
 	// MaxAge indicates how long (in seconds) the results of a preflight request
 	// can be cached.
@@ -51,75 +71,221 @@ type CORSConfig struct {
 }
 
 var defaultCORSConfig = &CORSConfig{
-	AllowOrigins:     "*",
-	AllowMethods:     "GET,POST,HEAD,PUT,DELETE,PATCH",
-	AllowHeaders:     "",
+	AllowOrigins:     []string{"*"},
+	AllowMethods:     []string{"GET", "POST", "HEAD", "PUT", "DELETE", "PATCH"},
+	AllowHeaders:     []string{"Accept", "Authorization", "Content-Type"},
 	AllowCredentials: false,
-	ExposeHeaders:    "",
+	ExposeHeaders:    nil,
 	MaxAge:           0,
 }
 
 var DefaultCORSConfig = defaultCORSConfig
 
-func CORSHandler(c *CORSConfig) http.Handler {
-	if c == nil {
-		c = defaultCORSConfig
-		goto skipSetDefaults
-	}
-	// if c.AllowOrigins == "" {
-	// 	c.AllowOrigins = defaultCORSConfig.AllowOrigins
-	// }
-	// if c.AllowMethods == "" {
-	// 	c.AllowMethods = defaultCORSConfig.AllowMethods
-	// }
-	// if c.AllowHeaders == "" {
-	// 	c.AllowHeaders = defaultCORSConfig.AllowHeaders
-	// }
-	// if !c.AllowCredentials {
-	// 	c.AllowCredentials = defaultCORSConfig.AllowCredentials
-	// }
-	// if c.ExposeHeaders != "" {
-	// 	c.ExposeHeaders = defaultCORSConfig.ExposeHeaders
-	// }
-	// if c.MaxAge == 0 {
-	// 	c.ExposeHeaders = defaultCORSConfig.ExposeHeaders
-	// }
-skipSetDefaults:
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		// handle a pre-flight request
-		case http.MethodOptions:
-			w.Header().Add(HeaderVary, HeaderOrigin)
-			w.Header().Add(HeaderVary, HeaderAccessControlRequestMethod)
-			w.Header().Add(HeaderVary, HeaderAccessControlRequestHeaders)
-			w.Header().Set(HeaderAccessControlAllowOrigin, c.AllowOrigins)
-			w.Header().Set(HeaderAccessControlAllowMethods, c.AllowMethods)
-			if c.AllowCredentials {
-				w.Header().Set(HeaderAccessControlAllowCredentials, "true")
+func checkCORSConfig(conf *CORSConfig) *CORSConfig {
+	if conf == nil {
+		conf = defaultCORSConfig
+	}
+	c := *conf
+	if len(c.AllowOrigins) == 0 && len(c.AllowOriginPatterns) == 0 && c.AllowOriginFunc == nil {
+		c.AllowOrigins = defaultCORSConfig.AllowOrigins
+	}
+	if len(c.AllowMethods) == 0 {
+		c.AllowMethods = defaultCORSConfig.AllowMethods
+	}
+	if len(c.AllowHeaders) == 0 {
+		c.AllowHeaders = defaultCORSConfig.AllowHeaders
+	}
+	if c.AllowCredentials {
+		for _, o := range c.AllowOrigins {
+			if o == "*" {
+				panic("cors: credentials can not be allowed for any origin, the allowed origins must be listed")
 			}
-			if c.AllowHeaders != "" {
-				w.Header().Set(HeaderAccessControlAllowHeaders, c.AllowHeaders)
-			} else {
-				if rh := r.Header.Get(HeaderAccessControlRequestHeaders); rh != "" {
-					w.Header().Set(HeaderAccessControlAllowHeaders, rh)
-				}
+		}
+	}
+	return &c
+}
+
+// cors holds a CORSConfig prepared for matching requests.
+type cors struct {
+	conf      *CORSConfig
+	anyOrigin bool
+	origins   map[string]bool
+	patterns  []*regexp.Regexp
+	methods   map[string]bool
+	anyHeader bool
+	headers   map[string]bool
+}
+
+func newCORS(conf *CORSConfig) *cors {
+	c := &cors{
+		conf:    checkCORSConfig(conf),
+		origins: make(map[string]bool),
+		methods: make(map[string]bool),
+		headers: make(map[string]bool),
+	}
+	for _, o := range c.conf.AllowOrigins {
+		o = strings.ToLower(o)
+		switch {
+		case o == "*":
+			c.anyOrigin = true
+		case strings.Contains(o, "*"):
+			// a wildcard matches one or more host name characters
+			re := strings.ReplaceAll(regexp.QuoteMeta(o), `\*`, `[a-z0-9.-]+`)
+			c.patterns = append(c.patterns, regexp.MustCompile("^"+re+"$"))
+		default:
+			c.origins[o] = true
+		}
+	}
+	for _, p := range c.conf.AllowOriginPatterns {
+		c.patterns = append(c.patterns, regexp.MustCompile("(?i)^(?:"+p+")$"))
+	}
+	for _, m := range c.conf.AllowMethods {
+		c.methods[strings.ToUpper(m)] = true
+	}
+	for _, h := range c.conf.AllowHeaders {
+		if h == "*" {
+			c.anyHeader = true
+		}
+		c.headers[strings.ToLower(h)] = true
+	}
+	return c
+}
+
+// allowOrigin reports whether the origin may access the resource.
+func (c *cors) allowOrigin(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+	o := strings.ToLower(origin)
+	if c.origins[o] {
+		return true
+	}
+	for _, re := range c.patterns {
+		if re.MatchString(o) {
+			return true
+		}
+	}
+	return c.conf.AllowOriginFunc != nil && c.conf.AllowOriginFunc(origin)
+}
+
+// allowMethod reports whether the method may be used by the actual request.
+func (c *cors) allowMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost:
+		// CORS-safelisted methods
+		return true
+	}
+	return c.methods[method]
+}
+
+// allowHeaders reports whether every header requested by a preflight may be
+// used by the actual request.
+func (c *cors) allowHeaders(requested []string) bool {
+	if c.anyHeader {
+		return true
+	}
+	for _, h := range requested {
+		if !c.headers[h] {
+			return false
+		}
+	}
+	return true
+}
+
+// setOrigin sets the headers common to preflight and actual requests.
+func (c *cors) setOrigin(h http.Header, origin string) {
+	if c.anyOrigin {
+		h.Set(HeaderAccessControlAllowOrigin, "*")
+	} else {
+		h.Set(HeaderAccessControlAllowOrigin, origin)
+	}
+	if c.conf.AllowCredentials {
+		h.Set(HeaderAccessControlAllowCredentials, "true")
+	}
+}
+
+// preflight answers a preflight request.
+func (c *cors) preflight(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Add(HeaderVary, HeaderOrigin)
+	h.Add(HeaderVary, HeaderAccessControlRequestMethod)
+	h.Add(HeaderVary, HeaderAccessControlRequestHeaders)
+	origin := r.Header.Get(HeaderOrigin)
+	method := r.Header.Get(HeaderAccessControlRequestMethod)
+	requested := splitHeaderList(r.Header.Values(HeaderAccessControlRequestHeaders))
+	if !c.allowOrigin(origin) || !c.allowMethod(method) || !c.allowHeaders(requested) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	c.setOrigin(h, origin)
+	h.Set(HeaderAccessControlAllowMethods, strings.Join(c.conf.AllowMethods, ", "))
+	if len(requested) > 0 {
+		// the requested headers were checked, so they are echoed
+		h.Set(HeaderAccessControlAllowHeaders, strings.Join(requested, ", "))
+	}
+	if c.conf.MaxAge > 0 {
+		h.Set(HeaderAccessControlMaxAge, strconv.Itoa(c.conf.MaxAge))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CORS returns a middleware handling cross-origin requests. Preflight
+// requests are answered and end the chain: they are refused with a 403 when
+// the origin, the method or any of the headers requested is not allowed.
+// Actual requests are passed on, along with the CORS headers when their
+// origin is allowed, so the browser blocks the response otherwise.
+//
+// CORS panics when the configuration allows credentials for any origin.
+//
+// The middleware can be used with rest.NewChain as well.
+func CORS(conf *CORSConfig) Middleware {
+	c := newCORS(conf)
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get(HeaderOrigin)
+			if origin == "" {
+				// not a cross-origin request
+				next.ServeHTTP(w, r)
+				return
 			}
-			if c.MaxAge > 0 {
-				w.Header().Set(HeaderAccessControlMaxAge, strconv.Itoa(c.MaxAge))
+			if r.Method == http.MethodOptions && r.Header.Get(HeaderAccessControlRequestMethod) != "" {
+				c.preflight(w, r)
+				return
 			}
-			w.WriteHeader(http.StatusNoContent)
-		default:
-			// simple, non pre-flight request
-			w.Header().Add(HeaderVary, HeaderOrigin)
-			w.Header().Set(HeaderAccessControlAllowOrigin, c.AllowOrigins)
-			w.Header().Set(HeaderAccessControlAllowMethods, c.AllowMethods)
-			if c.AllowCredentials {
-				w.Header().Set(HeaderAccessControlAllowCredentials, "true")
+			if !c.anyOrigin {
+				w.Header().Add(HeaderVary, HeaderOrigin)
+			}
+			if c.allowOrigin(origin) {
+				c.setOrigin(w.Header(), origin)
+				if len(c.conf.ExposeHeaders) > 0 {
+					w.Header().Set(HeaderAccessControlExposeHeaders, strings.Join(c.conf.ExposeHeaders, ", "))
+				}
 			}
-			if c.ExposeHeaders != "" {
-				w.Header().Set(HeaderAccessControlExposeHeaders, c.ExposeHeaders)
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// CORSHandler returns a handler setting the CORS headers, and answering the
+// preflight requests, of the configuration.
+//
+// Deprecated: CORSHandler does not call the next handler of the chain. Use
+// the CORS middleware instead.
+func CORSHandler(conf *CORSConfig) http.Handler {
+	return CORS(conf)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+}
+
+// splitHeaderList returns the lower cased names of a comma separated header
+// list.
+func splitHeaderList(values []string) []string {
+	var names []string
+	for _, v := range values {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, strings.ToLower(name))
 			}
 		}
 	}
-	return http.HandlerFunc(fn)
+	return names
 }
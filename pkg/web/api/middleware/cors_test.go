@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	var reached int
+	next := http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			reached++
+			w.WriteHeader(http.StatusOK)
+		},
+	)
+	h := CORS(
+		&CORSConfig{
+			AllowOrigins:        []string{"http://localhost:4200", "https://*.rooms.test"},
+			AllowOriginPatterns: []string{`https://pr-[0-9]+\.preview\.test`},
+			AllowOriginFunc:     func(origin string) bool { return origin == "app://rooms" },
+			AllowMethods:        []string{"GET", "PUT", "DELETE"},
+			AllowHeaders:        []string{"Content-Type", "X-XSRF-TOKEN"},
+			AllowCredentials:    true,
+			ExposeHeaders:       []string{"Location"},
+			MaxAge:              600,
+		},
+	)(next)
+
+	tests := []struct {
+		name    string
+		method  string
+		origin  string
+		request string // Access-Control-Request-Method
+		headers string // Access-Control-Request-Headers
+		code    int
+		allowed string
+		reached bool
+	}{
+		{"same origin", http.MethodGet, "", "", "", http.StatusOK, "", true},
+		{"listed", http.MethodGet, "http://localhost:4200", "", "", http.StatusOK, "http://localhost:4200", true},
+		{"wildcard", http.MethodGet, "https://admin.rooms.test", "", "", http.StatusOK, "https://admin.rooms.test", true},
+		{"wildcard other site", http.MethodGet, "https://rooms.test.attacker.example", "", "", http.StatusOK, "", true},
+		{"pattern", http.MethodGet, "https://pr-12.preview.test", "", "", http.StatusOK, "https://pr-12.preview.test", true},
+		{"callback", http.MethodGet, "app://rooms", "", "", http.StatusOK, "app://rooms", true},
+		{"not allowed", http.MethodGet, "http://attacker.example", "", "", http.StatusOK, "", true},
+		{
+			"preflight", http.MethodOptions, "http://localhost:4200", "PUT", "content-type, X-XSRF-TOKEN",
+			http.StatusNoContent, "http://localhost:4200", false,
+		},
+		{"preflight origin", http.MethodOptions, "http://attacker.example", "PUT", "", http.StatusForbidden, "", false},
+		{"preflight method", http.MethodOptions, "http://localhost:4200", "PATCH", "", http.StatusForbidden, "", false},
+		{
+			"preflight header", http.MethodOptions, "http://localhost:4200", "PUT", "Content-Type, X-Debug",
+			http.StatusForbidden, "", false,
+		},
+		{"plain options", http.MethodOptions, "http://localhost:4200", "", "", http.StatusOK, "http://localhost:4200", true},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				reached = 0
+				r := httptest.NewRequest(tt.method, "/api/bookings", nil)
+				if tt.origin != "" {
+					r.Header.Set("Origin", tt.origin)
+				}
+				if tt.request != "" {
+					r.Header.Set("Access-Control-Request-Method", tt.request)
+				}
+				if tt.headers != "" {
+					r.Header.Set("Access-Control-Request-Headers", tt.headers)
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				if w.Code != tt.code {
+					t.Errorf("[%v] Status %d, expecting %d", tt.name, w.Code, tt.code)
+				}
+				if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allowed {
+					t.Errorf("[%v] Allowed origin %q, expecting %q", tt.name, got, tt.allowed)
+				}
+				if (reached == 1) != tt.reached {
+					t.Errorf("[%v] Handler reached %v, expecting %v", tt.name, reached == 1, tt.reached)
+				}
+				if tt.allowed == "" {
+					return
+				}
+				if w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+					t.Errorf("[%v] Credentials are not allowed", tt.name)
+				}
+				if tt.code == http.StatusNoContent {
+					allowHeaders := w.Header().Get("Access-Control-Allow-Headers")
+					if !strings.Contains(allowHeaders, "x-xsrf-token") || w.Header().Get("Access-Control-Max-Age") != "600" {
+						t.Errorf("[%v] Unexpected preflight headers %v", tt.name, w.Header())
+					}
+				} else if w.Header().Get("Access-Control-Expose-Headers") != "Location" {
+					t.Errorf("[%v] Exposed headers are missing", tt.name)
+				}
+			},
+		)
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	h := CORS(nil)(http.HandlerFunc(okHandler))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Origin", "http://localhost:4200")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Allowed origin %q, expecting %q", got, "*")
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("Allowed credentials %q, expecting none", got)
+	}
+
+	tests := []struct {
+		name string
+		conf *CORSConfig
+	}{
+		{"default origins", &CORSConfig{AllowCredentials: true}},
+		{"wildcard origin", &CORSConfig{AllowOrigins: []string{"http://localhost:4200", "*"}, AllowCredentials: true}},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("[%v] Credentials allowed for any origin, expecting a panic", tt.name)
+					}
+				}()
+				CORS(tt.conf)
+			},
+		)
+	}
+}
@@ -0,0 +1,359 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// CSPDirective is a Content Security Policy directive.
+type CSPDirective string
+
+const (
+	DefaultSrc              CSPDirective = "default-src"
+	ScriptSrc               CSPDirective = "script-src"
+	StyleSrc                CSPDirective = "style-src"
+	ImgSrc                  CSPDirective = "img-src"
+	ConnectSrc              CSPDirective = "connect-src"
+	FontSrc                 CSPDirective = "font-src"
+	ObjectSrc               CSPDirective = "object-src"
+	MediaSrc                CSPDirective = "media-src"
+	FrameSrc                CSPDirective = "frame-src"
+	WorkerSrc               CSPDirective = "worker-src"
+	ManifestSrc             CSPDirective = "manifest-src"
+	ChildSrc                CSPDirective = "child-src"
+	FrameAncestors          CSPDirective = "frame-ancestors"
+	BaseURI                 CSPDirective = "base-uri"
+	FormAction              CSPDirective = "form-action"
+	Sandbox                 CSPDirective = "sandbox"
+	UpgradeInsecureRequests CSPDirective = "upgrade-insecure-requests"
+)
+
+// CSPSource is a source of a Content Security Policy directive, such as a
+// keyword, a scheme or a host ("https://cdn.example.com").
+type CSPSource string
+
+const (
+	SourceSelf          CSPSource = "'self'"
+	SourceNone          CSPSource = "'none'"
+	SourceUnsafeInline  CSPSource = "'unsafe-inline'"
+	SourceUnsafeEval    CSPSource = "'unsafe-eval'"
+	SourceStrictDynamic CSPSource = "'strict-dynamic'"
+	SourceData          CSPSource = "data:"
+	SourceBlob          CSPSource = "blob:"
+	SourceHTTPS         CSPSource = "https:"
+
+	// SourceNonce is replaced by the nonce of each request, as in
+	// "'nonce-<nonce>'". The nonce is found by templates using CSPNonce.
+	SourceNonce CSPSource = "'nonce'"
+)
+
+type cspDirective struct {
+	name    CSPDirective
+	sources []CSPSource
+}
+
+// CSP builds a Content Security Policy. The directives are written in the
+// order they were first set.
+//
+//	csp := NewCSP().
+//		Set(DefaultSrc, SourceSelf).
+//		Set(ScriptSrc, SourceSelf, SourceNonce).
+//		ReportURI("/csp-reports")
+type CSP struct {
+	directives []cspDirective
+	reportURI  string
+	reportTo   string
+	reportOnly bool
+}
+
+// NewCSP returns an empty policy.
+func NewCSP() *CSP {
+	return &CSP{}
+}
+
+// DefaultCSP returns a strict policy, only allowing resources from the same
+// origin, no plugins and no framing.
+func DefaultCSP() *CSP {
+	return NewCSP().
+		Set(DefaultSrc, SourceSelf).
+		Set(ObjectSrc, SourceNone).
+		Set(BaseURI, SourceSelf).
+		Set(FrameAncestors, SourceNone)
+}
+
+// Set replaces the sources of the directive. A directive without sources,
+// such as UpgradeInsecureRequests, is written on its own.
+func (p *CSP) Set(d CSPDirective, sources ...CSPSource) *CSP {
+	for i := range p.directives {
+		if p.directives[i].name == d {
+			p.directives[i].sources = sources
+			return p
+		}
+	}
+	p.directives = append(p.directives, cspDirective{name: d, sources: sources})
+	return p
+}
+
+// Add adds sources to the directive.
+func (p *CSP) Add(d CSPDirective, sources ...CSPSource) *CSP {
+	for i := range p.directives {
+		if p.directives[i].name == d {
+			p.directives[i].sources = append(p.directives[i].sources, sources...)
+			return p
+		}
+	}
+	return p.Set(d, sources...)
+}
+
+// ReportURI sets the URI the browsers post violation reports to.
+func (p *CSP) ReportURI(uri string) *CSP {
+	p.reportURI = uri
+	return p
+}
+
+// ReportTo sets the reporting group the browsers send violation reports to,
+// which must be defined by the Reporting-Endpoints header.
+func (p *CSP) ReportTo(group string) *CSP {
+	p.reportTo = group
+	return p
+}
+
+// ReportOnly makes the browsers report the violations of the policy without
+// enforcing it, which is useful to try a policy out.
+func (p *CSP) ReportOnly() *CSP {
+	p.reportOnly = true
+	return p
+}
+
+// Header returns the name of the header the policy is sent in.
+func (p *CSP) Header() string {
+	if p.reportOnly {
+		return HeaderContentSecurityPolicyReportOnly
+	}
+	return HeaderContentSecurityPolicy
+}
+
+// usesNonce reports whether a directive holds SourceNonce.
+func (p *CSP) usesNonce() bool {
+	for _, d := range p.directives {
+		for _, s := range d.sources {
+			if s == SourceNonce {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Build returns the policy, with SourceNonce replaced by the nonce.
+func (p *CSP) Build(nonce string) string {
+	var sb strings.Builder
+	for _, d := range p.directives {
+		if sb.Len() > 0 {
+			sb.WriteString("; ")
+		}
+		sb.WriteString(string(d.name))
+		for _, s := range d.sources {
+			sb.WriteByte(' ')
+			if s == SourceNonce {
+				sb.WriteString("'nonce-" + nonce + "'")
+				continue
+			}
+			sb.WriteString(string(s))
+		}
+	}
+	if p.reportURI != "" {
+		sb.WriteString("; report-uri " + p.reportURI)
+	}
+	if p.reportTo != "" {
+		sb.WriteString("; report-to " + p.reportTo)
+	}
+	return sb.String()
+}
+
+// String returns the policy, without a nonce.
+func (p *CSP) String() string {
+	return p.Build("")
+}
+
+// cspNonceKey is the context key used to store the nonce of the request.
+type cspNonceKey struct{}
+
+// CSPNonce returns the nonce of the request, to be placed in the nonce
+// attribute of the inline scripts and styles of a page. It is empty unless
+// the policy of the SecureHeaders middleware uses SourceNonce.
+func CSPNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+func newCSPNonce(r *http.Request) (*http.Request, string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return r, "", err
+	}
+	nonce := base64.StdEncoding.EncodeToString(b)
+	return r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, nonce)), nonce, nil
+}
+
+// CSPReport is a violation of a Content Security Policy, reported by a
+// browser.
+type CSPReport struct {
+	DocumentURI        string `json:"document-uri"`
+	Referrer           string `json:"referrer"`
+	BlockedURI         string `json:"blocked-uri"`
+	ViolatedDirective  string `json:"violated-directive"`
+	EffectiveDirective string `json:"effective-directive"`
+	OriginalPolicy     string `json:"original-policy"`
+	Disposition        string `json:"disposition"`
+	SourceFile         string `json:"source-file"`
+	LineNumber         int    `json:"line-number"`
+	ColumnNumber       int    `json:"column-number"`
+	StatusCode         int    `json:"status-code"`
+	ScriptSample       string `json:"script-sample"`
+}
+
+// reportingAPIReport is a report sent using the Reporting API, as used by
+// the report-to directive.
+type reportingAPIReport struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		Referrer           string `json:"referrer"`
+		BlockedURL         string `json:"blockedURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		OriginalPolicy     string `json:"originalPolicy"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+		ColumnNumber       int    `json:"columnNumber"`
+		StatusCode         int    `json:"statusCode"`
+		Sample             string `json:"sample"`
+	} `json:"body"`
+}
+
+// maxCSPReportSize limits the size of the reports accepted.
+const maxCSPReportSize = 64 << 10
+
+// CSPReportCollector is the endpoint the browsers post violation reports to.
+// It accepts the reports sent for both the report-uri and the report-to
+// directives, logs them and keeps the most recent ones.
+type CSPReportCollector struct {
+	// Logger logs the reports, when set.
+	Logger *log.Logger
+	// OnReport is called with every report, when set.
+	OnReport func(CSPReport)
+
+	mu      sync.Mutex
+	max     int
+	reports []CSPReport
+}
+
+// NewCSPReportCollector returns a collector keeping the max most recent
+// reports, logging them to the logger when it is not nil.
+func NewCSPReportCollector(logger *log.Logger, max int) *CSPReportCollector {
+	return &CSPReportCollector{Logger: logger, max: max}
+}
+
+// Reports returns the most recent reports, oldest first.
+func (c *CSPReportCollector) Reports() []CSPReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]CSPReport(nil), c.reports...)
+}
+
+func (c *CSPReportCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set(HeaderAllow, http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCSPReportSize+1))
+	if err != nil || len(body) > maxCSPReportSize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	reports, err := parseCSPReports(r.Header.Get(HeaderContentType), body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, report := range reports {
+		c.add(report)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *CSPReportCollector) add(report CSPReport) {
+	if c.Logger != nil {
+		c.Logger.Printf(
+			"csp: %s blocked %q on %q (%s:%d)", report.EffectiveDirective, report.BlockedURI, report.DocumentURI,
+			report.SourceFile, report.LineNumber,
+		)
+	}
+	if c.OnReport != nil {
+		c.OnReport(report)
+	}
+	if c.max <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.reports) == c.max {
+		c.reports = append(c.reports[:0], c.reports[1:]...)
+	}
+	c.reports = append(c.reports, report)
+}
+
+// parseCSPReports decodes the reports sent using the report-uri directive
+// ("application/csp-report") or the Reporting API
+// ("application/reports+json").
+func parseCSPReports(contentType string, body []byte) ([]CSPReport, error) {
+	if strings.HasPrefix(contentType, "application/reports+json") {
+		var batch []reportingAPIReport
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return nil, err
+		}
+		var reports []CSPReport
+		for _, rep := range batch {
+			if rep.Type != "csp-violation" {
+				continue
+			}
+			b := rep.Body
+			reports = append(
+				reports, CSPReport{
+					DocumentURI:        b.DocumentURL,
+					Referrer:           b.Referrer,
+					BlockedURI:         b.BlockedURL,
+					ViolatedDirective:  b.EffectiveDirective,
+					EffectiveDirective: b.EffectiveDirective,
+					OriginalPolicy:     b.OriginalPolicy,
+					Disposition:        b.Disposition,
+					SourceFile:         b.SourceFile,
+					LineNumber:         b.LineNumber,
+					ColumnNumber:       b.ColumnNumber,
+					StatusCode:         b.StatusCode,
+					ScriptSample:       b.Sample,
+				},
+			)
+		}
+		return reports, nil
+	}
+	var legacy struct {
+		Report CSPReport `json:"csp-report"`
+	}
+	if err := json.Unmarshal(body, &legacy); err != nil {
+		return nil, err
+	}
+	if legacy.Report.EffectiveDirective == "" {
+		legacy.Report.EffectiveDirective = legacy.Report.ViolatedDirective
+	}
+	return []CSPReport{legacy.Report}, nil
+}
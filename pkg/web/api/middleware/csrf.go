@@ -0,0 +1,275 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/scottcagno/angular-refresher/pkg/web"
+)
+
+// CSRFConfig configures the CSRF protection of unsafe requests (anything but
+// GET, HEAD, OPTIONS and TRACE).
+//
+// By default, the double-submit cookie pattern is used: the token is set in a
+// cookie readable by scripts, and unsafe requests must send it back in a
+// header (or a form field). This is the convention Angular follows, using the
+// "XSRF-TOKEN" cookie and the "X-XSRF-TOKEN" header.
+type CSRFConfig struct {
+	// CookieName is the name of the cookie holding the token.
+	//
+	// Optional. Default value "XSRF-TOKEN"
+	CookieName string
+
+	// HeaderName is the name of the request header carrying the token.
+	//
+	// Optional. Default value "X-XSRF-TOKEN"
+	HeaderName string
+
+	// FormField is the name of the form field carrying the token, for forms
+	// posted without scripts.
+	//
+	// Optional. Default value "_csrf"
+	FormField string
+
+	// CookieDomain and CookiePath limit the scope of the cookie.
+	//
+	// Optional. Default value "" and "/"
+	CookieDomain string
+	CookiePath   string
+
+	// Secure restricts the cookie to TLS connections.
+	//
+	// Optional. Default value false
+	Secure bool
+
+	// UseSession stores the token in the web.Session of the request
+	// (synchronizer token pattern) instead of only in the cookie. The
+	// session must be loaded by web.SessionMiddleware before this
+	// middleware runs. The token is still set in the cookie, so scripts can
+	// send it.
+	//
+	// Optional. Default value false
+	UseSession bool
+
+	// TrustedOrigins are the origins, other than the origin of the request
+	// host, allowed to send unsafe requests, such as "http://localhost:4200".
+	//
+	// Optional. Default value nil
+	TrustedOrigins []string
+
+	// ExemptPaths are the paths not checked. A path ending with "/" exempts
+	// every path it prefixes.
+	//
+	// Optional. Default value nil
+	ExemptPaths []string
+
+	// Exempt, when set, reports whether the request is not checked.
+	//
+	// Optional. Default value nil
+	Exempt func(r *http.Request) bool
+}
+
+var defaultCSRFConfig = &CSRFConfig{
+	CookieName: "XSRF-TOKEN",
+	HeaderName: HeaderXXSRFToken,
+	FormField:  "_csrf",
+	CookiePath: "/",
+}
+
+func checkCSRFConfig(conf *CSRFConfig) *CSRFConfig {
+	if conf == nil {
+		conf = defaultCSRFConfig
+	}
+	c := *conf
+	if c.CookieName == "" {
+		c.CookieName = defaultCSRFConfig.CookieName
+	}
+	if c.HeaderName == "" {
+		c.HeaderName = defaultCSRFConfig.HeaderName
+	}
+	if c.FormField == "" {
+		c.FormField = defaultCSRFConfig.FormField
+	}
+	if c.CookiePath == "" {
+		c.CookiePath = defaultCSRFConfig.CookiePath
+	}
+	return &c
+}
+
+var errCSRFNoSession = errors.New("csrf: the request has no session, web.SessionMiddleware must run first")
+
+// csrfSessionKey is the session key the synchronizer token is stored under.
+const csrfSessionKey = "_csrf_token"
+
+// csrfTokenKey is the context key used to store the token of the request.
+type csrfTokenKey struct{}
+
+// CSRFToken returns the token of the request, to be placed in forms. It is
+// empty unless the CSRF middleware handled the request.
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfTokenKey{}).(string)
+	return token
+}
+
+// CSRF returns a middleware protecting the handlers from cross-site request
+// forgery. Unsafe requests are refused with a 403 problem response when
+// their origin is not trusted, or when they do not carry the token.
+//
+// Requests without any cookie are not checked: a forged request relies on
+// the browser sending credentials on its own, so clients authenticating
+// with a header, such as a bearer token or an API key, are not affected.
+func CSRF(conf *CSRFConfig) Middleware {
+	c := checkCSRFConfig(conf)
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			token, err := c.token(r)
+			if err != nil {
+				writeProblem(w, http.StatusInternalServerError, "CSRF token unavailable", err.Error())
+				return
+			}
+			// The cookie is refreshed on every response, so scripts always
+			// find the current token
+			http.SetCookie(
+				w, &http.Cookie{
+					Name:     c.CookieName,
+					Value:    token,
+					Path:     c.CookiePath,
+					Domain:   c.CookieDomain,
+					Secure:   c.Secure,
+					HttpOnly: false, // scripts must read it
+					SameSite: http.SameSiteLaxMode,
+				},
+			)
+			r = r.WithContext(context.WithValue(r.Context(), csrfTokenKey{}, token))
+			if isSafeMethod(r.Method) || len(r.Cookies()) == 0 || c.exempt(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if detail := c.checkOrigin(r); detail != "" {
+				writeProblem(w, http.StatusForbidden, "CSRF check failed", detail)
+				return
+			}
+			sent := r.Header.Get(c.HeaderName)
+			if sent == "" {
+				sent = r.PostFormValue(c.FormField)
+			}
+			if sent == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				writeProblem(w, http.StatusForbidden, "CSRF check failed", "the CSRF token is missing or invalid")
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// token returns the token of the request, creating one when it has none.
+func (c *CSRFConfig) token(r *http.Request) (string, error) {
+	if c.UseSession {
+		sess, ok := web.SessionFrom(r.Context())
+		if !ok {
+			return "", errCSRFNoSession
+		}
+		if token, ok := sess.GetString(csrfSessionKey); ok && token != "" {
+			return token, nil
+		}
+		token, err := newCSRFToken()
+		if err != nil {
+			return "", err
+		}
+		sess.Set(csrfSessionKey, token)
+		return token, nil
+	}
+	if ck, err := r.Cookie(c.CookieName); err == nil && len(ck.Value) == csrfTokenLen {
+		return ck.Value, nil
+	}
+	return newCSRFToken()
+}
+
+// exempt reports whether the request was opted out of the check.
+func (c *CSRFConfig) exempt(r *http.Request) bool {
+	for _, p := range c.ExemptPaths {
+		if r.URL.Path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(r.URL.Path, p)) {
+			return true
+		}
+	}
+	return c.Exempt != nil && c.Exempt(r)
+}
+
+// checkOrigin checks the Origin header of the request, or the Referer header
+// when there is no Origin header, and returns why it failed. Requests over
+// TLS must carry one of them.
+func (c *CSRFConfig) checkOrigin(r *http.Request) string {
+	origin := r.Header.Get(HeaderOrigin)
+	if origin == "null" {
+		// sent by sandboxed documents, among others
+		return "the origin null is not trusted"
+	}
+	if origin == "" {
+		ref := r.Header.Get(HeaderReferer)
+		if ref == "" {
+			if r.TLS != nil {
+				return "the request has no Origin or Referer header"
+			}
+			return ""
+		}
+		u, err := url.Parse(ref)
+		if err != nil || u.Host == "" {
+			return "the Referer header is not valid"
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if strings.EqualFold(origin, scheme+"://"+r.Host) {
+		return ""
+	}
+	for _, trusted := range c.TrustedOrigins {
+		if strings.EqualFold(origin, trusted) {
+			return ""
+		}
+	}
+	return "the origin " + origin + " is not trusted"
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// csrfTokenLen is the length of an encoded token of 32 bytes.
+var csrfTokenLen = base64.RawURLEncoding.EncodedLen(32)
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// writeProblem writes an RFC 7807 problem details response.
+func writeProblem(w http.ResponseWriter, status int, title, detail string) {
+	w.Header().Set(HeaderContentType, "application/problem+json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(
+		map[string]any{
+			"type":   "about:blank",
+			"title":  title,
+			"status": status,
+			"detail": detail,
+		},
+	)
+}
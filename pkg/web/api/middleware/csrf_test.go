@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/scottcagno/angular-refresher/pkg/web"
+)
+
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// csrfCookie returns the token cookie set by the response.
+func csrfCookie(t *testing.T, w *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "XSRF-TOKEN" {
+			return c
+		}
+	}
+	t.Fatalf("No token cookie was set")
+	return nil
+}
+
+func TestCSRF(t *testing.T) {
+	h := CSRF(
+		&CSRFConfig{
+			TrustedOrigins: []string{"http://localhost:4200"},
+			ExemptPaths:    []string{"/hooks/"},
+		},
+	)(http.HandlerFunc(okHandler))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://rooms.test/api/bookings", nil))
+	token := csrfCookie(t, w)
+	if w.Code != http.StatusOK || token.HttpOnly {
+		t.Fatalf("Safe request: status %d, cookie %s", w.Code, token)
+	}
+	session := &http.Cookie{Name: "token", Value: "jwt"}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		header  map[string]string
+		form    url.Values
+		cookies []*http.Cookie
+		code    int
+	}{
+		{"safe", http.MethodGet, "/api/bookings", nil, nil, []*http.Cookie{session}, http.StatusOK},
+		{"no cookies", http.MethodPost, "/api/bookings", nil, nil, nil, http.StatusOK},
+		{"missing token", http.MethodPost, "/api/bookings", nil, nil, []*http.Cookie{session, token}, http.StatusForbidden},
+		{
+			"header", http.MethodPost, "/api/bookings", map[string]string{"X-XSRF-TOKEN": token.Value}, nil,
+			[]*http.Cookie{session, token}, http.StatusOK,
+		},
+		{
+			"form field", http.MethodPost, "/api/bookings", nil, url.Values{"_csrf": {token.Value}},
+			[]*http.Cookie{session, token}, http.StatusOK,
+		},
+		{
+			"wrong token", http.MethodDelete, "/api/bookings", map[string]string{"X-XSRF-TOKEN": "forged"}, nil,
+			[]*http.Cookie{session, token}, http.StatusForbidden,
+		},
+		{
+			"trusted origin", http.MethodPut, "/api/bookings",
+			map[string]string{"X-XSRF-TOKEN": token.Value, "Origin": "http://localhost:4200"}, nil,
+			[]*http.Cookie{session, token}, http.StatusOK,
+		},
+		{
+			"untrusted origin", http.MethodPut, "/api/bookings",
+			map[string]string{"X-XSRF-TOKEN": token.Value, "Origin": "http://attacker.example"}, nil,
+			[]*http.Cookie{session, token}, http.StatusForbidden,
+		},
+		{
+			"null origin", http.MethodPut, "/api/bookings",
+			map[string]string{"X-XSRF-TOKEN": token.Value, "Origin": "null"}, nil,
+			[]*http.Cookie{session, token}, http.StatusForbidden,
+		},
+		{
+			"same origin referer", http.MethodPut, "/api/bookings",
+			map[string]string{"X-XSRF-TOKEN": token.Value, "Referer": "http://rooms.test/bookings/3"}, nil,
+			[]*http.Cookie{session, token}, http.StatusOK,
+		},
+		{
+			"untrusted referer", http.MethodPut, "/api/bookings",
+			map[string]string{"X-XSRF-TOKEN": token.Value, "Referer": "http://attacker.example/"}, nil,
+			[]*http.Cookie{session, token}, http.StatusForbidden,
+		},
+		{"exempt path", http.MethodPost, "/hooks/build", nil, nil, []*http.Cookie{session}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var r *http.Request
+				if tt.form != nil {
+					r = httptest.NewRequest(tt.method, "http://rooms.test"+tt.path, strings.NewReader(tt.form.Encode()))
+					r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				} else {
+					r = httptest.NewRequest(tt.method, "http://rooms.test"+tt.path, nil)
+				}
+				for k, v := range tt.header {
+					r.Header.Set(k, v)
+				}
+				for _, c := range tt.cookies {
+					r.AddCookie(c)
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				if w.Code != tt.code {
+					t.Fatalf("[%v] Status %d, expecting %d: %s", tt.name, w.Code, tt.code, w.Body)
+				}
+				if w.Code != http.StatusForbidden {
+					return
+				}
+				var problem map[string]any
+				if err := json.NewDecoder(w.Body).Decode(&problem); err != nil ||
+					w.Header().Get("Content-Type") != "application/problem+json" || problem["status"] != float64(403) {
+					t.Errorf("[%v] Unexpected problem response %v, %v", tt.name, problem, err)
+				}
+			},
+		)
+	}
+}
+
+func TestCSRFSession(t *testing.T) {
+	sm := web.NewSessionManager(nil)
+	defer sm.Close()
+	h := web.SessionMiddleware(sm)(CSRF(&CSRFConfig{UseSession: true})(http.HandlerFunc(okHandler)))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 2 {
+		t.Fatalf("Expecting the session and token cookies, got %v", cookies)
+	}
+	token := csrfCookie(t, w)
+
+	tests := []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{"session token", token.Value, http.StatusOK},
+		{"other token", strings.Repeat("A", csrfTokenLen), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodPost, "/", nil)
+				for _, c := range cookies {
+					if c.Name == token.Name {
+						// the synchronizer token does not depend on the cookie
+						c = &http.Cookie{Name: c.Name, Value: tt.token}
+					}
+					r.AddCookie(c)
+				}
+				r.Header.Set("X-XSRF-TOKEN", tt.token)
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				if w.Code != tt.code {
+					t.Errorf("[%v] Status %d, expecting %d", tt.name, w.Code, tt.code)
+				}
+			},
+		)
+	}
+
+	// Without a session, the token can not be kept
+	w = httptest.NewRecorder()
+	CSRF(&CSRFConfig{UseSession: true})(http.HandlerFunc(okHandler)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Missing session: status %d, expecting %d", w.Code, http.StatusInternalServerError)
+	}
+}
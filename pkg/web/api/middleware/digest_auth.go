@@ -0,0 +1,344 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scottcagno/angular-refresher/pkg/web"
+)
+
+// The digest algorithms. MD5 is only supported for older clients.
+const (
+	DigestSHA256 = "SHA-256"
+	DigestMD5    = "MD5"
+)
+
+// DigestCredentialProvider supplies the digest secrets of users. It is
+// implemented by HtdigestFile.
+type DigestCredentialProvider interface {
+	// DigestHA1 returns the user, along with the hex encoded hash of
+	// "username:realm:password" computed using the algorithm.
+	DigestHA1(username, realm, algorithm string) (*web.SystemUser, string, bool)
+}
+
+// ComputeHA1 returns the hex encoded hash of "username:realm:password" using
+// the algorithm, as stored by a DigestCredentialProvider.
+func ComputeHA1(algorithm, username, realm, password string) string {
+	return digestHash(algorithm, username+":"+realm+":"+password)
+}
+
+func digestHash(algorithm, s string) string {
+	var h hash.Hash
+	if algorithm == DigestMD5 {
+		h = md5.New()
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// DigestAuthConfig configures the HTTP Digest authentication (RFC 7616) of
+// the handlers. Unlike Basic authentication, the password is never sent, but
+// the provider must hold a secret derived from it for every realm.
+type DigestAuthConfig struct {
+	// Realm names the protection space, shown to the user by browsers. It
+	// is part of the secrets, so changing it requires new secrets.
+	//
+	// Optional. Default value "restricted"
+	Realm string
+
+	// Provider supplies the secrets of the users.
+	//
+	// Required.
+	Provider DigestCredentialProvider
+
+	// Algorithms are the algorithms offered to the clients, most preferred
+	// first.
+	//
+	// Optional. Default value []string{DigestSHA256}
+	Algorithms []string
+
+	// NonceTTL is how long a nonce can be used, after which the client is
+	// asked to authenticate using a new one.
+	//
+	// Optional. Default value 5 minutes
+	NonceTTL time.Duration
+
+	// MaxFailures is the number of failed attempts after which a username
+	// is locked out. A negative value disables the lockout.
+	//
+	// Optional. Default value 5
+	MaxFailures int
+
+	// LockoutDuration is how long a username stays locked out, and how
+	// long failed attempts are remembered.
+	//
+	// Optional. Default value 15 minutes
+	LockoutDuration time.Duration
+}
+
+var defaultDigestAuthConfig = &DigestAuthConfig{
+	Realm:           "restricted",
+	Algorithms:      []string{DigestSHA256},
+	NonceTTL:        time.Duration(5) * time.Minute,
+	MaxFailures:     5,
+	LockoutDuration: time.Duration(15) * time.Minute,
+}
+
+func checkDigestAuthConfig(conf *DigestAuthConfig) *DigestAuthConfig {
+	if conf == nil || conf.Provider == nil {
+		panic("digest auth: a credential provider is required")
+	}
+	c := *conf
+	if c.Realm == "" {
+		c.Realm = defaultDigestAuthConfig.Realm
+	}
+	if len(c.Algorithms) == 0 {
+		c.Algorithms = defaultDigestAuthConfig.Algorithms
+	}
+	if c.NonceTTL == 0 {
+		c.NonceTTL = defaultDigestAuthConfig.NonceTTL
+	}
+	if c.MaxFailures == 0 {
+		c.MaxFailures = defaultDigestAuthConfig.MaxFailures
+	}
+	if c.LockoutDuration == 0 {
+		c.LockoutDuration = defaultDigestAuthConfig.LockoutDuration
+	}
+	return &c
+}
+
+// digestAuth holds the state of a Digest middleware.
+type digestAuth struct {
+	conf   *DigestAuthConfig
+	key    []byte // signs the nonces
+	opaque string
+	locks  *lockout
+	now    func() time.Time
+
+	mu     sync.Mutex
+	counts map[string]uint64 // the last nonce count used with each nonce
+}
+
+func newDigestAuth(conf *DigestAuthConfig) *digestAuth {
+	d := &digestAuth{
+		conf:   checkDigestAuthConfig(conf),
+		key:    make([]byte, 32),
+		now:    time.Now,
+		counts: make(map[string]uint64),
+	}
+	d.locks = newLockout(d.conf.MaxFailures, d.conf.LockoutDuration)
+	opaque := make([]byte, 16)
+	if _, err := rand.Read(d.key); err != nil {
+		panic(err)
+	}
+	if _, err := rand.Read(opaque); err != nil {
+		panic(err)
+	}
+	d.opaque = hex.EncodeToString(opaque)
+	return d
+}
+
+// DigestAuth returns a middleware only passing on the requests carrying a
+// valid digest of the credentials of a user, which the handlers find using
+// AuthUser. Other requests get a 401 challenge, or a 429 while their username
+// is locked out. Nonces are signed and expire, and every nonce count can
+// only be used once, so requests can not be replayed.
+func DigestAuth(conf *DigestAuthConfig) Middleware {
+	d := newDigestAuth(conf)
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			d.serve(next, w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func (d *digestAuth) serve(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	scheme, params, found := strings.Cut(r.Header.Get(HeaderAuthorization), " ")
+	if !found || !strings.EqualFold(scheme, "Digest") {
+		d.challenge(w, false)
+		return
+	}
+	p := parseDigestParams(params)
+	algorithm := p["algorithm"]
+	if algorithm == "" {
+		algorithm = DigestMD5
+	}
+	username, nc, cnonce := p["username"], p["nc"], p["cnonce"]
+	if username == "" || p["realm"] != d.conf.Realm || p["qop"] != "auth" || cnonce == "" ||
+		p["uri"] != r.URL.RequestURI() || !d.offers(algorithm) {
+		d.challenge(w, false)
+		return
+	}
+	count, err := strconv.ParseUint(nc, 16, 64)
+	if err != nil || len(nc) != 8 {
+		d.challenge(w, false)
+		return
+	}
+	if wait := d.locks.locked(username); wait > 0 {
+		tooManyAttempts(w, wait)
+		return
+	}
+	issued, ok := d.checkNonce(p["nonce"])
+	if !ok {
+		d.challenge(w, false)
+		return
+	}
+	if d.now().Sub(issued) > d.conf.NonceTTL {
+		// the client only needs to retry using a new nonce
+		d.challenge(w, true)
+		return
+	}
+	user, ha1, found := d.conf.Provider.DigestHA1(username, d.conf.Realm, algorithm)
+	if !found {
+		// compute a digest all the same, so the time taken does not
+		// reveal whether the user exists
+		ha1 = digestHash(algorithm, d.opaque+username)
+	}
+	ha2 := digestHash(algorithm, r.Method+":"+p["uri"])
+	expected := digestHash(algorithm, ha1+":"+p["nonce"]+":"+nc+":"+cnonce+":auth:"+ha2)
+	if subtle.ConstantTimeCompare([]byte(strings.ToLower(p["response"])), []byte(expected)) != 1 || !found {
+		d.locks.fail(username)
+		d.challenge(w, false)
+		return
+	}
+	// the digest is valid, so the nonce count can be recorded
+	if !d.useCount(p["nonce"], count) {
+		d.challenge(w, false)
+		return
+	}
+	d.locks.reset(username)
+	rspauth := digestHash(algorithm, ha1+":"+p["nonce"]+":"+nc+":"+cnonce+":auth:"+digestHash(algorithm, ":"+p["uri"]))
+	w.Header().Set(
+		"Authentication-Info",
+		`qop=auth, rspauth="`+rspauth+`", cnonce=`+strconv.Quote(cnonce)+`, nc=`+nc,
+	)
+	next.ServeHTTP(w, withAuthUser(r, user))
+}
+
+// offers reports whether the algorithm is offered to the clients.
+func (d *digestAuth) offers(algorithm string) bool {
+	for _, a := range d.conf.Algorithms {
+		if a == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// challenge asks the client to authenticate, using any of the algorithms.
+// A stale challenge tells the client its nonce expired, so it can retry
+// using the new nonce without asking the user again.
+func (d *digestAuth) challenge(w http.ResponseWriter, stale bool) {
+	nonce := d.newNonce()
+	for _, algorithm := range d.conf.Algorithms {
+		ch := `Digest realm=` + strconv.Quote(d.conf.Realm) + `, qop="auth", algorithm=` + algorithm +
+			`, nonce="` + nonce + `", opaque="` + d.opaque + `"`
+		if stale {
+			ch += ", stale=true"
+		}
+		w.Header().Add(HeaderWWWAuthenticate, ch)
+	}
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// newNonce returns a nonce holding the time it was issued, and random bytes,
+// signed so the nonces do not need to be stored until they are used.
+func (d *digestAuth) newNonce() string {
+	b := make([]byte, 16, 32)
+	binary.BigEndian.PutUint64(b, uint64(d.now().UnixNano()))
+	if _, err := rand.Read(b[8:16]); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(append(b, d.nonceMAC(b)...))
+}
+
+// checkNonce verifies the signature of the nonce, and returns the time it
+// was issued.
+func (d *digestAuth) checkNonce(nonce string) (time.Time, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != 32 || !hmac.Equal(b[16:], d.nonceMAC(b[:16])) {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b))), true
+}
+
+func (d *digestAuth) nonceMAC(b []byte) []byte {
+	mac := hmac.New(sha256.New, d.key)
+	mac.Write(b)
+	return mac.Sum(nil)[:16]
+}
+
+// useCount records the nonce count used with the nonce, and reports whether
+// it is higher than the ones used before.
+func (d *digestAuth) useCount(nonce string, count uint64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if last, found := d.counts[nonce]; found && count <= last {
+		return false
+	}
+	d.counts[nonce] = count
+	// forget the counts of the expired nonces, now and then
+	if len(d.counts)%1024 == 0 {
+		for n := range d.counts {
+			if t, ok := d.checkNonce(n); !ok || d.now().Sub(t) > d.conf.NonceTTL {
+				delete(d.counts, n)
+			}
+		}
+	}
+	return true
+}
+
+// parseDigestParams parses the comma separated "key=value" parameters of a
+// Digest authorization header, where the values may be quoted strings.
+func parseDigestParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params
+		}
+		key, rest, found := strings.Cut(s, "=")
+		if !found {
+			return params
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " \t")
+		var value strings.Builder
+		if strings.HasPrefix(rest, `"`) {
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			if i < len(rest) {
+				i++ // the closing quote
+			}
+			s = rest[i:]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			value.WriteString(strings.TrimSpace(rest[:end]))
+			s = rest[end:]
+		}
+		params[key] = value.String()
+	}
+}
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// digestClient answers the Digest challenges like a browser would.
+type digestClient struct {
+	username string
+	password string
+	nonce    string
+	nc       int
+}
+
+// authorize reads the nonce of the challenge.
+func (c *digestClient) authorize(t *testing.T, w *httptest.ResponseRecorder) {
+	ch := w.Header().Get("WWW-Authenticate")
+	if !strings.HasPrefix(ch, "Digest ") {
+		t.Fatalf("Challenge %q, expecting a Digest challenge", ch)
+	}
+	c.nonce = parseDigestParams(strings.TrimPrefix(ch, "Digest "))["nonce"]
+	c.nc = 0
+}
+
+// header returns the authorization header of a request, using the next
+// nonce count.
+func (c *digestClient) header(method, uri string) string {
+	c.nc++
+	nc := fmt.Sprintf("%08x", c.nc)
+	ha1 := ComputeHA1(DigestSHA256, c.username, "rooms", c.password)
+	ha2 := digestHash(DigestSHA256, method+":"+uri)
+	response := digestHash(DigestSHA256, ha1+":"+c.nonce+":"+nc+":0a4f113b:auth:"+ha2)
+	return fmt.Sprintf(
+		`Digest username=%q, realm="rooms", uri=%q, algorithm=SHA-256, qop=auth, nonce=%q, nc=%s, cnonce="0a4f113b", response=%q`,
+		c.username, uri, c.nonce, nc, response,
+	)
+}
+
+func TestLoadHtdigest(t *testing.T) {
+	f, err := LoadHtdigest(
+		writeFile(
+			t, ".htdigest",
+			"alice:rooms:"+ComputeHA1(DigestSHA256, "alice", "rooms", "s3cret")+"\n"+
+				"alice:rooms:"+ComputeHA1(DigestMD5, "alice", "rooms", "s3cret")+"\n",
+		),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, algorithm := range []string{DigestSHA256, DigestMD5} {
+		user, ha1, ok := f.DigestHA1("alice", "rooms", algorithm)
+		if !ok || user.Username != "alice" || ha1 != ComputeHA1(algorithm, "alice", "rooms", "s3cret") {
+			t.Errorf("[%v] Unexpected secret %q", algorithm, ha1)
+		}
+	}
+	if _, _, ok := f.DigestHA1("alice", "admin", DigestSHA256); ok {
+		t.Errorf("Found the secret of another realm")
+	}
+	for _, content := range []string{"alice:rooms\n", "alice:rooms:xyz\n", "alice:rooms:abcd\n"} {
+		if _, err = LoadHtdigest(writeFile(t, ".htdigest", content)); err == nil {
+			t.Errorf("Loaded %q, expecting an error", content)
+		}
+	}
+}
+
+func TestDigestAuth(t *testing.T) {
+	f, err := LoadHtdigest(
+		writeFile(t, ".htdigest", "alice:rooms:"+ComputeHA1(DigestSHA256, "alice", "rooms", "s3cret")+"\n"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDigestAuth(&DigestAuthConfig{Realm: "rooms", Provider: f})
+	now := time.Unix(1700000000, 0)
+	d.now = func() time.Time { return now }
+	var username string
+	next := http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			user, _ := AuthUser(r)
+			username = user.Username
+		},
+	)
+	serve := func(authorization string) *httptest.ResponseRecorder {
+		username = ""
+		r := httptest.NewRequest(http.MethodGet, "/api/rooms?floor=2", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		d.serve(next, w, r)
+		return w
+	}
+
+	alice := &digestClient{username: "alice", password: "s3cret"}
+	w := serve("")
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Status %d, expecting %d", w.Code, http.StatusUnauthorized)
+	}
+	alice.authorize(t, w)
+
+	w = serve(alice.header(http.MethodGet, "/api/rooms?floor=2"))
+	if w.Code != http.StatusOK || username != "alice" {
+		t.Fatalf("Status %d for %q, expecting %d for alice", w.Code, username, http.StatusOK)
+	}
+	if info := w.Header().Get("Authentication-Info"); !strings.Contains(info, "rspauth=") {
+		t.Errorf("Authentication-Info %q, expecting a rspauth", info)
+	}
+	authorization := alice.header(http.MethodGet, "/api/rooms?floor=2")
+	if w = serve(authorization); w.Code != http.StatusOK {
+		t.Fatalf("Status %d using the next nonce count, expecting %d", w.Code, http.StatusOK)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+	}{
+		{"replayed", authorization},
+		{"other uri", alice.header(http.MethodGet, "/api/rooms")},
+		{"wrong password", (&digestClient{username: "alice", password: "nope", nonce: alice.nonce}).header(http.MethodGet, "/api/rooms?floor=2")},
+		{"unknown user", (&digestClient{username: "bob", password: "s3cret", nonce: alice.nonce}).header(http.MethodGet, "/api/rooms?floor=2")},
+		{"forged nonce", (&digestClient{username: "alice", password: "s3cret", nonce: "bm9uY2U"}).header(http.MethodGet, "/api/rooms?floor=2")},
+		{"basic", "Basic YWxpY2U6czNjcmV0"},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if w := serve(tt.authorization); w.Code != http.StatusUnauthorized || username != "" {
+					t.Errorf("[%v] Status %d, expecting %d", tt.name, w.Code, http.StatusUnauthorized)
+				}
+			},
+		)
+	}
+
+	// An expired nonce is stale, so the client retries using a new one
+	now = now.Add(10 * time.Minute)
+	w = serve(alice.header(http.MethodGet, "/api/rooms?floor=2"))
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), "stale=true") {
+		t.Fatalf("Status %d, challenge %q, expecting a stale challenge", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+	alice.authorize(t, w)
+	if w = serve(alice.header(http.MethodGet, "/api/rooms?floor=2")); w.Code != http.StatusOK {
+		t.Fatalf("Status %d using the new nonce, expecting %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestParseDigestParams(t *testing.T) {
+	got := parseDigestParams(`username="Mufasa", realm="a \"quoted\", realm", nc=00000001,qop=auth`)
+	want := map[string]string{
+		"username": "Mufasa",
+		"realm":    `a "quoted", realm`,
+		"nc":       "00000001",
+		"qop":      "auth",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Parsed %v, expecting %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: %q, expecting %q", k, got[k], v)
+		}
+	}
+}
@@ -79,6 +79,7 @@ const (
 	HeaderContentSecurityPolicy           = "Content-Security-Policy"
 	HeaderContentSecurityPolicyReportOnly = "Content-Security-Policy-Report-Only"
 	HeaderCrossOriginResourcePolicy       = "Cross-Origin-Resource-Policy"
+	HeaderCrossOriginOpenerPolicy         = "Cross-Origin-Opener-Policy"
 	HeaderExpectCT                        = "Expect-CT"
 	// Deprecated: use HeaderPermissionsPolicy instead
 	HeaderFeaturePolicy           = "Feature-Policy"
@@ -97,6 +98,7 @@ const (
 	HeaderPingFrom                = "Ping-From"
 	HeaderPingTo                  = "Ping-To"
 	HeaderReportTo                = "Report-To"
+	HeaderReportingEndpoints      = "Reporting-Endpoints"
 	HeaderTE                      = "TE"
 	HeaderTrailer                 = "Trailer"
 	HeaderTransferEncoding        = "Transfer-Encoding"
@@ -124,6 +126,8 @@ const (
 	HeaderXPingback               = "X-Pingback"
 	HeaderXRequestID              = "X-Request-ID"
 	HeaderXRequestedWith          = "X-Requested-With"
+	HeaderXCSRFToken              = "X-CSRF-Token"
+	HeaderXXSRFToken              = "X-XSRF-Token"
 	HeaderXRobotsTag              = "X-Robots-Tag"
 	HeaderXUACompatible           = "X-UA-Compatible"
 )
@@ -0,0 +1,220 @@
+package middleware
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/scottcagno/angular-refresher/pkg/web"
+	"github.com/scottcagno/angular-refresher/pkg/web/password"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrHtpasswdFormat = errors.New("htpasswd: line is not in a supported format")
+
+// HtpasswdFile is a CredentialProvider reading the users from a file in the
+// htpasswd format, holding one "username:hash" line per user. The hashes
+// must be PHC encoded scrypt hashes, as made by the password package, bcrypt
+// hashes, as made by "htpasswd -B", or legacy "{SHA}" hashes, which are weak
+// and should be replaced. Files holding any other hash, such as the "$apr1$"
+// hashes made by "htpasswd -m", are refused. Empty lines and lines starting
+// with "#" are ignored.
+type HtpasswdFile struct {
+	path   string
+	hasher *password.Hasher
+	dummy  string
+
+	mu    sync.RWMutex
+	users map[string]string
+}
+
+// LoadHtpasswd reads the htpasswd file at path.
+func LoadHtpasswd(path string) (*HtpasswdFile, error) {
+	dummy, err := password.Hash("")
+	if err != nil {
+		return nil, err
+	}
+	f := &HtpasswdFile{path: path, hasher: password.DefaultHasher, dummy: dummy}
+	if err = f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload reads the file again, so the changes made to it are applied.
+func (f *HtpasswdFile) Reload() error {
+	fd, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	users := make(map[string]string)
+	err = readCredentialLines(
+		fd, 2, func(fields []string) error {
+			hash := fields[1]
+			switch htpasswdScheme(hash) {
+			case htpasswdScrypt, htpasswdSHA:
+			case htpasswdBcrypt:
+				if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+					return ErrHtpasswdFormat
+				}
+			default:
+				return ErrHtpasswdFormat
+			}
+			users[fields[0]] = hash
+			return nil
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+	f.mu.Lock()
+	f.users = users
+	f.mu.Unlock()
+	return nil
+}
+
+// Authenticate implements the CredentialProvider interface.
+func (f *HtpasswdFile) Authenticate(username, pass string) (*web.SystemUser, bool) {
+	f.mu.RLock()
+	hash, found := f.users[username]
+	f.mu.RUnlock()
+	if !found {
+		_, _, _ = f.hasher.Verify(pass, f.dummy)
+		return nil, false
+	}
+	switch htpasswdScheme(hash) {
+	case htpasswdSHA:
+		sum := sha1.Sum([]byte(pass))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) != 1 {
+			return nil, false
+		}
+		return &web.SystemUser{Username: username}, true
+	case htpasswdBcrypt:
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) != nil {
+			return nil, false
+		}
+		return &web.SystemUser{Username: username}, true
+	}
+	if ok, _, err := f.hasher.Verify(pass, hash); err != nil || !ok {
+		return nil, false
+	}
+	return &web.SystemUser{Username: username}, true
+}
+
+// The hash schemes supported in htpasswd files.
+const (
+	htpasswdUnsupported = iota
+	htpasswdScrypt
+	htpasswdBcrypt
+	htpasswdSHA
+)
+
+// htpasswdScheme returns the scheme of an htpasswd hash.
+func htpasswdScheme(hash string) int {
+	switch {
+	case strings.HasPrefix(hash, "$scrypt$") && password.IsHashed(hash):
+		return htpasswdScrypt
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return htpasswdBcrypt
+	case strings.HasPrefix(hash, "{SHA}"):
+		return htpasswdSHA
+	}
+	return htpasswdUnsupported
+}
+
+// HtdigestFile is a DigestCredentialProvider reading the users from a file in
+// the htdigest format, holding one "username:realm:ha1" line per user and
+// realm, where ha1 is the hex encoded hash of "username:realm:password". A
+// hash of 64 digits is a SHA-256 hash, and one of 32 digits an MD5 hash.
+type HtdigestFile struct {
+	path string
+
+	mu    sync.RWMutex
+	users map[string]string // "username:realm:algorithm" to ha1
+}
+
+// LoadHtdigest reads the htdigest file at path.
+func LoadHtdigest(path string) (*HtdigestFile, error) {
+	f := &HtdigestFile{path: path}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload reads the file again, so the changes made to it are applied.
+func (f *HtdigestFile) Reload() error {
+	fd, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	users := make(map[string]string)
+	err = readCredentialLines(
+		fd, 3, func(fields []string) error {
+			ha1 := strings.ToLower(fields[2])
+			if _, err := hex.DecodeString(ha1); err != nil {
+				return ErrHtpasswdFormat
+			}
+			var algorithm string
+			switch len(ha1) {
+			case 64:
+				algorithm = DigestSHA256
+			case 32:
+				algorithm = DigestMD5
+			default:
+				return ErrHtpasswdFormat
+			}
+			users[fields[0]+":"+fields[1]+":"+algorithm] = ha1
+			return nil
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+	f.mu.Lock()
+	f.users = users
+	f.mu.Unlock()
+	return nil
+}
+
+// DigestHA1 implements the DigestCredentialProvider interface.
+func (f *HtdigestFile) DigestHA1(username, realm, algorithm string) (*web.SystemUser, string, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	ha1, found := f.users[username+":"+realm+":"+algorithm]
+	if !found {
+		return nil, "", false
+	}
+	return &web.SystemUser{Username: username}, ha1, true
+}
+
+// readCredentialLines calls fn with the n colon separated fields of every
+// line that is not empty or a comment.
+func readCredentialLines(r io.Reader, n int, fn func(fields []string) error) error {
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.SplitN(text, ":", n)
+		if len(fields) != n || fields[0] == "" {
+			return fmt.Errorf("line %d: %w", line, ErrHtpasswdFormat)
+		}
+		if err := fn(fields); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SecureHeadersConfig configures the security headers set on every response.
+// The string fields can be set to "-" to leave their header out.
+type SecureHeadersConfig struct {
+	// CSP is the Content Security Policy of the responses.
+	//
+	// Optional. Default value DefaultCSP()
+	// Header definition: Content-Security-Policy: <policy>
+	CSP *CSP
+
+	// HSTSMaxAge is how long (in seconds) browsers must only use TLS to
+	// reach the host. It is only sent over TLS, and a negative value leaves
+	// the header out.
+	//
+	// Optional. Default value 31536000 (one year)
+	// Header definition: Strict-Transport-Security: max-age=<seconds>[; includeSubDomains][; preload]
+	HSTSMaxAge int
+
+	// HSTSIncludeSubdomains applies the HSTS policy to every subdomain.
+	//
+	// Optional. Default value false
+	HSTSIncludeSubdomains bool
+
+	// HSTSPreload asks for the host to be included in the HSTS preload
+	// lists of the browsers, which requires the policy to include the
+	// subdomains and last at least one year, so it implies both.
+	//
+	// Optional. Default value false
+	HSTSPreload bool
+
+	// FrameOptions controls whether the responses can be framed.
+	//
+	// Optional. Default value "DENY"
+	// Header definition: X-Frame-Options: DENY | SAMEORIGIN
+	FrameOptions string
+
+	// ContentTypeOptions stops browsers guessing the content type.
+	//
+	// Optional. Default value "nosniff"
+	// Header definition: X-Content-Type-Options: nosniff
+	ContentTypeOptions string
+
+	// ReferrerPolicy controls the referrer sent along with requests.
+	//
+	// Optional. Default value "strict-origin-when-cross-origin"
+	// Header definition: Referrer-Policy: <policy>
+	ReferrerPolicy string
+
+	// PermissionsPolicy controls the browser features the pages can use.
+	//
+	// Optional. Default value "camera=(), microphone=(), geolocation=()"
+	// Header definition: Permissions-Policy: <feature>=<allowlist>[, ...]
+	PermissionsPolicy string
+
+	// CrossOriginOpenerPolicy isolates the pages from the windows they open
+	// or are opened by.
+	//
+	// Optional. Default value "same-origin"
+	// Header definition: Cross-Origin-Opener-Policy: <policy>
+	CrossOriginOpenerPolicy string
+
+	// ReportingEndpoints maps the names of Reporting API groups to their
+	// URLs, for the report-to directive of the policy.
+	//
+	// Optional. Default value nil
+	// Header definition: Reporting-Endpoints: <name>="<url>"[, ...]
+	ReportingEndpoints map[string]string
+}
+
+var defaultSecureHeadersConfig = &SecureHeadersConfig{
+	HSTSMaxAge:              31536000,
+	FrameOptions:            "DENY",
+	ContentTypeOptions:      "nosniff",
+	ReferrerPolicy:          "strict-origin-when-cross-origin",
+	PermissionsPolicy:       "camera=(), microphone=(), geolocation=()",
+	CrossOriginOpenerPolicy: "same-origin",
+}
+
+func checkSecureHeadersConfig(conf *SecureHeadersConfig) *SecureHeadersConfig {
+	if conf == nil {
+		conf = defaultSecureHeadersConfig
+	}
+	c := *conf
+	if c.CSP == nil {
+		c.CSP = DefaultCSP()
+	}
+	if c.HSTSMaxAge == 0 {
+		c.HSTSMaxAge = defaultSecureHeadersConfig.HSTSMaxAge
+	}
+	if c.HSTSPreload {
+		c.HSTSIncludeSubdomains = true
+		if c.HSTSMaxAge < defaultSecureHeadersConfig.HSTSMaxAge {
+			c.HSTSMaxAge = defaultSecureHeadersConfig.HSTSMaxAge
+		}
+	}
+	if c.FrameOptions == "" {
+		c.FrameOptions = defaultSecureHeadersConfig.FrameOptions
+	}
+	if c.ContentTypeOptions == "" {
+		c.ContentTypeOptions = defaultSecureHeadersConfig.ContentTypeOptions
+	}
+	if c.ReferrerPolicy == "" {
+		c.ReferrerPolicy = defaultSecureHeadersConfig.ReferrerPolicy
+	}
+	if c.PermissionsPolicy == "" {
+		c.PermissionsPolicy = defaultSecureHeadersConfig.PermissionsPolicy
+	}
+	if c.CrossOriginOpenerPolicy == "" {
+		c.CrossOriginOpenerPolicy = defaultSecureHeadersConfig.CrossOriginOpenerPolicy
+	}
+	return &c
+}
+
+// SecureHeaders returns a middleware setting the security headers of the
+// config on every response. When the policy uses SourceNonce, a new nonce is
+// made for every request, and found by the handlers using CSPNonce.
+func SecureHeaders(conf *SecureHeadersConfig) Middleware {
+	c := checkSecureHeadersConfig(conf)
+	// The headers that do not change between requests are built once
+	static := make(http.Header)
+	set := func(name, value string) {
+		if value != "-" {
+			static.Set(name, value)
+		}
+	}
+	set(HeaderXFrameOptions, c.FrameOptions)
+	set(HeaderXContentTypeOptions, c.ContentTypeOptions)
+	set(HeaderReferrerPolicy, c.ReferrerPolicy)
+	set(HeaderPermissionsPolicy, c.PermissionsPolicy)
+	set(HeaderCrossOriginOpenerPolicy, c.CrossOriginOpenerPolicy)
+	if len(c.ReportingEndpoints) > 0 {
+		var endpoints []string
+		for name, url := range c.ReportingEndpoints {
+			endpoints = append(endpoints, name+`="`+url+`"`)
+		}
+		sort.Strings(endpoints)
+		static.Set(HeaderReportingEndpoints, strings.Join(endpoints, ", "))
+	}
+	var hsts string
+	if c.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(c.HSTSMaxAge)
+		if c.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if c.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+	useNonce := c.CSP.usesNonce()
+	policy := c.CSP.String()
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			for name := range static {
+				h.Set(name, static.Get(name))
+			}
+			// browsers ignore the header over plain HTTP
+			if hsts != "" && isTLS(r) {
+				h.Set(HeaderStrictTransportSecurity, hsts)
+			}
+			if useNonce {
+				var nonce string
+				var err error
+				if r, nonce, err = newCSPNonce(r); err != nil {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				h.Set(c.CSP.Header(), c.CSP.Build(nonce))
+			} else if policy != "" {
+				h.Set(c.CSP.Header(), policy)
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// isTLS reports whether the request was received over TLS, directly or by a
+// proxy.
+func isTLS(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get(HeaderXForwardedProto), "https")
+}
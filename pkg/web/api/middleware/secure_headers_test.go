@@ -0,0 +1,186 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCSP(t *testing.T) {
+	tests := []struct {
+		name   string
+		csp    *CSP
+		header string
+		policy string
+	}{
+		{
+			"default", DefaultCSP(), HeaderContentSecurityPolicy,
+			"default-src 'self'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'",
+		},
+		{
+			"nonce and reports",
+			NewCSP().
+				Set(ScriptSrc, SourceSelf).
+				Add(ScriptSrc, SourceNonce, SourceStrictDynamic).
+				Set(UpgradeInsecureRequests).
+				ReportURI("/csp-reports").
+				ReportTo("csp"),
+			HeaderContentSecurityPolicy,
+			"script-src 'self' 'nonce-abc' 'strict-dynamic'; upgrade-insecure-requests; report-uri /csp-reports; report-to csp",
+		},
+		{
+			"replaced", DefaultCSP().Set(DefaultSrc, SourceNone, "https://cdn.rooms.test").ReportOnly(),
+			HeaderContentSecurityPolicyReportOnly,
+			"default-src 'none' https://cdn.rooms.test; object-src 'none'; base-uri 'self'; frame-ancestors 'none'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if got := tt.csp.Build("abc"); got != tt.policy {
+					t.Errorf("[%v] Policy %q, expecting %q", tt.name, got, tt.policy)
+				}
+				if got := tt.csp.Header(); got != tt.header {
+					t.Errorf("[%v] Header %q, expecting %q", tt.name, got, tt.header)
+				}
+			},
+		)
+	}
+}
+
+func TestSecureHeaders(t *testing.T) {
+	var nonce string
+	next := http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			nonce = CSPNonce(r)
+		},
+	)
+
+	tests := []struct {
+		name   string
+		conf   *SecureHeadersConfig
+		tls    bool
+		header map[string]string
+	}{
+		{
+			"defaults", nil, false, map[string]string{
+				"X-Frame-Options":           "DENY",
+				"X-Content-Type-Options":    "nosniff",
+				"Referrer-Policy":           "strict-origin-when-cross-origin",
+				"Content-Security-Policy":   DefaultCSP().String(),
+				"Strict-Transport-Security": "",
+			},
+		},
+		{
+			"hsts", nil, true, map[string]string{
+				"Strict-Transport-Security": "max-age=31536000",
+			},
+		},
+		{
+			"hsts preload", &SecureHeadersConfig{HSTSMaxAge: 600, HSTSPreload: true}, true, map[string]string{
+				"Strict-Transport-Security": "max-age=31536000; includeSubDomains; preload",
+			},
+		},
+		{
+			"disabled", &SecureHeadersConfig{HSTSMaxAge: -1, FrameOptions: "-"}, true, map[string]string{
+				"Strict-Transport-Security": "",
+				"X-Frame-Options":           "",
+			},
+		},
+		{
+			"report to",
+			&SecureHeadersConfig{
+				CSP:                DefaultCSP().ReportTo("csp").ReportOnly(),
+				ReportingEndpoints: map[string]string{"csp": "https://rooms.test/csp-reports"},
+			},
+			false, map[string]string{
+				"Content-Security-Policy":             "",
+				"Content-Security-Policy-Report-Only": DefaultCSP().ReportTo("csp").String(),
+				"Reporting-Endpoints":                 `csp="https://rooms.test/csp-reports"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				if tt.tls {
+					r.TLS = &tls.ConnectionState{}
+				}
+				w := httptest.NewRecorder()
+				SecureHeaders(tt.conf)(next).ServeHTTP(w, r)
+				for name, want := range tt.header {
+					if got := w.Header().Get(name); got != want {
+						t.Errorf("[%v] %s: %q, expecting %q", tt.name, name, got, want)
+					}
+				}
+			},
+		)
+	}
+
+	// A new nonce is made for every request, and placed in the policy
+	h := SecureHeaders(&SecureHeadersConfig{CSP: NewCSP().Set(ScriptSrc, SourceNonce)})(next)
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if nonce == "" || seen[nonce] || w.Header().Get("Content-Security-Policy") != "script-src 'nonce-"+nonce+"'" {
+			t.Fatalf("Unexpected nonce %q, policy %q", nonce, w.Header().Get("Content-Security-Policy"))
+		}
+		seen[nonce] = true
+	}
+}
+
+func TestCSPReportCollector(t *testing.T) {
+	c := NewCSPReportCollector(nil, 2)
+	var count int
+	c.OnReport = func(CSPReport) { count++ }
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		code        int
+		blocked     string
+	}{
+		{
+			"report-uri", http.MethodPost, "application/csp-report",
+			`{"csp-report":{"document-uri":"https://rooms.test/","violated-directive":"script-src","blocked-uri":"https://evil.example/x.js"}}`,
+			http.StatusNoContent, "https://evil.example/x.js",
+		},
+		{
+			"report-to", http.MethodPost, "application/reports+json",
+			`[{"type":"csp-violation","body":{"documentURL":"https://rooms.test/","effectiveDirective":"img-src","blockedURL":"https://evil.example/x.png"}},{"type":"deprecation","body":{}}]`,
+			http.StatusNoContent, "https://evil.example/x.png",
+		},
+		{"not json", http.MethodPost, "application/csp-report", "nope", http.StatusBadRequest, ""},
+		{"too large", http.MethodPost, "application/csp-report", strings.Repeat(" ", maxCSPReportSize+1), http.StatusRequestEntityTooLarge, ""},
+		{"get", http.MethodGet, "", "", http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				r := httptest.NewRequest(tt.method, "/csp-reports", strings.NewReader(tt.body))
+				r.Header.Set("Content-Type", tt.contentType)
+				w := httptest.NewRecorder()
+				c.ServeHTTP(w, r)
+				if w.Code != tt.code {
+					t.Fatalf("[%v] Status %d, expecting %d", tt.name, w.Code, tt.code)
+				}
+				if tt.blocked == "" {
+					return
+				}
+				reports := c.Reports()
+				if last := reports[len(reports)-1]; last.BlockedURI != tt.blocked || last.EffectiveDirective == "" {
+					t.Errorf("[%v] Unexpected report %+v", tt.name, last)
+				}
+			},
+		)
+	}
+	if count != 2 || len(c.Reports()) != 2 {
+		t.Errorf("Collected %d reports, kept %d, expecting 2", count, len(c.Reports()))
+	}
+}
@@ -0,0 +1,661 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/scottcagno/angular-refresher/pkg/web"
+	"github.com/scottcagno/angular-refresher/pkg/web/jwt"
+)
+
+const (
+	tokenEndpoint      = "/token"
+	authorizeEndpoint  = "/authorize"
+	introspectEndpoint = "/introspect"
+	revokeEndpoint     = "/revoke"
+)
+
+// OAuth 2.0 error codes, as defined in RFC 6749, section 5.2 and 4.1.2.1.
+const (
+	oauthInvalidRequest          = "invalid_request"
+	oauthInvalidClient           = "invalid_client"
+	oauthInvalidGrant            = "invalid_grant"
+	oauthInvalidScope            = "invalid_scope"
+	oauthUnauthorizedClient      = "unauthorized_client"
+	oauthUnsupportedGrantType    = "unsupported_grant_type"
+	oauthUnsupportedResponseType = "unsupported_response_type"
+	oauthServerError             = "server_error"
+)
+
+// ClientSubjectPrefix prefixes the "sub" claim of tokens issued to a client
+// itself, so a client can never pass for the user whose name matches its ID.
+const ClientSubjectPrefix = "client:"
+
+// OAuthServerConfig configures an OAuthServer.
+type OAuthServerConfig struct {
+	// Service issues, validates and revokes the tokens.
+	Service *jwt.JWTService
+
+	// Users holds the resource owners.
+	//
+	// Optional. Default value is an empty UserStore
+	Users *UserStore
+
+	// Clients holds the registered clients.
+	//
+	// Optional. Default value is an empty OAuthClientStore
+	Clients *OAuthClientStore
+
+	// Authenticator authenticates the resource owner at the authorization
+	// endpoint, for example using the session or token cookie set by the
+	// login page of the application.
+	//
+	// Optional. Default value is HTTP basic authentication against Users
+	Authenticator Authenticator
+
+	// MFA holds the second factors of the users. The password grant, and the
+	// basic authentication fallback of the authorization endpoint, are
+	// refused for users that need a second factor, as neither can verify it.
+	//
+	// Optional. Default value nil, no second factor is required
+	MFA *MFAStore
+
+	// CodeTTL is the lifetime of an authorization code.
+	//
+	// Optional. Default value 1 minute
+	CodeTTL time.Duration
+}
+
+func checkOAuthServerConfig(conf *OAuthServerConfig) (*OAuthServerConfig, error) {
+	if conf == nil || conf.Service == nil {
+		return nil, errors.New("oauth: a JWTService is required")
+	}
+	c := *conf
+	if c.Users == nil {
+		c.Users = NewUserStore()
+	}
+	if c.Clients == nil {
+		c.Clients = NewOAuthClientStore()
+	}
+	if c.CodeTTL <= 0 {
+		c.CodeTTL = time.Minute
+	}
+	return &c, nil
+}
+
+// OAuthServer is an OAuth 2.0 authorization server (RFC 6749) issuing the
+// tokens of a JWTService. It supports the client_credentials, password,
+// authorization_code and refresh_token grants, requires PKCE (RFC 7636) for
+// the authorization_code grant, and implements token introspection
+// (RFC 7662) and revocation (RFC 7009).
+//
+// The granted scope is placed in the space separated "scope" claim, and the
+// client in the "client_id" claim, of every token issued. The Authorizer only
+// grants these tokens access to rules listing one of their scopes.
+type OAuthServer struct {
+	Service       *jwt.JWTService
+	Users         *UserStore
+	Clients       *OAuthClientStore
+	Authenticator Authenticator
+	MFA           *MFAStore
+
+	TokenPath      string
+	AuthorizePath  string
+	IntrospectPath string
+	RevokePath     string
+
+	codeTTL time.Duration
+	codes   *MemoryStore[string, authorizationCode]
+	now     func() time.Time
+}
+
+func NewOAuthServer(conf *OAuthServerConfig) (*OAuthServer, error) {
+	conf, err := checkOAuthServerConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &OAuthServer{
+		Service:        conf.Service,
+		Users:          conf.Users,
+		Clients:        conf.Clients,
+		Authenticator:  conf.Authenticator,
+		MFA:            conf.MFA,
+		TokenPath:      tokenEndpoint,
+		AuthorizePath:  authorizeEndpoint,
+		IntrospectPath: introspectEndpoint,
+		RevokePath:     revokeEndpoint,
+		codeTTL:        conf.CodeTTL,
+		codes:          NewMemoryStore[string, authorizationCode](),
+		now:            time.Now,
+	}, nil
+}
+
+// authorizationCode is the grant issued by the authorization endpoint.
+type authorizationCode struct {
+	ClientID      string
+	RedirectURI   string
+	Subject       string
+	Role          string
+	Scope         string
+	CodeChallenge string
+	ExpiresAt     time.Time
+}
+
+// oauthTokenResponse is the successful response of the token endpoint.
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// Token implements the token endpoint.
+func (s *OAuthServer) Token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeOAuthError(w, http.StatusMethodNotAllowed, oauthInvalidRequest, "the token endpoint only accepts POST")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, err.Error())
+		return
+	}
+	client, ok := s.authenticateClient(w, r, true)
+	if !ok {
+		return
+	}
+	grantType := r.PostForm.Get("grant_type")
+	switch grantType {
+	case GrantClientCredentials, GrantPassword, GrantAuthorizationCode, GrantRefreshToken:
+	case "":
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "grant_type is required")
+		return
+	default:
+		writeOAuthError(w, http.StatusBadRequest, oauthUnsupportedGrantType, "")
+		return
+	}
+	if !client.AllowsGrant(grantType) {
+		writeOAuthError(w, http.StatusBadRequest, oauthUnauthorizedClient, "the client may not use the "+grantType+" grant")
+		return
+	}
+	switch grantType {
+	case GrantClientCredentials:
+		s.clientCredentialsGrant(w, r, client)
+	case GrantPassword:
+		s.passwordGrant(w, r, client)
+	case GrantAuthorizationCode:
+		s.authorizationCodeGrant(w, r, client)
+	case GrantRefreshToken:
+		s.refreshTokenGrant(w, r, client)
+	}
+}
+
+// clientCredentialsGrant issues an access token to the client itself. As
+// recommended by RFC 6749, section 4.4.3, no refresh token is issued.
+func (s *OAuthServer) clientCredentialsGrant(w http.ResponseWriter, r *http.Request, client *OAuthClient) {
+	scope, ok := grantScope(client.Scopes, r.PostForm.Get("scope"))
+	if !ok {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidScope, "")
+		return
+	}
+	token, exp, err := s.Service.IssueAccessToken(ClientSubjectPrefix+client.ID, client.Role, oauthClaims(client.ID, scope))
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, oauthServerError, "")
+		return
+	}
+	writeOAuthJSON(
+		w, http.StatusOK, oauthTokenResponse{
+			AccessToken: token,
+			TokenType:   "Bearer",
+			ExpiresIn:   int(exp.Sub(s.now()).Seconds()),
+			Scope:       scope,
+		},
+	)
+}
+
+// passwordGrant exchanges the credentials of a user for a token pair. It only
+// exists for legacy clients; new clients should use the authorization_code
+// grant.
+func (s *OAuthServer) passwordGrant(w http.ResponseWriter, r *http.Request, client *OAuthClient) {
+	username, password := r.PostForm.Get("username"), r.PostForm.Get("password")
+	if username == "" || password == "" {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "username and password are required")
+		return
+	}
+	scope, ok := grantScope(client.Scopes, r.PostForm.Get("scope"))
+	if !ok {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidScope, "")
+		return
+	}
+	user, ok := s.Users.Authenticate(username, password)
+	if !ok {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "invalid username or password")
+		return
+	}
+	if s.requiresMFA(user) {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "a second factor is required, use the authorization_code grant")
+		return
+	}
+	pair, err := s.Service.IssueTokenPairWithClaims(user.Username, user.Role, oauthClaims(client.ID, scope))
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, oauthServerError, "")
+		return
+	}
+	s.writeTokenPair(w, pair, scope)
+}
+
+// authorizationCodeGrant exchanges an authorization code, along with the PKCE
+// code verifier, for a token pair. A code can only be used once.
+func (s *OAuthServer) authorizationCodeGrant(w http.ResponseWriter, r *http.Request, client *OAuthClient) {
+	code, verifier := r.PostForm.Get("code"), r.PostForm.Get("code_verifier")
+	if code == "" || verifier == "" {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "code and code_verifier are required")
+		return
+	}
+	ac, err := s.codes.Del(code)
+	if err != nil || ac.ClientID != client.ID || s.now().After(ac.ExpiresAt) {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "invalid authorization code")
+		return
+	}
+	if ac.RedirectURI != r.PostForm.Get("redirect_uri") {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "redirect_uri does not match the authorization request")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(pkceChallenge(verifier)), []byte(ac.CodeChallenge)) != 1 {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "invalid code_verifier")
+		return
+	}
+	pair, err := s.Service.IssueTokenPairWithClaims(ac.Subject, ac.Role, oauthClaims(client.ID, ac.Scope))
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, oauthServerError, "")
+		return
+	}
+	s.writeTokenPair(w, pair, ac.Scope)
+}
+
+// refreshTokenGrant exchanges a refresh token for a new token pair. The
+// refresh token must have been issued to the same client, and the scope may
+// only be narrowed.
+func (s *OAuthServer) refreshTokenGrant(w http.ResponseWriter, r *http.Request, client *OAuthClient) {
+	refreshToken := r.PostForm.Get("refresh_token")
+	if refreshToken == "" {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "refresh_token is required")
+		return
+	}
+	var scope string
+	pair, err := s.Service.RefreshWith(
+		refreshToken, func(rt *jwt.RefreshToken) error {
+			if id, _ := rt.Claims["client_id"].(string); id != client.ID {
+				return jwt.ErrRefreshTokenInvalid
+			}
+			granted, _ := rt.Claims["scope"].(string)
+			var ok bool
+			scope, ok = grantScope(strings.Fields(granted), r.PostForm.Get("scope"))
+			if !ok {
+				return errInvalidScope
+			}
+			rt.Claims = oauthClaims(client.ID, scope)
+			return nil
+		},
+	)
+	if err == errInvalidScope {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidScope, "the scope may not exceed the original grant")
+		return
+	}
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, err.Error())
+		return
+	}
+	s.writeTokenPair(w, pair, scope)
+}
+
+var errInvalidScope = errors.New("oauth: invalid scope")
+
+func (s *OAuthServer) writeTokenPair(w http.ResponseWriter, pair *jwt.TokenPair, scope string) {
+	writeOAuthJSON(
+		w, http.StatusOK, oauthTokenResponse{
+			AccessToken:  pair.AccessToken,
+			TokenType:    pair.TokenType,
+			ExpiresIn:    pair.ExpiresIn,
+			RefreshToken: pair.RefreshToken,
+			Scope:        scope,
+		},
+	)
+}
+
+// Authorize implements the authorization endpoint for the authorization_code
+// grant. The resource owner is authenticated using the Authenticator, and the
+// request is approved without a consent page, so it should only be used by
+// first party clients. The client must send a S256 PKCE code challenge.
+func (s *OAuthServer) Authorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		writeOAuthError(w, http.StatusMethodNotAllowed, oauthInvalidRequest, "")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, err.Error())
+		return
+	}
+	// Errors are only reported back to the client using a redirect once
+	// both the client and the redirect URI are known to be valid
+	client, err := s.Clients.GetClient(r.Form.Get("client_id"))
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidClient, "unknown client_id")
+		return
+	}
+	redirectURI := r.Form.Get("redirect_uri")
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !client.AllowsRedirect(redirectURI) {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "unregistered redirect_uri")
+		return
+	}
+	state := r.Form.Get("state")
+	redirectError := func(code, description string) {
+		q := url.Values{"error": {code}}
+		if description != "" {
+			q.Set("error_description", description)
+		}
+		if state != "" {
+			q.Set("state", state)
+		}
+		http.Redirect(w, r, withQuery(redirectURI, q), http.StatusFound)
+	}
+	if r.Form.Get("response_type") != "code" {
+		redirectError(oauthUnsupportedResponseType, "")
+		return
+	}
+	if !client.AllowsGrant(GrantAuthorizationCode) {
+		redirectError(oauthUnauthorizedClient, "")
+		return
+	}
+	challenge := r.Form.Get("code_challenge")
+	if challenge == "" || r.Form.Get("code_challenge_method") != "S256" {
+		redirectError(oauthInvalidRequest, "a S256 code_challenge is required")
+		return
+	}
+	scope, ok := grantScope(client.Scopes, r.Form.Get("scope"))
+	if !ok {
+		redirectError(oauthInvalidScope, "")
+		return
+	}
+	principal, err := s.authenticateOwner(w, r)
+	if err != nil {
+		return
+	}
+	code, err := randomToken()
+	if err != nil {
+		redirectError(oauthServerError, "")
+		return
+	}
+	var role string
+	if len(principal.Roles) > 0 {
+		role = principal.Roles[0]
+	}
+	err = s.codes.Add(
+		code, authorizationCode{
+			ClientID:      client.ID,
+			RedirectURI:   r.Form.Get("redirect_uri"),
+			Subject:       principal.Subject,
+			Role:          role,
+			Scope:         scope,
+			CodeChallenge: challenge,
+			ExpiresAt:     s.now().Add(s.codeTTL),
+		},
+	)
+	if err != nil {
+		redirectError(oauthServerError, "")
+		return
+	}
+	q := url.Values{"code": {code}}
+	if state != "" {
+		q.Set("state", state)
+	}
+	http.Redirect(w, r, withQuery(redirectURI, q), http.StatusFound)
+}
+
+// authenticateOwner authenticates the resource owner at the authorization
+// endpoint. If authentication fails, the challenge has been written and an
+// error is returned.
+func (s *OAuthServer) authenticateOwner(w http.ResponseWriter, r *http.Request) (*Principal, error) {
+	if s.Authenticator != nil {
+		principal, err := s.Authenticator.Authenticate(r)
+		if err != nil {
+			s.Authenticator.Challenge(w, r, err)
+			return nil, err
+		}
+		return principal, nil
+	}
+	username, password, hasBasicAuth := r.BasicAuth()
+	if hasBasicAuth {
+		if user, ok := s.Users.Authenticate(username, password); ok && !s.requiresMFA(user) {
+			return &Principal{Subject: user.Username, Roles: []string{user.Role}}, nil
+		}
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="oauth", charset="UTF-8"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	return nil, ErrNoCredentials
+}
+
+// requiresMFA reports whether the user needs a second factor to log in.
+func (s *OAuthServer) requiresMFA(user *web.SystemUser) bool {
+	return s.MFA != nil && s.MFA.Requires(user.Username, user.Role)
+}
+
+// Introspect implements the token introspection endpoint (RFC 7662). Only
+// confidential clients, such as resource servers, may introspect tokens. Both
+// access and refresh tokens are supported.
+func (s *OAuthServer) Introspect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeOAuthError(w, http.StatusMethodNotAllowed, oauthInvalidRequest, "the introspection endpoint only accepts POST")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, err.Error())
+		return
+	}
+	if _, ok := s.authenticateClient(w, r, false); !ok {
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "token is required")
+		return
+	}
+	if t, err := s.Service.ValidateTokenString(token); err == nil {
+		claims := t.Claims.(jwt.MapClaims)
+		resp := M{"active": true, "token_type": "access_token"}
+		for _, name := range []string{"scope", "client_id", "sub", "aud", "iss", "exp", "iat", "nbf", "jti"} {
+			if v, ok := claims[name]; ok {
+				resp[name] = v
+			}
+		}
+		resp["username"] = claims["sub"]
+		writeOAuthJSON(w, http.StatusOK, resp)
+		return
+	}
+	rt, err := s.Service.LookupRefreshToken(token)
+	if err != nil || rt.Used || rt.Revoked || s.now().After(rt.ExpiresAt) {
+		writeOAuthJSON(w, http.StatusOK, M{"active": false})
+		return
+	}
+	resp := M{
+		"active":     true,
+		"token_type": GrantRefreshToken,
+		"sub":        rt.Subject,
+		"username":   rt.Subject,
+		"iat":        rt.IssuedAt.Unix(),
+		"exp":        rt.ExpiresAt.Unix(),
+	}
+	for _, name := range []string{"scope", "client_id"} {
+		if v, ok := rt.Claims[name]; ok {
+			resp[name] = v
+		}
+	}
+	writeOAuthJSON(w, http.StatusOK, resp)
+}
+
+// Revoke implements the token revocation endpoint (RFC 7009). Revoking a
+// refresh token revokes every token in its family. As required by the RFC,
+// invalid and unknown tokens are answered with a 200 OK, but a client may
+// not revoke the tokens issued to another client.
+func (s *OAuthServer) Revoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeOAuthError(w, http.StatusMethodNotAllowed, oauthInvalidRequest, "the revocation endpoint only accepts POST")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, err.Error())
+		return
+	}
+	client, ok := s.authenticateClient(w, r, true)
+	if !ok {
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "token is required")
+		return
+	}
+	if rt, err := s.Service.LookupRefreshToken(token); err == nil {
+		if id, _ := rt.Claims["client_id"].(string); id != client.ID {
+			writeOAuthError(w, http.StatusBadRequest, oauthUnauthorizedClient, "the token was issued to another client")
+			return
+		}
+		_ = s.Service.RevokeRefreshToken(token)
+	} else if t, err := s.Service.ValidateTokenString(token); err == nil {
+		if id, _ := t.Claims.(jwt.MapClaims)["client_id"].(string); id != client.ID {
+			writeOAuthError(w, http.StatusBadRequest, oauthUnauthorizedClient, "the token was issued to another client")
+			return
+		}
+		_ = s.Service.RevokeToken(token)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// authenticateClient authenticates the client using HTTP basic authentication
+// or the client_id and client_secret form values. Public clients, which only
+// send their client_id, are accepted when allowPublic is set. If the client
+// can not be authenticated, the error has been written and false is returned.
+func (s *OAuthServer) authenticateClient(w http.ResponseWriter, r *http.Request, allowPublic bool) (*OAuthClient, bool) {
+	id, secret, hasBasicAuth := r.BasicAuth()
+	if hasBasicAuth {
+		// The credentials are form encoded before they are placed in
+		// the header, see RFC 6749, section 2.3.1
+		var err1, err2 error
+		id, err1 = url.QueryUnescape(id)
+		secret, err2 = url.QueryUnescape(secret)
+		if err1 != nil || err2 != nil {
+			writeInvalidClient(w)
+			return nil, false
+		}
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if id == "" {
+		writeInvalidClient(w)
+		return nil, false
+	}
+	if secret == "" && allowPublic {
+		client, err := s.Clients.GetClient(id)
+		if err != nil || !client.Public {
+			writeInvalidClient(w)
+			return nil, false
+		}
+		return client, true
+	}
+	client, err := s.Clients.Authenticate(id, secret)
+	if err != nil {
+		writeInvalidClient(w)
+		return nil, false
+	}
+	return client, true
+}
+
+// grantScope returns the scope to grant for the requested scope. A request
+// without a scope is granted every allowed scope, and a request for a scope
+// that is not allowed fails.
+func grantScope(allowed []string, requested string) (string, bool) {
+	if requested == "" {
+		return strings.Join(allowed, " "), true
+	}
+	scopes := strings.Fields(requested)
+	for _, scope := range scopes {
+		if !contains(allowed, scope) {
+			return "", false
+		}
+	}
+	return strings.Join(scopes, " "), true
+}
+
+func oauthClaims(clientID, scope string) jwt.MapClaims {
+	claims := jwt.MapClaims{"client_id": clientID}
+	if scope != "" {
+		claims["scope"] = scope
+	}
+	return claims
+}
+
+// pkceChallenge returns the S256 code challenge of the code verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// withQuery adds the query parameters to the URI, keeping any query it
+// already has.
+func withQuery(uri string, q url.Values) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	query := u.Query()
+	for k, v := range q {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func writeInvalidClient(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="oauth", charset="UTF-8"`)
+	writeOAuthError(w, http.StatusUnauthorized, oauthInvalidClient, "client authentication failed")
+}
+
+// writeOAuthError writes an error response as defined in RFC 6749, section 5.2.
+func writeOAuthError(w http.ResponseWriter, code int, err, description string) {
+	resp := M{"error": err}
+	if description != "" {
+		resp["error_description"] = description
+	}
+	writeOAuthJSON(w, code, resp)
+}
+
+// writeOAuthJSON writes the response, which must not be cached, because it
+// may hold tokens.
+func writeOAuthJSON(w http.ResponseWriter, code int, data any) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	WriteJSON(w, code, data)
+}
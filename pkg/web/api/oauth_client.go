@@ -0,0 +1,149 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+)
+
+// OAuth 2.0 grant types, as used in the grant_type parameter.
+const (
+	GrantClientCredentials = "client_credentials"
+	GrantPassword          = "password"
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+)
+
+var ErrInvalidClient = errors.New("oauth: client authentication failed")
+
+// OAuthClient is an application registered with the OAuth 2.0 authorization
+// server.
+type OAuthClient struct {
+	// ID is the client_id of the client.
+	ID string `json:"client_id"`
+
+	// Name is a human readable name for the client.
+	Name string `json:"client_name,omitempty"`
+
+	// Public clients, such as mobile and single page apps, can not keep a
+	// secret. They may only use the authorization_code grant with PKCE,
+	// and the refresh_token grant.
+	Public bool `json:"public"`
+
+	// RedirectURIs holds the exact redirect URIs the client may use with
+	// the authorization_code grant.
+	RedirectURIs []string `json:"redirect_uris,omitempty"`
+
+	// GrantTypes holds the grant types the client may use.
+	GrantTypes []string `json:"grant_types"`
+
+	// Scopes holds the scopes the client may request. A request without a
+	// scope is granted all of them.
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Role is the role placed in tokens issued to the client itself using
+	// the client_credentials grant.
+	Role string `json:"role,omitempty"`
+
+	// secretHash is the SHA-256 digest of the client secret.
+	secretHash string
+}
+
+// AllowsGrant reports whether the client may use the grant type.
+func (c *OAuthClient) AllowsGrant(grantType string) bool {
+	if c.Public && (grantType == GrantClientCredentials || grantType == GrantPassword) {
+		return false
+	}
+	return contains(c.GrantTypes, grantType)
+}
+
+// AllowsRedirect reports whether uri is one of the registered redirect URIs.
+// URIs are compared exactly, as required by RFC 6749, section 3.1.2.
+func (c *OAuthClient) AllowsRedirect(uri string) bool {
+	return contains(c.RedirectURIs, uri)
+}
+
+// OAuthClientStore is the registry of OAuth 2.0 clients. Only a digest of
+// every client secret is stored. Because the secrets are generated by the
+// store, and are long and random, a fast digest is sufficient.
+type OAuthClientStore struct {
+	store *MemoryStore[string, OAuthClient]
+}
+
+func NewOAuthClientStore() *OAuthClientStore {
+	return &OAuthClientStore{
+		store: NewMemoryStore[string, OAuthClient](),
+	}
+}
+
+// Register adds the client to the store. Confidential clients are assigned a
+// new secret, which is returned and can not be recovered afterwards. Public
+// clients are returned an empty secret.
+func (cs *OAuthClientStore) Register(client OAuthClient) (string, error) {
+	if client.ID == "" {
+		return "", errors.New("oauth: client_id is required")
+	}
+	var secret string
+	if !client.Public {
+		b := make([]byte, 32)
+		_, err := rand.Read(b)
+		if err != nil {
+			return "", err
+		}
+		secret = base64.RawURLEncoding.EncodeToString(b)
+		client.secretHash = clientSecretHash(secret)
+	}
+	err := cs.store.Add(client.ID, client)
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// GetClient returns the client with the provided id.
+func (cs *OAuthClientStore) GetClient(id string) (*OAuthClient, error) {
+	client, err := cs.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// DeleteClient removes the client from the store.
+func (cs *OAuthClientStore) DeleteClient(id string) error {
+	_, err := cs.store.Del(id)
+	return err
+}
+
+// Authenticate verifies the secret of a confidential client using a constant
+// time comparison. Public clients can not be authenticated.
+func (cs *OAuthClientStore) Authenticate(id, secret string) (*OAuthClient, error) {
+	client, err := cs.store.Get(id)
+	if err != nil || client.Public {
+		// Compare anyway, so the time taken does not reveal whether
+		// the client exists
+		subtle.ConstantTimeCompare([]byte(clientSecretHash(secret)), []byte(clientSecretHash("")))
+		return nil, ErrInvalidClient
+	}
+	if subtle.ConstantTimeCompare([]byte(clientSecretHash(secret)), []byte(client.secretHash)) != 1 {
+		return nil, ErrInvalidClient
+	}
+	return &client, nil
+}
+
+func clientSecretHash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,390 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scottcagno/angular-refresher/pkg/web/jwt"
+	"github.com/scottcagno/angular-refresher/pkg/web/password"
+	"github.com/scottcagno/angular-refresher/pkg/web/totp"
+)
+
+type testOAuth struct {
+	srv    *OAuthServer
+	secret string
+}
+
+func newTestOAuth(t *testing.T) *testOAuth {
+	users := NewUserStoreWithHasher(password.NewHasher(password.Params{LogN: 4}))
+	users.AddUser("admin", "secret", "ROLE_ADMIN")
+	srv, err := NewOAuthServer(&OAuthServerConfig{Service: newTestJWTService(t), Users: users})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := srv.Clients.Register(
+		OAuthClient{
+			ID:         "backend",
+			GrantTypes: []string{GrantClientCredentials, GrantPassword, GrantRefreshToken},
+			Scopes:     []string{"rooms:read", "rooms:write"},
+			Role:       "ROLE_SERVICE",
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = srv.Clients.Register(
+		OAuthClient{
+			ID:           "mobile",
+			Public:       true,
+			RedirectURIs: []string{"app://callback"},
+			GrantTypes:   []string{GrantAuthorizationCode, GrantRefreshToken, GrantClientCredentials},
+			Scopes:       []string{"rooms:read", "bookings"},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testOAuth{srv: srv, secret: secret}
+}
+
+// post sends the form to the handler, using basic authentication when a
+// client secret is provided.
+func (o *testOAuth) post(fn http.HandlerFunc, clientID, secret string, form url.Values) (*httptest.ResponseRecorder, M) {
+	if secret == "" && clientID != "" {
+		form.Set("client_id", clientID)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/oauth", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if secret != "" {
+		r.SetBasicAuth(clientID, secret)
+	}
+	w := httptest.NewRecorder()
+	fn(w, r)
+	resp := M{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func TestOAuthTokenEndpoint(t *testing.T) {
+	o := newTestOAuth(t)
+
+	tests := []struct {
+		name     string
+		clientID string
+		secret   string
+		form     url.Values
+		code     int
+		err      string
+		scope    string
+		refresh  bool
+	}{
+		{
+			"client credentials", "backend", "", url.Values{"grant_type": {GrantClientCredentials}},
+			http.StatusUnauthorized, oauthInvalidClient, "", false,
+		},
+		{
+			"wrong secret", "backend", "wrong", url.Values{"grant_type": {GrantClientCredentials}},
+			http.StatusUnauthorized, oauthInvalidClient, "", false,
+		},
+		{
+			"all scopes", "backend", o.secret, url.Values{"grant_type": {GrantClientCredentials}},
+			http.StatusOK, "", "rooms:read rooms:write", false,
+		},
+		{
+			"narrowed scope", "backend", o.secret,
+			url.Values{"grant_type": {GrantClientCredentials}, "scope": {"rooms:read"}},
+			http.StatusOK, "", "rooms:read", false,
+		},
+		{
+			"invalid scope", "backend", o.secret,
+			url.Values{"grant_type": {GrantClientCredentials}, "scope": {"admin"}},
+			http.StatusBadRequest, oauthInvalidScope, "", false,
+		},
+		{
+			"unsupported grant", "backend", o.secret, url.Values{"grant_type": {"implicit"}},
+			http.StatusBadRequest, oauthUnsupportedGrantType, "", false,
+		},
+		{
+			"password", "backend", o.secret,
+			url.Values{"grant_type": {GrantPassword}, "username": {"admin"}, "password": {"secret"}},
+			http.StatusOK, "", "rooms:read rooms:write", true,
+		},
+		{
+			"wrong password", "backend", o.secret,
+			url.Values{"grant_type": {GrantPassword}, "username": {"admin"}, "password": {"Secret"}},
+			http.StatusBadRequest, oauthInvalidGrant, "", false,
+		},
+		{
+			"public client credentials", "mobile", "", url.Values{"grant_type": {GrantClientCredentials}},
+			http.StatusBadRequest, oauthUnauthorizedClient, "", false,
+		},
+		{
+			"grant not allowed", "mobile", "",
+			url.Values{"grant_type": {GrantPassword}, "username": {"admin"}, "password": {"secret"}},
+			http.StatusBadRequest, oauthUnauthorizedClient, "", false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				w, resp := o.post(o.srv.Token, tt.clientID, tt.secret, tt.form)
+				if w.Code != tt.code {
+					t.Fatalf("[%v] Status %d, expecting %d: %s", tt.name, w.Code, tt.code, w.Body)
+				}
+				if w.Header().Get("Cache-Control") != "no-store" || w.Header().Get("Content-Type") != "application/json" {
+					t.Errorf("[%v] Unexpected headers: %v", tt.name, w.Header())
+				}
+				if tt.code != http.StatusOK {
+					if resp["error"] != tt.err {
+						t.Errorf("[%v] Error %v, expecting %v", tt.name, resp["error"], tt.err)
+					}
+					return
+				}
+				if resp["scope"] != tt.scope {
+					t.Errorf("[%v] Scope %v, expecting %v", tt.name, resp["scope"], tt.scope)
+				}
+				if _, ok := resp["refresh_token"]; ok != tt.refresh {
+					t.Errorf("[%v] Refresh token issued %v, expecting %v", tt.name, ok, tt.refresh)
+				}
+				token, err := o.srv.Service.ValidateTokenString(resp["access_token"].(string))
+				if err != nil {
+					t.Fatalf("[%v] Access token is invalid: %v", tt.name, err)
+				}
+				p := PrincipalFromClaims(token.Claims.(jwt.MapClaims))
+				if p.Claims["client_id"] != tt.clientID || strings.Join(p.Permissions, " ") != tt.scope {
+					t.Errorf("[%v] Unexpected principal: %+v", tt.name, p)
+				}
+				subject := ClientSubjectPrefix + tt.clientID
+				if tt.form.Get("grant_type") == GrantPassword {
+					subject = tt.form.Get("username")
+				}
+				if p.Subject != subject {
+					t.Errorf("[%v] Subject %q, expecting %q", tt.name, p.Subject, subject)
+				}
+			},
+		)
+	}
+}
+
+func TestOAuthMFA(t *testing.T) {
+	o := newTestOAuth(t)
+	o.srv.Users.AddUser("alice", "secret", "ROLE_USER")
+	o.srv.Users.AddUser("bob", "secret", "ROLE_USER")
+	o.srv.MFA = NewMFAStore("Room Booking")
+	now := time.Now()
+	key, err := o.srv.MFA.Enroll("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := totp.GenerateCode(key.Secret, now, o.srv.MFA.Params)
+	if _, err = o.srv.MFA.Confirm("bob", code); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		code     int
+	}{
+		{"no second factor", "alice", http.StatusOK},
+		{"enrolled", "bob", http.StatusBadRequest},
+		{"required role", "admin", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				w, resp := o.post(
+					o.srv.Token, "backend", o.secret,
+					url.Values{"grant_type": {GrantPassword}, "username": {tt.username}, "password": {"secret"}},
+				)
+				if w.Code != tt.code {
+					t.Fatalf("[%v] Password grant: status %d, expecting %d: %s", tt.name, w.Code, tt.code, w.Body)
+				}
+				if tt.code != http.StatusOK && resp["error"] != oauthInvalidGrant {
+					t.Errorf("[%v] Error %v, expecting %v", tt.name, resp["error"], oauthInvalidGrant)
+				}
+				if _, ok := resp["access_token"]; ok != (tt.code == http.StatusOK) {
+					t.Errorf("[%v] Access token issued %v, expecting %v", tt.name, ok, tt.code == http.StatusOK)
+				}
+
+				r := httptest.NewRequest(http.MethodGet, "/oauth/authorize", nil)
+				r.SetBasicAuth(tt.username, "secret")
+				w = httptest.NewRecorder()
+				_, err := o.srv.authenticateOwner(w, r)
+				if (err == nil) != (tt.code == http.StatusOK) {
+					t.Errorf("[%v] Basic authentication error %v, expecting success %v", tt.name, err, tt.code == http.StatusOK)
+				}
+			},
+		)
+	}
+}
+
+func TestOAuthAuthorizationCode(t *testing.T) {
+	o := newTestOAuth(t)
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+	authorize := func(q url.Values, user, pass string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+q.Encode(), nil)
+		if user != "" {
+			r.SetBasicAuth(user, pass)
+		}
+		w := httptest.NewRecorder()
+		o.srv.Authorize(w, r)
+		return w
+	}
+	query := func() url.Values {
+		return url.Values{
+			"response_type":         {"code"},
+			"client_id":             {"mobile"},
+			"redirect_uri":          {"app://callback"},
+			"scope":                 {"bookings"},
+			"state":                 {"xyz"},
+			"code_challenge":        {pkceChallenge(verifier)},
+			"code_challenge_method": {"S256"},
+		}
+	}
+
+	// Invalid clients and redirect URIs are never redirected to
+	q := query()
+	q.Set("redirect_uri", "https://attacker.example/callback")
+	if w := authorize(q, "admin", "secret"); w.Code != http.StatusBadRequest {
+		t.Fatalf("Unregistered redirect_uri: status %d, expecting %d", w.Code, http.StatusBadRequest)
+	}
+	q = query()
+	q.Del("code_challenge")
+	w := authorize(q, "admin", "secret")
+	if loc, _ := url.Parse(w.Header().Get("Location")); w.Code != http.StatusFound || loc.Query().Get("error") != oauthInvalidRequest {
+		t.Fatalf("Missing code_challenge: status %d, location %q", w.Code, w.Header().Get("Location"))
+	}
+	if w = authorize(query(), "admin", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong password: status %d, expecting %d", w.Code, http.StatusUnauthorized)
+	}
+
+	w = authorize(query(), "admin", "secret")
+	loc, err := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || err != nil {
+		t.Fatalf("Authorize: status %d, location %q", w.Code, w.Header().Get("Location"))
+	}
+	code := loc.Query().Get("code")
+	if code == "" || loc.Query().Get("state") != "xyz" {
+		t.Fatalf("Authorize: unexpected redirect %q", loc)
+	}
+
+	exchange := url.Values{
+		"grant_type":    {GrantAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {"app://callback"},
+		"code_verifier": {"wrong-verifier"},
+	}
+	if _, resp := o.post(o.srv.Token, "mobile", "", exchange); resp["error"] != oauthInvalidGrant {
+		t.Fatalf("Wrong code_verifier: error %v, expecting %v", resp["error"], oauthInvalidGrant)
+	}
+
+	// The failed exchange used up the code, so start over
+	w = authorize(query(), "admin", "secret")
+	loc, _ = url.Parse(w.Header().Get("Location"))
+	exchange.Set("code", loc.Query().Get("code"))
+	exchange.Set("code_verifier", verifier)
+	w, resp := o.post(o.srv.Token, "mobile", "", exchange)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Exchange: status %d: %s", w.Code, w.Body)
+	}
+	if resp["scope"] != "bookings" || resp["refresh_token"] == nil {
+		t.Fatalf("Exchange: unexpected response %v", resp)
+	}
+	token, err := o.srv.Service.ValidateTokenString(resp["access_token"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub, _ := token.Claims.(jwt.MapClaims)["sub"].(string); sub != "admin" {
+		t.Errorf("Access token subject %q, expecting %q", sub, "admin")
+	}
+	if _, resp = o.post(o.srv.Token, "mobile", "", exchange); resp["error"] != oauthInvalidGrant {
+		t.Fatalf("Reused code: error %v, expecting %v", resp["error"], oauthInvalidGrant)
+	}
+}
+
+func TestOAuthRefreshToken(t *testing.T) {
+	o := newTestOAuth(t)
+	_, resp := o.post(
+		o.srv.Token, "backend", o.secret,
+		url.Values{"grant_type": {GrantPassword}, "username": {"admin"}, "password": {"secret"}},
+	)
+	refresh := resp["refresh_token"].(string)
+
+	// A refresh token can not be used by another client, and the failed
+	// attempt leaves the token usable
+	form := url.Values{"grant_type": {GrantRefreshToken}, "refresh_token": {refresh}}
+	if _, resp = o.post(o.srv.Token, "mobile", "", form); resp["error"] != oauthInvalidGrant {
+		t.Fatalf("Other client: error %v, expecting %v", resp["error"], oauthInvalidGrant)
+	}
+	form.Set("scope", "rooms:read admin")
+	if _, resp = o.post(o.srv.Token, "backend", o.secret, form); resp["error"] != oauthInvalidScope {
+		t.Fatalf("Widened scope: error %v, expecting %v", resp["error"], oauthInvalidScope)
+	}
+	form.Set("scope", "rooms:read")
+	w, resp := o.post(o.srv.Token, "backend", o.secret, form)
+	if w.Code != http.StatusOK || resp["scope"] != "rooms:read" {
+		t.Fatalf("Narrowed scope: status %d: %s", w.Code, w.Body)
+	}
+
+	// The narrowed scope is kept by the rotated refresh token
+	form = url.Values{"grant_type": {GrantRefreshToken}, "refresh_token": {resp["refresh_token"].(string)}}
+	if _, resp = o.post(o.srv.Token, "backend", o.secret, form); resp["scope"] != "rooms:read" {
+		t.Fatalf("Rotated token: scope %v, expecting %v", resp["scope"], "rooms:read")
+	}
+	form.Set("refresh_token", refresh)
+	if _, resp = o.post(o.srv.Token, "backend", o.secret, form); resp["error"] != oauthInvalidGrant {
+		t.Fatalf("Reused token: error %v, expecting %v", resp["error"], oauthInvalidGrant)
+	}
+}
+
+func TestOAuthIntrospectAndRevoke(t *testing.T) {
+	o := newTestOAuth(t)
+	_, resp := o.post(
+		o.srv.Token, "backend", o.secret,
+		url.Values{"grant_type": {GrantPassword}, "username": {"admin"}, "password": {"secret"}},
+	)
+	access, refresh := resp["access_token"].(string), resp["refresh_token"].(string)
+
+	introspect := func(token string) M {
+		w, resp := o.post(o.srv.Introspect, "backend", o.secret, url.Values{"token": {token}})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Introspect: status %d: %s", w.Code, w.Body)
+		}
+		return resp
+	}
+	if w, _ := o.post(o.srv.Introspect, "mobile", "", url.Values{"token": {access}}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Public client introspection: status %d, expecting %d", w.Code, http.StatusUnauthorized)
+	}
+	if resp = introspect(access); resp["active"] != true || resp["client_id"] != "backend" ||
+		resp["username"] != "admin" || resp["token_type"] != "access_token" {
+		t.Fatalf("Introspect access token: unexpected response %v", resp)
+	}
+	if resp = introspect(refresh); resp["active"] != true || resp["scope"] != "rooms:read rooms:write" ||
+		resp["token_type"] != GrantRefreshToken {
+		t.Fatalf("Introspect refresh token: unexpected response %v", resp)
+	}
+	if resp = introspect("unknown"); len(resp) != 1 || resp["active"] != false {
+		t.Fatalf("Introspect unknown token: unexpected response %v", resp)
+	}
+
+	if w, _ := o.post(o.srv.Revoke, "mobile", "", url.Values{"token": {access}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("Revoke by other client: status %d, expecting %d", w.Code, http.StatusBadRequest)
+	}
+	for _, token := range []string{access, refresh, "unknown"} {
+		if w, _ := o.post(o.srv.Revoke, "backend", o.secret, url.Values{"token": {token}}); w.Code != http.StatusOK {
+			t.Fatalf("Revoke: status %d, expecting %d", w.Code, http.StatusOK)
+		}
+	}
+	for _, token := range []string{access, refresh} {
+		if resp = introspect(token); resp["active"] != false {
+			t.Fatalf("Revoked token is still active: %v", resp)
+		}
+	}
+}
@@ -0,0 +1,632 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scottcagno/angular-refresher/pkg/web"
+	"github.com/scottcagno/angular-refresher/pkg/web/jwt"
+)
+
+const (
+	loginEndpoint    = "/login"
+	callbackEndpoint = "/callback"
+
+	// Session keys used by the OIDCClient. The logged in user is stored
+	// under "current_user", so it is returned by web.Session.GetUser.
+	oidcStateKey   = "oidc_state"
+	oidcIDTokenKey = "oidc_id_token"
+	currentUserKey = "current_user"
+)
+
+var (
+	ErrOIDCState    = errors.New("oidc: invalid or expired login state")
+	ErrOIDCNonce    = errors.New("oidc: id token nonce does not match")
+	ErrOIDCNoRole   = errors.New("oidc: user has not been granted a role")
+	ErrOIDCUsername = errors.New("oidc: user has no verified username")
+	ErrOIDCSubject  = errors.New("oidc: userinfo subject does not match the id token")
+	ErrOIDCProvider = errors.New("oidc: invalid provider configuration")
+)
+
+// OIDCProvider holds the endpoints of an OpenID Connect provider, as published
+// in its discovery document.
+type OIDCProvider struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint,omitempty"`
+	JWKSURI               string   `json:"jwks_uri"`
+	EndSessionEndpoint    string   `json:"end_session_endpoint,omitempty"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported,omitempty"`
+}
+
+// DiscoverOIDCProvider loads the discovery document of the issuer from
+// "/.well-known/openid-configuration". The issuer in the document must match
+// the provided issuer exactly. If client is nil, a client with a ten second
+// timeout is used.
+func DiscoverOIDCProvider(issuer string, client *http.Client) (*OIDCProvider, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Get(strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: fetching discovery document: unexpected status %q", resp.Status)
+	}
+	provider := new(OIDCProvider)
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(provider)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: reading discovery document: %w", err)
+	}
+	if provider.Issuer != issuer {
+		return nil, fmt.Errorf("%w: issuer %q does not match %q", ErrOIDCProvider, provider.Issuer, issuer)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: missing endpoints", ErrOIDCProvider)
+	}
+	return provider, nil
+}
+
+// OIDCRoleMapping maps a role, or group, asserted by the provider onto the
+// role of a web.SystemUser.
+type OIDCRoleMapping struct {
+	Claim string
+	Role  string
+}
+
+// OIDCConfig configures an OIDCClient.
+type OIDCConfig struct {
+	// Issuer is the URL of the provider. The discovery document is loaded
+	// from "/.well-known/openid-configuration" below it.
+	Issuer string
+
+	// ClientID and ClientSecret are the credentials of the application
+	// registered with the provider. Without a secret, the client is a
+	// public client, and relies on PKCE alone.
+	ClientID     string
+	ClientSecret string
+
+	// RedirectURL is the URL of the callback endpoint registered with the
+	// provider, for example "https://rooms.example.com/auth/oidc/callback".
+	RedirectURL string
+
+	// Scopes are the scopes requested from the provider.
+	//
+	// Optional. Default value "openid", "profile" and "email"
+	Scopes []string
+
+	// UsernameClaim is the claim used as the username. The username is the
+	// subject of the tokens issued to the user, and owns their bookings, so
+	// the claim must be unique and never be handed to another person by the
+	// provider. The "preferred_username" claim is neither, and can often be
+	// edited by the users themselves. The "email" claim is only accepted when
+	// the "email_verified" claim is true.
+	//
+	// Optional. Default value "email"
+	UsernameClaim string
+
+	// RolesClaim is the claim holding the roles, or groups, of the user.
+	//
+	// Optional. Default value "groups"
+	RolesClaim string
+
+	// RoleMapping maps the roles asserted by the provider onto roles. The
+	// first entry matching one of the roles of the user is used.
+	RoleMapping []OIDCRoleMapping
+
+	// DefaultRole is the role of users without a mapped role. When empty,
+	// those users are refused.
+	//
+	// Optional. Default value ""
+	DefaultRole string
+
+	// Sessions holds the state of logins in progress, and the logged in
+	// user. Because the provider redirects back from another site, the
+	// session cookie must use SameSite=Lax.
+	//
+	// Optional. Default value web.NewSessionManager(nil)
+	Sessions *web.SessionManager
+
+	// Tokens, when set, issues a token pair when a user logs in, which is
+	// placed in the same cookies JWTAuthService uses, so resources secured
+	// by a JWTAuthService accept the user as well.
+	//
+	// Optional. Default value nil
+	Tokens *jwt.JWTService
+
+	// PostLoginURL is where the user is sent after logging in, unless the
+	// login request carried a "return_to" path.
+	//
+	// Optional. Default value "/"
+	PostLoginURL string
+
+	// PostLogoutURL is where the user is sent after logging out. It is
+	// passed to the provider when it supports RP-initiated logout, in which
+	// case it must be an absolute URL registered with the provider.
+	//
+	// Optional. Default value "/"
+	PostLogoutURL string
+
+	// Leeway allows for clock skew between the provider and this server.
+	//
+	// Optional. Default value jwt.DefaultLeeway
+	Leeway time.Duration
+
+	// HTTPClient is used for the requests to the provider.
+	//
+	// Optional. Default value is a client with a ten second timeout
+	HTTPClient *http.Client
+}
+
+func checkOIDCConfig(conf *OIDCConfig) (*OIDCConfig, error) {
+	if conf == nil || conf.Issuer == "" || conf.ClientID == "" || conf.RedirectURL == "" {
+		return nil, errors.New("oidc: the issuer, client id and redirect url are required")
+	}
+	c := *conf
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{"openid", "profile", "email"}
+	}
+	if !contains(c.Scopes, "openid") {
+		c.Scopes = append([]string{"openid"}, c.Scopes...)
+	}
+	if c.UsernameClaim == "" {
+		c.UsernameClaim = "email"
+	}
+	if c.RolesClaim == "" {
+		c.RolesClaim = "groups"
+	}
+	if c.Sessions == nil {
+		c.Sessions = web.NewSessionManager(nil)
+	}
+	if c.PostLoginURL == "" {
+		c.PostLoginURL = "/"
+	}
+	if c.PostLogoutURL == "" {
+		c.PostLogoutURL = "/"
+	}
+	if c.Leeway == 0 {
+		c.Leeway = jwt.DefaultLeeway
+	}
+	if c.HTTPClient == nil {
+		c.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &c, nil
+}
+
+// OIDCClient is an OpenID Connect relying party. It logs users in with the
+// authorization code flow and PKCE, verifies the ID token using the keys
+// published by the provider, and maps the user onto a web.SystemUser, which
+// is kept in the session.
+//
+// It implements the Authenticator interface, authenticating requests using
+// the session, so it can be used with MakeAuthService.
+type OIDCClient struct {
+	Provider *OIDCProvider
+
+	LoginPath    string
+	CallbackPath string
+	LogoutPath   string
+
+	conf        *OIDCConfig
+	mu          sync.Mutex
+	keys        *jwt.KeySet
+	keysFetched time.Time
+	now         func() time.Time
+}
+
+// NewOIDCClient loads the discovery document and the keys of the provider,
+// and returns a client using them.
+func NewOIDCClient(conf *OIDCConfig) (*OIDCClient, error) {
+	conf, err := checkOIDCConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := DiscoverOIDCProvider(conf.Issuer, conf.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := jwt.FetchJWKS(provider.JWKSURI, conf.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCClient{
+		Provider:     provider,
+		LoginPath:    loginEndpoint,
+		CallbackPath: callbackEndpoint,
+		LogoutPath:   logoutEndpoint,
+		conf:         conf,
+		keys:         keys,
+		keysFetched:  time.Now(),
+		now:          time.Now,
+	}, nil
+}
+
+// oidcLogin is the state of a login in progress, held in the session.
+type oidcLogin struct {
+	State    string
+	Nonce    string
+	Verifier string
+	ReturnTo string
+}
+
+func init() {
+	// The pending login is kept in the session, which must be able to
+	// encode it
+	gob.Register(&oidcLogin{})
+}
+
+// Login redirects the user to the provider. A local path passed in the
+// "return_to" parameter is where the user is sent after logging in.
+func (c *OIDCClient) Login(w http.ResponseWriter, r *http.Request) {
+	state, err1 := randomToken()
+	nonce, err2 := randomToken()
+	verifier, err3 := randomToken()
+	if err1 != nil || err2 != nil || err3 != nil {
+		WriteJSON(w, http.StatusInternalServerError, M{"err": "could not start login"})
+		return
+	}
+	sess, _ := c.conf.Sessions.MustGet(r)
+	sess.Set(
+		oidcStateKey, &oidcLogin{
+			State:    state,
+			Nonce:    nonce,
+			Verifier: verifier,
+			ReturnTo: localPath(r.URL.Query().Get("return_to")),
+		},
+	)
+	c.conf.Sessions.Save(w, r, sess)
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.conf.ClientID},
+		"redirect_uri":          {c.conf.RedirectURL},
+		"scope":                 {strings.Join(c.conf.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	http.Redirect(w, r, withQuery(c.Provider.AuthorizationEndpoint, q), http.StatusFound)
+}
+
+// Callback completes the login. It checks the state, exchanges the code for
+// the tokens of the user, verifies the ID token and stores the user in the
+// session.
+func (c *OIDCClient) Callback(w http.ResponseWriter, r *http.Request) {
+	sess, found := c.conf.Sessions.Get(r)
+	if !found {
+		WriteJSON(w, http.StatusBadRequest, M{"err": ErrOIDCState.Error()})
+		return
+	}
+	v, _ := sess.Get(oidcStateKey)
+	// The state is consumed by the first callback, even when it fails
+	sess.Del(oidcStateKey)
+	c.conf.Sessions.Save(w, r, sess)
+	login, ok := v.(*oidcLogin)
+	q := r.URL.Query()
+	if !ok || subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(login.State)) != 1 {
+		WriteJSON(w, http.StatusBadRequest, M{"err": ErrOIDCState.Error()})
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		WriteJSON(w, http.StatusUnauthorized, M{"err": e, "error_description": q.Get("error_description")})
+		return
+	}
+	if q.Get("code") == "" {
+		WriteJSON(w, http.StatusBadRequest, M{"err": "code is required"})
+		return
+	}
+	tokens, err := c.exchange(r.Context(), q.Get("code"), login.Verifier)
+	if err != nil {
+		WriteJSON(w, http.StatusBadGateway, M{"err": err.Error()})
+		return
+	}
+	claims, err := c.VerifyIDToken(tokens.IDToken, login.Nonce)
+	if err != nil {
+		WriteJSON(w, http.StatusUnauthorized, M{"err": err.Error()})
+		return
+	}
+	if c.Provider.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		info, err := c.userInfo(r.Context(), tokens.AccessToken)
+		if err != nil {
+			WriteJSON(w, http.StatusBadGateway, M{"err": err.Error()})
+			return
+		}
+		if info["sub"] != claims["sub"] {
+			WriteJSON(w, http.StatusUnauthorized, M{"err": ErrOIDCSubject.Error()})
+			return
+		}
+		// The claims of the verified ID token take precedence
+		for k, v := range info {
+			if _, found := claims[k]; !found {
+				claims[k] = v
+			}
+		}
+	}
+	user, err := c.MapUser(claims)
+	if err != nil {
+		WriteJSON(w, http.StatusForbidden, M{"err": err.Error()})
+		return
+	}
+	sess.Set(currentUserKey, user)
+	sess.Set(oidcIDTokenKey, tokens.IDToken)
+	// The user is now logged in, so the session gets a new id
+	c.conf.Sessions.Regenerate(w, r, sess)
+	if c.conf.Tokens != nil {
+		pair, err := c.conf.Tokens.IssueTokenPair(user.Username, user.Role)
+		if err != nil {
+			WriteJSON(w, http.StatusInternalServerError, M{"err": err.Error()})
+			return
+		}
+		setTokenCookies(w, c.conf.Tokens, pair)
+	}
+	returnTo := login.ReturnTo
+	if returnTo == "" {
+		returnTo = c.conf.PostLoginURL
+	}
+	http.Redirect(w, r, returnTo, http.StatusFound)
+}
+
+// Logout ends the session, and any tokens issued to the user, and sends the
+// user to the end session endpoint of the provider, when it has one.
+func (c *OIDCClient) Logout(w http.ResponseWriter, r *http.Request) {
+	var idToken string
+	if sess, found := c.conf.Sessions.Get(r); found {
+		v, _ := sess.Get(oidcIDTokenKey)
+		idToken, _ = v.(string)
+		c.conf.Sessions.Save(w, r, nil)
+	}
+	if c.conf.Tokens != nil {
+		if refreshToken, found := requestRefreshToken(r); found {
+			_ = c.conf.Tokens.RevokeRefreshToken(refreshToken)
+		}
+		if tokenString, err := jwt.ExtractToken(r, jwt.CookieExtractor("token")); err == nil {
+			_ = c.conf.Tokens.RevokeToken(tokenString)
+		}
+		clearTokenCookies(w)
+	}
+	if c.Provider.EndSessionEndpoint == "" {
+		http.Redirect(w, r, c.conf.PostLogoutURL, http.StatusFound)
+		return
+	}
+	q := url.Values{
+		"client_id":                {c.conf.ClientID},
+		"post_logout_redirect_uri": {c.conf.PostLogoutURL},
+	}
+	if idToken != "" {
+		q.Set("id_token_hint", idToken)
+	}
+	http.Redirect(w, r, withQuery(c.Provider.EndSessionEndpoint, q), http.StatusFound)
+}
+
+// oidcTokens is the response of the token endpoint of the provider.
+type oidcTokens struct {
+	IDToken     string `json:"id_token"`
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+}
+
+// exchange exchanges the authorization code for the tokens of the user.
+func (c *OIDCClient) exchange(ctx context.Context, code, verifier string) (*oidcTokens, error) {
+	form := url.Values{
+		"grant_type":    {GrantAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {c.conf.RedirectURL},
+		"code_verifier": {verifier},
+		"client_id":     {c.conf.ClientID},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.conf.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.conf.ClientID), url.QueryEscape(c.conf.ClientSecret))
+	}
+	resp, err := c.conf.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body := io.LimitReader(resp.Body, 1<<20)
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		_ = json.NewDecoder(body).Decode(&e)
+		return nil, fmt.Errorf("oidc: token request failed: %s %s %s", resp.Status, e.Error, e.Description)
+	}
+	tokens := new(oidcTokens)
+	err = json.NewDecoder(body).Decode(tokens)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: reading token response: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("oidc: token response holds no id token")
+	}
+	return tokens, nil
+}
+
+// VerifyIDToken verifies the signature and the claims of the ID token, and
+// returns its claims. The nonce must match the nonce sent in the
+// authentication request.
+func (c *OIDCClient) VerifyIDToken(idToken, nonce string) (jwt.MapClaims, error) {
+	algs := c.Provider.SigningAlgs
+	if len(algs) == 0 {
+		// RS256 is the default signing algorithm of ID tokens
+		algs = []string{jwt.SigningMethodRS256.Alg()}
+	}
+	token, err := jwt.Parse(
+		idToken, c.keyFunc,
+		jwt.WithValidMethods(algs),
+		jwt.WithIssuer(c.Provider.Issuer),
+		jwt.WithAudience(c.conf.ClientID),
+		jwt.WithRequiredClaims("sub", "exp", "iat"),
+		jwt.WithLeeway(c.conf.Leeway),
+		jwt.WithTimeFunc(c.now),
+	)
+	if err != nil {
+		return nil, err
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if azp, found := claims["azp"]; found && azp != c.conf.ClientID {
+		return nil, jwt.ErrTokenInvalidAudience
+	}
+	got, _ := claims["nonce"].(string)
+	if subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) != 1 {
+		return nil, ErrOIDCNonce
+	}
+	return claims, nil
+}
+
+// keyFunc selects the key of the provider used to sign the token. When the
+// key is unknown, the provider may have rotated its keys, so they are fetched
+// again, at most once a minute.
+func (c *OIDCClient) keyFunc(t *jwt.Token) (any, error) {
+	c.mu.Lock()
+	keys := c.keys
+	c.mu.Unlock()
+	key, err := keys.KeyFunc()(t)
+	if !errors.Is(err, jwt.ErrKeyNotFound) {
+		return key, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.now().Sub(c.keysFetched) < time.Minute {
+		return nil, err
+	}
+	keys, ferr := jwt.FetchJWKS(c.Provider.JWKSURI, c.conf.HTTPClient)
+	if ferr != nil {
+		return nil, err
+	}
+	c.keys, c.keysFetched = keys, c.now()
+	return keys.KeyFunc()(t)
+}
+
+// userInfo requests the claims of the user from the userinfo endpoint.
+func (c *OIDCClient) userInfo(ctx context.Context, accessToken string) (jwt.MapClaims, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.Provider.UserinfoEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.conf.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: userinfo request failed: %s", resp.Status)
+	}
+	claims := make(jwt.MapClaims)
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&claims)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: reading userinfo: %w", err)
+	}
+	return claims, nil
+}
+
+// MapUser maps the claims of the user onto a web.SystemUser. The username is
+// the UsernameClaim, there is no fallback, so users without it are refused.
+// The role is the first entry of the RoleMapping matching one of the roles in
+// the RolesClaim, or the DefaultRole.
+func (c *OIDCClient) MapUser(claims jwt.MapClaims) (*web.SystemUser, error) {
+	user := &web.SystemUser{Role: c.conf.DefaultRole}
+	username, _ := claims[c.conf.UsernameClaim].(string)
+	if username == "" || (c.conf.UsernameClaim == "email" && !emailVerified(claims)) {
+		return nil, ErrOIDCUsername
+	}
+	user.Username = username
+	var roles []string
+	switch v := claims[c.conf.RolesClaim].(type) {
+	case string:
+		roles = strings.Fields(v)
+	case []any:
+		for _, role := range v {
+			if s, ok := role.(string); ok {
+				roles = append(roles, s)
+			}
+		}
+	}
+	for _, m := range c.conf.RoleMapping {
+		if contains(roles, m.Claim) {
+			user.Role = m.Role
+			break
+		}
+	}
+	if user.Role == "" {
+		return nil, ErrOIDCNoRole
+	}
+	return user, nil
+}
+
+// emailVerified reports whether the provider verified the email address of
+// the user. Some providers send the claim as a string.
+func emailVerified(claims jwt.MapClaims) bool {
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// Register implements the Authenticator interface by starting a login.
+func (c *OIDCClient) Register(w http.ResponseWriter, r *http.Request) {
+	c.Login(w, r)
+}
+
+// Validate reports whether the request belongs to a logged in user. It
+// responds with the user if it does, and with a 401 otherwise.
+func (c *OIDCClient) Validate(w http.ResponseWriter, r *http.Request) {
+	p, err := c.Authenticate(r)
+	if err != nil {
+		c.Challenge(w, r, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, p)
+}
+
+// Authenticate implements the Authenticator interface using the user stored
+// in the session.
+func (c *OIDCClient) Authenticate(r *http.Request) (*Principal, error) {
+	sess, found := c.conf.Sessions.Get(r)
+	if !found {
+		return nil, ErrNoCredentials
+	}
+	user, found := sess.GetUser()
+	if !found {
+		return nil, ErrNoCredentials
+	}
+	return &Principal{Subject: user.Username, Roles: []string{user.Role}}, nil
+}
+
+// Challenge implements the Authenticator interface.
+func (c *OIDCClient) Challenge(w http.ResponseWriter, r *http.Request, err error) {
+	WriteJSON(w, http.StatusUnauthorized, M{"err": err.Error()})
+}
+
+// localPath returns the path if it is local to this server, and an empty
+// string otherwise, so a login can not redirect to another site.
+func localPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return ""
+	}
+	return path
+}
@@ -0,0 +1,335 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/scottcagno/angular-refresher/pkg/web"
+	"github.com/scottcagno/angular-refresher/pkg/web/jwt"
+)
+
+// stubProvider is an OpenID Connect provider issuing a code for every
+// authentication request it is sent.
+type stubProvider struct {
+	*httptest.Server
+	t      *testing.T
+	keys   *jwt.KeySet
+	codes  map[string]url.Values
+	groups []string
+	nonce  string // overrides the nonce of the ID token when set
+}
+
+func newStubProvider(t *testing.T) *stubProvider {
+	p := &stubProvider{t: t, keys: jwt.NewKeySet(), codes: make(map[string]url.Values)}
+	p.rotate()
+	mux := http.NewServeMux()
+	mux.HandleFunc(
+		"/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+			WriteJSON(
+				w, http.StatusOK, OIDCProvider{
+					Issuer:                p.URL,
+					AuthorizationEndpoint: p.URL + "/authorize",
+					TokenEndpoint:         p.URL + "/token",
+					UserinfoEndpoint:      p.URL + "/userinfo",
+					JWKSURI:               p.URL + "/jwks",
+					EndSessionEndpoint:    p.URL + "/logout",
+					SigningAlgs:           []string{"EdDSA"},
+				},
+			)
+		},
+	)
+	mux.Handle("/jwks", p.keys)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc(
+		"/userinfo", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer stub-access-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			WriteJSON(w, http.StatusOK, M{"sub": "u-1", "email": "jane@example.com", "email_verified": true, "groups": p.groups})
+		},
+	)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *stubProvider) rotate() {
+	priv, pub := jwt.GenerateEd25519KeyPair()
+	err := p.keys.Rotate(&jwt.SigningKey{Method: jwt.SigningMethodEdDSA, Private: priv, Public: pub}, 0)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+// authorize plays the part of the user approving the authentication request
+// that the client redirected to, and returns the callback query.
+func (p *stubProvider) authorize(location string) url.Values {
+	u, err := url.Parse(location)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	code := "code-" + u.Query().Get("state")
+	p.codes[code] = u.Query()
+	return url.Values{"code": {code}, "state": {u.Query().Get("state")}}
+}
+
+func (p *stubProvider) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	req, found := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	if id != "rooms" || secret != "s3cret" || !found ||
+		pkceChallenge(r.PostFormValue("code_verifier")) != req.Get("code_challenge") ||
+		r.PostFormValue("redirect_uri") != req.Get("redirect_uri") {
+		WriteJSON(w, http.StatusBadRequest, M{"error": "invalid_grant"})
+		return
+	}
+	nonce := req.Get("nonce")
+	if p.nonce != "" {
+		nonce = p.nonce
+	}
+	now := time.Now()
+	idToken, err := p.keys.Sign(
+		jwt.MapClaims{
+			"iss":                p.URL,
+			"aud":                "rooms",
+			"sub":                "u-1",
+			"preferred_username": "jane",
+			"nonce":              nonce,
+			"iat":                jwt.NewNumericDate(now),
+			"exp":                jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+	)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	WriteJSON(w, http.StatusOK, M{"id_token": idToken, "access_token": "stub-access-token", "token_type": "Bearer"})
+}
+
+func newTestOIDCClient(t *testing.T, p *stubProvider) *OIDCClient {
+	c, err := NewOIDCClient(
+		&OIDCConfig{
+			Issuer:       p.URL,
+			ClientID:     "rooms",
+			ClientSecret: "s3cret",
+			RedirectURL:  "http://rooms.test/auth/oidc/callback",
+			RoleMapping: []OIDCRoleMapping{
+				{Claim: "admins", Role: "ROLE_ADMIN"},
+				{Claim: "staff", Role: "ROLE_USER"},
+			},
+			Sessions: web.NewSessionManager(nil),
+			Tokens:   newTestJWTService(t),
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// login starts a login, lets the provider approve it and returns the session
+// cookie along with the callback query.
+func login(t *testing.T, c *OIDCClient, p *stubProvider, returnTo string) ([]*http.Cookie, url.Values) {
+	w := httptest.NewRecorder()
+	c.Login(w, httptest.NewRequest(http.MethodGet, "/auth/oidc/login?return_to="+url.QueryEscape(returnTo), nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("Login: status %d, expecting %d", w.Code, http.StatusFound)
+	}
+	return w.Result().Cookies(), p.authorize(w.Header().Get("Location"))
+}
+
+func callback(c *OIDCClient, cookies []*http.Cookie, q url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+q.Encode(), nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	c.Callback(w, r)
+	return w
+}
+
+// mergeCookies returns the cookies, replaced by the ones set by the response
+// when they share a name, as a browser would.
+func mergeCookies(cookies []*http.Cookie, w *httptest.ResponseRecorder) []*http.Cookie {
+	merged := make(map[string]*http.Cookie)
+	var names []string
+	for _, cookie := range append(cookies, w.Result().Cookies()...) {
+		if _, found := merged[cookie.Name]; !found {
+			names = append(names, cookie.Name)
+		}
+		merged[cookie.Name] = cookie
+	}
+	cookies = make([]*http.Cookie, 0, len(names))
+	for _, name := range names {
+		cookies = append(cookies, merged[name])
+	}
+	return cookies
+}
+
+func TestOIDCLogin(t *testing.T) {
+	p := newStubProvider(t)
+	p.groups = []string{"staff", "admins"}
+	c := newTestOIDCClient(t, p)
+
+	cookies, q := login(t, c, p, "/bookings")
+	w := callback(c, cookies, q)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/bookings" {
+		t.Fatalf("Callback: status %d, location %q: %s", w.Code, w.Header().Get("Location"), w.Body)
+	}
+	var access string
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "token" {
+			access = cookie.Value
+		}
+	}
+	before := cookies[0].Value
+	cookies = mergeCookies(cookies, w)
+	if cookies[0].Value == before {
+		t.Errorf("Session id was not regenerated on login")
+	}
+	token, err := c.conf.Tokens.ValidateTokenString(access)
+	if err != nil {
+		t.Fatalf("Callback issued an invalid access token: %v", err)
+	}
+	if claims := token.Claims.(jwt.MapClaims); claims["sub"] != "jane@example.com" || claims["role"] != "ROLE_ADMIN" {
+		t.Errorf("Unexpected access token claims: %v", claims)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	principal, err := c.Authenticate(r)
+	if err != nil || principal.Subject != "jane@example.com" || !principal.HasRole("ROLE_ADMIN") {
+		t.Fatalf("Authenticate: principal %+v, error %v", principal, err)
+	}
+
+	// The state is consumed by the callback, so it can not be replayed
+	if w = callback(c, cookies, q); w.Code != http.StatusBadRequest {
+		t.Fatalf("Replayed callback: status %d, expecting %d", w.Code, http.StatusBadRequest)
+	}
+
+	w = httptest.NewRecorder()
+	c.Logout(w, r)
+	loc, _ := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || loc.Path != "/logout" || loc.Query().Get("id_token_hint") == "" {
+		t.Fatalf("Logout: status %d, location %q", w.Code, loc)
+	}
+	if _, err = c.Authenticate(r); err != ErrNoCredentials {
+		t.Fatalf("Authenticate after logout: error %v, expecting %v", err, ErrNoCredentials)
+	}
+	if _, err = c.conf.Tokens.ValidateTokenString(access); err == nil {
+		t.Fatalf("Access token is still valid after logout")
+	}
+}
+
+func TestOIDCMapUser(t *testing.T) {
+	c := newTestOIDCClient(t, newStubProvider(t))
+	groups := []any{"staff"}
+
+	tests := []struct {
+		name     string
+		claim    string
+		claims   jwt.MapClaims
+		username string
+	}{
+		{"verified email", "", jwt.MapClaims{"email": "jane@example.com", "email_verified": true, "groups": groups}, "jane@example.com"},
+		{"verified as string", "", jwt.MapClaims{"email": "jane@example.com", "email_verified": "true", "groups": groups}, "jane@example.com"},
+		{"unverified email", "", jwt.MapClaims{"email": "jane@example.com", "email_verified": false, "groups": groups}, ""},
+		{"no verification", "", jwt.MapClaims{"email": "jane@example.com", "groups": groups}, ""},
+		{"preferred username is ignored", "", jwt.MapClaims{"sub": "u-1", "preferred_username": "jane", "groups": groups}, ""},
+		{"configured claim", "sub", jwt.MapClaims{"sub": "u-1", "email": "jane@example.com", "groups": groups}, "u-1"},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				c.conf.UsernameClaim = "email"
+				if tt.claim != "" {
+					c.conf.UsernameClaim = tt.claim
+				}
+				user, err := c.MapUser(tt.claims)
+				if tt.username == "" {
+					if err != ErrOIDCUsername {
+						t.Fatalf("[%v] Mapped %+v, expecting %v", tt.name, user, ErrOIDCUsername)
+					}
+					return
+				}
+				if err != nil || user.Username != tt.username || user.Role != "ROLE_USER" {
+					t.Fatalf("[%v] Mapped %+v, %v, expecting %q", tt.name, user, err, tt.username)
+				}
+			},
+		)
+	}
+}
+
+func TestOIDCCallbackErrors(t *testing.T) {
+	p := newStubProvider(t)
+	c := newTestOIDCClient(t, p)
+
+	tests := []struct {
+		name    string
+		prepare func(q url.Values)
+		groups  []string
+		nonce   string
+		code    int
+	}{
+		{"wrong state", func(q url.Values) { q.Set("state", "forged") }, []string{"staff"}, "", http.StatusBadRequest},
+		{"provider error", func(q url.Values) { q.Set("error", "access_denied") }, []string{"staff"}, "", http.StatusUnauthorized},
+		{"wrong code", func(q url.Values) { q.Set("code", "forged") }, []string{"staff"}, "", http.StatusBadGateway},
+		{"wrong nonce", func(q url.Values) {}, []string{"staff"}, "forged", http.StatusUnauthorized},
+		{"unmapped group", func(q url.Values) {}, []string{"contractors"}, "", http.StatusForbidden},
+		{"return to another site", func(q url.Values) {}, []string{"staff"}, "", http.StatusFound},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				p.groups, p.nonce = tt.groups, tt.nonce
+				cookies, q := login(t, c, p, "//attacker.example")
+				tt.prepare(q)
+				w := callback(c, cookies, q)
+				if w.Code != tt.code {
+					t.Fatalf("[%v] Status %d, expecting %d: %s", tt.name, w.Code, tt.code, w.Body)
+				}
+				if w.Code == http.StatusFound && w.Header().Get("Location") != "/" {
+					t.Errorf("[%v] Redirected to %q, expecting %q", tt.name, w.Header().Get("Location"), "/")
+				}
+			},
+		)
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	p := newStubProvider(t)
+	p.groups = []string{"staff"}
+	c := newTestOIDCClient(t, p)
+
+	p.rotate()
+	cookies, q := login(t, c, p, "")
+	if w := callback(c, cookies, q); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Keys were fetched again within a minute: status %d", w.Code)
+	}
+	c.keysFetched = c.keysFetched.Add(-time.Minute)
+	cookies, q = login(t, c, p, "")
+	if w := callback(c, cookies, q); w.Code != http.StatusFound {
+		t.Fatalf("Callback after key rotation: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestDiscoverOIDCProvider(t *testing.T) {
+	p := newStubProvider(t)
+	provider, err := DiscoverOIDCProvider(p.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(provider)
+	if provider.TokenEndpoint != p.URL+"/token" {
+		t.Errorf("Unexpected provider: %s", b)
+	}
+	if _, err = DiscoverOIDCProvider(p.URL+"/", nil); err == nil {
+		t.Errorf("Expected an error for a mismatched issuer")
+	}
+}
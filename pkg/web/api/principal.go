@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/scottcagno/angular-refresher/pkg/web/jwt"
+)
+
+// Principal represents the authenticated caller of a request. It is placed
+// into the request context by the authentication middleware and can be
+// retrieved by handlers using PrincipalFrom.
+type Principal struct {
+	// Subject is the unique name of the authenticated caller.
+	Subject string `json:"subject"`
+
+	// Roles holds the roles that have been granted to the caller.
+	Roles []string `json:"roles"`
+
+	// Permissions holds any permissions that have been granted to the
+	// caller directly, rather than through one of its roles.
+	Permissions []string `json:"permissions,omitempty"`
+
+	// Claims holds the raw claims the caller was authenticated with. It
+	// may be nil if the authentication scheme is not token based.
+	Claims jwt.MapClaims `json:"-"`
+}
+
+// HasRole reports whether the principal was directly granted the provided role.
+func (p *Principal) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// PrincipalFromClaims builds a Principal from a set of token claims. The
+// subject is taken from the "sub" claim, falling back to the "user" claim,
+// the roles are taken from the "roles" and "role" claims, and the permissions
+// are taken from the "permissions" claim or a space separated "scope" claim.
+func PrincipalFromClaims(claims jwt.MapClaims) *Principal {
+	p := &Principal{
+		Claims: claims,
+	}
+	if sub, ok := claims["sub"].(string); ok && sub != "" {
+		p.Subject = sub
+	} else if user, ok := claims["user"].(string); ok {
+		p.Subject = user
+	}
+	switch roles := claims["roles"].(type) {
+	case []string:
+		p.Roles = append(p.Roles, roles...)
+	case []any:
+		for _, role := range roles {
+			if s, ok := role.(string); ok {
+				p.Roles = append(p.Roles, s)
+			}
+		}
+	}
+	if role, ok := claims["role"].(string); ok && role != "" && !p.HasRole(role) {
+		p.Roles = append(p.Roles, role)
+	}
+	switch perms := claims["permissions"].(type) {
+	case []string:
+		p.Permissions = append(p.Permissions, perms...)
+	case []any:
+		for _, perm := range perms {
+			if s, ok := perm.(string); ok {
+				p.Permissions = append(p.Permissions, s)
+			}
+		}
+	}
+	if scope, ok := claims["scope"].(string); ok {
+		p.Permissions = append(p.Permissions, strings.Fields(scope)...)
+	}
+	return p
+}
+
+// principalKey is the context key used to store the *Principal.
+type principalKey struct{}
+
+// NewPrincipalContext returns a copy of ctx carrying the provided principal.
+// The principal is also made known to the Audit middleware, if the request
+// is audited.
+func NewPrincipalContext(ctx context.Context, p *Principal) context.Context {
+	if scope, ok := auditScopeFrom(ctx); ok {
+		scope.setPrincipal(p)
+	}
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the principal stored in ctx, if there is one.
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// RequestPrincipal is a shortcut for PrincipalFrom(r.Context()).
+func RequestPrincipal(r *http.Request) (*Principal, bool) {
+	return PrincipalFrom(r.Context())
+}
@@ -18,8 +18,8 @@ func ReadJSON(r *http.Request, ptr any) error {
 }
 
 func WriteJSON(w http.ResponseWriter, code int, data any) {
-	w.WriteHeader(code)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
 	if err := json.NewEncoder(w).Encode(data); err != nil {
 		w.WriteHeader(http.StatusExpectationFailed)
 		return
@@ -4,22 +4,53 @@ import (
 	"log"
 
 	"github.com/scottcagno/angular-refresher/pkg/web"
+	"github.com/scottcagno/angular-refresher/pkg/web/api/middleware"
+	"github.com/scottcagno/angular-refresher/pkg/web/password"
 )
 
+// UserStore implements the middleware.CredentialProvider interface, so it can
+// back the Basic authentication middleware.
+var _ middleware.CredentialProvider = (*UserStore)(nil)
+
 type UserStore struct {
-	store *MemoryStore[string, web.SystemUser]
+	store  *MemoryStore[string, web.SystemUser]
+	hasher *password.Hasher
+	dummy  string
 }
 
 func NewUserStore() *UserStore {
+	return NewUserStoreWithHasher(password.DefaultHasher)
+}
+
+// NewUserStoreWithHasher returns a *UserStore that hashes and verifies
+// passwords using the provided hasher. Legacy plaintext passwords are
+// accepted by Authenticate (and upgraded) when the hasher allows them.
+func NewUserStoreWithHasher(hasher *password.Hasher) *UserStore {
+	// The dummy hash is verified against when a user can not be found,
+	// so the time taken does not reveal whether the username exists.
+	dummy, err := hasher.Hash("")
+	if err != nil {
+		panic(err)
+	}
 	return &UserStore{
-		store: NewMemoryStore[string, web.SystemUser](),
+		store:  NewMemoryStore[string, web.SystemUser](),
+		hasher: hasher,
+		dummy:  dummy,
 	}
 }
 
-func (us *UserStore) AddUser(username, password, role string) {
-	err := us.store.Add(username, web.SystemUser{
+// AddUser adds a new user to the store. The password is always hashed before
+// it is stored, also when it looks like a hash, so callers can not store a
+// hash of their choosing. Use SetPasswordHash to migrate existing hashes.
+func (us *UserStore) AddUser(username, pass, role string) {
+	hash, err := us.hasher.Hash(pass)
+	if err != nil {
+		log.Printf("[UserStore] user could not be added: %s\n", err)
+		return
+	}
+	err = us.store.Add(username, web.SystemUser{
 		Username: username,
-		Password: password,
+		Password: hash,
 		Role:     role,
 	})
 	if err != nil {
@@ -27,10 +58,78 @@ func (us *UserStore) AddUser(username, password, role string) {
 	}
 }
 
+// UpdateUser replaces the stored user. The password is always hashed before
+// it is stored; when it is empty, the stored hash is kept.
 func (us *UserStore) UpdateUser(username string, user web.SystemUser) {
+	if user.Password == "" {
+		stored, err := us.store.Get(username)
+		if err != nil {
+			log.Printf("[UserStore] user could not be updated: %s\n", err)
+			return
+		}
+		user.Password = stored.Password
+		us.store.Set(username, user)
+		return
+	}
+	hash, err := us.hasher.Hash(user.Password)
+	if err != nil {
+		log.Printf("[UserStore] user could not be updated: %s\n", err)
+		return
+	}
+	user.Password = hash
 	us.store.Set(username, user)
 }
 
+// SetPassword hashes and stores a new password for an existing user.
+func (us *UserStore) SetPassword(username, pass string) bool {
+	user := us.GetUser(username)
+	if user == nil {
+		return false
+	}
+	user.Password = pass
+	us.UpdateUser(username, *user)
+	return true
+}
+
+// SetPasswordHash stores a PHC encoded hash for an existing user, when
+// migrating users whose passwords were hashed elsewhere. It must never be
+// given a value read from a request.
+func (us *UserStore) SetPasswordHash(username, hash string) bool {
+	if !password.IsHashed(hash) {
+		log.Printf("[UserStore] password of %q is not a PHC encoded hash\n", username)
+		return false
+	}
+	user := us.GetUser(username)
+	if user == nil {
+		return false
+	}
+	user.Password = hash
+	us.store.Set(username, *user)
+	return true
+}
+
+// Authenticate verifies the password of the user using a constant time
+// comparison. If the stored hash was created using outdated parameters, or is
+// a legacy plaintext entry, it is replaced by a fresh hash.
+func (us *UserStore) Authenticate(username, pass string) (*web.SystemUser, bool) {
+	user, err := us.store.Get(username)
+	if err != nil {
+		_, _, _ = us.hasher.Verify(pass, us.dummy)
+		return nil, false
+	}
+	ok, rehash, err := us.hasher.Verify(pass, user.Password)
+	if err != nil || !ok {
+		return nil, false
+	}
+	if rehash {
+		if hash, err := us.hasher.Hash(pass); err == nil {
+			user.Password = hash
+			us.store.Set(username, user)
+		}
+	}
+	return &user, true
+}
+
 func (us *UserStore) GetUser(username string) *web.SystemUser {
 	user, err := us.store.Get(username)
 	if err != nil {
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/scottcagno/angular-refresher/pkg/web"
+	"github.com/scottcagno/angular-refresher/pkg/web/password"
+)
+
+func TestUserStoreAuthenticate(t *testing.T) {
+	us := NewUserStoreWithHasher(password.NewHasher(password.Params{LogN: 4}))
+	us.AddUser("admin", "secret", "ROLE_ADMIN")
+
+	if stored := us.GetUser("admin"); stored == nil || stored.Password == "secret" || !password.IsHashed(stored.Password) {
+		t.Fatalf("password was not hashed before it was stored: %+v", stored)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		ok       bool
+	}{
+		{"valid", "admin", "secret", true},
+		{"wrong password", "admin", "Secret", false},
+		{"unknown user", "nobody", "secret", false},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				user, ok := us.Authenticate(tt.username, tt.password)
+				if ok != tt.ok {
+					t.Fatalf("got %v, want %v", ok, tt.ok)
+				}
+				if ok && user.Role != "ROLE_ADMIN" {
+					t.Fatalf("unexpected user: %+v", user)
+				}
+			},
+		)
+	}
+}
+
+func TestUserStorePlaintextMigration(t *testing.T) {
+	hasher := password.NewHasher(password.Params{LogN: 4})
+	hasher.AllowPlaintext = true
+	us := NewUserStoreWithHasher(hasher)
+	// simulate a legacy entry by writing to the underlying store directly
+	us.store.Set("user", web.SystemUser{Username: "user", Password: "secret", Role: "ROLE_USER"})
+
+	if _, ok := us.Authenticate("user", "secret"); !ok {
+		t.Fatalf("legacy plaintext password was rejected")
+	}
+	if stored := us.GetUser("user"); !password.IsHashed(stored.Password) {
+		t.Fatalf("legacy password was not upgraded: %q", stored.Password)
+	}
+	if _, ok := us.Authenticate("user", "secret"); !ok {
+		t.Fatalf("upgraded password was rejected")
+	}
+}
+
+func TestUserStoreHashesEveryPassword(t *testing.T) {
+	us := NewUserStoreWithHasher(password.NewHasher(password.Params{LogN: 4}))
+	other, err := password.NewHasher(password.Params{LogN: 4}).Hash("chosen")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a value shaped like a hash is a password like any other
+	us.AddUser("admin", other, "ROLE_ADMIN")
+	if _, ok := us.Authenticate("admin", "chosen"); ok {
+		t.Fatalf("AddUser stored the provided hash")
+	}
+	if _, ok := us.Authenticate("admin", other); !ok {
+		t.Fatalf("AddUser did not hash the password")
+	}
+	us.AddUser("user", "$a$b$c", "ROLE_USER")
+	if _, ok := us.Authenticate("user", "$a$b$c"); !ok {
+		t.Fatalf("A password shaped like a hash was rejected")
+	}
+
+	us.UpdateUser("admin", web.SystemUser{Username: "admin", Password: other, Role: "ROLE_ADMIN"})
+	if _, ok := us.Authenticate("admin", "chosen"); ok {
+		t.Fatalf("UpdateUser stored the provided hash")
+	}
+	us.UpdateUser("admin", web.SystemUser{Username: "admin", Role: "ROLE_USER"})
+	if user, ok := us.Authenticate("admin", other); !ok || user.Role != "ROLE_USER" {
+		t.Fatalf("UpdateUser without a password did not keep the stored hash")
+	}
+
+	if us.SetPasswordHash("admin", "plain") {
+		t.Fatalf("SetPasswordHash accepted a plaintext password")
+	}
+	if !us.SetPasswordHash("admin", other) {
+		t.Fatalf("SetPasswordHash rejected a hash")
+	}
+	if _, ok := us.Authenticate("admin", "chosen"); !ok {
+		t.Fatalf("SetPasswordHash did not store the hash")
+	}
+}
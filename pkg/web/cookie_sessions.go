@@ -0,0 +1,263 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCookieTooLarge is returned when an encoded session does not fit in the
+// configured number of cookies.
+var ErrCookieTooLarge = errors.New("session: encoded session is too large for the cookies")
+
+// CookieStoreConfig is a configuration object for a
+// cookie session store
+type CookieStoreConfig struct {
+	Name          string        `json:"name"`             // Name of the session cookie, defaults to "go_sess"
+	Domain        string        `json:"domain"`           // Domain is the domain to limit the session scope
+	Timeout       time.Duration `json:"timeout_duration"` // Timeout is the max idle session time allowed, defaults to 30 minutes
+	SameSite      http.SameSite `json:"same_site"`        // SameSite mode of the cookie, defaults to Strict
+	Secure        bool          `json:"secure"`           // Secure restricts the cookie to TLS connections
+	Keys          []CookieKey   `json:"-"`                // Keys protect the cookie, the first encodes and all of them decode (required)
+	Codec         string        `json:"codec"`            // Codec is the name of the registered codec encoding session values, defaults to "gob"
+	MaxCookieSize int           `json:"max_cookie_size"`  // MaxCookieSize is the max length of a single cookie value, defaults to 3800
+	MaxChunks     int           `json:"max_chunks"`       // MaxChunks is the max number of cookies a session is split across, defaults to 4
+}
+
+var defaultCookieStoreConfig = &CookieStoreConfig{
+	Name:          "go_sess",
+	Timeout:       time.Duration(30) * time.Minute,
+	SameSite:      http.SameSiteStrictMode,
+	Codec:         "gob",
+	MaxCookieSize: 3800,
+	MaxChunks:     4,
+}
+
+func checkCookieStoreConfig(conf *CookieStoreConfig) *CookieStoreConfig {
+	c := *conf
+	if c.Name == "" {
+		c.Name = defaultCookieStoreConfig.Name
+	}
+	if c.Timeout == 0 {
+		c.Timeout = defaultCookieStoreConfig.Timeout
+	}
+	if c.SameSite == 0 {
+		c.SameSite = defaultCookieStoreConfig.SameSite
+	}
+	if c.Codec == "" {
+		c.Codec = defaultCookieStoreConfig.Codec
+	}
+	if c.MaxCookieSize == 0 {
+		c.MaxCookieSize = defaultCookieStoreConfig.MaxCookieSize
+	}
+	if c.MaxChunks == 0 {
+		c.MaxChunks = defaultCookieStoreConfig.MaxChunks
+	}
+	return &c
+}
+
+// CookieStore implements the session manager interface
+// and keeps the whole session in cookies, so that nothing
+// is stored server side. The session is authenticated,
+// and encrypted when the keys have a BlockKey, using a
+// SecureCookie. A session too large for a single cookie
+// is split across several cookies, named "<name>",
+// "<name>_1", "<name>_2" and so on.
+//
+// Because the client holds the session, a session that is
+// deleted can still be used until it expires if the client
+// kept a copy of the cookies.
+type CookieStore struct {
+	*CookieStoreConfig
+	cookie *SecureCookie
+	codec  SessionCodec
+}
+
+// NewCookieStore takes a *CookieStoreConfig, which must hold at least one
+// key, and returns a *CookieStore.
+func NewCookieStore(conf *CookieStoreConfig) (*CookieStore, error) {
+	if conf == nil {
+		return nil, ErrInvalidCookieKey
+	}
+	conf = checkCookieStoreConfig(conf)
+	sc, err := NewSecureCookie(conf.Keys...)
+	if err != nil {
+		return nil, err
+	}
+	codec, ok := LookupSessionCodec(conf.Codec)
+	if !ok {
+		return nil, fmt.Errorf("session: unknown codec %q", conf.Codec)
+	}
+	return &CookieStore{
+		CookieStoreConfig: conf,
+		cookie:            sc,
+		codec:             codec,
+	}, nil
+}
+
+// New creates and returns a new session
+func (cs *CookieStore) New() *Session {
+	return &Session{
+		id:      newSessionID(),
+		data:    new(sync.Map),
+		expires: AddTime(time.Now(), cs.Timeout),
+	}
+}
+
+// chunkName returns the name of the nth cookie of the session.
+func (cs *CookieStore) chunkName(n int) string {
+	if n == 0 {
+		return cs.Name
+	}
+	return cs.Name + "_" + strconv.Itoa(n)
+}
+
+// Get returns the session held by the cookies (if there is a valid one)
+func (cs *CookieStore) Get(r *http.Request) (*Session, bool) {
+	first, err := r.Cookie(cs.chunkName(0))
+	if err != nil {
+		return nil, false
+	}
+	// The first cookie holds the number of chunks, as in "2~<chunk>"
+	count, chunk, found := strings.Cut(first.Value, "~")
+	n, err := strconv.Atoi(count)
+	if !found || err != nil || n < 1 || n > cs.MaxChunks {
+		return nil, false
+	}
+	var sb strings.Builder
+	sb.WriteString(chunk)
+	for i := 1; i < n; i++ {
+		c, err := r.Cookie(cs.chunkName(i))
+		if err != nil {
+			return nil, false
+		}
+		sb.WriteString(c.Value)
+	}
+	payload, expires, err := cs.cookie.Decode(cs.Name, sb.String())
+	if err != nil {
+		return nil, false
+	}
+	// The payload holds the session id, prefixed by its length, followed by
+	// the encoded values
+	if len(payload) < 1 || len(payload) < 1+int(payload[0]) {
+		return nil, false
+	}
+	idLen := 1 + int(payload[0])
+	values, err := cs.codec.Decode(payload[idLen:])
+	if err != nil {
+		return nil, false
+	}
+	sess := &Session{
+		id:      string(payload[1:idLen]),
+		data:    new(sync.Map),
+		expires: expires,
+	}
+	for k, v := range values {
+		sess.data.Store(k, v)
+	}
+	return sess, true
+}
+
+// MustGet returns the session held by the cookies (if there is a valid one),
+// otherwise, it creates and returns a new session. It is guaranteed to return
+// a session.
+func (cs *CookieStore) MustGet(r *http.Request) (*Session, bool) {
+	sess, found := cs.Get(r)
+	if !found {
+		return cs.New(), false
+	}
+	return sess, true
+}
+
+// Save writes the provided session to the cookies. If you would like to
+// remove a session, simply pass it a nil session, and it will time the
+// cookies out.
+func (cs *CookieStore) Save(w http.ResponseWriter, r *http.Request, session *Session) {
+	if session == nil {
+		cs.clearChunks(w, r, 0)
+		return
+	}
+	if err := cs.save(w, r, session); err != nil {
+		log.Print(err)
+	}
+}
+
+func (cs *CookieStore) save(w http.ResponseWriter, r *http.Request, session *Session) error {
+	values := make(map[string]any)
+	session.data.Range(
+		func(k, v any) bool {
+			values[fmt.Sprint(k)] = v
+			return true
+		},
+	)
+	data, err := cs.codec.Encode(values)
+	if err != nil {
+		return err
+	}
+	if len(session.id) > 255 {
+		return errors.New("session: session id is too long")
+	}
+	payload := append([]byte{byte(len(session.id))}, session.id...)
+	payload = append(payload, data...)
+	expires := AddTime(time.Now(), cs.Timeout)
+	encoded, err := cs.cookie.Encode(cs.Name, payload, expires)
+	if err != nil {
+		return err
+	}
+	var chunks []string
+	for len(encoded) > 0 {
+		size := cs.MaxCookieSize
+		if len(chunks) == 0 {
+			// leave room for the chunk count of the first cookie
+			size -= len(strconv.Itoa(cs.MaxChunks)) + 1
+		}
+		if size > len(encoded) {
+			size = len(encoded)
+		}
+		chunks = append(chunks, encoded[:size])
+		encoded = encoded[size:]
+	}
+	if len(chunks) > cs.MaxChunks {
+		return ErrCookieTooLarge
+	}
+	chunks[0] = strconv.Itoa(len(chunks)) + "~" + chunks[0]
+	session.expires = expires
+	session.modified.Store(false)
+	for i, chunk := range chunks {
+		http.SetCookie(w, cs.newCookie(cs.chunkName(i), chunk, expires))
+	}
+	cs.clearChunks(w, r, len(chunks))
+	return nil
+}
+
+// clearChunks times out the cookies the request holds from the nth chunk on,
+// which are left over from a larger session.
+func (cs *CookieStore) clearChunks(w http.ResponseWriter, r *http.Request, from int) {
+	for i := from; i < cs.MaxChunks; i++ {
+		if _, err := r.Cookie(cs.chunkName(i)); err != nil {
+			continue
+		}
+		c := cs.newCookie(cs.chunkName(i), "", time.Unix(0, 0))
+		c.MaxAge = -1
+		http.SetCookie(w, c)
+	}
+}
+
+func (cs *CookieStore) newCookie(name, value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   cs.Domain,
+		Expires:  expires,
+		MaxAge:   setMaxAge(expires),
+		Secure:   cs.Secure,
+		HttpOnly: true,
+		SameSite: cs.SameSite,
+	}
+}
@@ -0,0 +1,156 @@
+package web
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustCookieKey(t *testing.T, encrypt bool) CookieKey {
+	k, err := GenerateCookieKey(encrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestSecureCookie(t *testing.T) {
+	signed, encrypted := mustCookieKey(t, false), mustCookieKey(t, true)
+	old, _ := NewSecureCookie(signed)
+	rotated, _ := NewSecureCookie(encrypted, signed)
+	other, _ := NewSecureCookie(mustCookieKey(t, true))
+
+	value := []byte("user=jane")
+	expires := time.Now().Add(time.Minute)
+	plain, err := old.Encode("sess", value, expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := rotated.Encode("sess", value, expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := base64.RawURLEncoding.DecodeString(sealed); bytes.Contains(b, value) {
+		t.Errorf("Encrypted value is readable")
+	}
+	expired, _ := rotated.Encode("sess", value, time.Now().Add(-time.Second))
+	tampered := []byte(plain)
+	tampered[3] ^= 1
+
+	tests := []struct {
+		name    string
+		sc      *SecureCookie
+		cookie  string
+		encoded string
+		err     error
+	}{
+		{"signed", old, "sess", plain, nil},
+		{"old key after rotation", rotated, "sess", plain, nil},
+		{"encrypted", rotated, "sess", sealed, nil},
+		{"new key before rotation", old, "sess", sealed, ErrCookieInvalid},
+		{"unknown key", other, "sess", sealed, ErrCookieInvalid},
+		{"other cookie", rotated, "csrf", sealed, ErrCookieInvalid},
+		{"tampered", old, "sess", string(tampered), ErrCookieInvalid},
+		{"not base64", old, "sess", "!!", ErrCookieInvalid},
+		{"expired", rotated, "sess", expired, ErrCookieExpired},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, exp, err := tt.sc.Decode(tt.cookie, tt.encoded)
+				if err != tt.err {
+					t.Fatalf("[%v] Error %v, expecting %v", tt.name, err, tt.err)
+				}
+				if err == nil && (!bytes.Equal(got, value) || exp.Unix() != expires.Unix()) {
+					t.Errorf("[%v] Decoded %q, %v, expecting %q, %v", tt.name, got, exp, value, expires)
+				}
+			},
+		)
+	}
+
+	if _, err = NewSecureCookie(CookieKey{HashKey: []byte("short")}); err != ErrInvalidCookieKey {
+		t.Errorf("Short hash key: %v, expecting %v", err, ErrInvalidCookieKey)
+	}
+}
+
+// roundTrip saves the session and returns a request carrying the cookies set,
+// along with the response.
+func roundTrip(cs *CookieStore, r *http.Request, sess *Session) (*http.Request, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	cs.Save(w, r, sess)
+	next := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range w.Result().Cookies() {
+		if c.MaxAge >= 0 {
+			next.AddCookie(c)
+		}
+	}
+	return next, w
+}
+
+func TestCookieStore(t *testing.T) {
+	cs, err := NewCookieStore(&CookieStoreConfig{Keys: []CookieKey{mustCookieKey(t, true)}, MaxCookieSize: 400})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess := cs.New()
+	sess.Set("current_user", &SystemUser{Username: "jane", Role: "ROLE_USER"})
+	sess.Set("note", strings.Repeat("x", 400))
+
+	r, w := roundTrip(cs, httptest.NewRequest(http.MethodGet, "/", nil), sess)
+	if n := len(w.Result().Cookies()); n < 3 {
+		t.Fatalf("Session was not split across cookies: %d cookies", n)
+	}
+	for _, c := range w.Result().Cookies() {
+		if len(c.Value) > cs.MaxCookieSize || !c.HttpOnly {
+			t.Errorf("Unexpected cookie %s", c)
+		}
+	}
+	loaded, found := cs.Get(r)
+	if !found || loaded.ID() != sess.ID() {
+		t.Fatalf("Session was not found")
+	}
+	if user, ok := loaded.GetUser(); !ok || user.Username != "jane" {
+		t.Errorf("Unexpected user %+v", user)
+	}
+
+	// A smaller session clears the chunks left over
+	loaded.Del("note")
+	r2, w := roundTrip(cs, r, loaded)
+	var cleared int
+	for _, c := range w.Result().Cookies() {
+		if c.MaxAge < 0 {
+			cleared++
+		}
+	}
+	if len(r2.Cookies()) != 1 || cleared < 2 {
+		t.Errorf("Leftover chunks were not cleared: %d cookies, %d cleared", len(r2.Cookies()), cleared)
+	}
+	if _, found = cs.Get(r2); !found {
+		t.Fatalf("Smaller session was not found")
+	}
+
+	// A missing chunk invalidates the session
+	partial := httptest.NewRequest(http.MethodGet, "/", nil)
+	partial.AddCookie(r.Cookies()[0])
+	if _, found = cs.Get(partial); found {
+		t.Errorf("Session with a missing chunk was accepted")
+	}
+
+	// A session too large for the cookies is not saved
+	sess.Set("note", strings.Repeat("x", 2000))
+	if err = cs.save(httptest.NewRecorder(), r, sess); err != ErrCookieTooLarge {
+		t.Errorf("Large session: %v, expecting %v", err, ErrCookieTooLarge)
+	}
+
+	// Deleting the session times out every cookie
+	_, w = roundTrip(cs, r, nil)
+	for _, c := range w.Result().Cookies() {
+		if c.MaxAge >= 0 {
+			t.Errorf("Cookie %s was not timed out", c.Name)
+		}
+	}
+}
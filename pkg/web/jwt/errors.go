@@ -22,6 +22,8 @@ var (
 	ErrTokenNotValidYet      = errors.New("token is not valid yet")
 	ErrTokenInvalidId        = errors.New("token has invalid id")
 	ErrTokenInvalidClaims    = errors.New("token has invalid claims")
+	ErrTokenRevoked          = errors.New("token has been revoked")
+	ErrTokenRequiredClaim    = errors.New("token is missing required claim")
 )
 
 // The errors that might occur when parsing and validating a token
@@ -37,6 +39,7 @@ const (
 	ValidationErrorNotValidYet   // NBF validation failed
 	ValidationErrorId            // JTI validation failed
 	ValidationErrorClaimsInvalid // Generic claims validation error
+	ValidationErrorRevoked       // Token was found in the revocation store
 )
 
 // NewValidationError is a helper for constructing a ValidationError with a string error message
@@ -108,6 +111,8 @@ func (e *ValidationError) Is(err error) bool {
 		return e.Errors&ValidationErrorId != 0
 	case ErrTokenInvalidClaims:
 		return e.Errors&ValidationErrorClaimsInvalid != 0
+	case ErrTokenRevoked:
+		return e.Errors&ValidationErrorRevoked != 0
 	}
 
 	return false
@@ -161,6 +166,10 @@ func (e *ValidationError) Name(err error) string {
 		if e.Errors&ValidationErrorClaimsInvalid != 0 {
 			return fmt.Sprintf("%T", err)
 		}
+	case ErrTokenRevoked:
+		if e.Errors&ValidationErrorRevoked != 0 {
+			return fmt.Sprintf("%T", err)
+		}
 	}
 	return fmt.Sprintf("%s", "<nil>")
 }
@@ -0,0 +1,400 @@
+package jwt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Key management algorithms, as described in RFC 7518, section 4.
+const (
+	KeyAlgRSAOAEP256 = "RSA-OAEP-256"
+	KeyAlgDir        = "dir"
+	KeyAlgA256KW     = "A256KW"
+)
+
+// Content encryption algorithms, as described in RFC 7518, section 5.
+const (
+	EncA256GCM      = "A256GCM"
+	EncA128CBCHS256 = "A128CBC-HS256"
+)
+
+var (
+	ErrJWEUnsupportedAlg = errors.New("jwe: unsupported key management algorithm")
+	ErrJWEUnsupportedEnc = errors.New("jwe: unsupported content encryption algorithm")
+	ErrJWEDecryption     = errors.New("jwe: decryption failed")
+	ErrJWEMalformed      = errors.New("jwe: token is malformed")
+	ErrJWENotNested      = errors.New("jwe: encrypted payload is not a signed token")
+)
+
+// kwIV is the default initial value from RFC 3394, section 2.2.3.1.
+var kwIV = []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+
+// Encrypt encrypts the payload and returns it using the JWE compact
+// serialization described in RFC 7516, section 7.1. The key depends on the
+// key management algorithm: RSA-OAEP-256 takes an *rsa.PublicKey, while dir
+// and A256KW take a []byte. Any extra header parameters are added to the
+// protected header.
+func Encrypt(payload []byte, alg, enc string, key any, extra map[string]any) (string, error) {
+	cekSize, err := cekSize(enc)
+	if err != nil {
+		return "", err
+	}
+
+	// Determine the content encryption key, and how it is shared
+	var cek, encryptedKey []byte
+	switch alg {
+	case KeyAlgRSAOAEP256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return "", ErrInvalidKeyType
+		}
+		cek, err = randomBytes(cekSize)
+		if err != nil {
+			return "", err
+		}
+		encryptedKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, cek, nil)
+		if err != nil {
+			return "", err
+		}
+	case KeyAlgDir:
+		k, ok := key.([]byte)
+		if !ok {
+			return "", ErrInvalidKeyType
+		}
+		if len(k) != cekSize {
+			return "", ErrInvalidKey
+		}
+		cek = k
+	case KeyAlgA256KW:
+		kek, ok := key.([]byte)
+		if !ok {
+			return "", ErrInvalidKeyType
+		}
+		cek, err = randomBytes(cekSize)
+		if err != nil {
+			return "", err
+		}
+		encryptedKey, err = aesKeyWrap(kek, cek)
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", ErrJWEUnsupportedAlg
+	}
+
+	// Assemble the protected header, which is used as additional data
+	header := make(map[string]any, len(extra)+2)
+	for k, v := range extra {
+		header[k] = v
+	}
+	header["alg"] = alg
+	header["enc"] = enc
+	b, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	protected := encodeSegment(b)
+
+	iv, ciphertext, tag, err := encryptContent(enc, cek, payload, []byte(protected))
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(
+		[]string{
+			protected,
+			encodeSegment(encryptedKey),
+			encodeSegment(iv),
+			encodeSegment(ciphertext),
+			encodeSegment(tag),
+		}, ".",
+	), nil
+}
+
+// Decrypt decrypts a token using the JWE compact serialization, returning
+// the payload along with the protected header. The key depends on the key
+// management algorithm: RSA-OAEP-256 takes an *rsa.PrivateKey, while dir and
+// A256KW take a []byte.
+func Decrypt(token string, key any) ([]byte, map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return nil, nil, ErrJWEMalformed
+	}
+	var segs [5][]byte
+	for i, part := range parts {
+		seg, err := decodeSegment(part)
+		if err != nil {
+			return nil, nil, ErrJWEMalformed
+		}
+		segs[i] = seg
+	}
+	var header map[string]any
+	err := json.Unmarshal(segs[0], &header)
+	if err != nil {
+		return nil, nil, ErrJWEMalformed
+	}
+	alg, _ := header["alg"].(string)
+	enc, _ := header["enc"].(string)
+	cekSize, err := cekSize(enc)
+	if err != nil {
+		return nil, header, err
+	}
+
+	// Recover the content encryption key
+	var cek []byte
+	switch alg {
+	case KeyAlgRSAOAEP256:
+		priv, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, header, ErrInvalidKeyType
+		}
+		cek, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, segs[1], nil)
+		if err != nil {
+			return nil, header, ErrJWEDecryption
+		}
+	case KeyAlgDir:
+		k, ok := key.([]byte)
+		if !ok {
+			return nil, header, ErrInvalidKeyType
+		}
+		if len(segs[1]) != 0 {
+			return nil, header, ErrJWEMalformed
+		}
+		cek = k
+	case KeyAlgA256KW:
+		kek, ok := key.([]byte)
+		if !ok {
+			return nil, header, ErrInvalidKeyType
+		}
+		cek, err = aesKeyUnwrap(kek, segs[1])
+		if err != nil {
+			return nil, header, ErrJWEDecryption
+		}
+	default:
+		return nil, header, ErrJWEUnsupportedAlg
+	}
+	if len(cek) != cekSize {
+		return nil, header, ErrJWEDecryption
+	}
+
+	payload, err := decryptContent(enc, cek, segs[2], segs[3], segs[4], []byte(parts[0]))
+	if err != nil {
+		return nil, header, err
+	}
+	return payload, header, nil
+}
+
+// EncryptedString signs the token using signKey, and then encrypts the signed
+// token, producing a nested JWT as described in RFC 7519, section 5.2.
+func (t *Token) EncryptedString(signKey any, alg, enc string, encKey any) (string, error) {
+	signed, err := t.SignedString(signKey)
+	if err != nil {
+		return "", err
+	}
+	return Encrypt([]byte(signed), alg, enc, encKey, map[string]any{"cty": "JWT"})
+}
+
+// decryptNested decrypts a nested JWT, returning the inner signed token.
+func decryptNested(token string, key any) (string, error) {
+	payload, header, err := Decrypt(token, key)
+	if err != nil {
+		return "", err
+	}
+	if cty, _ := header["cty"].(string); !strings.EqualFold(cty, "JWT") {
+		return "", ErrJWENotNested
+	}
+	return string(payload), nil
+}
+
+func cekSize(enc string) (int, error) {
+	switch enc {
+	case EncA256GCM, EncA128CBCHS256:
+		return 32, nil
+	}
+	return 0, ErrJWEUnsupportedEnc
+}
+
+func encryptContent(enc string, cek, plaintext, aad []byte) (iv, ciphertext, tag []byte, err error) {
+	switch enc {
+	case EncA256GCM:
+		block, err := aes.NewCipher(cek)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		iv, err = randomBytes(aead.NonceSize())
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		sealed := aead.Seal(nil, iv, plaintext, aad)
+		split := len(sealed) - aead.Overhead()
+		return iv, sealed[:split], sealed[split:], nil
+	case EncA128CBCHS256:
+		macKey, encKey := cek[:16], cek[16:]
+		block, err := aes.NewCipher(encKey)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		iv, err = randomBytes(aes.BlockSize)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		// PKCS #7 padding
+		pad := aes.BlockSize - len(plaintext)%aes.BlockSize
+		padded := make([]byte, len(plaintext)+pad)
+		copy(padded, plaintext)
+		for i := len(plaintext); i < len(padded); i++ {
+			padded[i] = byte(pad)
+		}
+		ciphertext = make([]byte, len(padded))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+		return iv, ciphertext, cbcHMACTag(macKey, aad, iv, ciphertext), nil
+	}
+	return nil, nil, nil, ErrJWEUnsupportedEnc
+}
+
+func decryptContent(enc string, cek, iv, ciphertext, tag, aad []byte) ([]byte, error) {
+	switch enc {
+	case EncA256GCM:
+		block, err := aes.NewCipher(cek)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		if len(iv) != aead.NonceSize() || len(tag) != aead.Overhead() {
+			return nil, ErrJWEDecryption
+		}
+		sealed := make([]byte, 0, len(ciphertext)+len(tag))
+		sealed = append(append(sealed, ciphertext...), tag...)
+		plaintext, err := aead.Open(nil, iv, sealed, aad)
+		if err != nil {
+			return nil, ErrJWEDecryption
+		}
+		return plaintext, nil
+	case EncA128CBCHS256:
+		macKey, encKey := cek[:16], cek[16:]
+		// Always check the tag before decrypting, so the padding can not
+		// be used as an oracle
+		if subtle.ConstantTimeCompare(tag, cbcHMACTag(macKey, aad, iv, ciphertext)) != 1 {
+			return nil, ErrJWEDecryption
+		}
+		if len(iv) != aes.BlockSize || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+			return nil, ErrJWEDecryption
+		}
+		block, err := aes.NewCipher(encKey)
+		if err != nil {
+			return nil, err
+		}
+		plaintext := make([]byte, len(ciphertext))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+		pad := int(plaintext[len(plaintext)-1])
+		if pad == 0 || pad > aes.BlockSize {
+			return nil, ErrJWEDecryption
+		}
+		for _, b := range plaintext[len(plaintext)-pad:] {
+			if int(b) != pad {
+				return nil, ErrJWEDecryption
+			}
+		}
+		return plaintext[:len(plaintext)-pad], nil
+	}
+	return nil, ErrJWEUnsupportedEnc
+}
+
+// cbcHMACTag computes the authentication tag for AES_CBC_HMAC_SHA2, as
+// described in RFC 7518, section 5.2.2.1.
+func cbcHMACTag(macKey, aad, iv, ciphertext []byte) []byte {
+	al := make([]byte, 8)
+	binary.BigEndian.PutUint64(al, uint64(len(aad))*8)
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(aad)
+	mac.Write(iv)
+	mac.Write(ciphertext)
+	mac.Write(al)
+	return mac.Sum(nil)[:16]
+}
+
+// aesKeyWrap wraps the key using the AES key wrap algorithm from RFC 3394.
+func aesKeyWrap(kek, key []byte) ([]byte, error) {
+	if len(kek) != 32 {
+		return nil, ErrInvalidKey
+	}
+	if len(key) < 16 || len(key)%8 != 0 {
+		return nil, fmt.Errorf("aes key wrap: invalid key length %d", len(key))
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(key) / 8
+	out := make([]byte, len(key)+8)
+	copy(out, kwIV)
+	copy(out[8:], key)
+	b := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b, out[:8])
+			copy(b[8:], out[i*8:i*8+8])
+			block.Encrypt(b, b)
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(out[:8], binary.BigEndian.Uint64(b[:8])^t)
+			copy(out[i*8:], b[8:])
+		}
+	}
+	return out, nil
+}
+
+// aesKeyUnwrap unwraps a key wrapped using aesKeyWrap.
+func aesKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(kek) != 32 {
+		return nil, ErrInvalidKey
+	}
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, ErrJWEDecryption
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(wrapped)/8 - 1
+	out := make([]byte, len(wrapped))
+	copy(out, wrapped)
+	b := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(out[:8])^t)
+			copy(b[8:], out[i*8:i*8+8])
+			block.Decrypt(b, b)
+			copy(out[:8], b[:8])
+			copy(out[i*8:], b[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(out[:8], kwIV) != 1 {
+		return nil, ErrJWEDecryption
+	}
+	return out[8:], nil
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
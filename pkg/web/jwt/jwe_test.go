@@ -0,0 +1,160 @@
+package jwt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAESKeyWrap(t *testing.T) {
+	// Test vector from RFC 3394, section 4.6
+	kek, _ := hex.DecodeString("000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F")
+	key, _ := hex.DecodeString("00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F")
+	want := "28c9f404c4b810f4cbccb35cfb87f8263f5786e2d80ed326cbc7f0e71a99f43bfb988b9b7a02dd21"
+
+	wrapped, err := aesKeyWrap(kek, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(wrapped); got != want {
+		t.Errorf("Incorrect wrapped key.\nwas:\n%v\nexpecting:\n%v", got, want)
+	}
+	unwrapped, err := aesKeyUnwrap(kek, wrapped)
+	if err != nil || !bytes.Equal(unwrapped, key) {
+		t.Errorf("Incorrect unwrapped key: %x (%v)", unwrapped, err)
+	}
+	wrapped[0] ^= 1
+	if _, err = aesKeyUnwrap(kek, wrapped); err == nil {
+		t.Errorf("Tampered wrapped key passed the integrity check")
+	}
+}
+
+func jweTestKeys(t *testing.T) (rsaKey any, rsaPub any, secret []byte) {
+	key, err := RSAPrivateKeyFromString(string(sampleKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret = bytes.Repeat([]byte{0x42}, 32)
+	return key, &key.PublicKey, secret
+}
+
+func TestJWEEncryptDecrypt(t *testing.T) {
+	priv, pub, secret := jweTestKeys(t)
+	payload := []byte(`{"secret":"not for the browser"}`)
+
+	tests := []struct {
+		alg    string
+		enc    string
+		encKey any
+		decKey any
+	}{
+		{KeyAlgRSAOAEP256, EncA256GCM, pub, priv},
+		{KeyAlgRSAOAEP256, EncA128CBCHS256, pub, priv},
+		{KeyAlgDir, EncA256GCM, secret, secret},
+		{KeyAlgDir, EncA128CBCHS256, secret, secret},
+		{KeyAlgA256KW, EncA256GCM, secret, secret},
+		{KeyAlgA256KW, EncA128CBCHS256, secret, secret},
+	}
+	for _, tt := range tests {
+		name := tt.alg + "+" + tt.enc
+		t.Run(
+			name, func(t *testing.T) {
+				token, err := Encrypt(payload, tt.alg, tt.enc, tt.encKey, nil)
+				if err != nil {
+					t.Fatalf("[%v] Error encrypting: %v", name, err)
+				}
+				parts := strings.Split(token, ".")
+				if len(parts) != 5 {
+					t.Fatalf("[%v] Expected 5 segments, got %d", name, len(parts))
+				}
+				if tt.alg == KeyAlgDir && parts[1] != "" {
+					t.Errorf("[%v] Direct encryption must have an empty encrypted key", name)
+				}
+				got, header, err := Decrypt(token, tt.decKey)
+				if err != nil {
+					t.Fatalf("[%v] Error decrypting: %v", name, err)
+				}
+				if !bytes.Equal(got, payload) {
+					t.Errorf("[%v] Incorrect payload %q", name, got)
+				}
+				if header["enc"] != tt.enc {
+					t.Errorf("[%v] Incorrect enc header %v", name, header["enc"])
+				}
+
+				// Changing any segment must make decryption fail
+				for i := range parts {
+					tampered := append([]string(nil), parts...)
+					seg, _ := decodeSegment(tampered[i])
+					if len(seg) == 0 {
+						continue
+					}
+					seg[len(seg)-1] ^= 1
+					tampered[i] = encodeSegment(seg)
+					if _, _, err = Decrypt(strings.Join(tampered, "."), tt.decKey); err == nil {
+						t.Errorf("[%v] Tampered segment %d passed decryption", name, i)
+					}
+				}
+			},
+		)
+	}
+}
+
+func TestJWEInvalidKeys(t *testing.T) {
+	_, pub, secret := jweTestKeys(t)
+	if _, err := Encrypt(nil, KeyAlgDir, EncA256GCM, secret[:16], nil); err != ErrInvalidKey {
+		t.Errorf("got %v, want %v", err, ErrInvalidKey)
+	}
+	if _, err := Encrypt(nil, KeyAlgRSAOAEP256, EncA256GCM, secret, nil); err != ErrInvalidKeyType {
+		t.Errorf("got %v, want %v", err, ErrInvalidKeyType)
+	}
+	if _, err := Encrypt(nil, "RSA1_5", EncA256GCM, pub, nil); err != ErrJWEUnsupportedAlg {
+		t.Errorf("got %v, want %v", err, ErrJWEUnsupportedAlg)
+	}
+	if _, err := Encrypt(nil, KeyAlgDir, "A128GCM", secret, nil); err != ErrJWEUnsupportedEnc {
+		t.Errorf("got %v, want %v", err, ErrJWEUnsupportedEnc)
+	}
+}
+
+func TestParserDecryptsNestedToken(t *testing.T) {
+	priv, pub, secret := jweTestKeys(t)
+	signKey, verifyKey := priv, pub
+
+	token := NewTokenWithClaims(SigningMethodRS256, MapClaims{"foo": "bar"})
+	encrypted, err := token.EncryptedString(signKey, KeyAlgA256KW, EncA256GCM, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFn := func(t *Token) (any, error) { return verifyKey, nil }
+
+	parsed, err := NewParser(WithDecryptionKey(secret)).Parse(encrypted, keyFn)
+	if err != nil {
+		t.Fatalf("Error parsing nested token: %v", err)
+	}
+	if !parsed.Valid || parsed.Claims.(MapClaims)["foo"] != "bar" {
+		t.Fatalf("Incorrect nested token: %+v", parsed)
+	}
+
+	// Without a decryption key, the token can not be parsed
+	_, err = NewParser().Parse(encrypted, keyFn)
+	if !errors.Is(err, ErrTokenMalformed) {
+		t.Errorf("got %v, want %v", err, ErrTokenMalformed)
+	}
+
+	// Using the wrong decryption key
+	_, err = NewParser(WithDecryptionKey(bytes.Repeat([]byte{1}, 32))).Parse(encrypted, keyFn)
+	if !errors.Is(err, ErrTokenUnverifiable) {
+		t.Errorf("got %v, want %v", err, ErrTokenUnverifiable)
+	}
+
+	// A payload that is only encrypted, and not signed, is rejected
+	plain, err := Encrypt([]byte(`{"foo":"bar"}`), KeyAlgDir, EncA256GCM, secret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewParser(WithDecryptionKey(secret)).Parse(plain, keyFn)
+	if !errors.Is(err, ErrJWENotNested) {
+		t.Errorf("got %v, want %v", err, ErrJWENotNested)
+	}
+}
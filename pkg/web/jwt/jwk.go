@@ -0,0 +1,207 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	ErrJWKUnsupported = errors.New("jwk: unsupported key type")
+	ErrJWKInvalid     = errors.New("jwk: key is invalid")
+)
+
+// JWK is a public key in the JSON Web Key format described in RFC 7517. Only
+// the members required to describe RSA, EC and OKP (Ed25519) public keys are
+// supported.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set as described in RFC 7517, section 5.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Key returns the key with the provided kid.
+func (s *JWKSet) Key(kid string) (JWK, bool) {
+	for _, k := range s.Keys {
+		if k.Kid == kid {
+			return k, true
+		}
+	}
+	return JWK{}, false
+}
+
+// NewJWK returns the JWK describing the provided public key. Supported keys
+// are *rsa.PublicKey, *ecdsa.PublicKey and ed25519.PublicKey.
+func NewJWK(kid, alg string, key crypto.PublicKey) (JWK, error) {
+	jwk := JWK{
+		Kid: kid,
+		Use: "sig",
+		Alg: alg,
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeSegment(k.N.Bytes())
+		jwk.E = encodeSegment(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = k.Curve.Params().Name
+		jwk.X = encodeSegment(k.X.FillBytes(make([]byte, size)))
+		jwk.Y = encodeSegment(k.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encodeSegment(k)
+	default:
+		return JWK{}, ErrJWKUnsupported
+	}
+	if jwk.Kid == "" {
+		kid, err := jwk.Thumbprint()
+		if err != nil {
+			return JWK{}, err
+		}
+		jwk.Kid = kid
+	}
+	return jwk, nil
+}
+
+// PublicKey returns the public key described by the JWK.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeSegment(k.N)
+		if err != nil || len(n) == 0 {
+			return nil, ErrJWKInvalid
+		}
+		e, err := decodeSegment(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, ErrJWKInvalid
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, ErrJWKUnsupported
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil {
+			return nil, ErrJWKInvalid
+		}
+		y, err := decodeSegment(k.Y)
+		if err != nil {
+			return nil, ErrJWKInvalid
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, ErrJWKInvalid
+		}
+		return pub, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, ErrJWKUnsupported
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, ErrJWKInvalid
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, ErrJWKUnsupported
+}
+
+// Method returns the signing method used with the key. If the JWK does not
+// carry an "alg" member, the method is derived from the key type.
+func (k JWK) Method() (SigningMethod, error) {
+	alg := k.Alg
+	if alg == "" {
+		switch {
+		case k.Kty == "RSA":
+			alg = SigningMethodRS256.Alg()
+		case k.Kty == "EC" && k.Crv == "P-256":
+			alg = SigningMethodES256.Alg()
+		case k.Kty == "EC" && k.Crv == "P-384":
+			alg = SigningMethodES384.Alg()
+		case k.Kty == "EC" && k.Crv == "P-521":
+			alg = SigningMethodES512.Alg()
+		case k.Kty == "OKP":
+			alg = SigningMethodEdDSA.Alg()
+		}
+	}
+	method := GetSigningMethod(alg)
+	if method == nil {
+		return nil, fmt.Errorf("jwk: signing method (alg) %q is unavailable", alg)
+	}
+	return method, nil
+}
+
+// Thumbprint returns the RFC 7638 thumbprint of the key, which is suitable
+// for use as a kid.
+func (k JWK) Thumbprint() (string, error) {
+	var members any
+	switch k.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{k.E, k.Kty, k.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{k.Crv, k.Kty, k.X, k.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{k.Crv, k.Kty, k.X}
+	default:
+		return "", ErrJWKUnsupported
+	}
+	b, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return encodeSegment(sum[:]), nil
+}
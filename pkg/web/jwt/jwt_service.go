@@ -1,131 +1,407 @@
 package jwt
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
+	"encoding/json"
 	"fmt"
-	"os"
-	"sync"
 	"time"
 )
 
 const (
-	expirationTime = 12 * time.Hour
-	mySecretKey    = `eyJl2eHAiOjE12NzEyMzI30MjY3InJ3vbGU14iOiJST0xFX330FE5TUlO5I3iwid5X6Nlci7I6ImF`
+	// AccessTokenTTL is how long a signed access token remains valid.
+	AccessTokenTTL = 15 * time.Minute
+
+	// RefreshTokenTTL is how long an opaque refresh token remains valid.
+	RefreshTokenTTL = 7 * 24 * time.Hour
+
+	// DefaultIssuer is the "iss" claim of the tokens issued by the service.
+	DefaultIssuer = "angular-refresher"
+
+	// DefaultAudience is the "aud" claim of the tokens issued by the
+	// service; the API the tokens are meant for.
+	DefaultAudience = "angular-refresher-api"
+
+	// DefaultLeeway is the clock skew allowed when validating the time based
+	// claims of a token.
+	DefaultLeeway = 30 * time.Second
 )
 
-var jwtServiceOnce sync.Once
+// JWTServiceConfig configures a JWTService.
+type JWTServiceConfig struct {
+	// Issuer is the "iss" claim of issued tokens. Tokens from any other
+	// issuer are rejected.
+	//
+	// Optional. Default value DefaultIssuer
+	Issuer string
 
-type JWTService struct {
-	privateKey     *rsa.PrivateKey
-	publicKey      *rsa.PublicKey
-	expirationTime time.Time
-	signingMethod  SigningMethod
+	// Audience is the "aud" claim of issued tokens. Tokens meant for any
+	// other audience are rejected.
+	//
+	// Optional. Default value DefaultAudience
+	Audience string
+
+	// AccessTTL is how long an access token remains valid.
+	//
+	// Optional. Default value AccessTokenTTL
+	AccessTTL time.Duration
+
+	// RefreshTTL is how long a refresh token remains valid.
+	//
+	// Optional. Default value RefreshTokenTTL
+	RefreshTTL time.Duration
+
+	// Leeway is the clock skew allowed when validating tokens.
+	//
+	// Optional. Default value DefaultLeeway
+	Leeway time.Duration
+
+	// Algorithm is the signing method used to sign tokens; one of the RS,
+	// PS and ES families, or EdDSA.
+	//
+	// Optional. Default value "RS256"
+	Algorithm string
+
+	// Keys provides the signing key.
+	//
+	// Optional. Default value is a key generated in memory.
+	Keys KeySource
+
+	// RefreshStore holds the issued refresh tokens.
+	//
+	// Optional. Default value is a new MemoryRefreshStore.
+	RefreshStore RefreshStore
+
+	// Revocations holds the revoked access tokens.
+	//
+	// Optional. Default value is a new MemoryRevocationStore.
+	Revocations RevocationStore
+}
+
+func checkJWTServiceConfig(conf *JWTServiceConfig) *JWTServiceConfig {
+	var c JWTServiceConfig
+	if conf != nil {
+		c = *conf
+	}
+	if c.Issuer == "" {
+		c.Issuer = DefaultIssuer
+	}
+	if c.Audience == "" {
+		c.Audience = DefaultAudience
+	}
+	if c.AccessTTL <= 0 {
+		c.AccessTTL = AccessTokenTTL
+	}
+	if c.RefreshTTL <= 0 {
+		c.RefreshTTL = RefreshTokenTTL
+	}
+	if c.Leeway <= 0 {
+		c.Leeway = DefaultLeeway
+	}
+	if c.Algorithm == "" {
+		c.Algorithm = SigningMethodRS256.Alg()
+	}
+	if c.Keys == nil {
+		c.Keys = GeneratedKey()
+	}
+	if c.RefreshStore == nil {
+		c.RefreshStore = NewMemoryRefreshStore()
+	}
+	if c.Revocations == nil {
+		c.Revocations = NewMemoryRevocationStore()
+	}
+	return &c
 }
 
-var JWTServiceInstance *JWTService
+type JWTService struct {
+	keys         *KeySet
+	method       SigningMethod
+	accessTTL    time.Duration
+	refreshTTL   time.Duration
+	issuer       string
+	audience     string
+	leeway       time.Duration
+	refreshStore RefreshStore
+	revocations  RevocationStore
+	now          func() time.Time
+}
 
+// NewJWTService returns a JWTService using the RSA key pair found in the
+// provided files. If the private key file does not exist, a new key pair is
+// generated and written to both files.
+//
+// Deprecated: NewJWTService panics on error. Use NewJWTServiceWithConfig,
+// along with KeyFilesOrGenerate, instead.
 func NewJWTService(privateKeyFile, publicKeyFile string) *JWTService {
-	jwtServiceOnce.Do(
-		func() {
-			service, err := initJWTServiceInstance(privateKeyFile, publicKeyFile)
-			if err != nil {
-				panic(err)
-			}
-			JWTServiceInstance = service
+	service, err := NewJWTServiceWithConfig(
+		&JWTServiceConfig{
+			Keys: KeyFilesOrGenerate(privateKeyFile, publicKeyFile),
 		},
 	)
-	return JWTServiceInstance
-}
-
-func initJWTServiceInstance(privateKeyFile, publicKeyFile string) (*JWTService, error) {
-	// Initialize our variables
-	var key *rsa.PrivateKey
-	// Check to see if the specified private and public key files exist
-	_, err := os.Stat(privateKeyFile)
-	if os.IsNotExist(err) {
-		// The private key file does not exist, so the public should not
-		// exist either, but if it does, we should remove it.
-		_, err = os.Stat(publicKeyFile)
-		if os.IsExist(err) {
-			// The public key file does indeed exist; let us blow it away
-			err = os.RemoveAll(publicKeyFile)
-			if err != nil {
-				return nil, err
-			}
-		}
-		// Now, since we do not have any private and public keys we must create them.
-		key, err = rsa.GenerateKey(rand.Reader, 2048)
-		if err != nil {
-			return nil, err
-		}
-		// We should write our *rsa.PrivateKey to a file
-		err = WriteRSAPrivateKeyAsPEM(key, privateKeyFile)
-		if err != nil {
-			return nil, err
-		}
-		// And write our *rsa.PublicKey to a file
-		err = WriteRSAPublicKeyAsPEM(&key.PublicKey, publicKeyFile)
+	if err != nil {
+		panic(err)
+	}
+	return service
+}
+
+// NewJWTServiceWithConfig returns a new JWTService configured using the
+// provided config. A nil config uses the default values, signing tokens using
+// a key generated in memory. Every call returns an independent service.
+func NewJWTServiceWithConfig(conf *JWTServiceConfig) (*JWTService, error) {
+	conf = checkJWTServiceConfig(conf)
+	method := GetSigningMethod(conf.Algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("signing method (alg) %q is unavailable", conf.Algorithm)
+	}
+	key, err := conf.Keys(method)
+	if err != nil {
+		return nil, err
+	}
+	// Add our key pair to the key set, using its thumbprint as the kid
+	keys := NewKeySet()
+	err = keys.SetSigningKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &JWTService{
+		keys:         keys,
+		method:       method,
+		accessTTL:    conf.AccessTTL,
+		refreshTTL:   conf.RefreshTTL,
+		issuer:       conf.Issuer,
+		audience:     conf.Audience,
+		leeway:       conf.Leeway,
+		refreshStore: conf.RefreshStore,
+		revocations:  conf.Revocations,
+		now:          time.Now,
+	}, nil
+}
+
+// generateClaims creates the claims of a new access token for the user. The
+// time based claims are computed at issue time, and every token gets a unique
+// id. Any extra claims are added, but can not replace the claims set here.
+func (s *JWTService) generateClaims(username, role string, extra MapClaims) (MapClaims, time.Time, error) {
+	jti, err := randomString(16)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	now := s.now()
+	exp := now.Add(s.accessTTL)
+	claims := make(MapClaims, len(extra)+9)
+	for k, v := range extra {
+		claims[k] = v
+	}
+	claims["iss"] = s.issuer
+	claims["aud"] = s.audience
+	claims["sub"] = username
+	claims["user"] = username
+	claims["role"] = role
+	claims["iat"] = NewNumericDate(now)
+	claims["nbf"] = NewNumericDate(now)
+	claims["exp"] = NewNumericDate(exp)
+	claims["jti"] = jti
+	return claims, exp, nil
+}
+
+// GenerateSignedToken generates and signs a token for the provided user. Only
+// the username and role are placed in the token, never any credentials.
+func (s *JWTService) GenerateSignedToken(username, role string) string {
+	claims, _, err := s.generateClaims(username, role, nil)
+	if err != nil {
+		panic(err)
+	}
+	// Sign using the current signing key, which stamps the kid
+	str, err := s.keys.Sign(claims)
+	if err != nil {
+		panic(err)
+	}
+	return str
+}
+
+// IssueTokenPair signs a new access token for the user and creates a new
+// refresh token, starting a new refresh token family.
+func (s *JWTService) IssueTokenPair(username, role string) (*TokenPair, error) {
+	family, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+	return s.issueTokenPair(username, role, family, nil)
+}
+
+// IssueTokenPairWithClaims is like IssueTokenPair, but places the extra claims,
+// such as the scope of an OAuth 2.0 grant, in the access token. The extra
+// claims are kept with the refresh token, so every access token issued when
+// it is refreshed carries them as well.
+func (s *JWTService) IssueTokenPairWithClaims(username, role string, claims MapClaims) (*TokenPair, error) {
+	family, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+	return s.issueTokenPair(username, role, family, claims)
+}
+
+// IssueAccessToken signs a new access token, without a refresh token, placing
+// the extra claims in the token. It returns the token along with its expiry.
+func (s *JWTService) IssueAccessToken(subject, role string, claims MapClaims) (string, time.Time, error) {
+	claims, exp, err := s.generateClaims(subject, role, claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	access, err := s.keys.Sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return access, exp, nil
+}
+
+func (s *JWTService) issueTokenPair(username, role, family string, extra MapClaims) (*TokenPair, error) {
+	claims, exp, err := s.generateClaims(username, role, extra)
+	if err != nil {
+		return nil, err
+	}
+	access, err := s.keys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	rt := &RefreshToken{
+		ID:        refreshTokenID(refresh),
+		Family:    family,
+		Subject:   username,
+		Role:      role,
+		Claims:    extra,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(s.refreshTTL),
+	}
+	err = s.refreshStore.Save(rt)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:      access,
+		RefreshToken:     refresh,
+		TokenType:        "Bearer",
+		ExpiresIn:        int(s.accessTTL.Seconds()),
+		AccessExpiresAt:  exp,
+		RefreshExpiresAt: rt.ExpiresAt,
+	}, nil
+}
+
+// Refresh exchanges a refresh token for a new token pair. Every refresh token
+// can only be used once. If a token that was already used is presented
+// again, it has most likely been stolen, so every token in its family is
+// revoked and ErrRefreshTokenReused is returned.
+func (s *JWTService) Refresh(refreshToken string) (*TokenPair, error) {
+	return s.RefreshWith(refreshToken, nil)
+}
+
+// RefreshWith is like Refresh, but calls check with the stored refresh token
+// before it is used. If check returns an error, the refresh token is left
+// untouched and the error is returned. Check may replace the Claims of the
+// refresh token, for instance to narrow the scope of the new pair.
+func (s *JWTService) RefreshWith(refreshToken string, check func(rt *RefreshToken) error) (*TokenPair, error) {
+	id := refreshTokenID(refreshToken)
+	claims := MapClaims(nil)
+	if check != nil {
+		rt, err := s.refreshStore.Get(id)
 		if err != nil {
 			return nil, err
 		}
-	} else {
-		// We already have private and public key files, so we can read them
-		key, err = ReadRSAPrivateKeyFromPEM(privateKeyFile)
-		if err != nil {
-			return nil, err
+		if !rt.Used && !rt.Revoked && !s.now().After(rt.ExpiresAt) {
+			err = check(rt)
+			if err != nil {
+				return nil, err
+			}
+			claims = rt.Claims
 		}
-		// Next, read our public key file
-		var pub *rsa.PublicKey
-		pub, err = ReadRSAPublicKeyFromPEM(publicKeyFile)
+	}
+	rt, ok, err := s.refreshStore.Use(id)
+	if err != nil {
+		return nil, err
+	}
+	if check != nil {
+		rt.Claims = claims
+	}
+	if rt.Revoked {
+		return nil, ErrRefreshTokenRevoked
+	}
+	if !ok {
+		err = s.refreshStore.RevokeFamily(rt.Family)
 		if err != nil {
 			return nil, err
 		}
-		key.PublicKey = *pub
+		return nil, ErrRefreshTokenReused
 	}
-	// Finally, we can assemble and return our JWTService
-	return &JWTService{
-		privateKey:     key,
-		publicKey:      &key.PublicKey,
-		expirationTime: time.Now().Add(expirationTime),
-		signingMethod:  SigningMethodRS256,
-	}, nil
+	if s.now().After(rt.ExpiresAt) {
+		return nil, ErrRefreshTokenExpired
+	}
+	return s.issueTokenPair(rt.Subject, rt.Role, rt.Family, rt.Claims)
 }
 
-func (s *JWTService) generateToken(username, password, role string) *Token {
-	return NewTokenWithClaims(
-		s.signingMethod, MapClaims{
-			"user": username,
-			"pass": password,
-			"role": role,
-			"exp":  NewNumericDate(s.expirationTime),
-		},
-	)
+// LookupRefreshToken returns the stored record of the refresh token, which
+// may be used, revoked or expired.
+func (s *JWTService) LookupRefreshToken(refreshToken string) (*RefreshToken, error) {
+	return s.refreshStore.Get(refreshTokenID(refreshToken))
 }
 
-func (s *JWTService) GenerateSignedToken(username, password, role string) string {
-	token := s.generateToken(username, password, role)
-	// SignedString must take a *PrivateKey
-	str, err := token.SignedString(s.privateKey)
+// RevokeRefreshToken revokes the refresh token along with every other token
+// in its family. It is used when a user logs out.
+func (s *JWTService) RevokeRefreshToken(refreshToken string) error {
+	rt, err := s.refreshStore.Get(refreshTokenID(refreshToken))
 	if err != nil {
-		panic(err)
+		return err
 	}
-	return str
+	return s.refreshStore.RevokeFamily(rt.Family)
 }
 
 func (s *JWTService) ValidateTokenString(tokenString string) (*Token, error) {
-	parser := NewParser([]string{s.signingMethod.Alg()}, true, false)
+	return s.validateTokenString(tokenString, s.audience)
+}
 
-	// keyFn must return a *PublicKey type when parsing
-	keyFn := func(t *Token) (any, error) {
-		// Validate the signing method
-		if t.Method.Alg() != s.signingMethod.Alg() {
-			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-		}
-		return s.publicKey, nil
+// IssuePurposeToken signs a short-lived token for the subject that can only be
+// used for the provided purpose, such as completing the second step of a
+// login. The purpose is placed in the "aud" claim, so the token is never
+// accepted by ValidateTokenString; it is only accepted by ValidatePurposeToken
+// with the same purpose.
+func (s *JWTService) IssuePurposeToken(subject, role, purpose string, ttl time.Duration) (string, error) {
+	if purpose == "" || purpose == s.audience {
+		return "", fmt.Errorf("invalid token purpose %q", purpose)
+	}
+	claims, _, err := s.generateClaims(subject, role, nil)
+	if err != nil {
+		return "", err
 	}
+	claims["aud"] = purpose
+	claims["exp"] = NewNumericDate(s.now().Add(ttl))
+	return s.keys.Sign(claims)
+}
 
-	token, err := parser.Parse(tokenString, keyFn)
+// ValidatePurposeToken validates a token issued by IssuePurposeToken for the
+// provided purpose.
+func (s *JWTService) ValidatePurposeToken(tokenString, purpose string) (*Token, error) {
+	if purpose == "" || purpose == s.audience {
+		return nil, fmt.Errorf("invalid token purpose %q", purpose)
+	}
+	return s.validateTokenString(tokenString, purpose)
+}
+
+func (s *JWTService) validateTokenString(tokenString, audience string) (*Token, error) {
+	parser := NewParser(
+		WithValidMethods(s.keys.Algorithms()),
+		WithJSONNumber(),
+		WithIssuer(s.issuer),
+		WithAudience(audience),
+		WithRequiredClaims("sub", "exp", "iat"),
+		WithLeeway(s.leeway),
+		WithTimeFunc(s.now),
+		WithRevocations(s.revocations),
+	)
+
+	// The key set selects the public key using the kid in the header, and
+	// validates the signing method
+	token, err := parser.Parse(tokenString, s.keys.KeyFunc())
 	if err != nil {
 		return nil, err
 	}
@@ -136,6 +412,72 @@ func (s *JWTService) ValidateTokenString(tokenString string) (*Token, error) {
 	return token, nil
 }
 
-func (s *JWTService) ExpireTime() time.Time {
-	return s.expirationTime
+// SetRevocationStore replaces the store used to look up revoked tokens, for
+// instance with a *FileRevocationStore so revocations survive a restart.
+func (s *JWTService) SetRevocationStore(rs RevocationStore) {
+	s.revocations = rs
+}
+
+// RevokeToken revokes a single access token using its "jti" claim. The token
+// must carry a valid signature, but may already be expired or revoked.
+func (s *JWTService) RevokeToken(tokenString string) error {
+	token, err := s.parseSignedToken(tokenString)
+	if err != nil {
+		return err
+	}
+	claims := token.Claims.(MapClaims)
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return ErrTokenInvalidId
+	}
+	exp := s.now().Add(s.accessTTL)
+	if v, ok := claims["exp"].(json.Number); ok {
+		if sec, err := v.Int64(); err == nil {
+			exp = time.Unix(sec, 0)
+		}
+	}
+	return s.revocations.Revoke(jti, exp)
+}
+
+// RevokeSubject revokes every access token that was issued to the subject
+// up until now. Tokens issued afterwards are not affected.
+func (s *JWTService) RevokeSubject(subject string) error {
+	now := s.now()
+	return s.revocations.RevokeSubject(subject, now, now.Add(s.accessTTL))
+}
+
+// parseSignedToken parses the token and verifies its signature, without
+// validating any of its claims.
+func (s *JWTService) parseSignedToken(tokenString string) (*Token, error) {
+	parser := NewParser(WithValidMethods(s.keys.Algorithms()), WithJSONNumber(), WithoutClaimsValidation())
+	return parser.Parse(tokenString, s.keys.KeyFunc())
+}
+
+// KeySet returns the keys used to sign and verify tokens. The key set can be
+// published using its ServeHTTP method.
+func (s *JWTService) KeySet() *KeySet {
+	return s.keys
+}
+
+// RotateKey replaces the signing key with a newly generated key for the
+// signing method of the service. Tokens signed using the previous key remain
+// valid for the provided overlap, which should be at least the lifetime of an
+// access token. The new key is kept in memory only, and is not written to the
+// key files.
+func (s *JWTService) RotateKey(overlap time.Duration) error {
+	key, err := generateSigningKey(s.method)
+	if err != nil {
+		return err
+	}
+	return s.keys.Rotate(key, overlap)
+}
+
+// AccessTTL returns how long a newly issued access token remains valid.
+func (s *JWTService) AccessTTL() time.Duration {
+	return s.accessTTL
+}
+
+// RefreshTTL returns how long a newly issued refresh token remains valid.
+func (s *JWTService) RefreshTTL() time.Duration {
+	return s.refreshTTL
 }
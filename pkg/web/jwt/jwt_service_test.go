@@ -36,7 +36,7 @@ func TestJWTService_ValidateTokenString(t *testing.T) {
 
 	signingMethod := SigningMethodRS256
 
-	parser := NewParser([]string{signingMethod.Alg()}, true, false)
+	parser := NewParser(WithValidMethods([]string{signingMethod.Alg()}), WithJSONNumber())
 
 	keyFn := func(t *Token) (any, error) {
 		// Validate the signing method
@@ -0,0 +1,253 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	ErrKeyMismatch       = errors.New("public key does not match the private key")
+	ErrKeyNotInEnv       = errors.New("key is not set in the environment")
+	ErrUnsupportedMethod = errors.New("signing method does not support key pairs")
+)
+
+// KeySource provides the signing key of a JWTService, for the signing method
+// the service is configured with.
+type KeySource func(method SigningMethod) (*SigningKey, error)
+
+// GeneratedKey returns a KeySource that generates a new key pair in memory.
+// Tokens signed using the key do not survive a restart of the service.
+func GeneratedKey() KeySource {
+	return generateSigningKey
+}
+
+// KeyFiles returns a KeySource that reads a PEM encoded key pair from the
+// provided files. If publicKeyFile is empty, the public key is derived from
+// the private key.
+func KeyFiles(privateKeyFile, publicKeyFile string) KeySource {
+	return func(method SigningMethod) (*SigningKey, error) {
+		priv, err := os.ReadFile(privateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		var pub []byte
+		if publicKeyFile != "" {
+			pub, err = os.ReadFile(publicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return parseSigningKey(method, priv, pub)
+	}
+}
+
+// KeyFilesOrGenerate returns a KeySource that reads a PEM encoded key pair
+// from the provided files. If the private key file does not exist, a new key
+// pair is generated and written to both files.
+func KeyFilesOrGenerate(privateKeyFile, publicKeyFile string) KeySource {
+	return func(method SigningMethod) (*SigningKey, error) {
+		_, err := os.Stat(privateKeyFile)
+		if err == nil {
+			return KeyFiles(privateKeyFile, publicKeyFile)(method)
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		key, err := generateSigningKey(method)
+		if err != nil {
+			return nil, err
+		}
+		err = writeSigningKey(key, privateKeyFile, publicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+}
+
+// KeyPEM returns a KeySource that parses the provided PEM encoded key pair.
+// If publicKey is empty, the public key is derived from the private key.
+func KeyPEM(privateKey, publicKey string) KeySource {
+	return func(method SigningMethod) (*SigningKey, error) {
+		return parseSigningKey(method, []byte(privateKey), []byte(publicKey))
+	}
+}
+
+// KeyEnv returns a KeySource that parses the PEM encoded key pair found in
+// the provided environment variables. If publicKeyVar is empty, or the
+// variable is not set, the public key is derived from the private key.
+func KeyEnv(privateKeyVar, publicKeyVar string) KeySource {
+	return func(method SigningMethod) (*SigningKey, error) {
+		priv, found := os.LookupEnv(privateKeyVar)
+		if !found || priv == "" {
+			return nil, fmt.Errorf("%w: %s", ErrKeyNotInEnv, privateKeyVar)
+		}
+		var pub string
+		if publicKeyVar != "" {
+			pub = os.Getenv(publicKeyVar)
+		}
+		return KeyPEM(priv, pub)(method)
+	}
+}
+
+// generateSigningKey generates a new key pair for the signing method. RSA
+// keys are 2048 bits, and ECDSA keys use the curve matching the method.
+func generateSigningKey(method SigningMethod) (*SigningKey, error) {
+	var priv crypto.PrivateKey
+	var pub crypto.PublicKey
+	switch m := method.(type) {
+	case *SigningMethodRSA, *SigningMethodRSAPSS:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		priv, pub = key, &key.PublicKey
+	case *SigningMethodECDSA:
+		curve, err := methodCurve(m)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		priv, pub = key, &key.PublicKey
+	case *SigningMethodEd25519:
+		edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		priv, pub = edPriv, edPub
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedMethod, method.Alg())
+	}
+	return &SigningKey{Method: method, Private: priv, Public: pub}, nil
+}
+
+// parseSigningKey parses a PEM encoded key pair for the signing method. When
+// the public key is provided, it must match the private key.
+func parseSigningKey(method SigningMethod, privPEM, pubPEM []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(privPEM)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing keys")
+	}
+	key := &SigningKey{Method: method}
+	switch m := method.(type) {
+	case *SigningMethodRSA, *SigningMethodRSAPSS:
+		priv, err := parseRSAPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.Private, key.Public = priv, &priv.PublicKey
+	case *SigningMethodECDSA:
+		priv, err := parseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if priv.Curve.Params().BitSize != m.CurveBits {
+			return nil, fmt.Errorf("%w: curve %s can not be used with %s", ErrInvalidKey, priv.Curve.Params().Name, m.Alg())
+		}
+		key.Private, key.Public = priv, &priv.PublicKey
+	case *SigningMethodEd25519:
+		priv, err := parseEdPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.Private, key.Public = priv, priv.Public()
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedMethod, method.Alg())
+	}
+	if len(pubPEM) == 0 {
+		return key, nil
+	}
+	block, _ = pem.Decode(pubPEM)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing keys")
+	}
+	pub, err := parsePublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if eq, ok := key.Public.(interface{ Equal(crypto.PublicKey) bool }); !ok || !eq.Equal(pub) {
+		return nil, ErrKeyMismatch
+	}
+	return key, nil
+}
+
+// writeSigningKey writes the key pair to the provided files, using the same
+// encoding as the Write*AsPEM functions.
+func writeSigningKey(key *SigningKey, privateKeyFile, publicKeyFile string) error {
+	var err error
+	switch k := key.Private.(type) {
+	case *rsa.PrivateKey:
+		err = WriteRSAPrivateKeyAsPEM(k, privateKeyFile)
+		if err == nil && publicKeyFile != "" {
+			err = WriteRSAPublicKeyAsPEM(&k.PublicKey, publicKeyFile)
+		}
+	case *ecdsa.PrivateKey:
+		err = WriteECDSAPrivateKeyAsPEM(k, privateKeyFile)
+		if err == nil && publicKeyFile != "" {
+			err = WriteECDSAPublicKeyAsPEM(&k.PublicKey, publicKeyFile)
+		}
+	case ed25519.PrivateKey:
+		err = WriteEd25519PrivateKeyAsPEM(k, privateKeyFile)
+		if err == nil && publicKeyFile != "" {
+			err = WriteEd25519PublicKeyAsPEM(k.Public().(ed25519.PublicKey), publicKeyFile)
+		}
+	default:
+		err = ErrInvalidKeyType
+	}
+	return err
+}
+
+// parseRSAPrivateKey parses a PKCS1 or PKCS8 encoded RSA private key.
+func parseRSAPrivateKey(der []byte) (*rsa.PrivateKey, error) {
+	key, err := x509.ParsePKCS1PrivateKey(der)
+	if err == nil {
+		return key, nil
+	}
+	parsedKey, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := parsedKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("key type is not RSA")
+	}
+	return rsaKey, nil
+}
+
+// parsePublicKey parses a PKIX encoded public key, or a PKCS1 encoded RSA
+// public key.
+func parsePublicKey(der []byte) (crypto.PublicKey, error) {
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err == nil {
+		return key, nil
+	}
+	rsaKey, rsaErr := x509.ParsePKCS1PublicKey(der)
+	if rsaErr != nil {
+		return nil, err
+	}
+	return rsaKey, nil
+}
+
+func methodCurve(m *SigningMethodECDSA) (elliptic.Curve, error) {
+	switch m.CurveBits {
+	case 256:
+		return elliptic.P256(), nil
+	case 384:
+		return elliptic.P384(), nil
+	case 521:
+		return elliptic.P521(), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedMethod, m.Alg())
+}
@@ -0,0 +1,119 @@
+package jwt
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJWTServiceAlgorithms(t *testing.T) {
+	for _, alg := range []string{"RS256", "PS384", "ES256", "ES512", "EdDSA"} {
+		t.Run(
+			alg, func(t *testing.T) {
+				s, err := NewJWTServiceWithConfig(&JWTServiceConfig{Algorithm: alg})
+				if err != nil {
+					t.Fatalf("[%v] Error creating service: %v", alg, err)
+				}
+				token, err := s.ValidateTokenString(s.GenerateSignedToken("admin", "ROLE_ADMIN"))
+				if err != nil {
+					t.Fatalf("[%v] Error while verifying token: %v", alg, err)
+				}
+				if token.Method.Alg() != alg {
+					t.Errorf("[%v] Incorrect signing method %v", alg, token.Method.Alg())
+				}
+				if err = s.RotateKey(AccessTokenTTL); err != nil {
+					t.Errorf("[%v] Error rotating key: %v", alg, err)
+				}
+			},
+		)
+	}
+	if _, err := NewJWTServiceWithConfig(&JWTServiceConfig{Algorithm: "HS256"}); !errors.Is(err, ErrUnsupportedMethod) {
+		t.Errorf("got %v, want %v", err, ErrUnsupportedMethod)
+	}
+	if _, err := NewJWTServiceWithConfig(&JWTServiceConfig{Algorithm: "none"}); err == nil {
+		t.Errorf("Unknown signing method was accepted")
+	}
+}
+
+func TestJWTServiceIndependent(t *testing.T) {
+	first, err := NewJWTServiceWithConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewJWTServiceWithConfig(&JWTServiceConfig{Keys: KeyPEM(string(sampleKey), "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = second.ValidateTokenString(first.GenerateSignedToken("admin", "ROLE_ADMIN")); err == nil {
+		t.Errorf("Token signed by another service passed validation")
+	}
+
+	// Same keys, but another audience
+	other, err := NewJWTServiceWithConfig(&JWTServiceConfig{Keys: KeyPEM(string(sampleKey), ""), Audience: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = other.ValidateTokenString(second.GenerateSignedToken("admin", "ROLE_ADMIN"))
+	if !errors.Is(err, ErrTokenInvalidAudience) {
+		t.Errorf("got %v, want %v", err, ErrTokenInvalidAudience)
+	}
+}
+
+func TestKeySources(t *testing.T) {
+	dir := t.TempDir()
+	privFile := filepath.Join(dir, "private_key.pem")
+	pubFile := filepath.Join(dir, "public_key.pem")
+
+	// The key files are only written when they do not exist yet
+	generated, err := KeyFilesOrGenerate(privFile, pubFile)(SigningMethodES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(privFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("Private key file mode %v, expecting %v", mode, os.FileMode(0600))
+	}
+	loaded, err := KeyFiles(privFile, pubFile)(SigningMethodES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := KeyFilesOrGenerate(privFile, pubFile)(SigningMethodES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk1, _ := NewJWK("", "ES256", generated.Public)
+	jwk2, _ := NewJWK("", "ES256", loaded.Public)
+	jwk3, _ := NewJWK("", "ES256", again.Public)
+	if jwk1.Kid != jwk2.Kid || jwk1.Kid != jwk3.Kid {
+		t.Errorf("Loaded key does not match the generated key")
+	}
+	if _, err = KeyFiles(privFile, "")(SigningMethodES384); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("got %v, want %v", err, ErrInvalidKey)
+	}
+	if _, err = KeyFiles(filepath.Join(dir, "missing.pem"), "")(SigningMethodES256); !os.IsNotExist(err) {
+		t.Errorf("got %v, want a not exist error", err)
+	}
+
+	// The public key must match the private key
+	priv, pub := GenerateRSAKeyPair(2048)
+	_, otherPub := GenerateRSAKeyPair(2048)
+	if _, err = KeyPEM(RSAPrivateKeyToString(priv), RSAPublicKeyToString(pub))(SigningMethodRS256); err != nil {
+		t.Errorf("Error parsing key pair: %v", err)
+	}
+	_, err = KeyPEM(RSAPrivateKeyToString(priv), RSAPublicKeyToString(otherPub))(SigningMethodRS256)
+	if err != ErrKeyMismatch {
+		t.Errorf("got %v, want %v", err, ErrKeyMismatch)
+	}
+
+	t.Setenv("TEST_JWT_PRIVATE_KEY", RSAPrivateKeyToString(priv))
+	if _, err = KeyEnv("TEST_JWT_PRIVATE_KEY", "")(SigningMethodPS256); err != nil {
+		t.Errorf("Error reading key from the environment: %v", err)
+	}
+	if _, err = KeyEnv("TEST_JWT_MISSING_KEY", "")(SigningMethodRS256); !errors.Is(err, ErrKeyNotInEnv) {
+		t.Errorf("got %v, want %v", err, ErrKeyNotInEnv)
+	}
+}
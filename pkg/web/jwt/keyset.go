@@ -0,0 +1,348 @@
+package jwt
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoSigningKey = errors.New("key set has no signing key")
+	ErrKeyNotFound  = errors.New("no key found for kid")
+)
+
+// SigningKey is a private key along with the id and signing method it is
+// used with.
+type SigningKey struct {
+	Kid     string
+	Method  SigningMethod
+	Private crypto.PrivateKey
+	Public  crypto.PublicKey
+}
+
+// verificationKey is a public key that tokens are verified against. A zero
+// expires value means the key does not expire.
+type verificationKey struct {
+	jwk     JWK
+	method  SigningMethod
+	public  crypto.PublicKey
+	expires time.Time
+}
+
+// KeyGenerator creates the next signing key when a KeySet is rotated.
+type KeyGenerator func() (*SigningKey, error)
+
+// KeySet holds a single signing key along with any number of verification
+// keys, each of which is identified by its kid. Every token signed by the set
+// carries the kid of the signing key in its header, so that the matching key
+// can be selected during verification. When the signing key is rotated, the
+// previous key remains available for verification until its overlap period
+// has passed, so tokens issued before the rotation stay valid.
+type KeySet struct {
+	mu      sync.RWMutex
+	signing *SigningKey
+	keys    map[string]*verificationKey
+	now     func() time.Time
+}
+
+func NewKeySet() *KeySet {
+	return &KeySet{
+		keys: make(map[string]*verificationKey),
+		now:  time.Now,
+	}
+}
+
+// SetSigningKey makes the provided key the signing key of the set, and adds
+// its public key to the verification keys. If the key has no kid, its RFC 7638
+// thumbprint is used. Any previous signing key remains a verification key
+// without an expiry.
+func (ks *KeySet) SetSigningKey(key *SigningKey) error {
+	jwk, err := NewJWK(key.Kid, key.Method.Alg(), key.Public)
+	if err != nil {
+		return err
+	}
+	sk := *key
+	sk.Kid = jwk.Kid
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.signing = &sk
+	ks.keys[jwk.Kid] = &verificationKey{
+		jwk:    jwk,
+		method: key.Method,
+		public: key.Public,
+	}
+	return nil
+}
+
+// AddVerificationKey adds a public key that tokens can be verified against.
+// A zero expires value means the key does not expire.
+func (ks *KeySet) AddVerificationKey(kid string, method SigningMethod, key crypto.PublicKey, expires time.Time) error {
+	jwk, err := NewJWK(kid, method.Alg(), key)
+	if err != nil {
+		return err
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys[jwk.Kid] = &verificationKey{
+		jwk:     jwk,
+		method:  method,
+		public:  key,
+		expires: expires,
+	}
+	return nil
+}
+
+// AddJWKSet adds every key in the set as a verification key.
+func (ks *KeySet) AddJWKSet(set *JWKSet) error {
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			return fmt.Errorf("kid %q: %w", jwk.Kid, err)
+		}
+		method, err := jwk.Method()
+		if err != nil {
+			return fmt.Errorf("kid %q: %w", jwk.Kid, err)
+		}
+		err = ks.AddVerificationKey(jwk.Kid, method, pub, time.Time{})
+		if err != nil {
+			return fmt.Errorf("kid %q: %w", jwk.Kid, err)
+		}
+	}
+	return nil
+}
+
+// Rotate replaces the signing key. The previous signing key can still be used
+// to verify tokens until the overlap period has passed, after which it is
+// removed from the set.
+func (ks *KeySet) Rotate(next *SigningKey, overlap time.Duration) error {
+	ks.mu.Lock()
+	if ks.signing != nil {
+		if prev, found := ks.keys[ks.signing.Kid]; found {
+			prev.expires = ks.now().Add(overlap)
+		}
+	}
+	ks.mu.Unlock()
+	err := ks.SetSigningKey(next)
+	if err != nil {
+		return err
+	}
+	ks.RemoveExpired()
+	return nil
+}
+
+// RotateEvery rotates the signing key using keys created by gen, once every
+// interval. Previous keys remain valid for verification for the duration of
+// the overlap, which should be at least as long as the lifetime of a token.
+// Calling the returned function stops the rotation.
+func (ks *KeySet) RotateEvery(interval, overlap time.Duration, gen KeyGenerator, errFn func(error)) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				key, err := gen()
+				if err == nil {
+					err = ks.Rotate(key, overlap)
+				}
+				if err != nil && errFn != nil {
+					errFn(err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(
+			func() {
+				ticker.Stop()
+				close(done)
+			},
+		)
+	}
+}
+
+// RemoveExpired removes every verification key whose overlap period has
+// passed. The signing key is never removed.
+func (ks *KeySet) RemoveExpired() {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	now := ks.now()
+	for kid, k := range ks.keys {
+		if ks.signing != nil && kid == ks.signing.Kid {
+			continue
+		}
+		if !k.expires.IsZero() && now.After(k.expires) {
+			delete(ks.keys, kid)
+		}
+	}
+}
+
+// SigningKey returns the current signing key.
+func (ks *KeySet) SigningKey() (*SigningKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if ks.signing == nil {
+		return nil, ErrNoSigningKey
+	}
+	return ks.signing, nil
+}
+
+// Sign signs the claims using the current signing key, stamping the kid of
+// the key into the token header.
+func (ks *KeySet) Sign(claims Claims) (string, error) {
+	key, err := ks.SigningKey()
+	if err != nil {
+		return "", err
+	}
+	token := NewTokenWithClaims(key.Method, claims)
+	token.Header["kid"] = key.Kid
+	return token.SignedString(key.Private)
+}
+
+// KeyFunc returns a KeyFunc that selects the verification key using the kid
+// in the token header. The signing method of the token must match the method
+// the key is used with. Tokens without a kid are verified against the current
+// signing key (or the only key of a verification only set), so tokens issued
+// before key ids were introduced remain valid.
+func (ks *KeySet) KeyFunc() KeyFunc {
+	return func(t *Token) (any, error) {
+		ks.mu.RLock()
+		defer ks.mu.RUnlock()
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			switch {
+			case ks.signing != nil:
+				kid = ks.signing.Kid
+			case len(ks.keys) == 1:
+				for k := range ks.keys {
+					kid = k
+				}
+			default:
+				return nil, ErrKeyNotFound
+			}
+		}
+		key, found := ks.keys[kid]
+		if !found || (!key.expires.IsZero() && ks.now().After(key.expires)) {
+			return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, kid)
+		}
+		if t.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return key.public, nil
+	}
+}
+
+// Algorithms returns the signing methods used by the verification keys.
+func (ks *KeySet) Algorithms() []string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	seen := make(map[string]bool)
+	var algs []string
+	for _, k := range ks.keys {
+		if alg := k.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	sort.Strings(algs)
+	return algs
+}
+
+// JWKSet returns the public verification keys as a JSON Web Key Set.
+func (ks *KeySet) JWKSet() *JWKSet {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	set := &JWKSet{Keys: make([]JWK, 0, len(ks.keys))}
+	now := ks.now()
+	for _, k := range ks.keys {
+		if !k.expires.IsZero() && now.After(k.expires) {
+			continue
+		}
+		set.Keys = append(set.Keys, k.jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// ServeHTTP publishes the verification keys as a JSON Web Key Set. It is
+// meant to be mounted at "/.well-known/jwks.json".
+func (ks *KeySet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_ = json.NewEncoder(w).Encode(ks.JWKSet())
+}
+
+// ReadJWKSet reads a JSON Web Key Set from the provided reader.
+func ReadJWKSet(r io.Reader) (*JWKSet, error) {
+	set := new(JWKSet)
+	err := json.NewDecoder(r).Decode(set)
+	if err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// LoadJWKS returns a verification only KeySet, holding the keys found in
+// the JSON Web Key Set file at the provided path.
+func LoadJWKS(file string) (*KeySet, error) {
+	fp, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	set, err := ReadJWKSet(fp)
+	if err != nil {
+		return nil, err
+	}
+	ks := NewKeySet()
+	err = ks.AddJWKSet(set)
+	if err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// FetchJWKS returns a verification only KeySet, holding the keys published
+// at the provided URL. If client is nil, a client with a ten second timeout
+// is used.
+func FetchJWKS(url string, client *http.Client) (*KeySet, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching jwks: unexpected status %q", resp.Status)
+	}
+	set, err := ReadJWKSet(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	ks := NewKeySet()
+	err = ks.AddJWKSet(set)
+	if err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
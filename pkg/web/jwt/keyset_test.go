@@ -0,0 +1,207 @@
+package jwt
+
+import (
+	"crypto/elliptic"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJWKThumbprint(t *testing.T) {
+	// Example from RFC 7638, section 3.1
+	jwk := JWK{
+		Kty: "RSA",
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMs" +
+			"tn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91" +
+			"CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E: "AQAB",
+	}
+	got, err := jwk.Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("Incorrect thumbprint.\nwas:\n%v\nexpecting:\n%v", got, want)
+	}
+}
+
+func TestJWKRoundTrip(t *testing.T) {
+	rsaKey, _ := RSAPrivateKeyFromString(string(sampleKey))
+	ecKey, _ := GenerateECDSAKeyPair(elliptic.P384())
+	edKey, edPub := GenerateEd25519KeyPair()
+
+	tests := []struct {
+		name   string
+		method SigningMethod
+		key    any
+		pub    any
+	}{
+		{"RSA", SigningMethodRS256, rsaKey, &rsaKey.PublicKey},
+		{"EC", SigningMethodES384, ecKey, &ecKey.PublicKey},
+		{"OKP", SigningMethodEdDSA, edKey, edPub},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				jwk, err := NewJWK("", tt.method.Alg(), tt.pub)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if jwk.Kid == "" {
+					t.Fatalf("kid was not set to the thumbprint")
+				}
+				pub, err := jwk.PublicKey()
+				if err != nil {
+					t.Fatal(err)
+				}
+				method, err := jwk.Method()
+				if err != nil || method != tt.method {
+					t.Fatalf("got method %v (%v), want %v", method, err, tt.method)
+				}
+				sig, err := tt.method.Sign("foo.bar", tt.key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = tt.method.Verify("foo.bar", sig, pub); err != nil {
+					t.Fatalf("decoded key failed verification: %v", err)
+				}
+			},
+		)
+	}
+}
+
+func newTestKeySet(t *testing.T) *KeySet {
+	ks := NewKeySet()
+	priv, pub := GenerateECDSAKeyPair(elliptic.P256())
+	err := ks.SetSigningKey(&SigningKey{Method: SigningMethodES256, Private: priv, Public: pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func TestKeySetRotation(t *testing.T) {
+	ks := newTestKeySet(t)
+	now := time.Now()
+	ks.now = func() time.Time { return now }
+
+	before, err := ks.Sign(MapClaims{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := ks.SigningKey()
+
+	priv, pub := GenerateEd25519KeyPair()
+	err = ks.Rotate(&SigningKey{Kid: "next", Method: SigningMethodEdDSA, Private: priv, Public: pub}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := ks.Sign(MapClaims{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parser := NewParser(WithValidMethods(ks.Algorithms()))
+	for _, str := range []string{before, after} {
+		token, err := parser.Parse(str, ks.KeyFunc())
+		if err != nil {
+			t.Fatalf("token failed verification during the overlap: %v", err)
+		}
+		if token.Header["kid"] == nil {
+			t.Fatalf("token is missing the kid header")
+		}
+	}
+	if len(ks.JWKSet().Keys) != 2 {
+		t.Fatalf("expected both keys to be published during the overlap")
+	}
+
+	// once the overlap has passed, the previous key is gone
+	ks.now = func() time.Time { return now.Add(2 * time.Hour) }
+	ks.RemoveExpired()
+	if _, err = parser.Parse(before, ks.KeyFunc()); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v, want %v", err, ErrKeyNotFound)
+	}
+	if _, found := ks.JWKSet().Key(first.Kid); found {
+		t.Fatalf("expired key is still published")
+	}
+	if _, err = parser.Parse(after, ks.KeyFunc()); err != nil {
+		t.Fatalf("current key failed verification: %v", err)
+	}
+}
+
+func TestKeySetRejectsMismatchedAlg(t *testing.T) {
+	ks := newTestKeySet(t)
+	key, _ := ks.SigningKey()
+	token := NewTokenWithClaims(SigningMethodES384, MapClaims{"foo": "bar"})
+	token.Header["kid"] = key.Kid
+	priv, _ := GenerateECDSAKeyPair(elliptic.P384())
+	str, err := token.SignedString(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewParser().Parse(str, ks.KeyFunc()); err == nil {
+		t.Fatalf("token using a different alg than its key passed verification")
+	}
+}
+
+func TestKeySetServeAndFetch(t *testing.T) {
+	ks := newTestKeySet(t)
+	signed, err := ks.Sign(MapClaims{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(ks)
+	defer srv.Close()
+
+	fetched, err := FetchJWKS(srv.URL+"/.well-known/jwks.json", srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewParser(WithValidMethods(fetched.Algorithms())).Parse(signed, fetched.KeyFunc()); err != nil {
+		t.Fatalf("token failed verification against fetched key set: %v", err)
+	}
+
+	// the same document loaded from disk
+	b, err := json.Marshal(ks.JWKSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err = os.WriteFile(file, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadJWKS(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewParser().Parse(signed, loaded.KeyFunc()); err != nil {
+		t.Fatalf("token failed verification against loaded key set: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/.well-known/jwks.json", nil)
+	w := httptest.NewRecorder()
+	ks.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestJWTService_RotateKey(t *testing.T) {
+	s := newTestService(t)
+	before := s.GenerateSignedToken("admin", "ROLE_ADMIN")
+	if err := s.RotateKey(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	after := s.GenerateSignedToken("admin", "ROLE_ADMIN")
+	for _, str := range []string{before, after} {
+		if _, err := s.ValidateTokenString(str); err != nil {
+			t.Fatalf("token failed verification after rotation: %v", err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+)
+
+var (
+	ErrNotECPublicKey  = errors.New("key is not a valid ECDSA public key")
+	ErrNotECPrivateKey = errors.New("key is not a valid ECDSA private key")
+)
+
+// GenerateECDSAKeyPair generates a new key pair using the provided curve. Use
+// elliptic.P256(), elliptic.P384() or elliptic.P521() for ES256, ES384 and
+// ES512 respectively.
+func GenerateECDSAKeyPair(curve elliptic.Curve) (*ecdsa.PrivateKey, *ecdsa.PublicKey) {
+	priv, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return priv, &priv.PublicKey
+}
+
+func ECDSAPrivateKeyToString(key *ecdsa.PrivateKey) string {
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		panic(err)
+	}
+	block := &pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: keyBytes,
+	}
+	return string(pem.EncodeToMemory(block))
+}
+
+func ECDSAPublicKeyToString(key *ecdsa.PublicKey) string {
+	keyBytes, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		panic(err)
+	}
+	block := &pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: keyBytes,
+	}
+	return string(pem.EncodeToMemory(block))
+}
+
+func WriteECDSAPrivateKeyAsPEM(key *ecdsa.PrivateKey, file string) error {
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	block := &pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: keyBytes,
+	}
+	err = WritePrivatePEM(block, file)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func WriteECDSAPublicKeyAsPEM(key *ecdsa.PublicKey, file string) error {
+	keyBytes, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return err
+	}
+	block := &pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: keyBytes,
+	}
+	err = WritePEM(block, file)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func ReadECDSAPrivateKeyFromPEM(file string) (*ecdsa.PrivateKey, error) {
+	block, err := ReadPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseECPrivateKey(block.Bytes)
+}
+
+func ReadECDSAPublicKeyFromPEM(file string) (*ecdsa.PublicKey, error) {
+	block, err := ReadPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseECPublicKey(block.Bytes)
+}
+
+// ParseECPrivateKeyFromPEM parses a PEM encoded SEC1 or PKCS8 private key
+func ParseECPrivateKeyFromPEM(key []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing keys")
+	}
+	return parseECPrivateKey(block.Bytes)
+}
+
+// ParseECPublicKeyFromPEM parses a PEM encoded PKIX public key or certificate
+func ParseECPublicKeyFromPEM(key []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing keys")
+	}
+	return parseECPublicKey(block.Bytes)
+}
+
+func parseECPrivateKey(der []byte) (*ecdsa.PrivateKey, error) {
+	key, err := x509.ParseECPrivateKey(der)
+	if err == nil {
+		return key, nil
+	}
+	parsedKey, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := parsedKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, ErrNotECPrivateKey
+	}
+	return ecKey, nil
+}
+
+func parseECPublicKey(der []byte) (*ecdsa.PublicKey, error) {
+	parsedKey, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		cert, cerr := x509.ParseCertificate(der)
+		if cerr != nil {
+			return nil, err
+		}
+		parsedKey = cert.PublicKey
+	}
+	ecKey, ok := parsedKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, ErrNotECPublicKey
+	}
+	return ecKey, nil
+}
@@ -0,0 +1,136 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+)
+
+var (
+	ErrNotEdPrivateKey = errors.New("key is not a valid Ed25519 private key")
+	ErrNotEdPublicKey  = errors.New("key is not a valid Ed25519 public key")
+)
+
+func GenerateEd25519KeyPair() (ed25519.PrivateKey, ed25519.PublicKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return priv, pub
+}
+
+func Ed25519PrivateKeyToString(key ed25519.PrivateKey) string {
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		panic(err)
+	}
+	block := &pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: keyBytes,
+	}
+	return string(pem.EncodeToMemory(block))
+}
+
+func Ed25519PublicKeyToString(key ed25519.PublicKey) string {
+	keyBytes, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		panic(err)
+	}
+	block := &pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: keyBytes,
+	}
+	return string(pem.EncodeToMemory(block))
+}
+
+func WriteEd25519PrivateKeyAsPEM(key ed25519.PrivateKey, file string) error {
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	block := &pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: keyBytes,
+	}
+	err = WritePrivatePEM(block, file)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func WriteEd25519PublicKeyAsPEM(key ed25519.PublicKey, file string) error {
+	keyBytes, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return err
+	}
+	block := &pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: keyBytes,
+	}
+	err = WritePEM(block, file)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func ReadEd25519PrivateKeyFromPEM(file string) (ed25519.PrivateKey, error) {
+	block, err := ReadPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseEdPrivateKey(block.Bytes)
+}
+
+func ReadEd25519PublicKeyFromPEM(file string) (ed25519.PublicKey, error) {
+	block, err := ReadPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseEdPublicKey(block.Bytes)
+}
+
+// ParseEdPrivateKeyFromPEM parses a PEM encoded PKCS8 Ed25519 private key
+func ParseEdPrivateKeyFromPEM(key []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing keys")
+	}
+	return parseEdPrivateKey(block.Bytes)
+}
+
+// ParseEdPublicKeyFromPEM parses a PEM encoded PKIX Ed25519 public key
+func ParseEdPublicKeyFromPEM(key []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing keys")
+	}
+	return parseEdPublicKey(block.Bytes)
+}
+
+func parseEdPrivateKey(der []byte) (ed25519.PrivateKey, error) {
+	parsedKey, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := parsedKey.(ed25519.PrivateKey)
+	if !ok {
+		return nil, ErrNotEdPrivateKey
+	}
+	return edKey, nil
+}
+
+func parseEdPublicKey(der []byte) (ed25519.PublicKey, error) {
+	parsedKey, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := parsedKey.(ed25519.PublicKey)
+	if !ok {
+		return nil, ErrNotEdPublicKey
+	}
+	return edKey, nil
+}
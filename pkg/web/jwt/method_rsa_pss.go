@@ -0,0 +1,145 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+)
+
+// SigningMethodRSAPSS implements the RSAPSS family of signing methods.
+// Expects *rsa.PrivateKey for signing and *rsa.PublicKey for validation
+type SigningMethodRSAPSS struct {
+	*SigningMethodRSA
+	Options *rsa.PSSOptions
+
+	// VerifyOptions is optional. If set, it overrides Options for
+	// rsa.VerifyPSS. Used to accept tokens signed with
+	// rsa.PSSSaltLengthAuto, which doesn't comply with RFC 7518 but is
+	// what some other libraries produce.
+	VerifyOptions *rsa.PSSOptions
+}
+
+// Specific instances for RS/PS and company.
+var (
+	SigningMethodPS256 *SigningMethodRSAPSS
+	SigningMethodPS384 *SigningMethodRSAPSS
+	SigningMethodPS512 *SigningMethodRSAPSS
+)
+
+func init() {
+	// PS256
+	SigningMethodPS256 = newSigningMethodRSAPSS("PS256", crypto.SHA256)
+	RegisterSigningMethod(
+		SigningMethodPS256.Alg(), func() SigningMethod {
+			return SigningMethodPS256
+		},
+	)
+
+	// PS384
+	SigningMethodPS384 = newSigningMethodRSAPSS("PS384", crypto.SHA384)
+	RegisterSigningMethod(
+		SigningMethodPS384.Alg(), func() SigningMethod {
+			return SigningMethodPS384
+		},
+	)
+
+	// PS512
+	SigningMethodPS512 = newSigningMethodRSAPSS("PS512", crypto.SHA512)
+	RegisterSigningMethod(
+		SigningMethodPS512.Alg(), func() SigningMethod {
+			return SigningMethodPS512
+		},
+	)
+}
+
+// newSigningMethodRSAPSS returns a signing method that signs using a salt
+// length equal to the hash size, as required by RFC 7518, section 3.5, and
+// that verifies using automatic salt length detection, so tokens produced by
+// other libraries can still be verified.
+func newSigningMethodRSAPSS(name string, hash crypto.Hash) *SigningMethodRSAPSS {
+	return &SigningMethodRSAPSS{
+		SigningMethodRSA: &SigningMethodRSA{
+			Name: name,
+			Hash: hash,
+		},
+		Options: &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthEqualsHash,
+		},
+		VerifyOptions: &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthAuto,
+		},
+	}
+}
+
+// Strict returns a copy of the signing method that only verifies signatures
+// using a salt length equal to the hash size, rejecting any other salt
+// length. It can be registered in place of the default method using
+// RegisterSigningMethod.
+func (m *SigningMethodRSAPSS) Strict() *SigningMethodRSAPSS {
+	return &SigningMethodRSAPSS{
+		SigningMethodRSA: m.SigningMethodRSA,
+		Options:          m.Options,
+	}
+}
+
+// Verify implements token verification for the SigningMethod.
+// For this verify method, key must be an rsa.PublicKey struct
+func (m *SigningMethodRSAPSS) Verify(signingString, signature string, key interface{}) error {
+	var err error
+
+	// Decode the signature
+	var sig []byte
+	if sig, err = decodeSegment(signature); err != nil {
+		return err
+	}
+
+	var rsaKey *rsa.PublicKey
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		rsaKey = k
+	default:
+		return ErrInvalidKey
+	}
+
+	// Create hasher
+	if !m.Hash.Available() {
+		return ErrHashUnavailable
+	}
+	hasher := m.Hash.New()
+	hasher.Write([]byte(signingString))
+
+	opts := m.Options
+	if m.VerifyOptions != nil {
+		opts = m.VerifyOptions
+	}
+
+	return rsa.VerifyPSS(rsaKey, m.Hash, hasher.Sum(nil), sig, opts)
+}
+
+// Sign implements token signing for the SigningMethod.
+// For this signing method, key must be an rsa.PrivateKey struct
+func (m *SigningMethodRSAPSS) Sign(signingString string, key interface{}) (string, error) {
+	var rsaKey *rsa.PrivateKey
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		rsaKey = k
+	default:
+		return "", ErrInvalidKeyType
+	}
+
+	// Create the hasher
+	if !m.Hash.Available() {
+		return "", ErrHashUnavailable
+	}
+
+	hasher := m.Hash.New()
+	hasher.Write([]byte(signingString))
+
+	// Sign the string and return the encoded bytes
+	if sigBytes, err := rsa.SignPSS(rand.Reader, rsaKey, m.Hash, hasher.Sum(nil), m.Options); err == nil {
+		return encodeSegment(sigBytes), nil
+	} else {
+		return "", err
+	}
+}
@@ -1,10 +1,13 @@
 package jwt
 
 import (
+	"crypto/elliptic"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -310,3 +313,39 @@ func ParseRSAPublicKeyFromPEM(key []byte) (*rsa.PublicKey, error) {
 
 	return pkey, nil
 }
+
+func TestWritePrivateKeyMode(t *testing.T) {
+	rsaKey, _ := GenerateRSAKeyPair(2048)
+	ecKey, _ := GenerateECDSAKeyPair(elliptic.P256())
+	edKey, _ := GenerateEd25519KeyPair()
+
+	tests := []struct {
+		name  string
+		write func(file string) error
+	}{
+		{"rsa", func(file string) error { return WriteRSAPrivateKeyAsPEM(rsaKey, file) }},
+		{"ecdsa", func(file string) error { return WriteECDSAPrivateKeyAsPEM(ecKey, file) }},
+		{"ed25519", func(file string) error { return WriteEd25519PrivateKeyAsPEM(edKey, file) }},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				file := filepath.Join(t.TempDir(), "private_key.pem")
+				// an existing file is tightened as well
+				if err := os.WriteFile(file, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := tt.write(file); err != nil {
+					t.Fatalf("[%v] Unexpected error: %v", tt.name, err)
+				}
+				info, err := os.Stat(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if mode := info.Mode().Perm(); mode != 0600 {
+					t.Errorf("[%v] Mode %v, expecting %v", tt.name, mode, os.FileMode(0600))
+				}
+			},
+		)
+	}
+}
@@ -98,7 +98,7 @@ func WriteRSAPrivateKeyAsPEM(key *rsa.PrivateKey, file string) error {
 		Type:  "RSA PRIVATE KEY",
 		Bytes: keyBytes,
 	}
-	err := WritePEM(block, file)
+	err := WritePrivatePEM(block, file)
 	if err != nil {
 		return err
 	}
@@ -140,13 +140,30 @@ func ReadPEM(file string) (*pem.Block, error) {
 
 func WritePEM(block *pem.Block, file string) error {
 	pemBytes := pem.EncodeToMemory(block)
-	err := os.WriteFile(file, pemBytes, 0655)
+	err := os.WriteFile(file, pemBytes, 0644)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// WritePrivatePEM is like WritePEM, but only the owner may read the file.
+// The permissions of an existing file are tightened as well.
+func WritePrivatePEM(block *pem.Block, file string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = f.Chmod(0600)
+	if err == nil {
+		err = pem.Encode(f, block)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
 func RSAPublicKeyToString(key *rsa.PublicKey) string {
 	keyBytes := x509.MarshalPKCS1PublicKey(key)
 	block := &pem.Block{
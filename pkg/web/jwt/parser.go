@@ -3,34 +3,66 @@ package jwt
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
 	"strings"
+	"time"
 )
 
-type ParserOptions struct {
-	ValidMethods         []string
-	UseJSONNumber        bool
-	SkipClaimsValidation bool
-}
-
 type Parser struct {
 	validMethods         []string
 	useJSONNumber        bool
 	skipClaimsValidation bool
+	issuer               string
+	audience             string
+	requiredClaims       []string
+	leeway               time.Duration
+	timeFunc             func() time.Time
+	maxAge               time.Duration
+	revocations          RevocationStore
+	decryptionKey        any
 }
 
-func NewParser(validMethods []string, useJSONNumber, skipClaimsValidation bool) *Parser {
+// NewParser returns a Parser configured using the provided options.
+func NewParser(options ...ParserOption) *Parser {
 	p := new(Parser)
-	if validMethods != nil && len(validMethods) > 0 {
-		p.validMethods = validMethods
+	for _, option := range options {
+		option(p)
 	}
-	if useJSONNumber {
-		p.useJSONNumber = true
+	return p
+}
+
+// ParseTyped parses, validates and verifies the token in the same way as
+// Parse, and returns its claims decoded into a new value of type C. C is
+// usually a pointer to a struct that embeds RegisteredClaims.
+//
+//	claims, err := ParseTyped[*UserClaims](token, keyFn, WithIssuer("api"))
+func ParseTyped[C Claims](token string, keyFn KeyFunc, options ...ParserOption) (C, error) {
+	var claims C
+	typ := reflect.TypeOf(&claims).Elem()
+	switch typ.Kind() {
+	case reflect.Pointer:
+		claims = reflect.New(typ.Elem()).Interface().(C)
+	case reflect.Map:
+		claims = reflect.MakeMap(typ).Interface().(C)
+	case reflect.Struct:
+		// Decode into a pointer, as the decoder can not set a struct held
+		// by an interface
+		ptr := reflect.New(typ)
+		_, err := NewParser(options...).ParseWithClaims(token, ptr.Interface().(Claims), keyFn)
+		if err != nil {
+			var zero C
+			return zero, err
+		}
+		return ptr.Elem().Interface().(C), nil
 	}
-	if skipClaimsValidation {
-		p.skipClaimsValidation = true
+	_, err := NewParser(options...).ParseWithClaims(token, claims, keyFn)
+	if err != nil {
+		var zero C
+		return zero, err
 	}
-	return p
+	return claims, nil
 }
 
 func (p *Parser) Parse(token string, keyFn KeyFunc) (*Token, error) {
@@ -39,6 +71,18 @@ func (p *Parser) Parse(token string, keyFn KeyFunc) (*Token, error) {
 
 func (p *Parser) ParseWithClaims(token string, claims Claims, keyFn KeyFunc) (*Token, error) {
 
+	// Decrypt the token first, if it is encrypted
+	if p.decryptionKey != nil && strings.Count(token, ".") == 4 {
+		inner, err := decryptNested(token, p.decryptionKey)
+		if err != nil {
+			if errors.Is(err, ErrJWEMalformed) {
+				return nil, &ValidationError{Inner: err, Errors: ValidationErrorMalformed}
+			}
+			return nil, &ValidationError{Inner: err, Errors: ValidationErrorUnverifiable}
+		}
+		token = inner
+	}
+
 	jwt, parts, err := p.ParseUnverified(token, claims)
 	if err != nil {
 		return jwt, err
@@ -83,15 +127,17 @@ func (p *Parser) ParseWithClaims(token string, claims Claims, keyFn KeyFunc) (*T
 
 	// Validate Claims
 	if !p.skipClaimsValidation {
-		err = jwt.Claims.Valid()
-		if err != nil {
-			// If the Claims Valid returned an error, check if it is a validation error,
-			// If it was another error type, create a ValidationError with a generic ClaimsInvalid flag set
-			e, ok := err.(*ValidationError)
-			if !ok {
-				vErr = &ValidationError{Inner: err, Errors: ValidationErrorClaimsInvalid}
-			} else {
-				vErr = e
+		vErr = p.validateClaims(jwt.Claims, parts[1])
+		if p.revocations != nil {
+			revoked, err := checkRevoked(p.revocations, parts[1])
+			if err != nil {
+				vErr.Inner = err
+				vErr.Errors |= ValidationErrorClaimsInvalid
+			} else if revoked {
+				if vErr.Inner == nil {
+					vErr.Inner = ErrTokenRevoked
+				}
+				vErr.Errors |= ValidationErrorRevoked
 			}
 		}
 	}
@@ -110,6 +156,109 @@ func (p *Parser) ParseWithClaims(token string, claims Claims, keyFn KeyFunc) (*T
 	return jwt, vErr
 }
 
+// validateClaims validates the claims of a token. Unless a leeway or a time
+// function is configured, the time based claims are validated by the Valid
+// method of the claims, so custom claims keep their own validation.
+func (p *Parser) validateClaims(claims Claims, seg string) *ValidationError {
+	vErr := new(ValidationError)
+	if p.leeway == 0 && p.timeFunc == nil {
+		err := claims.Valid()
+		if err != nil {
+			// If the Claims Valid returned an error, check if it is a validation error,
+			// If it was another error type, create a ValidationError with a generic ClaimsInvalid flag set
+			e, ok := err.(*ValidationError)
+			if !ok {
+				vErr = &ValidationError{Inner: err, Errors: ValidationErrorClaimsInvalid}
+			} else {
+				vErr = e
+			}
+		}
+	} else {
+		now := p.now()
+		if !claims.VerifyExpiresAt(now.Add(-p.leeway).Unix(), false) {
+			vErr.Inner = ErrTokenExpired
+			vErr.Errors |= ValidationErrorExpired
+		}
+		if !claims.VerifyIssuedAt(now.Add(p.leeway).Unix(), false) {
+			vErr.Inner = ErrTokenUsedBeforeIssued
+			vErr.Errors |= ValidationErrorIssuedAt
+		}
+		if !claims.VerifyNotBefore(now.Add(p.leeway).Unix(), false) {
+			vErr.Inner = ErrTokenNotValidYet
+			vErr.Errors |= ValidationErrorNotValidYet
+		}
+	}
+	if p.issuer != "" && !claims.VerifyIssuer(p.issuer, true) {
+		vErr.Inner = ErrTokenInvalidIssuer
+		vErr.Errors |= ValidationErrorIssuer
+	}
+	if p.audience != "" && !claims.VerifyAudience(p.audience, true) {
+		vErr.Inner = ErrTokenInvalidAudience
+		vErr.Errors |= ValidationErrorAudience
+	}
+	if len(p.requiredClaims) == 0 && p.maxAge == 0 {
+		return vErr
+	}
+
+	// The presence of claims can not be checked using the Claims interface,
+	// so the claims segment is decoded again
+	var raw map[string]json.RawMessage
+	b, err := decodeSegment(seg)
+	if err == nil {
+		err = json.Unmarshal(b, &raw)
+	}
+	if err != nil {
+		vErr.Inner = err
+		vErr.Errors |= ValidationErrorClaimsInvalid
+		return vErr
+	}
+	for _, name := range p.requiredClaims {
+		if _, found := raw[name]; !found {
+			vErr.Inner = fmt.Errorf("%w: %q", ErrTokenRequiredClaim, name)
+			vErr.Errors |= requiredClaimFlag(name)
+		}
+	}
+	if p.maxAge > 0 {
+		var iat NumericDate
+		v, found := raw["iat"]
+		if !found || json.Unmarshal(v, &iat) != nil {
+			vErr.Inner = fmt.Errorf("%w: %q", ErrTokenRequiredClaim, "iat")
+			vErr.Errors |= ValidationErrorIssuedAt
+		} else if age := p.now().Sub(iat.Time); age > p.maxAge+p.leeway {
+			vErr.Inner = fmt.Errorf("%s: exceeds max age by %s", ErrTokenExpired, age-p.maxAge)
+			vErr.Errors |= ValidationErrorExpired
+		}
+	}
+	return vErr
+}
+
+// requiredClaimFlag returns the flag that is set when the named claim is
+// required, but missing.
+func requiredClaimFlag(name string) uint32 {
+	switch name {
+	case "aud":
+		return ValidationErrorAudience
+	case "exp":
+		return ValidationErrorExpired
+	case "iat":
+		return ValidationErrorIssuedAt
+	case "iss":
+		return ValidationErrorIssuer
+	case "nbf":
+		return ValidationErrorNotValidYet
+	case "jti":
+		return ValidationErrorId
+	}
+	return ValidationErrorClaimsInvalid
+}
+
+func (p *Parser) now() time.Time {
+	if p.timeFunc != nil {
+		return p.timeFunc()
+	}
+	return time.Now()
+}
+
 func (p *Parser) ParseUnverified(token string, claims Claims) (*Token, []string, error) {
 	parts := strings.Split(token, ".")
 	if len(parts) != 3 {
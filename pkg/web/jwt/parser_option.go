@@ -0,0 +1,105 @@
+package jwt
+
+import "time"
+
+// ParserOption is used to configure a Parser. Options are passed to NewParser,
+// Parse, ParseWithClaims and ParseTyped.
+type ParserOption func(*Parser)
+
+// WithValidMethods restricts the signing methods (the "alg" header) that are
+// accepted. Tokens signed using any other method fail with
+// ValidationErrorSignatureInvalid. Setting this option is strongly
+// encouraged.
+func WithValidMethods(methods []string) ParserOption {
+	return func(p *Parser) {
+		if len(methods) > 0 {
+			p.validMethods = methods
+		}
+	}
+}
+
+// WithJSONNumber decodes numbers in MapClaims as json.Number instead of
+// float64.
+func WithJSONNumber() ParserOption {
+	return func(p *Parser) {
+		p.useJSONNumber = true
+	}
+}
+
+// WithoutClaimsValidation disables claims validation, so only the signature
+// of the token is verified.
+func WithoutClaimsValidation() ParserOption {
+	return func(p *Parser) {
+		p.skipClaimsValidation = true
+	}
+}
+
+// WithIssuer requires the "iss" claim to match the provided issuer. Tokens
+// without an issuer, or with another issuer, fail with
+// ValidationErrorIssuer.
+func WithIssuer(issuer string) ParserOption {
+	return func(p *Parser) {
+		p.issuer = issuer
+	}
+}
+
+// WithAudience requires the "aud" claim to contain the provided audience.
+// Tokens without an audience, or meant for another audience, fail with
+// ValidationErrorAudience.
+func WithAudience(audience string) ParserOption {
+	return func(p *Parser) {
+		p.audience = audience
+	}
+}
+
+// WithRequiredClaims requires the named claims to be present in the token.
+// A missing registered claim fails with its matching flag (for instance
+// ValidationErrorExpired for "exp"), any other missing claim fails with
+// ValidationErrorClaimsInvalid.
+func WithRequiredClaims(names ...string) ParserOption {
+	return func(p *Parser) {
+		p.requiredClaims = append(p.requiredClaims, names...)
+	}
+}
+
+// WithLeeway allows for clock skew between the issuer and the parser when
+// validating the "exp", "nbf" and "iat" claims.
+func WithLeeway(leeway time.Duration) ParserOption {
+	return func(p *Parser) {
+		p.leeway = leeway
+	}
+}
+
+// WithTimeFunc sets the function used to get the current time when
+// validating the time based claims. It is mostly useful in tests.
+func WithTimeFunc(fn func() time.Time) ParserOption {
+	return func(p *Parser) {
+		p.timeFunc = fn
+	}
+}
+
+// WithMaxAge rejects tokens that were issued longer ago than the provided
+// duration, regardless of their "exp" claim. It requires the "iat" claim to
+// be present. Tokens that are too old fail with ValidationErrorExpired.
+func WithMaxAge(age time.Duration) ParserOption {
+	return func(p *Parser) {
+		p.maxAge = age
+	}
+}
+
+// WithRevocations sets the RevocationStore that is consulted during claims
+// validation. Tokens found in the store fail with ValidationErrorRevoked.
+func WithRevocations(rs RevocationStore) ParserOption {
+	return func(p *Parser) {
+		p.revocations = rs
+	}
+}
+
+// WithDecryptionKey sets the key used to decrypt encrypted tokens. When set,
+// a token using the JWE compact serialization is decrypted, and the signed
+// token nested inside it is verified as usual. See Decrypt for the key types.
+func WithDecryptionKey(key any) ParserOption {
+	return func(p *Parser) {
+		p.decryptionKey = key
+	}
+}
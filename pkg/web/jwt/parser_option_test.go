@@ -0,0 +1,173 @@
+package jwt
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var optionTestKey = []byte("parser-option-test-key")
+
+func optionTestKeyFunc(t *Token) (any, error) { return optionTestKey, nil }
+
+func signOptionTestToken(t *testing.T, claims Claims) string {
+	str, err := NewTokenWithClaims(SigningMethodHS256, claims).SignedString(optionTestKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return str
+}
+
+func TestParserOptions(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	timeFn := func() time.Time { return now }
+	claims := MapClaims{
+		"iss": "issuer",
+		"aud": []string{"api", "web"},
+		"sub": "admin",
+		"iat": now.Add(-10 * time.Minute).Unix(),
+		"exp": now.Add(-10 * time.Second).Unix(),
+	}
+	tests := []struct {
+		name    string
+		claims  MapClaims
+		options []ParserOption
+		errors  uint32
+		err     error
+	}{
+		{"expired", claims, []ParserOption{WithTimeFunc(timeFn)}, ValidationErrorExpired, ErrTokenExpired},
+		{"leeway", claims, []ParserOption{WithTimeFunc(timeFn), WithLeeway(time.Minute)}, 0, nil},
+		{
+			"not valid yet", MapClaims{"nbf": now.Add(20 * time.Second).Unix()},
+			[]ParserOption{WithTimeFunc(timeFn)}, ValidationErrorNotValidYet, ErrTokenNotValidYet,
+		},
+		{
+			"not valid yet leeway", MapClaims{"nbf": now.Add(20 * time.Second).Unix()},
+			[]ParserOption{WithTimeFunc(timeFn), WithLeeway(time.Minute)}, 0, nil,
+		},
+		{
+			"issuer", claims,
+			[]ParserOption{WithTimeFunc(timeFn), WithLeeway(time.Minute), WithIssuer("issuer")}, 0, nil,
+		},
+		{
+			"invalid issuer", claims,
+			[]ParserOption{WithTimeFunc(timeFn), WithLeeway(time.Minute), WithIssuer("other")},
+			ValidationErrorIssuer, ErrTokenInvalidIssuer,
+		},
+		{
+			"audience", claims,
+			[]ParserOption{WithTimeFunc(timeFn), WithLeeway(time.Minute), WithAudience("web")}, 0, nil,
+		},
+		{
+			"invalid audience", claims,
+			[]ParserOption{WithTimeFunc(timeFn), WithLeeway(time.Minute), WithAudience("other")},
+			ValidationErrorAudience, ErrTokenInvalidAudience,
+		},
+		{
+			"missing audience", MapClaims{"sub": "admin"},
+			[]ParserOption{WithAudience("api")}, ValidationErrorAudience, ErrTokenInvalidAudience,
+		},
+		{
+			"required claims", claims,
+			[]ParserOption{WithTimeFunc(timeFn), WithLeeway(time.Minute), WithRequiredClaims("sub", "exp")}, 0, nil,
+		},
+		{
+			"missing exp", MapClaims{"sub": "admin"},
+			[]ParserOption{WithRequiredClaims("sub", "exp")}, ValidationErrorExpired, ErrTokenExpired,
+		},
+		{
+			"missing custom claim", MapClaims{"sub": "admin"},
+			[]ParserOption{WithRequiredClaims("role")}, ValidationErrorClaimsInvalid, ErrTokenRequiredClaim,
+		},
+		{
+			"max age", claims,
+			[]ParserOption{WithTimeFunc(timeFn), WithLeeway(time.Minute), WithMaxAge(time.Hour)}, 0, nil,
+		},
+		{
+			"exceeds max age", claims,
+			[]ParserOption{WithTimeFunc(timeFn), WithLeeway(time.Minute), WithMaxAge(5 * time.Minute)},
+			ValidationErrorExpired, ErrTokenExpired,
+		},
+		{
+			"max age without iat", MapClaims{"sub": "admin"},
+			[]ParserOption{WithMaxAge(time.Hour)}, ValidationErrorIssuedAt, ErrTokenUsedBeforeIssued,
+		},
+		{
+			"without claims validation", claims,
+			[]ParserOption{WithTimeFunc(timeFn), WithIssuer("other"), WithoutClaimsValidation()}, 0, nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				str := signOptionTestToken(t, tt.claims)
+				_, err := NewParser(tt.options...).Parse(str, optionTestKeyFunc)
+				if tt.errors == 0 {
+					if err != nil {
+						t.Errorf("[%v] Error while verifying token: %v", tt.name, err)
+					}
+					return
+				}
+				var ve *ValidationError
+				if !errors.As(err, &ve) {
+					t.Fatalf("[%v] Expecting validation error, got %v", tt.name, err)
+				}
+				if ve.Errors != tt.errors {
+					t.Errorf("[%v] Errors don't match expectation.  %v != %v", tt.name, ve.Errors, tt.errors)
+				}
+				if !errors.Is(err, tt.err) {
+					t.Errorf("[%v] Expecting error %v, got %v", tt.name, tt.err, err)
+				}
+			},
+		)
+	}
+}
+
+type optionTestClaims struct {
+	Role string `json:"role"`
+	RegisteredClaims
+}
+
+func TestParseTyped(t *testing.T) {
+	now := time.Now()
+	str := signOptionTestToken(
+		t, &optionTestClaims{
+			Role: "ROLE_ADMIN",
+			RegisteredClaims: RegisteredClaims{
+				Issuer:    "issuer",
+				Subject:   "admin",
+				ExpiresAt: NewNumericDate(now.Add(time.Minute)),
+			},
+		},
+	)
+
+	claims, err := ParseTyped[*optionTestClaims](str, optionTestKeyFunc, WithIssuer("issuer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Role != "ROLE_ADMIN" || claims.Subject != "admin" {
+		t.Errorf("Incorrect claims %+v", claims)
+	}
+
+	value, err := ParseTyped[optionTestClaims](str, optionTestKeyFunc)
+	if err != nil || value.Role != "ROLE_ADMIN" {
+		t.Errorf("Incorrect claims %+v (%v)", value, err)
+	}
+
+	mapped, err := ParseTyped[MapClaims](str, optionTestKeyFunc)
+	if err != nil || mapped["role"] != "ROLE_ADMIN" {
+		t.Errorf("Incorrect claims %+v (%v)", mapped, err)
+	}
+
+	_, err = ParseTyped[*optionTestClaims](str, optionTestKeyFunc, WithIssuer("other"))
+	if !errors.Is(err, ErrTokenInvalidIssuer) {
+		t.Errorf("got %v, want %v", err, ErrTokenInvalidIssuer)
+	}
+
+	_, err = ParseTyped[*optionTestClaims](
+		str, optionTestKeyFunc, WithTimeFunc(func() time.Time { return now.Add(time.Hour) }),
+	)
+	if !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("got %v, want %v", err, ErrTokenExpired)
+	}
+}
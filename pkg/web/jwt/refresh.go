@@ -0,0 +1,154 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid")
+	ErrRefreshTokenExpired = errors.New("refresh token is expired")
+	ErrRefreshTokenRevoked = errors.New("refresh token has been revoked")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+)
+
+// RefreshToken is the server side record of an opaque refresh token. Only a
+// digest of the token itself is kept, so the contents of a store can not be
+// used to obtain new access tokens.
+type RefreshToken struct {
+	// ID is the digest of the opaque token string.
+	ID string
+
+	// Family is shared by every token that descends from the same login.
+	// When a token is reused, the whole family is revoked.
+	Family string
+
+	Subject string
+	Role    string
+
+	// Claims holds additional claims placed in every access token issued
+	// using the token, such as the scope of an OAuth 2.0 grant.
+	Claims MapClaims
+
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+
+	// Used is set once the token has been exchanged for a new pair.
+	Used bool
+
+	// Revoked is set when the token, or its family, is revoked.
+	Revoked bool
+}
+
+// RefreshStore stores refresh tokens server side.
+type RefreshStore interface {
+	// Save stores the refresh token, replacing any existing token with
+	// the same ID.
+	Save(rt *RefreshToken) error
+
+	// Get returns the refresh token with the provided ID.
+	Get(id string) (*RefreshToken, error)
+
+	// Use marks the token as used. It reports false if the token was
+	// already used or revoked, which makes the exchange of a token for a
+	// new pair atomic.
+	Use(id string) (*RefreshToken, bool, error)
+
+	// RevokeFamily revokes every token in the provided family.
+	RevokeFamily(family string) error
+}
+
+// MemoryRefreshStore is an in memory RefreshStore. Expired tokens are
+// removed whenever a new token is saved.
+type MemoryRefreshStore struct {
+	mu     sync.Mutex
+	tokens map[string]*RefreshToken
+}
+
+func NewMemoryRefreshStore() *MemoryRefreshStore {
+	return &MemoryRefreshStore{
+		tokens: make(map[string]*RefreshToken),
+	}
+}
+
+func (s *MemoryRefreshStore) Save(rt *RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, t := range s.tokens {
+		if now.After(t.ExpiresAt) {
+			delete(s.tokens, id)
+		}
+	}
+	cp := *rt
+	s.tokens[rt.ID] = &cp
+	return nil
+}
+
+func (s *MemoryRefreshStore) Get(id string) (*RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt, found := s.tokens[id]
+	if !found {
+		return nil, ErrRefreshTokenInvalid
+	}
+	cp := *rt
+	return &cp, nil
+}
+
+func (s *MemoryRefreshStore) Use(id string) (*RefreshToken, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt, found := s.tokens[id]
+	if !found {
+		return nil, false, ErrRefreshTokenInvalid
+	}
+	cp := *rt
+	if rt.Used || rt.Revoked {
+		return &cp, false, nil
+	}
+	rt.Used = true
+	return &cp, true, nil
+}
+
+func (s *MemoryRefreshStore) RevokeFamily(family string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rt := range s.tokens {
+		if rt.Family == family {
+			rt.Revoked = true
+		}
+	}
+	return nil
+}
+
+// TokenPair is a signed access token along with the opaque refresh token
+// that can be exchanged for the next pair.
+type TokenPair struct {
+	AccessToken      string    `json:"access_token"`
+	RefreshToken     string    `json:"refresh_token"`
+	TokenType        string    `json:"token_type"`
+	ExpiresIn        int       `json:"expires_in"`
+	AccessExpiresAt  time.Time `json:"-"`
+	RefreshExpiresAt time.Time `json:"-"`
+}
+
+// randomString returns n random bytes encoded using unpadded base64url.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// refreshTokenID returns the digest under which a refresh token is stored.
+func refreshTokenID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,121 @@
+package jwt
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestService(t *testing.T) *JWTService {
+	s, err := NewJWTServiceWithConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestJWTService_PerTokenClaims(t *testing.T) {
+	s := newTestService(t)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	first := s.GenerateSignedToken("admin", "ROLE_ADMIN")
+	s.now = func() time.Time { return now.Add(-time.Minute) }
+	second := s.GenerateSignedToken("admin", "ROLE_ADMIN")
+	s.now = func() time.Time { return now }
+
+	var claims [2]MapClaims
+	for i, str := range []string{first, second} {
+		token, err := s.ValidateTokenString(str)
+		if err != nil {
+			t.Fatalf("[%d] unexpected error: %v", i, err)
+		}
+		claims[i] = token.Claims.(MapClaims)
+		for _, name := range []string{"iat", "nbf", "exp", "jti"} {
+			if _, ok := claims[i][name]; !ok {
+				t.Fatalf("[%d] missing %q claim", i, name)
+			}
+		}
+	}
+	if claims[0]["exp"] == claims[1]["exp"] {
+		t.Errorf("tokens issued at different times share the same expiry")
+	}
+	if claims[0]["jti"] == claims[1]["jti"] {
+		t.Errorf("tokens share the same id")
+	}
+}
+
+func TestJWTService_RefreshRotation(t *testing.T) {
+	s := newTestService(t)
+	pair, err := s.IssueTokenPair("admin", "ROLE_ADMIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := s.Refresh(pair.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	if next.RefreshToken == pair.RefreshToken {
+		t.Fatalf("refresh token was not rotated")
+	}
+	if _, err = s.ValidateTokenString(next.AccessToken); err != nil {
+		t.Fatalf("refreshed access token is invalid: %v", err)
+	}
+
+	// Presenting the first token again must revoke the whole family,
+	// including the token that was issued in exchange for it.
+	if _, err = s.Refresh(pair.RefreshToken); err != ErrRefreshTokenReused {
+		t.Fatalf("got %v, want %v", err, ErrRefreshTokenReused)
+	}
+	if _, err = s.Refresh(next.RefreshToken); err != ErrRefreshTokenRevoked {
+		t.Fatalf("got %v, want %v", err, ErrRefreshTokenRevoked)
+	}
+}
+
+func TestJWTService_RefreshErrors(t *testing.T) {
+	s := newTestService(t)
+	if _, err := s.Refresh("unknown"); err != ErrRefreshTokenInvalid {
+		t.Fatalf("got %v, want %v", err, ErrRefreshTokenInvalid)
+	}
+
+	pair, err := s.IssueTokenPair("admin", "ROLE_ADMIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.RevokeRefreshToken(pair.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Refresh(pair.RefreshToken); err != ErrRefreshTokenRevoked {
+		t.Fatalf("got %v, want %v", err, ErrRefreshTokenRevoked)
+	}
+
+	pair, err = s.IssueTokenPair("admin", "ROLE_ADMIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return time.Now().Add(RefreshTokenTTL + time.Minute) }
+	if _, err = s.Refresh(pair.RefreshToken); err != ErrRefreshTokenExpired {
+		t.Fatalf("got %v, want %v", err, ErrRefreshTokenExpired)
+	}
+}
+
+func TestJWTService_PurposeToken(t *testing.T) {
+	s := newTestService(t)
+	token, err := s.IssuePurposeToken("admin", "ROLE_ADMIN", "mfa", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.ValidateTokenString(token); err == nil {
+		t.Errorf("purpose token was accepted as an access token")
+	}
+	if _, err = s.ValidatePurposeToken(token, "password-reset"); err == nil {
+		t.Errorf("purpose token was accepted for another purpose")
+	}
+	if _, err = s.ValidatePurposeToken(s.GenerateSignedToken("admin", "ROLE_ADMIN"), "mfa"); err == nil {
+		t.Errorf("access token was accepted as a purpose token")
+	}
+	if _, err = s.ValidatePurposeToken(token, "mfa"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = s.IssuePurposeToken("admin", "ROLE_ADMIN", DefaultAudience, time.Minute); err == nil {
+		t.Errorf("purpose token was issued for the audience of access tokens")
+	}
+}
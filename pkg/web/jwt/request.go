@@ -9,6 +9,11 @@ import (
 var ErrNoTokenInRequest = errors.New("no token present in request")
 var ErrNoCookieFound = errors.New("no cookie present with specified name in request")
 
+// TokenExtractor is a function that attempts to locate a raw token string
+// within a request. It should return ErrNoTokenInRequest (or ErrNoCookieFound)
+// when the request simply does not carry a token in the place it looks.
+type TokenExtractor func(r *http.Request) (string, error)
+
 func ExtractTokenFromRequest(r *http.Request) (string, error) {
 	tokenHeader := r.Header.Get("Authorization")
 	// The usual convention is for "Bearer" to be title-cased. However, there's no
@@ -16,13 +21,55 @@ func ExtractTokenFromRequest(r *http.Request) (string, error) {
 	if tokenHeader == "" || !strings.HasPrefix(strings.ToLower(tokenHeader), "bearer ") {
 		return "", ErrNoTokenInRequest
 	}
-	return tokenHeader[7:], nil
+	tokenString := strings.TrimSpace(tokenHeader[7:])
+	if tokenString == "" {
+		return "", ErrNoTokenInRequest
+	}
+	return tokenString, nil
 }
 
 func ExtractTokenFromCookie(name string, r *http.Request) (string, error) {
 	c, err := r.Cookie(name)
-	if errors.Is(err, http.ErrNoCookie) {
+	if err != nil {
+		if errors.Is(err, http.ErrNoCookie) {
+			return "", ErrNoCookieFound
+		}
 		return "", err
 	}
+	if c.Value == "" {
+		return "", ErrNoCookieFound
+	}
 	return c.Value, nil
 }
+
+// HeaderExtractor returns a TokenExtractor that looks for a bearer token in
+// the Authorization header of the request.
+func HeaderExtractor() TokenExtractor {
+	return ExtractTokenFromRequest
+}
+
+// CookieExtractor returns a TokenExtractor that looks for a token in the
+// cookie with the provided name.
+func CookieExtractor(name string) TokenExtractor {
+	return func(r *http.Request) (string, error) {
+		return ExtractTokenFromCookie(name, r)
+	}
+}
+
+// ExtractToken runs the provided extractors in order and returns the first
+// token that is found. If none of the extractors locate a token, it returns
+// ErrNoTokenInRequest. Any other error returned by an extractor stops the
+// search and is returned to the caller.
+func ExtractToken(r *http.Request, extractors ...TokenExtractor) (string, error) {
+	for _, extract := range extractors {
+		tokenString, err := extract(r)
+		if err == nil {
+			return tokenString, nil
+		}
+		if errors.Is(err, ErrNoTokenInRequest) || errors.Is(err, ErrNoCookieFound) {
+			continue
+		}
+		return "", err
+	}
+	return "", ErrNoTokenInRequest
+}
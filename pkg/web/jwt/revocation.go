@@ -0,0 +1,245 @@
+package jwt
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RevocationStore keeps track of tokens that must no longer be accepted, even
+// though they have not yet expired. Tokens can be revoked individually using
+// their "jti" claim, or all at once for a subject, in which case every token
+// for the subject that was issued before the provided time is revoked.
+type RevocationStore interface {
+	// Revoke revokes the token with the provided id. The entry is kept
+	// until expiresAt, after which the token is rejected as expired.
+	Revoke(jti string, expiresAt time.Time) error
+
+	// RevokeSubject revokes every token for the subject that was issued
+	// before the provided time. The entry is kept until expiresAt, which
+	// should be no earlier than the expiry of the last token affected.
+	RevokeSubject(subject string, before, expiresAt time.Time) error
+
+	// IsRevoked reports whether a token with the provided id, subject and
+	// issue time has been revoked.
+	IsRevoked(jti, subject string, issuedAt time.Time) (bool, error)
+
+	// Prune removes every entry that has expired by the provided time.
+	Prune(now time.Time) error
+}
+
+// subjectRevocation revokes all tokens for a subject issued before Before.
+type subjectRevocation struct {
+	Before    time.Time `json:"before"`
+	ExpiresAt time.Time `json:"exp"`
+}
+
+// MemoryRevocationStore is an in memory RevocationStore. Expired entries are
+// pruned automatically whenever a new entry is added.
+type MemoryRevocationStore struct {
+	mu sync.RWMutex
+	revocationEntries
+}
+
+// revocationEntries holds the entries of a revocation store, and is also the
+// format in which a FileRevocationStore is written to disk.
+type revocationEntries struct {
+	IDs      map[string]time.Time         `json:"ids"`
+	Subjects map[string]subjectRevocation `json:"subjects"`
+}
+
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		revocationEntries: revocationEntries{
+			IDs:      make(map[string]time.Time),
+			Subjects: make(map[string]subjectRevocation),
+		},
+	}
+}
+
+func (s *MemoryRevocationStore) Revoke(jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(time.Now())
+	s.IDs[jti] = expiresAt
+	return nil
+}
+
+func (s *MemoryRevocationStore) RevokeSubject(subject string, before, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(time.Now())
+	if prev, found := s.Subjects[subject]; found {
+		// Never move an existing cut-off backwards
+		if prev.Before.After(before) {
+			before = prev.Before
+		}
+		if prev.ExpiresAt.After(expiresAt) {
+			expiresAt = prev.ExpiresAt
+		}
+	}
+	s.Subjects[subject] = subjectRevocation{Before: before, ExpiresAt: expiresAt}
+	return nil
+}
+
+func (s *MemoryRevocationStore) IsRevoked(jti, subject string, issuedAt time.Time) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if jti != "" {
+		if _, found := s.IDs[jti]; found {
+			return true, nil
+		}
+	}
+	if subject != "" {
+		if sr, found := s.Subjects[subject]; found {
+			// A token without an issue time can not prove that it was
+			// issued after the cut-off, so it is considered revoked.
+			if issuedAt.IsZero() || issuedAt.Before(sr.Before) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (s *MemoryRevocationStore) Prune(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+	return nil
+}
+
+func (s *MemoryRevocationStore) prune(now time.Time) {
+	for jti, exp := range s.IDs {
+		if now.After(exp) {
+			delete(s.IDs, jti)
+		}
+	}
+	for sub, sr := range s.Subjects {
+		if now.After(sr.ExpiresAt) {
+			delete(s.Subjects, sub)
+		}
+	}
+}
+
+// FileRevocationStore is a RevocationStore that is kept in memory and written
+// to a JSON file every time it changes, so revocations survive a restart.
+type FileRevocationStore struct {
+	path string
+	mu   sync.Mutex
+	*MemoryRevocationStore
+}
+
+// NewFileRevocationStore opens the revocation store at the provided path,
+// loading any entries that are already present. The file is created when the
+// first entry is added.
+func NewFileRevocationStore(path string) (*FileRevocationStore, error) {
+	fs := &FileRevocationStore{
+		path:                  path,
+		MemoryRevocationStore: NewMemoryRevocationStore(),
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fs, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(b, &fs.MemoryRevocationStore.revocationEntries)
+	if err != nil {
+		return nil, err
+	}
+	fs.MemoryRevocationStore.prune(time.Now())
+	return fs, nil
+}
+
+func (fs *FileRevocationStore) Revoke(jti string, expiresAt time.Time) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	err := fs.MemoryRevocationStore.Revoke(jti, expiresAt)
+	if err != nil {
+		return err
+	}
+	return fs.save()
+}
+
+func (fs *FileRevocationStore) RevokeSubject(subject string, before, expiresAt time.Time) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	err := fs.MemoryRevocationStore.RevokeSubject(subject, before, expiresAt)
+	if err != nil {
+		return err
+	}
+	return fs.save()
+}
+
+func (fs *FileRevocationStore) Prune(now time.Time) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	err := fs.MemoryRevocationStore.Prune(now)
+	if err != nil {
+		return err
+	}
+	return fs.save()
+}
+
+// save writes the store to a temporary file, which then replaces the
+// existing file, so a crash can never leave a partially written store.
+func (fs *FileRevocationStore) save() error {
+	fs.MemoryRevocationStore.mu.RLock()
+	b, err := json.Marshal(&fs.MemoryRevocationStore.revocationEntries)
+	fs.MemoryRevocationStore.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fs.path), filepath.Base(fs.path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fs.path)
+}
+
+// revocationClaims are the claims used to look a token up in a
+// RevocationStore. They are decoded separately from the token claims, so
+// that any Claims implementation can be checked.
+type revocationClaims struct {
+	ID       string       `json:"jti"`
+	Subject  string       `json:"sub"`
+	User     string       `json:"user"`
+	IssuedAt *NumericDate `json:"iat"`
+}
+
+// checkRevoked reports whether the token described by the encoded claims
+// segment has been revoked.
+func checkRevoked(rs RevocationStore, seg string) (bool, error) {
+	b, err := decodeSegment(seg)
+	if err != nil {
+		return false, err
+	}
+	var rc revocationClaims
+	err = json.Unmarshal(b, &rc)
+	if err != nil {
+		return false, err
+	}
+	if rc.Subject == "" {
+		rc.Subject = rc.User
+	}
+	var iat time.Time
+	if rc.IssuedAt != nil {
+		iat = rc.IssuedAt.Time
+	}
+	return rs.IsRevoked(rc.ID, rc.Subject, iat)
+}
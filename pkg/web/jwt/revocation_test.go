@@ -0,0 +1,104 @@
+package jwt
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJWTService_RevokeToken(t *testing.T) {
+	s := newTestService(t)
+	revoked := s.GenerateSignedToken("admin", "ROLE_ADMIN")
+	other := s.GenerateSignedToken("admin", "ROLE_ADMIN")
+
+	if err := s.RevokeToken(revoked); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.ValidateTokenString(revoked)
+	if !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("got %v, want %v", err, ErrTokenRevoked)
+	}
+	if ve, ok := err.(*ValidationError); !ok || ve.Errors&ValidationErrorRevoked == 0 {
+		t.Fatalf("expected ValidationErrorRevoked to be set, got %#v", err)
+	}
+	if _, err = s.ValidateTokenString(other); err != nil {
+		t.Fatalf("revoking one token affected another: %v", err)
+	}
+}
+
+func TestJWTService_RevokeSubject(t *testing.T) {
+	s := newTestService(t)
+	now := time.Now()
+	s.now = func() time.Time { return now.Add(-time.Minute) }
+	before := s.GenerateSignedToken("admin", "ROLE_ADMIN")
+	user := s.GenerateSignedToken("user", "ROLE_USER")
+
+	s.now = func() time.Time { return now.Add(-30 * time.Second) }
+	if err := s.RevokeSubject("admin"); err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return now }
+	after := s.GenerateSignedToken("admin", "ROLE_ADMIN")
+
+	tests := []struct {
+		name    string
+		token   string
+		revoked bool
+	}{
+		{"issued before", before, true},
+		{"issued after", after, false},
+		{"other subject", user, false},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				_, err := s.ValidateTokenString(tt.token)
+				if got := errors.Is(err, ErrTokenRevoked); got != tt.revoked {
+					t.Fatalf("revoked = %v, want %v (err: %v)", got, tt.revoked, err)
+				}
+			},
+		)
+	}
+}
+
+func TestMemoryRevocationStorePrune(t *testing.T) {
+	rs := NewMemoryRevocationStore()
+	now := time.Now()
+	_ = rs.Revoke("expired", now.Add(-time.Minute))
+	_ = rs.RevokeSubject("admin", now.Add(-2*time.Minute), now.Add(-time.Minute))
+	_ = rs.Revoke("current", now.Add(time.Minute))
+	if err := rs.Prune(now); err != nil {
+		t.Fatal(err)
+	}
+	if len(rs.IDs) != 1 || len(rs.Subjects) != 0 {
+		t.Fatalf("expired entries were not pruned: %+v", rs.revocationEntries)
+	}
+}
+
+func TestFileRevocationStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked.json")
+	rs, err := NewFileRevocationStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := time.Now().Add(time.Hour)
+	if err = rs.Revoke("abc", exp); err != nil {
+		t.Fatal(err)
+	}
+	if err = rs.RevokeSubject("admin", time.Now(), exp); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen the store, and make sure the entries are still there
+	rs, err = NewFileRevocationStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked, _ := rs.IsRevoked("abc", "", time.Time{}); !revoked {
+		t.Errorf("revoked id was not persisted")
+	}
+	if revoked, _ := rs.IsRevoked("", "admin", time.Now().Add(-time.Hour)); !revoked {
+		t.Errorf("revoked subject was not persisted")
+	}
+}
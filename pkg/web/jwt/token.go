@@ -103,8 +103,8 @@ func decodeSegment(seg string) ([]byte, error) {
 // for verifying the signature.
 // The caller is strongly encouraged to set the WithValidMethods option to
 // validate the 'alg' claim in the token matches the expected algorithm.
-func Parse(token string, keyFn KeyFunc, options ParserOptions) (*Token, error) {
-	return NewParser(options.ValidMethods, options.UseJSONNumber, options.SkipClaimsValidation).Parse(token, keyFn)
+func Parse(token string, keyFn KeyFunc, options ...ParserOption) (*Token, error) {
+	return NewParser(options...).Parse(token, keyFn)
 }
 
 // ParseWithClaims is a shortcut for NewParser().ParseWithClaims().
@@ -113,6 +113,6 @@ func Parse(token string, keyFn KeyFunc, options ParserOptions) (*Token, error) {
 // (such as RegisteredClaims), make sure that a: you either embed a non-pointer version of
 // the claims or b: if you are using a pointer, allocate the proper memory for it before passing
 // in the overall claims, otherwise you might run into a panic.
-func ParseWithClaims(token string, claims Claims, keyFn KeyFunc, options ParserOptions) (*Token, error) {
-	return NewParser(options.ValidMethods, options.UseJSONNumber, options.SkipClaimsValidation).ParseWithClaims(token, claims, keyFn)
+func ParseWithClaims(token string, claims Claims, keyFn KeyFunc, options ...ParserOption) (*Token, error) {
+	return NewParser(options...).ParseWithClaims(token, claims, keyFn)
 }
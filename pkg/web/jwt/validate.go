@@ -6,7 +6,7 @@ import (
 )
 
 func ValidateTokenString(signingMethod SigningMethod, public *rsa.PublicKey, tokenString string) (*Token, error) {
-	parser := NewParser([]string{signingMethod.Alg()}, true, false)
+	parser := NewParser(WithValidMethods([]string{signingMethod.Alg()}), WithJSONNumber())
 	token, err := parser.Parse(
 		tokenString, func(t *Token) (any, error) {
 			// Validate the signing method
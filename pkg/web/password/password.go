@@ -0,0 +1,213 @@
+// Package password provides hashing and verification of user passwords.
+// Hashes are derived using scrypt and encoded using the PHC string format,
+// for example:
+//
+//	$scrypt$ln=15,r=8,p=1$<salt>$<hash>
+//
+// where the salt and hash are base64 encoded without padding. Because the
+// cost parameters are stored alongside every hash, they can be raised at any
+// time and existing hashes upgraded the next time a user logs in.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const scryptID = "scrypt"
+
+var (
+	ErrInvalidHash     = errors.New("password: hash is not in a recognized format")
+	ErrUnsupportedHash = errors.New("password: hash uses an unsupported algorithm")
+)
+
+// b64 is the base64 encoding used by the PHC string format.
+var b64 = base64.RawStdEncoding
+
+// Params holds the cost parameters used when hashing passwords.
+type Params struct {
+	// LogN is the base two logarithm of the scrypt CPU/memory cost N.
+	LogN uint8
+
+	// R is the scrypt block size.
+	R int
+
+	// P is the scrypt parallelization parameter.
+	P int
+
+	// SaltLen is the length of the random salt in bytes.
+	SaltLen int
+
+	// KeyLen is the length of the derived key in bytes.
+	KeyLen int
+}
+
+// DefaultParams are the recommended interactive login parameters for scrypt,
+// using roughly 32 MiB of memory per hash.
+var DefaultParams = Params{
+	LogN:    15,
+	R:       8,
+	P:       1,
+	SaltLen: 16,
+	KeyLen:  32,
+}
+
+// Hasher hashes and verifies passwords using a set of Params.
+type Hasher struct {
+	// Params are used for every new hash. Verifying a hash that was created
+	// using weaker parameters reports that it should be rehashed.
+	Params Params
+
+	// AllowPlaintext enables verifying passwords against legacy plaintext
+	// entries. Matching plaintext entries always report that they should be
+	// rehashed, which provides a migration path for existing stores.
+	AllowPlaintext bool
+}
+
+// NewHasher returns a *Hasher using the provided params. Any zero values are
+// replaced by the matching value from DefaultParams.
+func NewHasher(params Params) *Hasher {
+	if params.LogN == 0 {
+		params.LogN = DefaultParams.LogN
+	}
+	if params.R == 0 {
+		params.R = DefaultParams.R
+	}
+	if params.P == 0 {
+		params.P = DefaultParams.P
+	}
+	if params.SaltLen == 0 {
+		params.SaltLen = DefaultParams.SaltLen
+	}
+	if params.KeyLen == 0 {
+		params.KeyLen = DefaultParams.KeyLen
+	}
+	return &Hasher{
+		Params: params,
+	}
+}
+
+// DefaultHasher is used by the package level Hash and Verify functions.
+var DefaultHasher = NewHasher(DefaultParams)
+
+// Hash returns the PHC encoded hash of the password.
+func (h *Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.Params.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(password), salt, 1<<h.Params.LogN, h.Params.R, h.Params.P, h.Params.KeyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(
+		"$%s$ln=%d,r=%d,p=%d$%s$%s", scryptID, h.Params.LogN, h.Params.R, h.Params.P,
+		b64.EncodeToString(salt), b64.EncodeToString(key),
+	), nil
+}
+
+// Verify reports whether the password matches the encoded hash using a
+// constant time comparison. The second return value reports whether the
+// encoded hash should be replaced by a new one, either because it was created
+// using parameters that differ from the current ones, or because it is a
+// legacy plaintext entry.
+func (h *Hasher) Verify(password, encoded string) (bool, bool, error) {
+	if !IsHashed(encoded) {
+		if !h.AllowPlaintext {
+			return false, false, ErrInvalidHash
+		}
+		// Compare digests, so that the length of the stored password
+		// is not leaked by the comparison.
+		a, b := sha256.Sum256([]byte(password)), sha256.Sum256([]byte(encoded))
+		return subtle.ConstantTimeCompare(a[:], b[:]) == 1, true, nil
+	}
+	ph, err := decode(encoded)
+	if err != nil {
+		return false, false, err
+	}
+	key, err := scrypt.Key([]byte(password), ph.salt, 1<<ph.params.LogN, ph.params.R, ph.params.P, len(ph.key))
+	if err != nil {
+		return false, false, err
+	}
+	if subtle.ConstantTimeCompare(key, ph.key) != 1 {
+		return false, false, nil
+	}
+	return true, h.needsRehash(ph), nil
+}
+
+// NeedsRehash reports whether the encoded hash should be replaced by a new
+// one created using the current parameters.
+func (h *Hasher) NeedsRehash(encoded string) bool {
+	ph, err := decode(encoded)
+	if err != nil {
+		return true
+	}
+	return h.needsRehash(ph)
+}
+
+func (h *Hasher) needsRehash(ph *phcHash) bool {
+	return ph.params.LogN != h.Params.LogN || ph.params.R != h.Params.R || ph.params.P != h.Params.P ||
+		len(ph.salt) != h.Params.SaltLen || len(ph.key) != h.Params.KeyLen
+}
+
+// Hash returns the PHC encoded hash of the password using the DefaultHasher.
+func Hash(password string) (string, error) {
+	return DefaultHasher.Hash(password)
+}
+
+// Verify reports whether the password matches the encoded hash using the
+// DefaultHasher. See Hasher.Verify for details.
+func Verify(password, encoded string) (bool, bool, error) {
+	return DefaultHasher.Verify(password, encoded)
+}
+
+// IsHashed reports whether the value looks like a PHC encoded hash, rather
+// than a legacy plaintext password.
+func IsHashed(s string) bool {
+	return strings.HasPrefix(s, "$") && strings.Count(s, "$") >= 3
+}
+
+// phcHash is a decoded PHC hash string.
+type phcHash struct {
+	params Params
+	salt   []byte
+	key    []byte
+}
+
+// decode parses a "$scrypt$ln=..,r=..,p=..$salt$hash" string.
+func decode(encoded string) (*phcHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[0] != "" {
+		return nil, ErrInvalidHash
+	}
+	if parts[1] != scryptID {
+		return nil, ErrUnsupportedHash
+	}
+	ph := new(phcHash)
+	var logN uint8
+	_, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &logN, &ph.params.R, &ph.params.P)
+	if err != nil {
+		return nil, ErrInvalidHash
+	}
+	if logN < 1 || logN > 31 {
+		return nil, ErrInvalidHash
+	}
+	ph.params.LogN = logN
+	ph.salt, err = b64.DecodeString(parts[3])
+	if err != nil {
+		return nil, ErrInvalidHash
+	}
+	ph.key, err = b64.DecodeString(parts[4])
+	if err != nil || len(ph.key) == 0 {
+		return nil, ErrInvalidHash
+	}
+	ph.params.SaltLen, ph.params.KeyLen = len(ph.salt), len(ph.key)
+	return ph, nil
+}
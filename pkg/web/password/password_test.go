@@ -0,0 +1,96 @@
+package password
+
+import (
+	"strings"
+	"testing"
+)
+
+// testParams keeps the tests fast.
+var testParams = Params{LogN: 4, R: 8, P: 1, SaltLen: 16, KeyLen: 32}
+
+// TestHasherVerifyKnownHash checks that hashes are compatible with other
+// scrypt implementations, using a test vector from RFC 7914, section 12.
+func TestHasherVerifyKnownHash(t *testing.T) {
+	encoded := "$scrypt$ln=10,r=8,p=16$TmFDbA$/bq+HJ00cgB4VucZDQHp/nxq18vII3gw53N2Y0s3MWIurzDZLiKjiG/xCSedmDDaxyevuUqD7m2DYMvfoswGQA"
+	ok, _, err := NewHasher(testParams).Verify("password", encoded)
+	if err != nil || !ok {
+		t.Fatalf("Verified %v, %v, expecting a match", ok, err)
+	}
+	if ok, _, _ = NewHasher(testParams).Verify("Password", encoded); ok {
+		t.Fatalf("Verified a wrong password")
+	}
+}
+
+func TestHasherHashAndVerify(t *testing.T) {
+	h := NewHasher(testParams)
+	encoded, err := h.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$scrypt$ln=4,r=8,p=1$") {
+		t.Fatalf("unexpected encoding: %q", encoded)
+	}
+	if !IsHashed(encoded) {
+		t.Fatalf("IsHashed(%q) = false", encoded)
+	}
+	if other, _ := h.Hash("secret"); other == encoded {
+		t.Fatalf("two hashes of the same password should use different salts")
+	}
+
+	ok, rehash, err := h.Verify("secret", encoded)
+	if err != nil || !ok || rehash {
+		t.Fatalf("Verify(correct) = %v, %v, %v", ok, rehash, err)
+	}
+	ok, _, err = h.Verify("Secret", encoded)
+	if err != nil || ok {
+		t.Fatalf("Verify(wrong) = %v, %v", ok, err)
+	}
+}
+
+func TestHasherParameterUpgrade(t *testing.T) {
+	old := NewHasher(testParams)
+	encoded, err := old.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stronger := testParams
+	stronger.LogN++
+	h := NewHasher(stronger)
+	ok, rehash, err := h.Verify("secret", encoded)
+	if err != nil || !ok {
+		t.Fatalf("Verify = %v, %v", ok, err)
+	}
+	if !rehash || !h.NeedsRehash(encoded) {
+		t.Fatalf("hash created with weaker params should need a rehash")
+	}
+}
+
+func TestHasherPlaintextMigration(t *testing.T) {
+	h := NewHasher(testParams)
+	if _, _, err := h.Verify("secret", "secret"); err != ErrInvalidHash {
+		t.Fatalf("plaintext should be rejected by default, got %v", err)
+	}
+	h.AllowPlaintext = true
+	ok, rehash, err := h.Verify("secret", "secret")
+	if err != nil || !ok || !rehash {
+		t.Fatalf("Verify(plaintext) = %v, %v, %v", ok, rehash, err)
+	}
+	ok, _, _ = h.Verify("secret", "other")
+	if ok {
+		t.Fatalf("mismatched plaintext passed verification")
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, s := range []string{
+		"$scrypt$ln=4,r=8,p=1$salt",
+		"$bcrypt$ln=4,r=8,p=1$c2FsdA$a2V5",
+		"$scrypt$ln=99,r=8,p=1$c2FsdA$a2V5",
+		"$scrypt$r=8$c2FsdA$a2V5",
+		"$scrypt$ln=4,r=8,p=1$!!$a2V5",
+	} {
+		if _, err := decode(s); err == nil {
+			t.Errorf("decode(%q) should fail", s)
+		}
+	}
+}
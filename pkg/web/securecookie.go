@@ -0,0 +1,145 @@
+package web
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidCookieKey = errors.New("securecookie: hash key must be at least 32 bytes, block key 16, 24 or 32 bytes")
+	ErrCookieInvalid    = errors.New("securecookie: value is not valid")
+	ErrCookieExpired    = errors.New("securecookie: value has expired")
+)
+
+// CookieKey is a key pair used to protect cookie values. The HashKey
+// authenticates values using HMAC-SHA256, and the optional BlockKey encrypts
+// them using AES-GCM.
+type CookieKey struct {
+	HashKey  []byte
+	BlockKey []byte
+}
+
+// GenerateCookieKey returns a new random key pair. The BlockKey is only set
+// when encrypt is true.
+func GenerateCookieKey(encrypt bool) (CookieKey, error) {
+	k := CookieKey{HashKey: make([]byte, 32)}
+	if _, err := rand.Read(k.HashKey); err != nil {
+		return k, err
+	}
+	if encrypt {
+		k.BlockKey = make([]byte, 32)
+		if _, err := rand.Read(k.BlockKey); err != nil {
+			return k, err
+		}
+	}
+	return k, nil
+}
+
+type cookieKey struct {
+	hashKey []byte
+	aead    cipher.AEAD
+}
+
+// SecureCookie encodes values so that they can be kept by the client in a
+// cookie without being tampered with, and optionally without being read. The
+// expiry time is part of the protected value, so an old cookie can not be
+// replayed after it expires, whatever the browser was told.
+//
+// Keys are rotated by adding a new key in front of the list: values are
+// always encoded using the first key, and decoded using any of them.
+type SecureCookie struct {
+	keys []cookieKey
+}
+
+func NewSecureCookie(keys ...CookieKey) (*SecureCookie, error) {
+	if len(keys) == 0 {
+		return nil, ErrInvalidCookieKey
+	}
+	sc := &SecureCookie{}
+	for _, k := range keys {
+		if len(k.HashKey) < 32 {
+			return nil, ErrInvalidCookieKey
+		}
+		ck := cookieKey{hashKey: k.HashKey}
+		if len(k.BlockKey) > 0 {
+			block, err := aes.NewCipher(k.BlockKey)
+			if err != nil {
+				return nil, ErrInvalidCookieKey
+			}
+			ck.aead, err = cipher.NewGCM(block)
+			if err != nil {
+				return nil, err
+			}
+		}
+		sc.keys = append(sc.keys, ck)
+	}
+	return sc, nil
+}
+
+// Encode protects the value of the named cookie until it expires, and returns
+// it encoded as base64. The name is authenticated along with the value, so a
+// value can not be moved to another cookie.
+func (sc *SecureCookie) Encode(name string, value []byte, expires time.Time) (string, error) {
+	k := sc.keys[0]
+	body := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(body, uint64(expires.Unix()))
+	body = append(body, value...)
+	if k.aead != nil {
+		nonce := make([]byte, k.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		body = k.aead.Seal(nonce, nonce, body, []byte(name))
+	}
+	return base64.RawURLEncoding.EncodeToString(append(body, cookieMAC(k.hashKey, name, body)...)), nil
+}
+
+// Decode verifies the encoded value of the named cookie using each of the
+// keys in turn, and returns the value along with its expiry time.
+func (sc *SecureCookie) Decode(name, encoded string) ([]byte, time.Time, error) {
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(b) < sha256.Size {
+		return nil, time.Time{}, ErrCookieInvalid
+	}
+	body, mac := b[:len(b)-sha256.Size], b[len(b)-sha256.Size:]
+	for _, k := range sc.keys {
+		if !hmac.Equal(mac, cookieMAC(k.hashKey, name, body)) {
+			continue
+		}
+		plain := body
+		if k.aead != nil {
+			if len(body) < k.aead.NonceSize() {
+				return nil, time.Time{}, ErrCookieInvalid
+			}
+			nonce := body[:k.aead.NonceSize()]
+			plain, err = k.aead.Open(nil, nonce, body[k.aead.NonceSize():], []byte(name))
+			if err != nil {
+				return nil, time.Time{}, ErrCookieInvalid
+			}
+		}
+		if len(plain) < 8 {
+			return nil, time.Time{}, ErrCookieInvalid
+		}
+		expires := time.Unix(int64(binary.BigEndian.Uint64(plain[:8])), 0)
+		if !time.Now().Before(expires) {
+			return nil, time.Time{}, ErrCookieExpired
+		}
+		return plain[8:], expires, nil
+	}
+	return nil, time.Time{}, ErrCookieInvalid
+}
+
+func cookieMAC(key []byte, name string, body []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name))
+	mac.Write([]byte{'|'})
+	mac.Write(body)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,240 @@
+package web
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSessionNotFound is returned by a SessionBackend when a session does not
+// exist, or has expired.
+var ErrSessionNotFound = errors.New("session: not found")
+
+// SessionBackend stores the encoded sessions of a SessionManager. Sessions are
+// identified by their id, and must not be returned after they expire.
+type SessionBackend interface {
+	// Load returns the data and expiry time of the session.
+	Load(id string) ([]byte, time.Time, error)
+
+	// Save stores the data of the session until it expires.
+	Save(id string, data []byte, expires time.Time) error
+
+	// Delete removes the session. Deleting a session that does not exist
+	// is not an error.
+	Delete(id string) error
+
+	// Touch extends the expiry time of the session without changing its
+	// data.
+	Touch(id string, expires time.Time) error
+
+	// GC removes the expired sessions. Backends that expire sessions on
+	// their own may do nothing.
+	GC() error
+}
+
+// validSessionID reports whether the id only holds characters used in
+// generated session ids. Ids are read from cookies, so they are checked
+// before they are used as a file name or a key.
+func validSessionID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !strings.ContainsRune(letterBytes+"0123456789-_", c) {
+			return false
+		}
+	}
+	return true
+}
+
+type memoryEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+// MemoryBackend keeps the sessions in memory. Sessions are lost when the
+// process exits, and are not shared between instances.
+type MemoryBackend struct {
+	mu       sync.Mutex // serializes Save, Delete and Touch
+	sessions sync.Map
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{}
+}
+
+func (mb *MemoryBackend) Load(id string) ([]byte, time.Time, error) {
+	v, ok := mb.sessions.Load(id)
+	if !ok {
+		return nil, time.Time{}, ErrSessionNotFound
+	}
+	e := v.(*memoryEntry)
+	if !time.Now().Before(e.expires) {
+		mb.sessions.Delete(id)
+		return nil, time.Time{}, ErrSessionNotFound
+	}
+	return e.data, e.expires, nil
+}
+
+func (mb *MemoryBackend) Save(id string, data []byte, expires time.Time) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.sessions.Store(id, &memoryEntry{data: data, expires: expires})
+	return nil
+}
+
+func (mb *MemoryBackend) Delete(id string) error {
+	// Holding mu, a concurrent Touch can not store the session again after
+	// it has been deleted.
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.sessions.Delete(id)
+	return nil
+}
+
+func (mb *MemoryBackend) Touch(id string, expires time.Time) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	data, _, err := mb.Load(id)
+	if err != nil {
+		return err
+	}
+	mb.sessions.Store(id, &memoryEntry{data: data, expires: expires})
+	return nil
+}
+
+func (mb *MemoryBackend) GC() error {
+	now := time.Now()
+	mb.sessions.Range(
+		func(id, v any) bool {
+			if !now.Before(v.(*memoryEntry).expires) {
+				mb.sessions.Delete(id)
+			}
+			return true
+		},
+	)
+	return nil
+}
+
+// fileSessionExt is the extension of session files, so that GC only ever
+// removes files written by the backend.
+const fileSessionExt = ".sess"
+
+// FileBackend keeps every session in a file of its own in a directory. The
+// file holds the expiry time of the session followed by its data. Sessions
+// survive a restart, and can be shared by instances on the same host.
+type FileBackend struct {
+	dir string
+	mu  sync.Mutex // serializes Save, Delete and Touch
+}
+
+// NewFileBackend returns a backend storing sessions in dir, which is created
+// if it does not exist.
+func NewFileBackend(dir string) (*FileBackend, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileBackend{dir: dir}, nil
+}
+
+func (fb *FileBackend) path(id string) (string, error) {
+	if !validSessionID(id) {
+		return "", ErrSessionNotFound
+	}
+	return filepath.Join(fb.dir, id+fileSessionExt), nil
+}
+
+func (fb *FileBackend) Load(id string) ([]byte, time.Time, error) {
+	path, err := fb.path(id)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, time.Time{}, ErrSessionNotFound
+		}
+		return nil, time.Time{}, err
+	}
+	if len(b) < 8 {
+		return nil, time.Time{}, ErrSessionNotFound
+	}
+	expires := time.Unix(0, int64(binary.BigEndian.Uint64(b[:8])))
+	if !time.Now().Before(expires) {
+		_ = os.Remove(path)
+		return nil, time.Time{}, ErrSessionNotFound
+	}
+	return b[8:], expires, nil
+}
+
+// Save writes the session to a temporary file first and renames it, so that
+// a concurrent Load never reads a partially written session.
+func (fb *FileBackend) Save(id string, data []byte, expires time.Time) error {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return fb.write(id, data, expires)
+}
+
+func (fb *FileBackend) write(id string, data []byte, expires time.Time) error {
+	path, err := fb.path(id)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(fb.dir, id+".*.tmp")
+	if err != nil {
+		return err
+	}
+	var header [8]byte
+	binary.BigEndian.PutUint64(header[:], uint64(expires.UnixNano()))
+	_, err = f.Write(append(header[:], data...))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+func (fb *FileBackend) Delete(id string) error {
+	path, err := fb.path(id)
+	if err != nil {
+		return nil
+	}
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (fb *FileBackend) Touch(id string, expires time.Time) error {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	data, _, err := fb.Load(id)
+	if err != nil {
+		return err
+	}
+	return fb.write(id, data, expires)
+}
+
+func (fb *FileBackend) GC() error {
+	matches, err := filepath.Glob(filepath.Join(fb.dir, "*"+fileSessionExt))
+	if err != nil {
+		return err
+	}
+	for _, path := range matches {
+		// Load removes the file when the session has expired
+		_, _, _ = fb.Load(strings.TrimSuffix(filepath.Base(path), fileSessionExt))
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package web
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a minimal server speaking the Redis protocol, supporting the
+// commands used by the RedisBackend.
+type fakeRedis struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	values   map[string]string
+	expires  map[string]time.Time
+	password string
+	commands []string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, values: make(map[string]string), expires: make(map[string]time.Time), password: password}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	authed := f.password == ""
+	for {
+		reply, err := readReply(rd)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range reply.([]any) {
+			args = append(args, string(arg.([]byte)))
+		}
+		cmd := strings.ToUpper(args[0])
+		f.mu.Lock()
+		f.commands = append(f.commands, cmd)
+		if !authed && cmd != "AUTH" {
+			f.mu.Unlock()
+			fmt.Fprint(conn, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+		if exp, ok := f.expires[args[len(args)-1]]; ok && time.Now().After(exp) {
+			delete(f.values, args[len(args)-1])
+			delete(f.expires, args[len(args)-1])
+		}
+		switch cmd {
+		case "AUTH":
+			authed = args[1] == f.password
+			fmt.Fprint(conn, "+OK\r\n")
+		case "SELECT":
+			fmt.Fprint(conn, "+OK\r\n")
+		case "SET":
+			ms, _ := strconv.Atoi(args[4])
+			f.values[args[1]] = args[2]
+			f.expires[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+			fmt.Fprint(conn, "+OK\r\n")
+		case "GET":
+			if v, ok := f.values[args[1]]; ok && time.Now().Before(f.expires[args[1]]) {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+			} else {
+				fmt.Fprint(conn, "$-1\r\n")
+			}
+		case "PTTL":
+			if _, ok := f.values[args[1]]; ok {
+				fmt.Fprintf(conn, ":%d\r\n", time.Until(f.expires[args[1]]).Milliseconds())
+			} else {
+				fmt.Fprint(conn, ":-2\r\n")
+			}
+		case "PEXPIRE":
+			if _, ok := f.values[args[1]]; ok {
+				ms, _ := strconv.Atoi(args[2])
+				f.expires[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+				fmt.Fprint(conn, ":1\r\n")
+			} else {
+				fmt.Fprint(conn, ":0\r\n")
+			}
+		case "DEL":
+			_, ok := f.values[args[1]]
+			delete(f.values, args[1])
+			delete(f.expires, args[1])
+			if ok {
+				fmt.Fprint(conn, ":1\r\n")
+			} else {
+				fmt.Fprint(conn, ":0\r\n")
+			}
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		f.mu.Unlock()
+	}
+}
+
+func TestSessionBackends(t *testing.T) {
+	fileBackend, err := NewFileBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	redis := newFakeRedis(t, "s3cret")
+	redisBackend := NewRedisBackend(RedisConfig{Addr: redis.ln.Addr().String(), Password: "s3cret", DB: 2})
+	defer redisBackend.Close()
+
+	tests := []struct {
+		name    string
+		backend SessionBackend
+	}{
+		{"memory", NewMemoryBackend()},
+		{"file", fileBackend},
+		{"redis", redisBackend},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				b := tt.backend
+				expires := time.Now().Add(time.Minute)
+				if err := b.Save("abc", []byte("data"), expires); err != nil {
+					t.Fatalf("[%v] Save: %v", tt.name, err)
+				}
+				data, exp, err := b.Load("abc")
+				if err != nil || string(data) != "data" || exp.Sub(expires).Abs() > time.Second {
+					t.Fatalf("[%v] Load %q, %v, %v, expecting %q, %v", tt.name, data, exp, err, "data", expires)
+				}
+				if err = b.Touch("abc", expires.Add(time.Hour)); err != nil {
+					t.Fatalf("[%v] Touch: %v", tt.name, err)
+				}
+				if _, exp, _ = b.Load("abc"); exp.Sub(expires.Add(time.Hour)).Abs() > time.Second {
+					t.Errorf("[%v] Touch did not extend the expiry: %v", tt.name, exp)
+				}
+				if err = b.Touch("missing", expires); err != ErrSessionNotFound {
+					t.Errorf("[%v] Touch of a missing session: %v, expecting %v", tt.name, err, ErrSessionNotFound)
+				}
+				if err = b.Save("old", []byte("data"), time.Now().Add(20*time.Millisecond)); err != nil {
+					t.Fatalf("[%v] Save: %v", tt.name, err)
+				}
+				time.Sleep(30 * time.Millisecond)
+				if err = b.GC(); err != nil {
+					t.Fatalf("[%v] GC: %v", tt.name, err)
+				}
+				if _, _, err = b.Load("old"); err != ErrSessionNotFound {
+					t.Errorf("[%v] Expired session: %v, expecting %v", tt.name, err, ErrSessionNotFound)
+				}
+				if err = b.Delete("abc"); err != nil {
+					t.Fatalf("[%v] Delete: %v", tt.name, err)
+				}
+				if _, _, err = b.Load("abc"); err != ErrSessionNotFound {
+					t.Errorf("[%v] Deleted session: %v, expecting %v", tt.name, err, ErrSessionNotFound)
+				}
+				if _, _, err = b.Load("../../etc/passwd"); err != ErrSessionNotFound {
+					t.Errorf("[%v] Invalid id: %v, expecting %v", tt.name, err, ErrSessionNotFound)
+				}
+			},
+		)
+	}
+	if redis.commands[0] != "AUTH" || redis.commands[1] != "SELECT" {
+		t.Errorf("Unexpected redis commands %v", redis.commands)
+	}
+}
+
+func TestFileBackendGC(t *testing.T) {
+	dir := t.TempDir()
+	b, err := NewFileBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "other.txt"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_ = b.Save("expired", nil, time.Now().Add(-time.Second))
+	_ = b.Save("valid", nil, time.Now().Add(time.Minute))
+	if err = b.GC(); err != nil {
+		t.Fatal(err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(matches) != 2 {
+		t.Errorf("Unexpected files after GC: %v", matches)
+	}
+}
+
+func TestSessionStoreBackend(t *testing.T) {
+	backend := NewMemoryBackend()
+	for _, codec := range []string{"gob", "json"} {
+		t.Run(
+			codec, func(t *testing.T) {
+				ss := NewSessionManager(
+					&SessionManagerConfig{
+						SessionID: "sid", Domain: "localhost", Timeout: time.Minute, Backend: backend, Codec: codec,
+					},
+				)
+				defer ss.Close()
+				sess := ss.New()
+				sess.Set("current_user", &SystemUser{Username: "jane", Role: "ROLE_USER"})
+				sess.Set("count", 3)
+				w := httptest.NewRecorder()
+				ss.Save(w, httptest.NewRequest(http.MethodGet, "/", nil), sess)
+
+				// A second store sharing the backend, as another instance would
+				other := NewSessionManager(ss.SessionManagerConfig)
+				defer other.Close()
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.AddCookie(w.Result().Cookies()[0])
+				loaded, found := other.Get(r)
+				if !found {
+					t.Fatalf("[%v] Session was not found", codec)
+				}
+				count, _ := loaded.Get("count")
+				switch codec {
+				case "gob":
+					if user, ok := loaded.GetUser(); !ok || user.Username != "jane" || count != 3 {
+						t.Errorf("[%v] Unexpected values %+v, %v", codec, user, count)
+					}
+				case "json":
+					if count != float64(3) {
+						t.Errorf("[%v] Unexpected count %v", codec, count)
+					}
+				}
+
+				w = httptest.NewRecorder()
+				other.Save(w, r, nil)
+				if _, found = ss.Get(r); found {
+					t.Errorf("[%v] Session was not deleted", codec)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,83 @@
+package web
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// SessionCodec encodes the values of a session, so that they can be kept by a
+// SessionBackend.
+type SessionCodec interface {
+	Encode(values map[string]any) ([]byte, error)
+	Decode(data []byte) (map[string]any, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]SessionCodec{
+		"gob":  GobCodec{},
+		"json": JSONCodec{},
+	}
+)
+
+// RegisterSessionCodec makes a codec available by the provided name, so that
+// it can be selected in the SessionStoreConfig. Registering a codec under an
+// existing name replaces it.
+func RegisterSessionCodec(name string, codec SessionCodec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[name] = codec
+}
+
+// LookupSessionCodec returns the codec registered by the provided name.
+func LookupSessionCodec(name string) (SessionCodec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[name]
+	return codec, ok
+}
+
+// GobCodec encodes session values using encoding/gob, which preserves their
+// types. The concrete type of every value that is not a basic type must be
+// registered using gob.Register, as is done for *SystemUser.
+type GobCodec struct{}
+
+func (GobCodec) Encode(values map[string]any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(values); err != nil {
+		return nil, fmt.Errorf("session: gob encoding failed: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Decode(data []byte) (map[string]any, error) {
+	var values map[string]any
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&values); err != nil {
+		return nil, fmt.Errorf("session: gob decoding failed: %w", err)
+	}
+	return values, nil
+}
+
+// JSONCodec encodes session values as JSON. Values are decoded into the
+// generic JSON types (string, float64, bool, []any and map[string]any), so
+// it is only suited to sessions holding plain values.
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(values map[string]any) ([]byte, error) {
+	return json.Marshal(values)
+}
+
+func (JSONCodec) Decode(data []byte) (map[string]any, error) {
+	var values map[string]any
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("session: json decoding failed: %w", err)
+	}
+	return values, nil
+}
+
+func init() {
+	gob.Register(&SystemUser{})
+}
@@ -0,0 +1,326 @@
+package web
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SessionManagerConfig is a configuration object for a
+// session manager
+type SessionManagerConfig struct {
+	SessionID       string         `json:"session_id"`       // SessionID is the name of the session cookie, defaults to "go_sess_id"
+	Domain          string         `json:"domain"`           // Domain is the domain to limit the session scope
+	Path            string         `json:"path"`             // Path is the path to limit the session scope, defaults to "/"
+	Timeout         time.Duration  `json:"timeout_duration"` // Timeout is the max idle session time allowed, every save extends the session by it, at least one second
+	AbsoluteTimeout time.Duration  `json:"absolute_timeout"` // AbsoluteTimeout is the max lifetime of a session however active it is, defaults to 24 hours
+	Secure          bool           `json:"secure"`           // Secure restricts the cookie to TLS connections
+	ScriptAccess    bool           `json:"script_access"`    // ScriptAccess clears the HttpOnly attribute, leave it false unless scripts must read the cookie
+	SameSite        http.SameSite  `json:"same_site"`        // SameSite mode of the cookie, use Lax when logins redirect back from another site
+	Backend         SessionBackend `json:"-"`                // Backend stores the sessions, defaults to an in-memory backend
+	Codec           string         `json:"codec"`            // Codec is the name of the registered codec encoding session values, defaults to "gob"
+}
+
+// SessionStoreConfig is the former name of the SessionManagerConfig.
+//
+// Deprecated: use SessionManagerConfig.
+type SessionStoreConfig = SessionManagerConfig
+
+// defaultConfig is pretty self-explanatory
+var defaultConfig = &SessionManagerConfig{
+	SessionID:       "go_sess_id",
+	Domain:          "localhost",
+	Path:            "/",
+	Timeout:         time.Duration(30) * time.Minute,
+	AbsoluteTimeout: time.Duration(24) * time.Hour,
+	SameSite:        http.SameSiteStrictMode,
+	Codec:           "gob",
+}
+
+// minTimeout is the shortest idle session time allowed, the
+// garbage collector runs every half of it
+const minTimeout = time.Second
+
+// checkSessionManagerConfig returns a copy of the config
+// with any default values that need to be set
+func checkSessionManagerConfig(conf *SessionManagerConfig) *SessionManagerConfig {
+	if conf == nil {
+		conf = defaultConfig
+	}
+	c := *conf
+	if c.SessionID == "" {
+		c.SessionID = defaultConfig.SessionID
+	}
+	if c.Domain == "" {
+		c.Domain = defaultConfig.Domain
+	}
+	if c.Path == "" {
+		c.Path = defaultConfig.Path
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultConfig.Timeout
+	}
+	if c.Timeout < minTimeout {
+		c.Timeout = minTimeout
+	}
+	if c.AbsoluteTimeout <= 0 {
+		c.AbsoluteTimeout = defaultConfig.AbsoluteTimeout
+	}
+	if c.SameSite == 0 {
+		c.SameSite = defaultConfig.SameSite
+	}
+	if c.Backend == nil {
+		c.Backend = NewMemoryBackend()
+	}
+	if c.Codec == "" {
+		c.Codec = defaultConfig.Codec
+	}
+	return &c
+}
+
+// SessionManager implements the SessionProvider interface
+// and is a session manager using cookies. The sessions are
+// kept by the configured SessionBackend, encoded using the
+// configured SessionCodec. Session ids are random, and are
+// replaced by Regenerate, which should be called whenever
+// a user logs in.
+//
+// Every manager is independent, and collects the expired
+// sessions of its backend until Close is called.
+type SessionManager struct {
+	*SessionManagerConfig
+	codec  SessionCodec
+	cancel context.CancelFunc
+	once   sync.Once
+	now    func() time.Time
+}
+
+// SessionStore is the former name of the SessionManager.
+//
+// Deprecated: use SessionManager.
+type SessionStore = SessionManager
+
+// NewSessionManager takes a *SessionManagerConfig, and returns a new
+// *SessionManager. A nil config will use the default values. It panics when
+// the config names a codec that is not registered.
+func NewSessionManager(conf *SessionManagerConfig) *SessionManager {
+	conf = checkSessionManagerConfig(conf)
+	codec, ok := LookupSessionCodec(conf.Codec)
+	if !ok {
+		panic(fmt.Sprintf("session: unknown codec %q", conf.Codec))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sm := &SessionManager{
+		SessionManagerConfig: conf,
+		codec:                codec,
+		cancel:               cancel,
+		now:                  time.Now,
+	}
+	go sm.gc(ctx)
+	return sm
+}
+
+// NewSessionStore returns a new *SessionManager.
+//
+// Deprecated: use NewSessionManager. Session stores used to be a singleton;
+// every call now returns a new, independent, instance.
+func NewSessionStore(conf *SessionStoreConfig) *SessionStore {
+	return NewSessionManager(conf)
+}
+
+// New creates and returns a new session
+func (sm *SessionManager) New() *Session {
+	now := sm.now()
+	return &Session{
+		id:      newSessionID(),
+		data:    new(sync.Map),
+		created: now,
+		expires: AddTime(now, sm.Timeout),
+	}
+}
+
+// Get returns a cached session (if one exists)
+func (sm *SessionManager) Get(r *http.Request) (*Session, bool) {
+	c := getCookie(r, sm.SessionID)
+	if c == nil {
+		return nil, false
+	}
+	return sm.load(c.Value)
+}
+
+// load reads the session from the backend and decodes its values. The data
+// stored in the backend is the creation time of the session followed by the
+// encoded values.
+func (sm *SessionManager) load(id string) (*Session, bool) {
+	data, expires, err := sm.Backend.Load(id)
+	if err != nil {
+		if err != ErrSessionNotFound {
+			log.Printf("session: loading %q failed: %v", id, err)
+		}
+		return nil, false
+	}
+	if len(data) < 8 {
+		return nil, false
+	}
+	created := time.Unix(0, int64(binary.BigEndian.Uint64(data[:8])))
+	if !sm.now().Before(created.Add(sm.AbsoluteTimeout)) {
+		_ = sm.Backend.Delete(id)
+		return nil, false
+	}
+	values, err := sm.codec.Decode(data[8:])
+	if err != nil {
+		log.Print(err)
+		return nil, false
+	}
+	sess := &Session{
+		id:      id,
+		data:    new(sync.Map),
+		created: created,
+		expires: expires,
+	}
+	for k, v := range values {
+		sess.data.Store(k, v)
+	}
+	return sess, true
+}
+
+// MustGet returns a cached session (if one exists), otherwise, it creates and
+// returns a new session. It is guaranteed to return a session.
+func (sm *SessionManager) MustGet(r *http.Request) (*Session, bool) {
+	// First, attempt to get an existing session from the cookie.
+	sess, found := sm.Get(r)
+	if !found {
+		// If no session was found, then we must create a new session,
+		// and return false (indicating that we did not find an existing
+		// one, but one had to be created.) Don't forget, this session
+		// WILL NOT be saved unless the Save method is called.
+		return sm.New(), false
+	}
+	// Otherwise, we have successfully located an existing session,
+	// so we can simply return it along with a true (indicating that
+	// the session did in fact exist)
+	return sess, true
+}
+
+// expiry returns the time the session expires when it is saved or touched
+// now: after the idle timeout, but never past the absolute timeout.
+func (sm *SessionManager) expiry(session *Session) time.Time {
+	expires := AddTime(sm.now(), sm.Timeout)
+	if limit := session.created.Add(sm.AbsoluteTimeout); expires.After(limit) {
+		expires = limit
+	}
+	return expires
+}
+
+// Save persists the provided session. If you would like to remove a session, simply
+// pass it a nil session, and it will time the cookie out. Changes made to a
+// session are only visible to other requests once it has been saved.
+func (sm *SessionManager) Save(w http.ResponseWriter, r *http.Request, session *Session) {
+	if session == nil {
+		cook := getCookie(r, sm.SessionID)
+		if cook == nil {
+			return
+		}
+		if err := sm.Backend.Delete(cook.Value); err != nil {
+			log.Printf("session: deleting %q failed: %v", cook.Value, err)
+		}
+		http.SetCookie(w, newCookie(sm.SessionManagerConfig, cook.Value, time.Now()))
+		return
+	}
+	expires := sm.expiry(session)
+	if !expires.After(sm.now()) {
+		// The session has reached its absolute timeout
+		_ = sm.Backend.Delete(session.id)
+		http.SetCookie(w, newCookie(sm.SessionManagerConfig, session.id, time.Now()))
+		return
+	}
+	values := make(map[string]any)
+	session.data.Range(
+		func(k, v any) bool {
+			values[fmt.Sprint(k)] = v
+			return true
+		},
+	)
+	data, err := sm.codec.Encode(values)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	header := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(header, uint64(session.created.UnixNano()))
+	if err = sm.Backend.Save(session.id, append(header, data...), expires); err != nil {
+		log.Printf("session: saving %q failed: %v", session.id, err)
+		return
+	}
+	session.expires = expires
+	session.modified.Store(false)
+	http.SetCookie(w, newCookie(sm.SessionManagerConfig, session.id, session.expires))
+}
+
+// Touch extends the expiry time of the provided session, without storing
+// its values again.
+func (sm *SessionManager) Touch(w http.ResponseWriter, session *Session) {
+	expires := sm.expiry(session)
+	if err := sm.Backend.Touch(session.id, expires); err != nil {
+		return
+	}
+	session.expires = expires
+	http.SetCookie(w, newCookie(sm.SessionManagerConfig, session.id, session.expires))
+}
+
+// Regenerate gives the provided session a new id, removes the session stored
+// under its former id, and saves it. It must be called when a user logs in,
+// so that a session id planted by an attacker before the login can not be
+// used afterwards (session fixation). The absolute timeout starts over.
+func (sm *SessionManager) Regenerate(w http.ResponseWriter, r *http.Request, session *Session) {
+	if err := sm.Backend.Delete(session.id); err != nil {
+		log.Printf("session: deleting %q failed: %v", session.id, err)
+	}
+	session.id = newSessionID()
+	session.created = sm.now()
+	sm.Save(w, r, session)
+}
+
+// Close stops collecting the expired sessions, and closes the backend when it
+// holds any resources, such as the connection of a RedisBackend. The manager
+// must not be used afterwards.
+func (sm *SessionManager) Close() error {
+	var err error
+	sm.once.Do(
+		func() {
+			sm.cancel()
+			if c, ok := sm.Backend.(io.Closer); ok {
+				err = c.Close()
+			}
+		},
+	)
+	return err
+}
+
+// String is the session manager's stringer method
+func (sm *SessionManager) String() string {
+	return fmt.Sprintf("SessionManager{%s, backend=%T, codec=%s}", sm.SessionID, sm.Backend, sm.Codec)
+}
+
+// gc is the session manager "garbage collector" and
+// cleans and disposes of expired sessions (server side)
+// until the manager is closed
+func (sm *SessionManager) gc(ctx context.Context) {
+	ticker := time.NewTicker(sm.Timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := sm.Backend.GC(); err != nil {
+				log.Printf("session: gc failed: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
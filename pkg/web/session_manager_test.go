@@ -0,0 +1,178 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// withCookie returns a request carrying the session cookie set by the response
+func withCookie(w *httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestSessionManagerConfig(t *testing.T) {
+	sm := NewSessionManager(nil)
+	defer sm.Close()
+	if sm.SessionID != defaultConfig.SessionID || sm.Timeout != defaultConfig.Timeout || sm.Backend == nil {
+		t.Errorf("Nil config did not get the defaults: %s", sm)
+	}
+	if defaultConfig.Backend != nil {
+		t.Errorf("Default config was modified")
+	}
+
+	conf := &SessionManagerConfig{SessionID: "sid", Path: "/app", Secure: true, SameSite: http.SameSiteLaxMode}
+	other := NewSessionManager(conf)
+	defer other.Close()
+	if conf.Backend != nil || conf.Timeout != 0 {
+		t.Errorf("Provided config was modified")
+	}
+	if sm.Backend == other.Backend {
+		t.Errorf("Managers share the default backend")
+	}
+
+	w := httptest.NewRecorder()
+	other.Save(w, httptest.NewRequest(http.MethodGet, "/", nil), other.New())
+	c := w.Result().Cookies()[0]
+	if c.Name != "sid" || c.Path != "/app" || !c.Secure || !c.HttpOnly || c.SameSite != http.SameSiteLaxMode {
+		t.Errorf("Unexpected cookie attributes %s", c)
+	}
+	// A session of one manager is unknown to the other
+	if _, found := sm.Get(withCookie(w)); found {
+		t.Errorf("Session found by an independent manager")
+	}
+}
+
+func TestSessionManagerConfigTimeouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		absolute time.Duration
+		want     time.Duration
+		wantAbs  time.Duration
+	}{
+		{"negative", -time.Minute, -time.Hour, defaultConfig.Timeout, defaultConfig.AbsoluteTimeout},
+		{"one nanosecond", time.Nanosecond, time.Hour, minTimeout, time.Hour},
+		{"below minimum", minTimeout / 2, 0, minTimeout, defaultConfig.AbsoluteTimeout},
+		{"valid", time.Minute, time.Hour, time.Minute, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				// a ticker of a non-positive period would panic here
+				sm := NewSessionManager(&SessionManagerConfig{Timeout: tt.timeout, AbsoluteTimeout: tt.absolute})
+				defer sm.Close()
+				if sm.Timeout != tt.want || sm.AbsoluteTimeout != tt.wantAbs {
+					t.Errorf(
+						"[%v] Timeouts %v and %v, expecting %v and %v",
+						tt.name, sm.Timeout, sm.AbsoluteTimeout, tt.want, tt.wantAbs,
+					)
+				}
+			},
+		)
+	}
+}
+
+func TestSessionManagerTimeouts(t *testing.T) {
+	now := time.Now()
+	sm := NewSessionManager(&SessionManagerConfig{Timeout: time.Minute, AbsoluteTimeout: 3 * time.Minute})
+	defer sm.Close()
+	sm.now = func() time.Time { return now }
+
+	sess := sm.New()
+	if sess.ID() == sm.New().ID() || len(sess.ID()) != 43 {
+		t.Fatalf("Unexpected session id %q", sess.ID())
+	}
+	w := httptest.NewRecorder()
+	sm.Save(w, httptest.NewRequest(http.MethodGet, "/", nil), sess)
+	r := withCookie(w)
+
+	tests := []struct {
+		name  string
+		after time.Duration
+		touch bool
+		found bool
+	}{
+		{"active", 50 * time.Second, true, true},
+		{"sliding", 100 * time.Second, true, true},
+		{"absolute limit", 170 * time.Second, true, true},
+		{"absolute", 180 * time.Second, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				sm.now = func() time.Time { return now.Add(tt.after) }
+				got, found := sm.load(sess.ID())
+				if found != tt.found {
+					t.Fatalf("[%v] Found %v, expecting %v", tt.name, found, tt.found)
+				}
+				if tt.touch {
+					sm.Touch(httptest.NewRecorder(), got)
+					if limit := now.Add(sm.AbsoluteTimeout); got.expires.After(limit) {
+						t.Errorf("[%v] Expires %v, after %v", tt.name, got.expires, limit)
+					}
+				}
+			},
+		)
+	}
+	if _, found := sm.Get(r); found {
+		t.Errorf("Session found past its absolute timeout")
+	}
+}
+
+func TestSessionManagerRegenerate(t *testing.T) {
+	sm := NewSessionManager(nil)
+	defer sm.Close()
+	sess := sm.New()
+	sess.Set("cart", "room 3")
+	w := httptest.NewRecorder()
+	sm.Save(w, httptest.NewRequest(http.MethodGet, "/", nil), sess)
+	planted := withCookie(w)
+
+	old := sess.ID()
+	sess.Register("jane", "s3cret", "ROLE_USER")
+	w = httptest.NewRecorder()
+	sm.Regenerate(w, planted, sess)
+	if sess.ID() == old {
+		t.Fatalf("Session id was not regenerated")
+	}
+	if _, found := sm.Get(planted); found {
+		t.Errorf("Session found using the former id")
+	}
+	loaded, found := sm.Get(withCookie(w))
+	if !found {
+		t.Fatalf("Regenerated session was not found")
+	}
+	if v, _ := loaded.Get("cart"); v != "room 3" {
+		t.Errorf("Session values were not kept: %v", v)
+	}
+	if _, ok := loaded.GetUser(); !ok {
+		t.Errorf("Logged in user was not kept")
+	}
+}
+
+type closingBackend struct {
+	*MemoryBackend
+	closed int
+}
+
+func (b *closingBackend) Close() error {
+	b.closed++
+	return nil
+}
+
+func TestSessionManagerClose(t *testing.T) {
+	b := &closingBackend{MemoryBackend: NewMemoryBackend()}
+	sm := NewSessionManager(&SessionManagerConfig{Backend: b})
+	if err := sm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.Close(); err != nil || b.closed != 1 {
+		t.Errorf("Backend closed %d times, expecting 1", b.closed)
+	}
+}
@@ -0,0 +1,96 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// sessionKey is the context key used to store the *Session.
+type sessionKey struct{}
+
+// NewSessionContext returns a copy of ctx carrying the provided session.
+func NewSessionContext(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// SessionFrom returns the session stored in ctx by the session middleware,
+// if there is one.
+func SessionFrom(ctx context.Context) (*Session, bool) {
+	s, ok := ctx.Value(sessionKey{}).(*Session)
+	return s, ok && s != nil
+}
+
+// sessionToucher is implemented by the providers able to extend a session
+// without storing its values again, such as the SessionManager.
+type sessionToucher interface {
+	Touch(w http.ResponseWriter, s *Session)
+}
+
+// SessionMiddleware returns a middleware loading the session of every request
+// from the provider, or creating a new one, and placing it in the request
+// context, where handlers find it using SessionFrom.
+//
+// Handlers do not need to save the session: just before the headers of the
+// response are written, a session that was modified is saved, a session that
+// was destroyed is deleted, and an existing session that was not modified has
+// its expiry extended (when the provider supports it). A new session is only
+// saved once something was stored in it.
+func SessionMiddleware(p SessionProvider) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			sess, found := p.MustGet(r)
+			sw := &sessionWriter{
+				ResponseWriter: w,
+				commit: func() {
+					switch {
+					case sess.deleted.Load():
+						if found {
+							p.Save(w, r, nil)
+						}
+					case sess.Modified():
+						p.Save(w, r, sess)
+					case found:
+						if t, ok := p.(sessionToucher); ok {
+							t.Touch(w, sess)
+						}
+					}
+				},
+			}
+			next.ServeHTTP(sw, r.WithContext(NewSessionContext(r.Context(), sess)))
+			// The handler may not have written anything
+			sw.once.Do(sw.commit)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// sessionWriter commits the session before the headers are written.
+type sessionWriter struct {
+	http.ResponseWriter
+	commit func()
+	once   sync.Once
+}
+
+func (w *sessionWriter) WriteHeader(statusCode int) {
+	w.once.Do(w.commit)
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	w.once.Do(w.commit)
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, when the underlying writer does.
+func (w *sessionWriter) Flush() {
+	w.once.Do(w.commit)
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController.
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
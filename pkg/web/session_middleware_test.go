@@ -0,0 +1,140 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSessionMiddleware(t *testing.T) {
+	for _, codec := range []string{"gob", "json"} {
+		t.Run(
+			codec, func(t *testing.T) {
+				sm := NewSessionManager(&SessionManagerConfig{Codec: codec})
+				defer sm.Close()
+				mux := http.NewServeMux()
+				mux.HandleFunc(
+					"/login", func(w http.ResponseWriter, r *http.Request) {
+						sess, _ := SessionFrom(r.Context())
+						sess.Register("jane", "s3cret", "ROLE_USER")
+						sess.Set("visits", 1)
+						sess.Set("theme", "dark")
+						sess.AddFlash("Welcome back")
+						fmt.Fprint(w, "ok")
+					},
+				)
+				mux.HandleFunc(
+					"/home", func(w http.ResponseWriter, r *http.Request) {
+						sess, _ := SessionFrom(r.Context())
+						user, _ := sess.GetUser()
+						visits, _ := sess.GetInt("visits")
+						theme, _ := sess.GetString("theme")
+						fmt.Fprintf(w, "%s %d %s %v", user.Username, visits, theme, sess.Flashes())
+					},
+				)
+				mux.HandleFunc(
+					"/read", func(w http.ResponseWriter, r *http.Request) {
+						// nothing written, the session is committed afterwards
+					},
+				)
+				mux.HandleFunc(
+					"/logout", func(w http.ResponseWriter, r *http.Request) {
+						sess, _ := SessionFrom(r.Context())
+						sess.Destroy()
+						w.WriteHeader(http.StatusNoContent)
+					},
+				)
+				h := SessionMiddleware(sm)(mux)
+
+				serve := func(path string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+					r := httptest.NewRequest(http.MethodGet, path, nil)
+					for _, c := range cookies {
+						r.AddCookie(c)
+					}
+					w := httptest.NewRecorder()
+					h.ServeHTTP(w, r)
+					return w
+				}
+
+				if w := serve("/read", nil); len(w.Result().Cookies()) != 0 {
+					t.Errorf("[%v] Unmodified new session was saved", codec)
+				}
+				w := serve("/login", nil)
+				cookies := w.Result().Cookies()
+				if len(cookies) != 1 {
+					t.Fatalf("[%v] Modified session was not saved", codec)
+				}
+
+				tests := []struct {
+					name   string
+					path   string
+					body   string
+					cookie bool
+				}{
+					{"values and flash", "/home", "jane 1 dark [Welcome back]", true},
+					{"flash consumed", "/home", "jane 1 dark []", true},
+					{"touched", "/read", "", true},
+					{"logout", "/logout", "", true},
+				}
+				for _, tt := range tests {
+					w = serve(tt.path, cookies)
+					if tt.body != "" && w.Body.String() != tt.body {
+						t.Errorf("[%v] Body %q, expecting %q", tt.name, w.Body, tt.body)
+					}
+					if got := len(w.Result().Cookies()) == 1; got != tt.cookie {
+						t.Errorf("[%v] Cookie set %v, expecting %v", tt.name, got, tt.cookie)
+					}
+				}
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.AddCookie(cookies[0])
+				if _, found := sm.Get(r); found {
+					t.Errorf("[%v] Session was not deleted on logout", codec)
+				}
+			},
+		)
+	}
+}
+
+func TestSessionGetters(t *testing.T) {
+	sm := NewSessionManager(nil)
+	defer sm.Close()
+	sess := sm.New()
+	sess.Set("int", 3)
+	sess.Set("float", 2.0)
+	sess.Set("fraction", 2.5)
+	sess.Set("string", "s")
+
+	tests := []struct {
+		name string
+		key  string
+		n    int
+		ok   bool
+	}{
+		{"int", "int", 3, true},
+		{"json number", "float", 2, true},
+		{"fraction", "fraction", 0, false},
+		{"string", "string", 0, false},
+		{"missing", "missing", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				n, ok := sess.GetInt(tt.key)
+				if n != tt.n || ok != tt.ok {
+					t.Errorf("[%v] GetInt %v %v, expecting %v %v", tt.name, n, ok, tt.n, tt.ok)
+				}
+			},
+		)
+	}
+	if _, ok := sess.GetString("int"); ok {
+		t.Errorf("GetString accepted an int")
+	}
+	if !sess.Modified() {
+		t.Errorf("Session is not modified")
+	}
+	sm.Save(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), sess)
+	if sess.Modified() {
+		t.Errorf("Session is still modified after saving")
+	}
+}
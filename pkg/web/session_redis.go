@@ -0,0 +1,254 @@
+package web
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RedisConfig configures the connection of a RedisBackend.
+type RedisConfig struct {
+	Addr     string        `json:"addr"`         // Addr is the host:port of the server, defaults to "localhost:6379"
+	Password string        `json:"-"`            // Password is sent using AUTH when set
+	DB       int           `json:"db"`           // DB is selected using SELECT when not 0
+	Prefix   string        `json:"prefix"`       // Prefix is prepended to every session id, defaults to "session:"
+	Timeout  time.Duration `json:"dial_timeout"` // Timeout of dialing and of every command, defaults to 5 seconds
+}
+
+// RedisBackend keeps the sessions in a server speaking the Redis protocol
+// (RESP), such as Redis, KeyDB or Valkey, so that they survive a restart and
+// are shared between instances. Sessions are expired by the server, so GC
+// does nothing.
+type RedisBackend struct {
+	conf RedisConfig
+
+	mu   sync.Mutex // guards conn, commands are sent one at a time
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+func NewRedisBackend(conf RedisConfig) *RedisBackend {
+	if conf.Addr == "" {
+		conf.Addr = "localhost:6379"
+	}
+	if conf.Prefix == "" {
+		conf.Prefix = "session:"
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = 5 * time.Second
+	}
+	return &RedisBackend{conf: conf}
+}
+
+// redisError is an error reply of the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// errRedisNil is the nil reply of the server, such as GET of a missing key.
+var errRedisNil = errors.New("redis: nil")
+
+// dial connects to the server, and authenticates and selects the database
+// when configured.
+func (rb *RedisBackend) dial() error {
+	conn, err := net.DialTimeout("tcp", rb.conf.Addr, rb.conf.Timeout)
+	if err != nil {
+		return err
+	}
+	rb.conn, rb.rd = conn, bufio.NewReader(conn)
+	if rb.conf.Password != "" {
+		if _, err = rb.roundTrip("AUTH", rb.conf.Password); err != nil {
+			rb.close()
+			return err
+		}
+	}
+	if rb.conf.DB != 0 {
+		if _, err = rb.roundTrip("SELECT", strconv.Itoa(rb.conf.DB)); err != nil {
+			rb.close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (rb *RedisBackend) close() {
+	if rb.conn != nil {
+		_ = rb.conn.Close()
+	}
+	rb.conn, rb.rd = nil, nil
+}
+
+// Close closes the connection to the server.
+func (rb *RedisBackend) Close() error {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.close()
+	return nil
+}
+
+// do sends the command and returns the reply, connecting first if needed. The
+// connection is dropped after any error other than an error reply, so that
+// the next command reconnects.
+func (rb *RedisBackend) do(args ...string) (any, error) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if rb.conn == nil {
+		if err := rb.dial(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := rb.roundTrip(args...)
+	var re redisError
+	if err != nil && err != errRedisNil && !errors.As(err, &re) {
+		rb.close()
+	}
+	return reply, err
+}
+
+func (rb *RedisBackend) roundTrip(args ...string) (any, error) {
+	_ = rb.conn.SetDeadline(time.Now().Add(rb.conf.Timeout))
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	if _, err := rb.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readReply(rb.rd)
+}
+
+// readReply reads a single RESP reply. Simple strings and bulk strings are
+// returned as []byte, integers as int64 and arrays as []any.
+func readReply(rd *bufio.Reader) (any, error) {
+	line, err := rd.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: invalid reply %q", line)
+	}
+	kind, body := line[0], string(line[1:len(line)-2])
+	switch kind {
+	case '+':
+		return []byte(body), nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		b := make([]byte, n+2)
+		if _, err = io.ReadFull(rd, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		items := make([]any, n)
+		for i := range items {
+			items[i], err = readReply(rd)
+			if err != nil && err != errRedisNil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: invalid reply %q", line)
+}
+
+func (rb *RedisBackend) key(id string) string {
+	return rb.conf.Prefix + id
+}
+
+func (rb *RedisBackend) Load(id string) ([]byte, time.Time, error) {
+	if !validSessionID(id) {
+		return nil, time.Time{}, ErrSessionNotFound
+	}
+	reply, err := rb.do("GET", rb.key(id))
+	if err == errRedisNil {
+		return nil, time.Time{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, _ := reply.([]byte)
+	reply, err = rb.do("PTTL", rb.key(id))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	// PTTL replies -2 when the key no longer exists, and -1 when it
+	// never expires, which Save never does
+	ttl, _ := reply.(int64)
+	if ttl < 0 {
+		return nil, time.Time{}, ErrSessionNotFound
+	}
+	return data, time.Now().Add(time.Duration(ttl) * time.Millisecond), nil
+}
+
+func (rb *RedisBackend) Save(id string, data []byte, expires time.Time) error {
+	if !validSessionID(id) {
+		return ErrSessionNotFound
+	}
+	ttl := time.Until(expires).Milliseconds()
+	if ttl <= 0 {
+		return rb.Delete(id)
+	}
+	_, err := rb.do("SET", rb.key(id), string(data), "PX", strconv.FormatInt(ttl, 10))
+	return err
+}
+
+func (rb *RedisBackend) Delete(id string) error {
+	if !validSessionID(id) {
+		return nil
+	}
+	_, err := rb.do("DEL", rb.key(id))
+	return err
+}
+
+func (rb *RedisBackend) Touch(id string, expires time.Time) error {
+	if !validSessionID(id) {
+		return ErrSessionNotFound
+	}
+	ttl := time.Until(expires).Milliseconds()
+	if ttl <= 0 {
+		return rb.Delete(id)
+	}
+	reply, err := rb.do("PEXPIRE", rb.key(id), strconv.FormatInt(ttl, 10))
+	if err != nil {
+		return err
+	}
+	if n, _ := reply.(int64); n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (rb *RedisBackend) GC() error {
+	return nil
+}
@@ -1,23 +1,34 @@
 package web
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
-	"math/rand"
+	"math"
 	"net/http"
 	"net/url"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/scottcagno/angular-refresher/pkg/web/password"
 )
 
-type SessionManager interface {
+// SessionProvider is implemented by the SessionManager,
+// which keeps sessions server side, and the CookieStore,
+// which keeps them in cookies.
+type SessionProvider interface {
 	// New should create and return a new session
 	New() *Session
 
 	// Get should return a cached session
 	Get(r *http.Request) (*Session, bool)
 
+	// MustGet should return a cached session, or a new
+	// session when there is none
+	MustGet(r *http.Request) (*Session, bool)
+
 	// Save should persist session to the underlying store
 	// implementation. Passing a nil session erases it.
 	Save(w http.ResponseWriter, r *http.Request, s *Session)
@@ -28,15 +39,24 @@ func AddTime(t time.Time, duration time.Duration) time.Time {
 }
 
 type Session struct {
-	id      string
-	data    *sync.Map
-	expires time.Time
+	id       string
+	data     *sync.Map
+	created  time.Time
+	expires  time.Time
+	modified atomic.Bool
+	deleted  atomic.Bool
 }
 
 func (s *Session) ID() string {
 	return s.id
 }
 
+// CreatedAt returns the time the session was created, or
+// regenerated.
+func (s *Session) CreatedAt() time.Time {
+	return s.created
+}
+
 func (s *Session) Has(k any) bool {
 	_, ok := s.data.Load(k)
 	return ok
@@ -44,6 +64,7 @@ func (s *Session) Has(k any) bool {
 
 func (s *Session) Set(k, v any) {
 	s.data.Store(k, v)
+	s.modified.Store(true)
 }
 
 func (s *Session) Get(k any) (any, bool) {
@@ -53,6 +74,84 @@ func (s *Session) Get(k any) (any, bool) {
 
 func (s *Session) Del(k any) {
 	s.data.Delete(k)
+	s.modified.Store(true)
+}
+
+// Modified reports whether the values of the session have changed since it
+// was loaded or last saved.
+func (s *Session) Modified() bool {
+	return s.modified.Load()
+}
+
+// Destroy marks the session to be deleted by the session middleware, when
+// the response is written.
+func (s *Session) Destroy() {
+	s.deleted.Store(true)
+}
+
+// flashKey is the key the flash messages are stored under.
+const flashKey = "_flash"
+
+// AddFlash adds a message to the session, to be shown by the next request
+// reading the flash messages, such as the page a form redirects to.
+func (s *Session) AddFlash(msg string) {
+	s.Set(flashKey, append(s.peekFlashes(), msg))
+}
+
+// Flashes returns the flash messages of the session, and removes them.
+func (s *Session) Flashes() []string {
+	flashes := s.peekFlashes()
+	if len(flashes) > 0 {
+		s.Del(flashKey)
+	}
+	return flashes
+}
+
+func (s *Session) peekFlashes() []string {
+	v, _ := s.data.Load(flashKey)
+	switch f := v.(type) {
+	case []string:
+		return f
+	case []any:
+		// decoded by the JSON codec
+		flashes := make([]string, 0, len(f))
+		for _, msg := range f {
+			flashes = append(flashes, fmt.Sprint(msg))
+		}
+		return flashes
+	}
+	return nil
+}
+
+// GetString returns the value stored under k, if it is a string.
+func (s *Session) GetString(k any) (string, bool) {
+	v, ok := s.data.Load(k)
+	if !ok {
+		return "", false
+	}
+	str, ok := v.(string)
+	return str, ok
+}
+
+// GetInt returns the value stored under k, if it is a whole number. Numbers
+// decoded by the JSON codec are float64, so they are accepted as well.
+func (s *Session) GetInt(k any) (int, bool) {
+	v, ok := s.data.Load(k)
+	if !ok {
+		return 0, false
+	}
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int64:
+		return int(n), true
+	case float64:
+		if n != math.Trunc(n) {
+			return 0, false
+		}
+		return int(n), true
+	}
+	return 0, false
 }
 
 func (s *Session) ExpiresIn() int64 {
@@ -64,23 +163,37 @@ type AuthUser interface {
 	Authenticate(username, password string) (*SystemUser, bool)
 }
 
+// SystemUser is an authenticated user of the system. The Password field holds
+// the PHC encoded password hash (see the password package) and is never
+// written out as JSON.
 type SystemUser struct {
 	Username string `json:"username"`
-	Password string `json:"password"`
+	Password string `json:"-"`
 	Role     string `json:"role"`
 }
 
-func (s *Session) Register(username, password, role string) {
-	s.data.Store(
+// CheckPassword reports whether the provided password matches the stored
+// password hash, using a constant time comparison.
+func (u *SystemUser) CheckPassword(pass string) bool {
+	ok, _, err := password.Verify(pass, u.Password)
+	return err == nil && ok
+}
+
+func (s *Session) Register(username, pass, role string) {
+	hash, err := password.Hash(pass)
+	if err != nil {
+		return
+	}
+	s.Set(
 		"current_user", &SystemUser{
 			Username: username,
-			Password: password,
+			Password: hash,
 			Role:     role,
 		},
 	)
 }
 
-func (s *Session) Authenticate(username, password string) (*SystemUser, bool) {
+func (s *Session) Authenticate(username, pass string) (*SystemUser, bool) {
 	v, ok := s.data.Load("current_user")
 	if !ok {
 		return nil, false
@@ -89,220 +202,50 @@ func (s *Session) Authenticate(username, password string) (*SystemUser, bool) {
 	if !ok {
 		return nil, false
 	}
-	if su.Username != username {
-		return nil, false
-	}
-	if su.Password != password {
+	// Always check the password, so the time taken does not reveal
+	// whether the username matched.
+	match := su.CheckPassword(pass)
+	if subtle.ConstantTimeCompare([]byte(su.Username), []byte(username)) != 1 || !match {
 		return nil, false
 	}
 	return su, true
 }
 
+// GetUser returns the user stored in the session. The JSON codec decodes
+// the user as a map, which is turned back into a *SystemUser.
 func (s *Session) GetUser() (*SystemUser, bool) {
 	user, found := s.data.Load("current_user")
 	if !found {
 		return nil, false
 	}
-	su, ok := user.(*SystemUser)
-	if !ok {
-		return nil, false
-	}
-	return su, true
-}
-
-// SessionStoreConfig is a configuration object for a
-// session manager
-type SessionStoreConfig struct {
-	SessionID string        `json:"session_id"`       // SessionID is the global session id
-	Domain    string        `json:"domain"`           // Domain is the domain to limit the session scope
-	Timeout   time.Duration `json:"timeout_duration"` // Timeout is the max idle session time allowed
-}
-
-// defaultSessionConfig is pretty self-explanatory
-var defaultConfig = &SessionStoreConfig{
-	SessionID: "go_sess_id",
-	Domain:    "localhost",
-	Timeout:   time.Duration(30) * time.Minute,
-}
-
-// checkConfig checks the SessionConfig and sets
-// and default values that need to be set
-func checkSessionStoreConfig(conf *SessionStoreConfig) {
-	if conf == nil {
-		conf = defaultConfig
-	}
-	if conf.SessionID == "" {
-		conf.SessionID = defaultConfig.SessionID
-	}
-	if conf.Domain == "" {
-		conf.Domain = defaultConfig.Domain
-	}
-	if conf.Timeout == 0 {
-		conf.Timeout = defaultConfig.Timeout
-	}
-}
-
-var ssOnce sync.Once
-
-var SessionStoreInstance *SessionStore
-
-// SessionStore implements the session manager interface
-// and is a basic session manager using cookies.
-type SessionStore struct {
-	*SessionStoreConfig
-	sessions *sync.Map
-}
-
-// NewSessionStore takes a *SessionStoreConfig. It initializes and returns
-// a *SessionStore. The sid (provided in the config) will be used as the key
-// for all session cookies, and the timeout (provided in the config) is the
-// maximum allowable idle session time before the session is expired. This
-// function uses sync.Once to ensure that only one *SessionStore is alive.
-// You may call NewSessionStore() multiple times throughout your application
-// and the same instance will be returned.
-func NewSessionStore(conf *SessionStoreConfig) *SessionStore {
-	if conf == nil {
-		conf = defaultConfig
-	}
-	checkSessionStoreConfig(conf)
-	ssOnce.Do(
-		func() {
-			SessionStoreInstance = initSessionStoreInstance(conf)
-		},
-	)
-	return SessionStoreInstance
-}
-
-// initSessionStoreInstance takes a *SessionStoreConfig. It initializes and
-// returns a *SessionStore. The sid (provided in the config) will be used as
-// the key for all session cookies, and the timeout (provided in the config)
-// is the maximum allowable idle session time before the session is expired.
-func initSessionStoreInstance(conf *SessionStoreConfig) *SessionStore {
-	ss := &SessionStore{
-		SessionStoreConfig: conf,
-		sessions:           new(sync.Map),
-	}
-	go ss.gc()
-	return ss
-}
-
-// New creates and returns a new session
-func (ss *SessionStore) New() *Session {
-	return &Session{
-		id:      RandStringN(32), // create session id 32 chars long
-		data:    new(sync.Map),
-		expires: AddTime(time.Now(), ss.Timeout),
-	}
-}
-
-// Get returns a cached session (if one exists)
-func (ss *SessionStore) Get(r *http.Request) (*Session, bool) {
-	c := getCookie(r, ss.SessionID)
-	if c == nil {
-		return nil, false
-	}
-	v, ok := ss.sessions.Load(c.Value)
-	if !ok {
-		return nil, false
-	}
-	return v.(*Session), true
-}
-
-// MustGet returns a cached session (if one exists), otherwise, it creates and
-// returns a new session. It is guaranteed to return a session.
-func (ss *SessionStore) MustGet(r *http.Request) (*Session, bool) {
-	// First, attempt to get an existing session from the cookie.
-	c := getCookie(r, ss.SessionID)
-	if c == nil {
-		// If no cookie was found, then we must create a new session,
-		// and return false (indicating that we did not find an existing
-		// one, but one had to be created.) Don't forget, this session
-		// WILL NOT be saved unless the Save method is called.
-		return &Session{
-			id:      RandStringN(32), // create session id 32 chars long
-			data:    new(sync.Map),
-			expires: AddTime(time.Now(), ss.Timeout),
-		}, false
-	}
-	// Otherwise, we have found a session, but we must check to ensure
-	// it is not expired and still exists on the server side.
-	v, found := ss.sessions.Load(c.Value)
-	if !found {
-		// If no session was found, then we must create a new session,
-		// and return false (indicating that we did not find an existing
-		// one, but one had to be created.) Don't forget, this session
-		// WILL NOT be saved unless the Save method is called.
-		return &Session{
-			id:      RandStringN(32), // create session id 32 chars long
-			data:    new(sync.Map),
-			expires: AddTime(time.Now(), ss.Timeout),
-		}, false
-	}
-	// Otherwise, we have successfully located an existing session,
-	// so we can simply return it along with a true (indicating that
-	// the session did in fact exist)
-	return v.(*Session), true
-}
-
-// Save persists the provided session. If you would like to remove a session, simply
-// pass it a nil session, and it will time the cookie out.
-func (ss *SessionStore) Save(w http.ResponseWriter, r *http.Request, session *Session) {
-	if session == nil {
-		cook := getCookie(r, ss.SessionID)
-		if cook == nil {
-			return
+	switch su := user.(type) {
+	case *SystemUser:
+		return su, true
+	case map[string]any:
+		username, _ := su["username"].(string)
+		role, _ := su["role"].(string)
+		if username == "" {
+			return nil, false
 		}
-		ss.sessions.Delete(cook.Value)
-		http.SetCookie(w, newCookie(ss.SessionID, cook.Value, ss.Domain, time.Now()))
-		return
+		return &SystemUser{Username: username, Role: role}, true
 	}
-	session.expires = AddTime(time.Now(), ss.Timeout)
-	ss.sessions.Store(session.id, session)
-	http.SetCookie(w, newCookie(ss.SessionID, session.id, ss.Domain, session.expires))
-}
-
-// String is the session store's stringer method
-func (ss *SessionStore) String() string {
-	var sessions []string
-	ss.sessions.Range(
-		func(id, sess interface{}) bool {
-			sessions = append(sessions, id.(string))
-			return true
-		},
-	)
-	return strings.Join(sessions, "\n")
-}
-
-// gc is the session store "garbage collector" and
-// cleans and disposes of expired sessions (server side)
-func (ss *SessionStore) gc() {
-	ss.sessions.Range(
-		func(id, sess interface{}) bool {
-			if sess.(*Session).ExpiresIn() < 0 {
-				ss.sessions.Delete(id)
-			}
-			return true
-		},
-	)
-	time.AfterFunc(ss.Timeout/2, func() { ss.gc() })
+	return nil, false
 }
 
 // newCookie is a helper that wraps the creation of a new
-// cookie and returns a filled out *http.Cookie instance
-func newCookie(name, value, domain string, expires time.Time) *http.Cookie {
+// session cookie and returns a filled out *http.Cookie
+// instance using the cookie attributes of the config
+func newCookie(conf *SessionManagerConfig, value string, expires time.Time) *http.Cookie {
 	return &http.Cookie{
-		Name:       URLEncode(name),
-		Value:      Base64Encode(value),
-		Path:       "/",
-		Domain:     domain,
-		Expires:    expires,
-		RawExpires: "",
-		MaxAge:     setMaxAge(expires),
-		Secure:     false,                   // set to true, if using TLS (false otherwise)
-		HttpOnly:   true,                    // protects against XSS attacks
-		SameSite:   http.SameSiteStrictMode, // protects against CSRF attacks
-		Raw:        "",
-		Unparsed:   nil,
+		Name:     URLEncode(conf.SessionID),
+		Value:    Base64Encode(value),
+		Path:     conf.Path,
+		Domain:   conf.Domain,
+		Expires:  expires,
+		MaxAge:   setMaxAge(expires),
+		Secure:   conf.Secure,        // set to true, if using TLS (false otherwise)
+		HttpOnly: !conf.ScriptAccess, // protects against XSS attacks
+		SameSite: conf.SameSite,      // protects against CSRF attacks
 	}
 }
 
@@ -326,36 +269,50 @@ func getCookie(r *http.Request, name string) *http.Cookie {
 	if err != nil || err == http.ErrNoCookie {
 		return nil
 	}
-	c.Value = Base64Decode(c.Value)
+	// The value is sent by the client, so it may not decode
+	b, err := base64.RawURLEncoding.DecodeString(c.Value)
+	if err != nil {
+		return nil
+	}
+	c.Value = string(b)
 	return c
 }
 
-const (
-	letterBytes   = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	letterIdxBits = 6                    // 6 bits to represent a letter index
-	letterIdxMask = 1<<letterIdxBits - 1 // All 1-bits, as many as letterIdxBits
-	letterIdxMax  = 63 / letterIdxBits   // # of letter indices fitting in 63 bits
-)
+const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
-// RandStringN creates a random string N characters in length
+// RandStringN creates a random string N characters in length,
+// using letters picked by a cryptographically secure generator
 func RandStringN(n int) string {
-	var src = rand.NewSource(time.Now().UnixNano() + int64(rand.Uint64()))
 	b := make([]byte, n)
-	// A src.Int63() generates 63 random bits, enough for letterIdxMax characters!
-	for i, cache, remain := n-1, src.Int63(), letterIdxMax; i >= 0; {
-		if remain == 0 {
-			cache, remain = src.Int63(), letterIdxMax
+	buf := make([]byte, n)
+	for i := 0; i < n; {
+		if _, err := rand.Read(buf); err != nil {
+			panic(fmt.Sprintf("rand: reading random bytes failed %q", err))
 		}
-		if idx := int(cache & letterIdxMask); idx < len(letterBytes) {
-			b[i] = letterBytes[idx]
-			i--
+		for _, c := range buf {
+			// Skip the values that would make some letters more likely
+			// than others (256 is not a multiple of 52)
+			if int(c) >= 256-256%len(letterBytes) {
+				continue
+			}
+			b[i] = letterBytes[int(c)%len(letterBytes)]
+			if i++; i == n {
+				break
+			}
 		}
-		cache >>= letterIdxBits
-		remain--
 	}
 	return string(b)
 }
 
+// newSessionID returns a new session id, holding 256 random bits
+func newSessionID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("rand: reading random bytes failed %q", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
 // Base64Encode takes a plaintext string and returns a base64 encoded string
 func Base64Encode(s string) string {
 	return base64.RawURLEncoding.EncodeToString([]byte(s))
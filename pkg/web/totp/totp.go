@@ -0,0 +1,262 @@
+// Package totp implements time-based one-time passwords (RFC 6238), as used
+// by authenticator apps for a second login factor, along with the otpauth://
+// key URIs used to enroll them, for example:
+//
+//	otpauth://totp/Room%20Booking:admin?secret=<base32>&issuer=Room%20Booking&algorithm=SHA1&digits=6&period=30
+//
+// The package only generates and validates codes. Preventing the reuse of a
+// code is left to the caller, which should remember the counter returned by
+// Validate and reject any code with a counter that is not greater than it.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidSecret = errors.New("totp: secret is not valid base32")
+	ErrInvalidParams = errors.New("totp: invalid parameters")
+)
+
+// b32 is the encoding of secrets. Authenticator apps expect unpadded base32.
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Algorithm is the HMAC hash function used to derive codes.
+type Algorithm string
+
+const (
+	SHA1   Algorithm = "SHA1"
+	SHA256 Algorithm = "SHA256"
+	SHA512 Algorithm = "SHA512"
+)
+
+func (a Algorithm) hash() (func() hash.Hash, error) {
+	switch a {
+	case SHA1:
+		return sha1.New, nil
+	case SHA256:
+		return sha256.New, nil
+	case SHA512:
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidParams, a)
+}
+
+// Params holds the parameters codes are generated and validated with.
+type Params struct {
+	// Digits is the number of digits of a code, 6 or 8.
+	Digits int
+
+	// Period is the time step during which a code is valid.
+	Period time.Duration
+
+	// Algorithm is the hash function of the HMAC.
+	Algorithm Algorithm
+
+	// Skew is the number of time steps before and after the current one
+	// that are accepted as well, to allow for clock drift.
+	Skew int
+}
+
+// DefaultParams are the parameters supported by every authenticator app.
+var DefaultParams = Params{
+	Digits:    6,
+	Period:    30 * time.Second,
+	Algorithm: SHA1,
+	Skew:      1,
+}
+
+// checkParams replaces any zero values by the matching value from
+// DefaultParams.
+func checkParams(p Params) (Params, error) {
+	if p.Digits == 0 {
+		p.Digits = DefaultParams.Digits
+	}
+	if p.Period == 0 {
+		p.Period = DefaultParams.Period
+	}
+	if p.Algorithm == "" {
+		p.Algorithm = DefaultParams.Algorithm
+	}
+	if p.Digits < 6 || p.Digits > 8 || p.Period < time.Second || p.Skew < 0 {
+		return p, ErrInvalidParams
+	}
+	return p, nil
+}
+
+// GenerateSecret returns a new random 160 bit secret, encoded as base32.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(b), nil
+}
+
+// decodeSecret decodes a base32 secret, ignoring case, spaces and padding as
+// they are commonly entered by hand.
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := b32.DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+	return key, nil
+}
+
+// Counter returns the time step that t falls in.
+func Counter(t time.Time, period time.Duration) int64 {
+	return t.Unix() / int64(period/time.Second)
+}
+
+// GenerateCode returns the code for the secret at time t.
+func GenerateCode(secret string, t time.Time, p Params) (string, error) {
+	p, err := checkParams(p)
+	if err != nil {
+		return "", err
+	}
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, Counter(t, p.Period), p)
+}
+
+// hotp computes the HOTP value (RFC 4226, section 5.3) for the counter.
+func hotp(key []byte, counter int64, p Params) (string, error) {
+	h, err := p.Algorithm.hash()
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(h, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < p.Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", p.Digits, value%mod), nil
+}
+
+// Validate reports whether the code is valid for the secret at time t, within
+// the skew of the params. It returns the counter of the time step the code
+// matched, which the caller should store to prevent the code from being used
+// again. Codes are compared in constant time.
+func Validate(code, secret string, t time.Time, p Params) (int64, bool) {
+	p, err := checkParams(p)
+	if err != nil {
+		return 0, false
+	}
+	key, err := decodeSecret(secret)
+	if err != nil || len(code) != p.Digits {
+		return 0, false
+	}
+	counter := Counter(t, p.Period)
+	var matched int64
+	var ok bool
+	// Check every step, so the time taken does not reveal which step matched
+	for i := -p.Skew; i <= p.Skew; i++ {
+		expected, err := hotp(key, counter+int64(i), p)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(expected)) == 1 && !ok {
+			matched, ok = counter+int64(i), true
+		}
+	}
+	return matched, ok
+}
+
+// Key is a TOTP secret along with the details shown by authenticator apps.
+type Key struct {
+	// Issuer is the name of the service, shown by authenticator apps.
+	Issuer string
+
+	// Account is the name of the user, usually the username or email.
+	Account string
+
+	// Secret is the base32 encoded shared secret.
+	Secret string
+
+	Params Params
+}
+
+// NewKey returns a key with a new secret for the account, using the
+// DefaultParams.
+func NewKey(issuer, account string) (*Key, error) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	return &Key{
+		Issuer:  issuer,
+		Account: account,
+		Secret:  secret,
+		Params:  DefaultParams,
+	}, nil
+}
+
+// URI returns the otpauth:// key URI of the key, which is usually shown to
+// the user as a QR code.
+func (k *Key) URI() string {
+	p, _ := checkParams(k.Params)
+	label := url.PathEscape(k.Account)
+	if k.Issuer != "" {
+		label = url.PathEscape(k.Issuer) + ":" + label
+	}
+	q := url.Values{
+		"secret":    {k.Secret},
+		"algorithm": {string(p.Algorithm)},
+		"digits":    {strconv.Itoa(p.Digits)},
+		"period":    {strconv.Itoa(int(p.Period / time.Second))},
+	}
+	if k.Issuer != "" {
+		q.Set("issuer", k.Issuer)
+	}
+	// Spaces are encoded as %20, because some apps do not decode "+"
+	return "otpauth://totp/" + label + "?" + strings.ReplaceAll(q.Encode(), "+", "%20")
+}
+
+// GenerateRecoveryCodes returns n random single use recovery codes, formatted
+// as two groups of five lowercase base32 characters, such as "k3xq7-m2vpa".
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	b := make([]byte, 10)
+	for i := range codes {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := strings.ToLower(b32.EncodeToString(b))[:10]
+		codes[i] = s[:5] + "-" + s[5:]
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode normalizes a recovery code as entered by a user, so it
+// can be compared against the stored code.
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Join(strings.Fields(code), ""))
+	code = strings.ReplaceAll(code, "-", "")
+	if len(code) != 10 {
+		return code
+	}
+	return code[:5] + "-" + code[5:]
+}
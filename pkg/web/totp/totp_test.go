@@ -0,0 +1,114 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test vectors from RFC 6238, appendix B.
+var rfc6238TestData = []struct {
+	unix   int64
+	sha1   string
+	sha256 string
+	sha512 string
+}{
+	{59, "94287082", "46119246", "90693936"},
+	{1111111109, "07081804", "68084774", "25091201"},
+	{1111111111, "14050471", "67062674", "99943326"},
+	{1234567890, "89005924", "91819424", "93441116"},
+	{2000000000, "69279037", "90698825", "38618901"},
+	{20000000000, "65353130", "77737706", "47863826"},
+}
+
+func testSecret(ascii string) string {
+	return base32.StdEncoding.EncodeToString([]byte(ascii))
+}
+
+func TestGenerateCode(t *testing.T) {
+	secrets := map[Algorithm]string{
+		SHA1:   testSecret("12345678901234567890"),
+		SHA256: testSecret("12345678901234567890123456789012"),
+		SHA512: testSecret("1234567890123456789012345678901234567890123456789012345678901234"),
+	}
+	for _, data := range rfc6238TestData {
+		for alg, want := range map[Algorithm]string{SHA1: data.sha1, SHA256: data.sha256, SHA512: data.sha512} {
+			p := Params{Digits: 8, Algorithm: alg}
+			code, err := GenerateCode(secrets[alg], time.Unix(data.unix, 0), p)
+			if err != nil {
+				t.Fatalf("[%v %d] %v", alg, data.unix, err)
+			}
+			if code != want {
+				t.Errorf("[%v %d] Code %s, expecting %s", alg, data.unix, code, want)
+			}
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	code := func(d time.Duration) string {
+		c, err := GenerateCode(secret, now.Add(d), DefaultParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		code    string
+		ok      bool
+		counter int64
+	}{
+		{"current step", code(0), true, Counter(now, DefaultParams.Period)},
+		{"previous step", code(-30 * time.Second), true, Counter(now, DefaultParams.Period) - 1},
+		{"next step", code(30 * time.Second), true, Counter(now, DefaultParams.Period) + 1},
+		{"outside skew", code(-90 * time.Second), false, 0},
+		{"wrong length", code(0)[:5], false, 0},
+		{"not a code", "abcdef", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				counter, ok := Validate(tt.code, secret, now, DefaultParams)
+				if ok != tt.ok || counter != tt.counter {
+					t.Errorf("[%v] Got (%d, %v), expecting (%d, %v)", tt.name, counter, ok, tt.counter, tt.ok)
+				}
+			},
+		)
+	}
+	if _, ok := Validate(code(0), "not base32!", now, DefaultParams); ok {
+		t.Errorf("Invalid secret was accepted")
+	}
+}
+
+func TestKeyURI(t *testing.T) {
+	k := &Key{Issuer: "Room Booking", Account: "admin", Secret: "JBSWY3DPEHPK3PXP", Params: DefaultParams}
+	want := "otpauth://totp/Room%20Booking:admin?algorithm=SHA1&digits=6&issuer=Room%20Booking&period=30&secret=JBSWY3DPEHPK3PXP"
+	if uri := k.URI(); uri != want {
+		t.Errorf("URI %s, expecting %s", uri, want)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' || seen[code] {
+			t.Fatalf("Unexpected recovery code %q", code)
+		}
+		seen[code] = true
+		if n := NormalizeRecoveryCode(" " + strings.ToUpper(strings.ReplaceAll(code, "-", " "))); n != code {
+			t.Errorf("Normalized %q, expecting %q", n, code)
+		}
+	}
+}